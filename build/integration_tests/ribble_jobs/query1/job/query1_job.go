@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log"
+
 	"github.com/josenarvaezp/displ/build/integration_tests/ribble_jobs/query1"
 	"github.com/josenarvaezp/displ/pkg/ribble"
 )
@@ -19,10 +21,13 @@ func main() {
 	}
 
 	// define job
-	ribble.Job(
+	err := ribble.Job(
 		query1.Query1,
 		nil,
 		query1.Sort,
 		config,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -132,7 +132,7 @@ func Query1(filename string) aggregators.MapAggregator {
 		avgQuantityKey := fmt.Sprintf("%s-%s-quantity_avg", returnflag, linestatus)
 		avgPriceKey := fmt.Sprintf("%s-%s-avg_price", returnflag, linestatus)
 		avgDiscKey := fmt.Sprintf("%s-%s-avg_disc", returnflag, linestatus)
-		sumCountKey := fmt.Sprintf("%s-%s-count", returnflag, linestatus)
+		countKey := fmt.Sprintf("%s-%s-count", returnflag, linestatus)
 
 		// sum values
 		output.AddSum(sumQuantityKey, quantity)
@@ -141,7 +141,7 @@ func Query1(filename string) aggregators.MapAggregator {
 		output.AddSum(sumChargeKey, charge)
 
 		// count
-		output.AddSum(sumCountKey, 1)
+		output.AddCount(countKey)
 
 		// Avg values
 		output.AddAvg(avgQuantityKey, quantity)
@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,48 +31,33 @@ func assertOutputQ1(t *testing.T, expectedOutputFile string, jobID string) {
 	})
 
 	// wait for job to be completed
-	for true {
-		objects, err := s3Client.ListObjects(context.Background(), &s3.ListObjectsInput{
-			Bucket: &jobID,
-			Prefix: aws.String("output/"),
-		})
-		if err != nil || len(objects.Contents) == 0 {
-			// wait 5 seconds
-			fmt.Println("sleeping")
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		// output ready
-		require.Len(t, objects.Contents, 1)
-
-		res, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-			Bucket: aws.String(jobID),
-			Key:    objects.Contents[0].Key,
-		})
-		require.Nil(t, err)
+	manifest := waitForManifest(s3Client, jobID)
+	require.Len(t, manifest.Parts, 1)
 
-		defer res.Body.Close()
+	res, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(manifest.Bucket),
+		Key:    aws.String(manifest.Parts[0]),
+	})
+	require.Nil(t, err)
 
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(res.Body)
-		result := buf.Bytes()
+	defer res.Body.Close()
 
-		expectedResult, err := os.ReadFile(expectedOutputFile)
-		require.Nil(t, err)
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(res.Body)
+	result := buf.Bytes()
 
-		var resultJson, expectedResultJson []map[string]interface{}
-		err = json.Unmarshal(result, &resultJson)
-		require.Nil(t, err)
+	expectedResult, err := os.ReadFile(expectedOutputFile)
+	require.Nil(t, err)
 
-		err = json.Unmarshal(expectedResult, &expectedResultJson)
-		require.Nil(t, err)
+	var resultJson, expectedResultJson []map[string]interface{}
+	err = json.Unmarshal(result, &resultJson)
+	require.Nil(t, err)
 
-		jsonEqual := reflect.DeepEqual(expectedResultJson, resultJson)
-		assert.True(t, jsonEqual)
+	err = json.Unmarshal(expectedResult, &expectedResultJson)
+	require.Nil(t, err)
 
-		return
-	}
+	jsonEqual := reflect.DeepEqual(expectedResultJson, resultJson)
+	assert.True(t, jsonEqual)
 }
 
 func assertOutputQ6(t *testing.T, expectedOutputFile string, jobID string) {
@@ -87,42 +73,58 @@ func assertOutputQ6(t *testing.T, expectedOutputFile string, jobID string) {
 	})
 
 	// wait for job to be completed
-	for true {
-		objects, err := s3Client.ListObjects(context.Background(), &s3.ListObjectsInput{
-			Bucket: &jobID,
-		})
-		if err != nil || len(objects.Contents) != 5 {
-			// wait 5 seconds
-			fmt.Println("sleeping")
-			time.Sleep(5 * time.Second)
-			continue
-		}
+	manifest := waitForManifest(s3Client, jobID)
+	require.Len(t, manifest.Parts, 1)
 
-		res, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-			Bucket: aws.String(jobID),
-			Key:    aws.String("output"),
-		})
-		require.Nil(t, err)
+	res, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(manifest.Bucket),
+		Key:    aws.String(manifest.Parts[0]),
+	})
+	require.Nil(t, err)
 
-		defer res.Body.Close()
+	defer res.Body.Close()
 
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(res.Body)
-		result := buf.Bytes()
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(res.Body)
+	result := buf.Bytes()
 
-		expectedResult, err := os.ReadFile(expectedOutputFile)
-		require.Nil(t, err)
+	expectedResult, err := os.ReadFile(expectedOutputFile)
+	require.Nil(t, err)
 
-		var resultJson, expectedResultJson map[string]interface{}
-		err = json.Unmarshal(result, &resultJson)
-		require.Nil(t, err)
+	var resultJson, expectedResultJson map[string]interface{}
+	err = json.Unmarshal(result, &resultJson)
+	require.Nil(t, err)
 
-		err = json.Unmarshal(expectedResult, &expectedResultJson)
-		require.Nil(t, err)
+	err = json.Unmarshal(expectedResult, &expectedResultJson)
+	require.Nil(t, err)
 
-		jsonEqual := reflect.DeepEqual(expectedResultJson, resultJson)
-		assert.True(t, jsonEqual)
+	jsonEqual := reflect.DeepEqual(expectedResultJson, resultJson)
+	assert.True(t, jsonEqual)
+}
 
-		return
+// waitForManifest waits until the job writes its success manifest to the default output location
+func waitForManifest(s3Client *s3.Client, jobID string) *lambdas.SuccessManifest {
+	for {
+		res, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(jobID),
+			Key:    aws.String(lambdas.GetOutputKey(lambdas.DefaultOutputPrefix, lambdas.SuccessManifestName)),
+		})
+		if err != nil {
+			// wait 5 seconds
+			fmt.Println("sleeping")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		var manifest lambdas.SuccessManifest
+		err = json.NewDecoder(res.Body).Decode(&manifest)
+		res.Body.Close()
+		if err != nil {
+			fmt.Println("sleeping")
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		return &manifest
 	}
 }
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -14,6 +20,7 @@ import (
 	"github.com/josenarvaezp/displ/internal/driver"
 	"github.com/josenarvaezp/displ/internal/generators"
 	"github.com/josenarvaezp/displ/internal/logs"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
 )
 
 var (
@@ -27,6 +34,26 @@ var (
 	local     bool
 	logsSleep int32
 	reducers  int
+	inProcess bool
+	inputPath string
+	dataDir   string
+
+	// time between checks of the coordinator of in-process jobs
+	pollInterval time.Duration
+
+	// flags of the cleanup, status, output and submit commands
+	keepOutput   bool
+	outputFormat string
+	resultFormat string
+	resultFile   string
+	sortBy       string
+	wait         bool
+	waitTimeout  time.Duration
+)
+
+const (
+	// waitPollInterval is the time between checks of the job status when waiting
+	waitPollInterval = 5 * time.Second
 )
 
 func main() {
@@ -35,6 +62,10 @@ func main() {
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(setCredsCmd)
 	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(outputCmd)
+	rootCmd.AddCommand(submitCmd)
 
 	setCredsCmd.PersistentFlags().StringVar(&accountID, "account-id", "", "AWS account id")
 	setCredsCmd.PersistentFlags().StringVar(&username, "username", "", "AWS username")
@@ -53,13 +84,40 @@ func main() {
 	uploadCmd.Flags().CountP("verbose", "v", "counted verbosity")
 
 	runCmd.PersistentFlags().StringVar(&jobID, "job-id", "", "id of job to run")
-	runCmd.MarkPersistentFlagRequired("job-id")
+	runCmd.PersistentFlags().BoolVar(&inProcess, "in-process", false, "run the job in-process without AWS")
+	runCmd.PersistentFlags().StringVar(&jobPath, "job", "", "path to go file defining job, used with --in-process")
+	runCmd.PersistentFlags().StringVar(&inputPath, "input", "", "local file or directory used as input, used with --in-process")
+	runCmd.PersistentFlags().StringVar(&dataDir, "data-dir", "", "local directory used as object store, used with --in-process")
+	runCmd.PersistentFlags().IntVar(&reducers, "reducers", 0, "number of reducers to use, used with --in-process")
+	runCmd.PersistentFlags().DurationVar(&pollInterval, "poll-interval", 0, "time between checks of the coordinator, used with --in-process")
 	runCmd.Flags().CountP("verbose", "v", "counted verbosity")
 
 	logsCmd.PersistentFlags().StringVar(&jobID, "job-id", "", "id of job to run")
 	logsCmd.PersistentFlags().Int32Var(&logsSleep, "sleep", 60, "time in seconds for fetching logs")
 	logsCmd.MarkPersistentFlagRequired("job-id")
 
+	cleanupCmd.PersistentFlags().StringVar(&jobID, "job-id", "", "id of job to clean up")
+	cleanupCmd.PersistentFlags().BoolVar(&keepOutput, "keep-output", false, "keep the job bucket and its output objects")
+	cleanupCmd.MarkPersistentFlagRequired("job-id")
+	cleanupCmd.Flags().CountP("verbose", "v", "counted verbosity")
+
+	statusCmd.PersistentFlags().StringVar(&jobID, "job-id", "", "id of job to query")
+	statusCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "text", "output format, text or json")
+	statusCmd.MarkPersistentFlagRequired("job-id")
+
+	outputCmd.PersistentFlags().StringVar(&jobID, "job-id", "", "id of job to download the output of")
+	outputCmd.PersistentFlags().StringVarP(&resultFormat, "output", "o", "json", "output format, json, jsonl or csv")
+	outputCmd.PersistentFlags().StringVarP(&resultFile, "file", "f", "", "file the output is written to, defaults to stdout")
+	outputCmd.PersistentFlags().StringVar(&sortBy, "sort-by", "key", "order of sorted reducer outputs, key or value")
+	outputCmd.MarkPersistentFlagRequired("job-id")
+
+	submitCmd.PersistentFlags().StringVar(&jobPath, "job", "", "path to go file defining job")
+	submitCmd.PersistentFlags().IntVar(&reducers, "reducers", 0, "number of reducers to use")
+	submitCmd.PersistentFlags().BoolVar(&wait, "wait", false, "wait until the job is done")
+	submitCmd.PersistentFlags().DurationVar(&waitTimeout, "timeout", 0, "maximum time to wait for the job, used with --wait")
+	submitCmd.MarkPersistentFlagRequired("job")
+	submitCmd.Flags().CountP("verbose", "v", "counted verbosity")
+
 	rootCmd.Execute()
 }
 
@@ -79,188 +137,414 @@ var buildCmd = &cobra.Command{
 		verbosity, _ := cmd.Flags().GetCount("verbose")
 		logrus.SetLevel(logs.ConfigLogLevelToLevel(verbosity))
 
-		// set driver
-		jobID := uuid.New()
-		jobDriver := driver.NewBuildDriver(jobID)
-
-		// add job path info to driver
-		jobDriver.BuildData = &generators.BuildData{
-			JobPath:  jobPath,
-			BuildDir: fmt.Sprintf("./build/lambda_gen/%s", jobDriver.JobID.String()),
+		jobID, err := buildJob(jobPath)
+		if err != nil {
+			os.Exit(1)
 		}
 
-		// add loger info
-		driverLogger := logrus.WithFields(logrus.Fields{
-			"Job ID": jobDriver.JobID.String(),
-		})
-
-		// build directory for job's generated files
-		if _, err := os.Stat(jobDriver.BuildData.BuildDir); os.IsNotExist(err) {
-			err := os.MkdirAll(jobDriver.BuildData.BuildDir, os.ModePerm)
-			if err != nil {
-				driverLogger.WithError(err).Fatal("Error creating directory")
-				return
-			}
-		}
+		fmt.Println("Build successful with Job ID: ", jobID)
+	},
+}
 
-		// build binary that creates lambda files
-		err := jobDriver.BuildJobGenerationBinary()
+// buildJob generates the lambda files for the job defined at the given path
+// and builds their docker images. It returns the ID of the new job
+func buildJob(path string) (uuid.UUID, error) {
+	// set driver
+	jobID := uuid.New()
+	jobDriver := driver.NewBuildDriver(jobID)
+
+	// add job path info to driver
+	jobDriver.BuildData = &generators.BuildData{
+		JobPath:  path,
+		BuildDir: fmt.Sprintf("./build/lambda_gen/%s", jobDriver.JobID.String()),
+	}
+
+	// add loger info
+	driverLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": jobDriver.JobID.String(),
+	})
+
+	// build directory for job's generated files
+	if _, err := os.Stat(jobDriver.BuildData.BuildDir); os.IsNotExist(err) {
+		err := os.MkdirAll(jobDriver.BuildData.BuildDir, os.ModePerm)
 		if err != nil {
-			driverLogger.WithError(err).Fatal("Error building binary from job path")
-			return
-		}
+			driverLogger.WithError(err).Error("Error creating directory")
+			return jobID, err
+		}
+	}
+
+	// build binary that creates lambda files
+	err := jobDriver.BuildJobGenerationBinary()
+	if err != nil {
+		driverLogger.WithError(err).Error("Error building binary from job path")
+		return jobID, err
+	}
+
+	// run binary to create lambda files (go files and dockerfiles)
+	fmt.Println("Generating resources...")
+	err = jobDriver.GenerateResourcesFromBinary()
+	if err != nil {
+		driverLogger.WithError(err).Error("Error generating lambda files")
+		return jobID, err
+	}
+
+	// build mapper and coordinator docker images
+	fmt.Println("Building docker images...")
+	err = jobDriver.BuildDockerCustomImages()
+	if err != nil {
+		driverLogger.WithError(err).Error("Error building images")
+		return jobID, err
+	}
+
+	return jobID, nil
+}
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload",
+	Short: "Upload the resources needed for the processing job",
+	Long:  `Upload the resources needed for the processing job`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Creating resources...")
+
+		ctx := context.Background()
 
-		// run binary to create lambda files (go files and dockerfiles)
-		fmt.Println("Generating resources...")
-		err = jobDriver.GenerateResourcesFromBinary()
+		// get verbosity for logs
+		verbosity, _ := cmd.Flags().GetCount("verbose")
+		logrus.SetLevel(logs.ConfigLogLevelToLevel(verbosity))
+
+		// set driver
+		jobDriver, err := newJobDriver(jobID)
 		if err != nil {
-			driverLogger.WithError(err).Fatal("Error generating lambda files")
 			return
 		}
 
-		// build mapper and coordinator docker images
-		fmt.Println("Building docker images...")
-		err = jobDriver.BuildDockerCustomImages()
+		err = uploadJob(ctx, jobDriver, reducers)
 		if err != nil {
-			driverLogger.WithError(err).Fatal("Error building images")
 			return
 		}
 
-		fmt.Println("Build successful with Job ID: ", jobDriver.JobID)
+		fmt.Println("Upload successful with Job ID: ", jobDriver.JobID)
 	},
 }
 
-var uploadCmd = &cobra.Command{
-	Use:   "upload",
-	Short: "Upload the resources needed for the processing job",
-	Long:  `Upload the resources needed for the processing job`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Creating resources...")
+// newJobDriver initializes a driver for the job with the given ID using the
+// config file and build data generated when the job was built
+func newJobDriver(id string) (*driver.Driver, error) {
+	// get driver config values
+	configFile := fmt.Sprintf("%s/%s/config.yaml", generators.GeneratedFilesDir, id)
+	conf, err := config.ReadLocalConfigFile(configFile)
+	if err != nil {
+		logrus.WithField(
+			"File name", configFile,
+		).WithError(err).Error("Error reading config file")
+		return nil, err
+	}
+
+	// add job path info to driver
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		logrus.WithError(err).Error("Error parsing ID, it must be an uuid")
+		return nil, err
+	}
+
+	// set driver
+	jobDriver, err := driver.NewDriver(jobID, conf)
+	if err != nil {
+		logrus.WithError(err).Error("Error initializing driver")
+		return nil, err
+	}
+	jobDriver.JobID = jobID
+
+	// get build data
+	buildData, err := generators.ReadBuildData(jobDriver.JobID.String())
+	if err != nil {
+		logrus.WithError(err).Error("Error reading build data")
+		return nil, err
+	}
+	jobDriver.BuildData = buildData
+
+	return jobDriver, nil
+}
+
+// uploadJob creates the resources needed to run the job and uploads its functions.
+// If numReducers is 0 one reducer is used for every two mappers
+func uploadJob(ctx context.Context, jobDriver *driver.Driver, numReducers int) error {
+	// add loger info
+	driverLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": jobDriver.JobID.String(),
+	})
+
+	// Setting up resources
+	fmt.Println("Creating job S3 bucket...")
+	err := jobDriver.CreateJobBucket(ctx)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating the job bucket")
+		return err
+	}
+
+	err = jobDriver.CreateOutputBucket(ctx)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating the output bucket")
+		return err
+	}
+
+	// generate mappings from S3 input bucket
+	fmt.Println("Generating mappings...")
+	mappings, err := jobDriver.GenerateMappings(ctx)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error generating mappings from S3")
+		return err
+	}
+
+	// get number of reducers
+	numMappings := len(mappings)
+	if numReducers == 0 {
+		// no reducers specified
+		numReducers = int(math.Ceil(float64(numMappings) / 2))
+	}
+
+	// update build data
+	jobDriver.BuildData.NumMappers = numMappings
+	jobDriver.BuildData.NumReducers = numReducers
+	err = generators.WriteBuildData(jobDriver.BuildData, jobDriver.JobID.String())
+	if err != nil {
+		driverLogger.WithError(err).Error("Error updating build data")
+		return err
+	}
+
+	// write mappings to s3
+	fmt.Println("Writing mappings to S3...")
+	err = jobDriver.WriteMappings(ctx, mappings)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error writing mappings to S3")
+		return err
+	}
+
+	// create streams for job
+	fmt.Println("Creating streams in SQS...")
+	err = jobDriver.CreateQueues(ctx, numReducers)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating the job streams")
+		return err
+	}
+
+	// create log group and stream
+	fmt.Println("Creating log stream in CloudWatch...")
+	err = jobDriver.CreateLogsInfra(ctx)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating log group and stream")
+		return err
+	}
+
+	// create dlq SQS for the mappers and coordinator
+	fmt.Println("Creating SQS dead-letter queue...")
+	dlqArn, err := jobDriver.CreateLambdaDLQ(ctx)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating the dead-letter queue for the job mappers")
+		return err
+	}
+
+	// upload images to amazon ECR and create lambda function
+	fmt.Println("Uploading Lambda functions...")
+	err = jobDriver.UploadLambdaFunctions(ctx, dlqArn)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating functions")
+		return err
+	}
+
+	return nil
+}
 
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the job",
+	Long:  `Run the job`,
+	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
 		// get verbosity for logs
 		verbosity, _ := cmd.Flags().GetCount("verbose")
 		logrus.SetLevel(logs.ConfigLogLevelToLevel(verbosity))
 
-		// get driver config values
-		configFile := fmt.Sprintf("%s/%s/config.yaml", generators.GeneratedFilesDir, jobID)
-		conf, err := config.ReadLocalConfigFile(configFile)
-		if err != nil {
-			logrus.WithField(
-				"File name", configFile,
-			).WithError(err).Error("Error reading config file")
+		if inProcess {
+			runInProcess()
 			return
 		}
 
-		// add job path info to driver
-		jobID, err := uuid.Parse(jobID)
-		if err != nil {
-			logrus.WithError(err).Error("Error parsing ID, it must be an uuid")
+		if jobID == "" {
+			logrus.Error("The job-id flag is required")
 			return
 		}
 
 		// set driver
-		jobDriver, err := driver.NewDriver(jobID, conf)
+		jobDriver, err := newJobDriver(jobID)
 		if err != nil {
-			logrus.WithError(err).Error("Error initializing driver")
 			return
 		}
-		jobDriver.JobID = jobID
-
-		// add loger info
-		driverLogger := logrus.WithFields(logrus.Fields{
-			"Job ID": jobDriver.JobID.String(),
-		})
 
-		// get build data
-		buildData, err := generators.ReadBuildData(jobDriver.JobID.String())
+		// start coordinator
+		err = jobDriver.StartCoordinator(ctx)
 		if err != nil {
-			logrus.WithError(err).Error("Error reading build data")
+			logrus.WithField("Job ID", jobDriver.JobID.String()).WithError(err).Error("Error starting the coordinator")
 			return
 		}
-		jobDriver.BuildData = buildData
 
-		// Setting up resources
-		fmt.Println("Creating job S3 bucket...")
-		err = jobDriver.CreateJobBucket(ctx)
+		fmt.Println("Running job: ", jobDriver.JobID)
+	},
+}
+
+// runInProcess builds the job binary and runs the job in-process
+// against a local input without using AWS
+func runInProcess() {
+	if jobPath == "" || (inputPath == "" && dataDir == "") {
+		logrus.Error("The job flag and either the input or data-dir flag are required to run in-process")
+		return
+	}
+
+	// set driver
+	jobDriver := driver.NewBuildDriver(uuid.New())
+
+	driverLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": jobDriver.JobID.String(),
+	})
+
+	// the job binary is built in a temporary directory
+	buildDir, err := ioutil.TempDir("", "ribble-")
+	if err != nil {
+		driverLogger.WithError(err).Error("Error creating directory")
+		return
+	}
+	defer os.RemoveAll(buildDir)
+
+	jobDriver.BuildData = &generators.BuildData{
+		JobPath:  jobPath,
+		BuildDir: buildDir,
+	}
+
+	// build job binary
+	err = jobDriver.BuildJobGenerationBinary()
+	if err != nil {
+		driverLogger.WithError(err).Error("Error building binary from job path")
+		return
+	}
+
+	// run job
+	err = jobDriver.RunBinaryInProcess(inputPath, dataDir, reducers, pollInterval)
+	if err != nil {
+		driverLogger.WithError(err).Error("Error running job in-process")
+		return
+	}
+}
+
+var setCredsCmd = &cobra.Command{
+	Use:   "set-credentials",
+	Short: "Set credentials for the job",
+	Long:  `Set credentials for the job`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		// get verbosity for logs
+		verbosity, _ := cmd.Flags().GetCount("verbose")
+		logrus.SetLevel(logs.ConfigLogLevelToLevel(verbosity))
+
+		// set driver
+		jobDriver, err := driver.NewSetupDriver(&config.Config{
+			AccountID: accountID,
+			Username:  username,
+		})
 		if err != nil {
-			driverLogger.WithError(err).Error("Error creating the job bucket")
+			logrus.WithError(err).Error("Error initializing driver")
 			return
 		}
 
-		// generate mappings from S3 input bucket
-		fmt.Println("Generating mappings...")
-		mappings, err := jobDriver.GenerateMappings(ctx)
+		// create role
+		_, err = jobDriver.CreateRole(ctx)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error generating mappings from S3")
+			logrus.WithError(err).Error("Error creating ribble role")
 			return
 		}
 
-		// get number of reducers
-		numMappings := len(mappings)
-		if reducers == 0 {
-			// no reducers specified
-			reducers = int(math.Ceil(float64(numMappings) / 2))
+		// create policy
+		policyARN, err := jobDriver.CreateRolePolicy(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("Error creating ribble policy")
+			return
 		}
 
-		// update build data
-		buildData.NumMappers = numMappings
-		buildData.NumReducers = reducers
-		err = generators.WriteBuildData(buildData, jobID.String())
+		// attach policy to role
+		err = jobDriver.AttachRolePolicy(ctx, policyARN)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error updating build data")
+			logrus.WithError(err).Error("Error attaching ribber policy to role")
 			return
 		}
 
-		// write mappings to s3
-		fmt.Println("Writing mappings to S3...")
-		err = jobDriver.WriteMappings(ctx, mappings)
+		// create policy to allow user to use role
+		userPolicyArn, err := jobDriver.CreateUserPolicy(ctx)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error writing mappings to S3")
+			logrus.WithError(err).Error("Error creating user policy to assume ribble role")
 			return
 		}
 
-		// create streams for job
-		fmt.Println("Creating streams in SQS...")
-		err = jobDriver.CreateQueues(ctx, reducers)
+		// attach policy to user
+		err = jobDriver.AttachUserPolicy(ctx, userPolicyArn)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error creating the job streams")
+			logrus.WithError(err).Error("Error attaching policy to user")
 			return
 		}
 
-		// create log group and stream
-		fmt.Println("Creating log stream in CloudWatch...")
-		err = jobDriver.CreateLogsInfra(ctx)
+		fmt.Println("Ribble credentials created succesfully")
+	},
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "track",
+	Short: "Track the job",
+	Long:  `Track the job`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		// get driver config values
+		configFile := fmt.Sprintf("%s/%s/config.yaml", generators.GeneratedFilesDir, jobID)
+		conf, err := config.ReadLocalConfigFile(configFile)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error creating log group and stream")
+			logrus.WithField(
+				"File name", configFile,
+			).WithError(err).Error("Error reading config file")
 			return
 		}
 
-		// create dlq SQS for the mappers and coordinator
-		fmt.Println("Creating SQS dead-letter queue...")
-		dlqArn, err := jobDriver.CreateLambdaDLQ(ctx)
+		// add job path info to driver
+		jobID, err := uuid.Parse(jobID)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error creating the dead-letter queue for the job mappers")
+			logrus.WithError(err).Error("Error parsing ID, it must be an uuid")
 			return
 		}
 
-		// upload images to amazon ECR and create lambda function
-		fmt.Println("Uploading Lambda functions...")
-		err = jobDriver.UploadLambdaFunctions(ctx, dlqArn)
+		// set driver
+		jobDriver, err := driver.NewDriver(jobID, conf)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error creating functions")
+			logrus.WithError(err).Error("Error initializing driver")
 			return
 		}
+		jobDriver.JobID = jobID
 
-		fmt.Println("Upload successful with Job ID: ", jobDriver.JobID)
+		err = jobDriver.ReadRibbleLogs(ctx, logsSleep)
+		if err != nil {
+			var failedErr *lambdas.JobFailedError
+			if errors.As(err, &failedErr) {
+				logrus.WithField("Queue", failedErr.Failure.Queue).Error(failedErr.Error())
+				os.Exit(driver.ExitCodeFailed)
+			}
+			logrus.WithError(err).Error("Error reading logs")
+			return
+		}
 	},
 }
 
-var runCmd = &cobra.Command{
-	Use:   "run",
-	Short: "Run the job",
-	Long:  `Run the job`,
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Delete the resources created for the job",
+	Long:  `Delete the resources created for the job`,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
@@ -297,92 +581,146 @@ var runCmd = &cobra.Command{
 			"Job ID": jobID.String(),
 		})
 
-		// get build data
+		// get build data, without it the functions and repositories are not known
 		buildData, err := generators.ReadBuildData(jobDriver.JobID.String())
 		if err != nil {
-			logrus.WithError(err).Error("Error reading build data")
-			return
+			driverLogger.WithError(err).Warn("Error reading build data, functions and repositories are not deleted")
 		}
 		jobDriver.BuildData = buildData
 
-		// start coordinator
-		err = jobDriver.StartCoordinator(ctx)
+		fmt.Println("Deleting job resources...")
+		err = jobDriver.Cleanup(ctx, keepOutput)
 		if err != nil {
-			driverLogger.WithError(err).Error("Error starting the coordinator")
-			return
+			driverLogger.WithError(err).Error("Error cleaning up the job")
+			os.Exit(1)
 		}
 
-		fmt.Println("Running job: ", jobDriver.JobID)
+		fmt.Println("Cleanup successful for Job ID: ", jobDriver.JobID)
 	},
 }
 
-var setCredsCmd = &cobra.Command{
-	Use:   "set-credentials",
-	Short: "Set credentials for the job",
-	Long:  `Set credentials for the job`,
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the current phase of the job",
+	Long: `Report the current phase of the job and exit. The exit code is 0 if the job
+has completed, 1 if the status could not be read, 2 if the job is in progress,
+3 if the job doesn't exist and 4 if the job has failed`,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
-		// get verbosity for logs
-		verbosity, _ := cmd.Flags().GetCount("verbose")
-		logrus.SetLevel(logs.ConfigLogLevelToLevel(verbosity))
+		if outputFormat != "text" && outputFormat != "json" {
+			logrus.Error("The output flag must be text or json")
+			os.Exit(driver.ExitCodeError)
+		}
 
-		// set driver
-		jobDriver, err := driver.NewSetupDriver(&config.Config{
-			AccountID: accountID,
-			Username:  username,
-		})
+		// get driver config values
+		configFile := fmt.Sprintf("%s/%s/config.yaml", generators.GeneratedFilesDir, jobID)
+		conf, err := config.ReadLocalConfigFile(configFile)
 		if err != nil {
-			logrus.WithError(err).Error("Error initializing driver")
-			return
+			logrus.WithField(
+				"File name", configFile,
+			).WithError(err).Error("Error reading config file")
+			os.Exit(driver.ExitCodeError)
 		}
 
-		// create role
-		_, err = jobDriver.CreateRole(ctx)
+		// add job path info to driver
+		jobID, err := uuid.Parse(jobID)
 		if err != nil {
-			logrus.WithError(err).Error("Error creating ribble role")
-			return
+			logrus.WithError(err).Error("Error parsing ID, it must be an uuid")
+			os.Exit(driver.ExitCodeError)
 		}
 
-		// create policy
-		policyARN, err := jobDriver.CreateRolePolicy(ctx)
+		// set driver
+		jobDriver, err := driver.NewDriver(jobID, conf)
 		if err != nil {
-			logrus.WithError(err).Error("Error creating ribble policy")
-			return
+			logrus.WithError(err).Error("Error initializing driver")
+			os.Exit(driver.ExitCodeError)
 		}
+		jobDriver.JobID = jobID
 
-		// attach policy to role
-		err = jobDriver.AttachRolePolicy(ctx, policyARN)
+		// build data holds the number of mappers and reducers
+		buildData, err := generators.ReadBuildData(jobDriver.JobID.String())
 		if err != nil {
-			logrus.WithError(err).Error("Error attaching ribber policy to role")
-			return
+			logrus.WithError(err).Error("Error reading build data")
+			os.Exit(driver.ExitCodeError)
 		}
+		jobDriver.BuildData = buildData
 
-		// create policy to allow user to use role
-		userPolicyArn, err := jobDriver.CreateUserPolicy(ctx)
+		status, err := jobDriver.GetJobStatus(ctx)
 		if err != nil {
-			logrus.WithError(err).Error("Error creating user policy to assume ribble role")
-			return
+			logrus.WithField("Job ID", jobID.String()).WithError(err).Error("Error getting job status")
+			os.Exit(driver.ExitCodeError)
 		}
 
-		// attach policy to user
-		err = jobDriver.AttachUserPolicy(ctx, userPolicyArn)
+		if outputFormat == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(status)
+		} else {
+			err = printJobStatus(os.Stdout, status)
+		}
 		if err != nil {
-			logrus.WithError(err).Error("Error attaching policy to user")
-			return
+			logrus.WithError(err).Error("Error writing job status")
+			os.Exit(driver.ExitCodeError)
 		}
 
-		fmt.Println("Ribble credentials created succesfully")
+		os.Exit(status.ExitCode())
 	},
 }
 
-var logsCmd = &cobra.Command{
-	Use:   "track",
-	Short: "Track the job",
-	Long:  `Track the job`,
+// printJobStatus writes the job status as a table
+func printJobStatus(out io.Writer, status *driver.JobStatus) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Job ID:\t%s\n", status.JobID)
+	fmt.Fprintf(w, "Phase:\t%s\n", status.Phase)
+	if status.Failure != nil {
+		fmt.Fprintf(w, "Failure:\t%s\n", status.Failure.Message())
+	}
+	if status.Phase != driver.PhaseNotFound {
+		fmt.Fprintf(w, "Mappers done:\t%d/%d\n", status.MappersDone, status.NumMappers)
+		fmt.Fprintf(w, "Reducers done:\t%d/%d\n", status.ReducersDone, status.NumReducers)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "QUEUE\tMESSAGES\tIN FLIGHT\tDELAYED")
+		for _, queue := range status.Queues {
+			if !queue.Exists {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", queue.Name, queue.Messages, queue.InFlight, queue.Delayed)
+		}
+		if len(status.SkippedSplits) > 0 {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "SKIPPED SPLIT\tMAPPING\tERROR")
+			for _, split := range status.SkippedSplits {
+				fmt.Fprintf(
+					w,
+					"s3://%s/%s [%d-%d]\t%s\t%s\n",
+					split.Bucket,
+					split.Key,
+					split.InitialByte,
+					split.FinalByte,
+					split.MappingID,
+					split.Message,
+				)
+			}
+		}
+	}
+
+	return w.Flush()
+}
+
+var outputCmd = &cobra.Command{
+	Use:   "output",
+	Short: "Download and merge the output of the job",
+	Long:  `Download and merge the output of every reducer of the job into a single result`,
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
 
+		switch driver.OutputFormat(resultFormat) {
+		case driver.OutputFormatJSON, driver.OutputFormatJSONLines, driver.OutputFormatCSV:
+		default:
+			logrus.Error("The output flag must be json, jsonl or csv")
+			os.Exit(1)
+		}
+
 		// get driver config values
 		configFile := fmt.Sprintf("%s/%s/config.yaml", generators.GeneratedFilesDir, jobID)
 		conf, err := config.ReadLocalConfigFile(configFile)
@@ -390,28 +728,143 @@ var logsCmd = &cobra.Command{
 			logrus.WithField(
 				"File name", configFile,
 			).WithError(err).Error("Error reading config file")
-			return
+			os.Exit(1)
 		}
 
 		// add job path info to driver
 		jobID, err := uuid.Parse(jobID)
 		if err != nil {
 			logrus.WithError(err).Error("Error parsing ID, it must be an uuid")
-			return
+			os.Exit(1)
 		}
 
 		// set driver
 		jobDriver, err := driver.NewDriver(jobID, conf)
 		if err != nil {
 			logrus.WithError(err).Error("Error initializing driver")
-			return
+			os.Exit(1)
 		}
 		jobDriver.JobID = jobID
 
-		err = jobDriver.ReadRibbleLogs(ctx, logsSleep)
+		driverLogger := logrus.WithFields(logrus.Fields{
+			"Job ID": jobID.String(),
+		})
+
+		// the output is only complete once the job is done
+		status, err := jobDriver.GetJobStatus(ctx)
 		if err != nil {
-			logrus.WithError(err).Error("Error reading logs")
+			driverLogger.WithError(err).Error("Error getting job status")
+			os.Exit(1)
+		}
+		if status.Phase != driver.PhaseCompleted {
+			driverLogger.WithField("Phase", status.Phase).Error("The job has not completed")
+			os.Exit(1)
+		}
+
+		records, err := jobDriver.GetJobOutput(ctx, driver.SortBy(sortBy))
+		if err != nil {
+			driverLogger.WithError(err).Error("Error getting job output")
+			os.Exit(1)
+		}
+
+		// write output
+		out := os.Stdout
+		if resultFile != "" {
+			out, err = os.Create(resultFile)
+			if err != nil {
+				driverLogger.WithError(err).Error("Error creating output file")
+				os.Exit(1)
+			}
+		}
+
+		err = driver.WriteOutput(out, records, driver.OutputFormat(resultFormat))
+		if err != nil {
+			driverLogger.WithError(err).Error("Error writing job output")
+			os.Exit(1)
+		}
+
+		if resultFile != "" {
+			if err := out.Close(); err != nil {
+				driverLogger.WithError(err).Error("Error writing job output")
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var submitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Build, upload and run the job",
+	Long: `Build, upload and run the job. With --wait the command blocks until the job
+is done and the exit code is 0 if the job has completed and non-zero otherwise`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		// get verbosity for logs
+		verbosity, _ := cmd.Flags().GetCount("verbose")
+		logrus.SetLevel(logs.ConfigLogLevelToLevel(verbosity))
+
+		// build job
+		id, err := buildJob(jobPath)
+		if err != nil {
+			os.Exit(driver.ExitCodeError)
+		}
+		fmt.Println("Build successful with Job ID: ", id)
+
+		// set driver
+		fmt.Println("Creating resources...")
+		jobDriver, err := newJobDriver(id.String())
+		if err != nil {
+			os.Exit(driver.ExitCodeError)
+		}
+
+		driverLogger := logrus.WithFields(logrus.Fields{
+			"Job ID": id.String(),
+		})
+
+		// upload job
+		err = uploadJob(ctx, jobDriver, reducers)
+		if err != nil {
+			os.Exit(driver.ExitCodeError)
+		}
+
+		// start coordinator
+		err = jobDriver.StartCoordinator(ctx)
+		if err != nil {
+			driverLogger.WithError(err).Error("Error starting the coordinator")
+			os.Exit(driver.ExitCodeError)
+		}
+
+		fmt.Println("Running job: ", jobDriver.JobID)
+
+		if !wait {
 			return
 		}
+
+		// wait until the job is done
+		fmt.Println("Waiting for job to complete...")
+		waitCtx := ctx
+		if waitTimeout > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx, waitTimeout)
+			defer cancel()
+		}
+
+		status, err := jobDriver.WaitForJob(waitCtx, waitPollInterval)
+		if err != nil {
+			driverLogger.WithError(err).Error("Error waiting for the job")
+			os.Exit(driver.ExitCodeError)
+		}
+
+		if status.Failure != nil {
+			driverLogger.WithField("Queue", status.Failure.Queue).Errorf("Job failed: %s", status.Failure.Message())
+			os.Exit(status.ExitCode())
+		}
+		if status.Phase != driver.PhaseCompleted {
+			driverLogger.WithField("Phase", status.Phase).Error("The job has not completed")
+			os.Exit(status.ExitCode())
+		}
+
+		fmt.Println("Job completed successfully: ", jobDriver.JobID)
 	},
 }
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"log"
+
 	"github.com/josenarvaezp/displ/evaluation/query1"
+	"github.com/josenarvaezp/displ/pkg/records"
 	"github.com/josenarvaezp/displ/pkg/ribble"
+	// lets the job run with the in-process flag
+	_ "github.com/josenarvaezp/displ/pkg/ribble/inprocess"
 )
 
 func main() {
@@ -16,13 +21,20 @@ func main() {
 		Username:            "ribble",
 		LogicalSplit:        true,
 		RandomizedPartition: false,
+		Records: records.RecordFormat{
+			Format:  records.FormatTbl,
+			Columns: query1.LineitemColumns,
+		},
 	}
 
 	// define job
-	ribble.Job(
+	err := ribble.Job(
 		query1.Query1,
 		nil,
 		query1.Sort,
 		config,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
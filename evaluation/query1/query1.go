@@ -1,17 +1,12 @@
 package query1
 
 import (
-	"bufio"
-	"fmt"
-	"log"
 	"math"
-	"os"
 	"sort"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/records"
 )
 
 /*
@@ -66,94 +61,95 @@ const (
 	L_COMMENT
 )
 
-func Query1(filename string) aggregators.MapAggregator {
-	file, err := os.Open(filename)
+// LineitemColumns are the columns of the lineitem table
+var LineitemColumns = []string{
+	"l_orderkey",
+	"l_partkey",
+	"l_suppkey",
+	"l_linenumber",
+	"l_quantity",
+	"l_extendedprice",
+	"l_discount",
+	"l_tax",
+	"l_returnflag",
+	"l_linestatus",
+	"l_shipdate",
+	"l_commitdate",
+	"l_receiptdate",
+	"l_shipinstruct",
+	"l_shipmode",
+	"l_comment",
+}
+
+func Query1(record records.Record, output aggregators.MapAggregator) error {
+	shipDate, err := record.Date(L_SHIPDATE)
+	if err != nil {
+		return err
+	}
+
+	finalDate := time.Date(1998, 12, 01, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -90)
+	if finalDate.Before(shipDate) {
+		// skip
+		return nil
+	}
+
+	// group by fields
+	returnflag, err := record.String(L_RETURNFLAG)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
-
-	// init output map
-	output := aggregators.NewMap()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		fields := strings.Split(scanner.Text(), "|")
-
-		if len(fields) != 17 {
-			// incorrect number of fields read
-			continue
-		}
-
-		shipdateValue := fields[L_SHIPDATE]
-		year, _ := strconv.Atoi(shipdateValue[0:4])
-		month, _ := strconv.Atoi(shipdateValue[6:7])
-		day, _ := strconv.Atoi(shipdateValue[9:10])
-		shipDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
-
-		finalDate := time.Date(1998, 12, 01, 0, 0, 0, 0, time.Local).AddDate(0, 0, -90)
-		if finalDate.Before(shipDate) {
-			// skip
-			continue
-		}
-
-		// group by fields
-		returnflag := fields[L_RETURNFLAG]
-		linestatus := fields[L_LINESTATUS]
-
-		// retrieve values as integers
-		quantity, err := convertToFloat(fields[L_QUANTITY])
-		if err != nil {
-			log.Fatal("error converting quantity to int")
-		}
-
-		extendedPrice, err := convertToFloat(fields[L_EXTENDEDPRICE])
-		if err != nil {
-			log.Fatal("error converting quantity to int")
-		}
-
-		discount, err := convertToFloat(fields[L_DISCOUNT])
-		if err != nil {
-			log.Fatal("error converting quantity to int")
-		}
-
-		tax, err := convertToFloat(fields[L_TAX])
-		if err != nil {
-			log.Fatal("error converting quantity to int")
-		}
-
-		discPrice := extendedPrice * (1 - discount)
-		charge := extendedPrice * (1 - discount) * (1 + tax)
-
-		sumQuantityKey := fmt.Sprintf("%s-%s-l_quantity_sum", returnflag, linestatus)
-		sumBasePriceKey := fmt.Sprintf("%s-%s-l_base_price_sum", returnflag, linestatus)
-		sumDiscPriceKey := fmt.Sprintf("%s-%s-l_disc_price_sum", returnflag, linestatus)
-		sumChargeKey := fmt.Sprintf("%s-%s-l_charge_sum", returnflag, linestatus)
-		avgQuantityKey := fmt.Sprintf("%s-%s-quantity_avg", returnflag, linestatus)
-		avgPriceKey := fmt.Sprintf("%s-%s-avg_price", returnflag, linestatus)
-		avgDiscKey := fmt.Sprintf("%s-%s-avg_disc", returnflag, linestatus)
-		sumCountKey := fmt.Sprintf("%s-%s-count", returnflag, linestatus)
-
-		// sum values
-		output.AddSum(sumQuantityKey, quantity)
-		output.AddSum(sumBasePriceKey, extendedPrice)
-		output.AddSum(sumDiscPriceKey, discPrice)
-		output.AddSum(sumChargeKey, charge)
-
-		// count
-		output.AddSum(sumCountKey, 1)
-
-		// Avg values
-		output.AddAvg(avgQuantityKey, quantity)
-		output.AddAvg(avgPriceKey, extendedPrice)
-		output.AddAvg(avgDiscKey, discount)
+	linestatus, err := record.String(L_LINESTATUS)
+	if err != nil {
+		return err
 	}
 
-	return output
+	// retrieve values as floats
+	quantity, err := getFloat(record, L_QUANTITY)
+	if err != nil {
+		return err
+	}
+
+	extendedPrice, err := getFloat(record, L_EXTENDEDPRICE)
+	if err != nil {
+		return err
+	}
+
+	discount, err := getFloat(record, L_DISCOUNT)
+	if err != nil {
+		return err
+	}
+
+	tax, err := getFloat(record, L_TAX)
+	if err != nil {
+		return err
+	}
+
+	discPrice := extendedPrice * (1 - discount)
+	charge := extendedPrice * (1 - discount) * (1 + tax)
+
+	// each aggregate is a field of the row of the group
+	key := aggregators.NewStringGroupKey(returnflag, linestatus)
+
+	// sum values
+	output.AddSum(key.Field("sum_qty"), quantity)
+	output.AddSum(key.Field("sum_base_price"), extendedPrice)
+	output.AddSum(key.Field("sum_disc_price"), discPrice)
+	output.AddSum(key.Field("sum_charge"), charge)
+
+	// count
+	output.AddCount(key.Field("count_order"))
+
+	// Avg values
+	output.AddAvg(key.Field("avg_qty"), quantity)
+	output.AddAvg(key.Field("avg_price"), extendedPrice)
+	output.AddAvg(key.Field("avg_disc"), discount)
+
+	return nil
 }
 
-func convertToFloat(value string) (float64, error) {
-	floatValue, err := strconv.ParseFloat(value, 64)
+// getFloat returns the field of the record as a float
+func getFloat(record records.Record, field int) (float64, error) {
+	floatValue, err := record.Float(field)
 	if err != nil {
 		return 0, err
 	}
@@ -169,23 +165,20 @@ type AggregatorPairList []aggregators.AggregatorPair
 func (p AggregatorPairList) Len() int      { return len(p) }
 func (p AggregatorPairList) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 func (p AggregatorPairList) Less(i, j int) bool {
-
-	keyArrI := strings.Split(p[i].Key, "-")
-	returnFlagI := keyArrI[0]
-	lineStatusI := keyArrI[1]
-
-	keyArrJ := strings.Split(p[j].Key, "-")
-	returnFlagJ := keyArrJ[0]
-	lineStatusJ := keyArrJ[1]
-
-	if returnFlagI == returnFlagJ {
-		return lineStatusI < lineStatusJ
+	// keys are ordered by returnflag and linestatus
+	keyI, fieldI, errI := aggregators.ParseGroupKeyField(p[i].Key)
+	keyJ, fieldJ, errJ := aggregators.ParseGroupKeyField(p[j].Key)
+	if errI != nil || errJ != nil {
+		return p[i].Key < p[j].Key
 	}
 
-	return returnFlagI < returnFlagJ
+	if cmp := aggregators.CompareGroupKeys(keyI, keyJ); cmp != 0 {
+		return cmp < 0
+	}
+	return fieldI < fieldJ
 }
 
-// Sort sorts the output by value in ascending order
+// Sort sorts the output by returnflag and linestatus in ascending order
 func Sort(ma aggregators.MapAggregator) sort.Interface {
 	keys := make(AggregatorPairList, len(ma))
 	i := 0
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"log"
+
 	"github.com/josenarvaezp/displ/evaluation/query6"
+	"github.com/josenarvaezp/displ/pkg/records"
 	"github.com/josenarvaezp/displ/pkg/ribble"
+	// lets the job run with the in-process flag
+	_ "github.com/josenarvaezp/displ/pkg/ribble/inprocess"
 )
 
 func main() {
@@ -16,13 +21,20 @@ func main() {
 		Username:            "ribble",
 		LogicalSplit:        true,
 		RandomizedPartition: true,
+		Records: records.RecordFormat{
+			Format:  records.FormatTbl,
+			Columns: query6.LineitemColumns,
+		},
 	}
 
 	// define job
-	ribble.Job(
+	err := ribble.Job(
 		query6.Query6,
 		nil,
 		nil,
 		config,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,14 +1,10 @@
 package query6
 
 import (
-	"bufio"
-	"log"
-	"os"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/records"
 )
 
 /*
@@ -57,58 +53,62 @@ type values struct {
 	extendedPrice float64
 }
 
-func Query6(filename string) aggregators.MapAggregator {
-	file, err := os.Open(filename)
+// LineitemColumns are the columns of the lineitem table
+var LineitemColumns = []string{
+	"l_orderkey",
+	"l_partkey",
+	"l_suppkey",
+	"l_linenumber",
+	"l_quantity",
+	"l_extendedprice",
+	"l_discount",
+	"l_tax",
+	"l_returnflag",
+	"l_linestatus",
+	"l_shipdate",
+	"l_commitdate",
+	"l_receiptdate",
+	"l_shipinstruct",
+	"l_shipmode",
+	"l_comment",
+}
+
+func Query6(record records.Record, output aggregators.MapAggregator) error {
+	// get values
+	lineValues, err := getValues(record)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
-
-	// init output map
-	output := aggregators.NewMap()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		// read line and get values
-		fields := strings.Split(scanner.Text(), "|")
-
-		if len(fields) != 17 {
-			// incorrect number of fields read
-			continue
-		}
 
-		lineValues := getValues(fields)
-
-		if lineValues.skip() {
-			// skip record as it doesn't accept the
-			// Where statement of the query
-			continue
-		}
-
-		// sum values
-		output.AddSum("revenue", lineValues.extendedPrice*lineValues.discount)
+	if lineValues.skip() {
+		// skip record as it doesn't accept the
+		// Where statement of the query
+		return nil
 	}
 
-	return output
+	// sum values
+	return output.AddSum("revenue", lineValues.extendedPrice*lineValues.discount)
 }
 
-func getValues(fields []string) *values {
-	shipDate := getShipDate(fields[L_SHIPDATE])
+func getValues(record records.Record) (*values, error) {
+	shipDate, err := record.Date(L_SHIPDATE)
+	if err != nil {
+		return nil, err
+	}
 
-	// retrieve values as integers
-	quantity, err := convertToFloat(fields[L_QUANTITY])
+	quantity, err := record.Float(L_QUANTITY)
 	if err != nil {
-		log.Fatal("error converting quantity to int")
+		return nil, err
 	}
 
-	extendedPrice, err := convertToFloat(fields[L_EXTENDEDPRICE])
+	extendedPrice, err := record.Float(L_EXTENDEDPRICE)
 	if err != nil {
-		log.Fatal("error converting quantity to int")
+		return nil, err
 	}
 
-	discount, err := convertToFloat(fields[L_DISCOUNT])
+	discount, err := record.Float(L_DISCOUNT)
 	if err != nil {
-		log.Fatal("error converting quantity to int")
+		return nil, err
 	}
 
 	return &values{
@@ -116,18 +116,11 @@ func getValues(fields []string) *values {
 		quantity:      quantity,
 		extendedPrice: extendedPrice,
 		discount:      discount,
-	}
-}
-
-func getShipDate(shipdateValue string) time.Time {
-	year, _ := strconv.Atoi(shipdateValue[0:4])
-	month, _ := strconv.Atoi(shipdateValue[6:7])
-	day, _ := strconv.Atoi(shipdateValue[9:10])
-	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+	}, nil
 }
 
 func (v *values) skip() bool {
-	dateCondition := time.Date(1994, 01, 01, 0, 0, 0, 0, time.Local)
+	dateCondition := time.Date(1994, 01, 01, 0, 0, 0, 0, time.UTC)
 
 	if v.shipDate.Before(dateCondition) {
 		return true
@@ -147,12 +140,3 @@ func (v *values) skip() bool {
 
 	return false
 }
-
-func convertToFloat(value string) (float64, error) {
-	floatValue, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	return floatValue, nil
-}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"log"
+
 	"github.com/josenarvaezp/displ/examples/wordcount"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
 	"github.com/josenarvaezp/displ/pkg/ribble"
+	// lets the job run with the in-process flag
+	_ "github.com/josenarvaezp/displ/pkg/ribble/inprocess"
 )
 
 func main() {
@@ -16,13 +21,17 @@ func main() {
 		Username:            "my-iam-user",
 		LogicalSplit:        true,
 		RandomizedPartition: false,
+		MapperFailurePolicy: lambdas.SkipFailedSplits,
 	}
 
 	// define job
-	ribble.Job(
+	err := ribble.Job(
 		wordcount.WordCount,
 		nil,
 		wordcount.Sort,
 		config,
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -2,7 +2,6 @@ package wordcount
 
 import (
 	"bufio"
-	"log"
 	"os"
 	"sort"
 	"strings"
@@ -10,10 +9,12 @@ import (
 	"github.com/josenarvaezp/displ/pkg/aggregators"
 )
 
-func WordCount(filename string) aggregators.MapAggregator {
+// WordCount counts the words of the file. Its errors are handled with the
+// mapper failure policy of the job instead of stopping the mapper
+func WordCount(filename string) (aggregators.MapAggregator, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
 
@@ -26,14 +27,17 @@ func WordCount(filename string) aggregators.MapAggregator {
 		line := scanner.Text()
 		words := strings.Fields(line)
 		for _, word := range words {
-			output.AddSum(word, 1)
+			output.AddCount(word)
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-	return output
+	return output, nil
 }
 
-// Having filters the words that have less than 5 in their sum
+// Having filters the words that have a count of less than 5
 func Having(mapAggregator aggregators.MapAggregator) aggregators.MapAggregator {
 	// delete all items from map that have less then 5 count
 	for key, aggregator := range mapAggregator {
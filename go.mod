@@ -16,6 +16,7 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/sqs v1.13.1
 	github.com/aws/aws-sdk-go-v2/service/sts v1.11.1
 	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.15.1
 	github.com/kr/text v0.2.0 // indirect
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.3.0
@@ -0,0 +1,38 @@
+package compression
+
+import "bytes"
+
+// Bzip2HeaderSize is the size of the header of a bzip2 stream followed
+// by the magic number of its first block
+const Bzip2HeaderSize = 10
+
+// bzip2BlockMagic is the magic number that starts the blocks of a bzip2 stream,
+// the first block of a stream starts right after the stream header
+var bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+
+// LastBzip2Stream returns the index of the last bzip2 stream that starts in data or -1.
+// Objects written by parallel compressors like pbzip2 or lbzip2 are concatenated
+// streams, and the streams are byte aligned so they can be decompressed on their own
+func LastBzip2Stream(data []byte) int {
+	for end := len(data); end > 0; {
+		index := bytes.LastIndex(data[:end], []byte("BZh"))
+		if index == -1 {
+			return -1
+		}
+
+		if isBzip2Header(data[index:]) {
+			return index
+		}
+		end = index + 2
+	}
+
+	return -1
+}
+
+// isBzip2Header reports whether data starts with a bzip2 stream header,
+// which is BZh, the block size from 1 to 9 and the magic number of a block
+func isBzip2Header(data []byte) bool {
+	return len(data) >= Bzip2HeaderSize &&
+		data[3] >= '1' && data[3] <= '9' &&
+		bytes.Equal(data[4:Bzip2HeaderSize], bzip2BlockMagic)
+}
@@ -0,0 +1,78 @@
+package compression
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is the compression codec of an object
+type Codec string
+
+const (
+	None  Codec = ""
+	Gzip  Codec = "gzip"
+	Zstd  Codec = "zstd"
+	Bzip2 Codec = "bzip2"
+)
+
+// extensions maps the extensions of compressed objects to their codec
+var extensions = map[string]Codec{
+	".gz":   Gzip,
+	".gzip": Gzip,
+	".zst":  Zstd,
+	".zstd": Zstd,
+	".bz2":  Bzip2,
+}
+
+// contentEncodings maps the content encodings of compressed objects to their codec
+var contentEncodings = map[string]Codec{
+	"gzip":    Gzip,
+	"x-gzip":  Gzip,
+	"zstd":    Zstd,
+	"bzip2":   Bzip2,
+	"x-bzip2": Bzip2,
+}
+
+// FromKey returns the codec of an object given the extension of its key
+func FromKey(key string) Codec {
+	return extensions[strings.ToLower(path.Ext(key))]
+}
+
+// FromContentEncoding returns the codec of an object given its content encoding.
+// When several encodings are listed the last one is the outermost, which is the
+// one that has to be decoded first
+func FromContentEncoding(contentEncoding string) Codec {
+	encodings := strings.Split(contentEncoding, ",")
+	encoding := strings.ToLower(strings.TrimSpace(encodings[len(encodings)-1]))
+
+	return contentEncodings[encoding]
+}
+
+// NewReader returns a reader that decompresses the data read from reader. Readers
+// of all codecs accept concatenated streams or frames, so a range that starts and
+// ends at frame boundaries can be decompressed on its own
+func NewReader(codec Codec, reader io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case None:
+		return ioutil.NopCloser(reader), nil
+	case Gzip:
+		return gzip.NewReader(reader)
+	case Zstd:
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case Bzip2:
+		return ioutil.NopCloser(bzip2.NewReader(reader)), nil
+	default:
+		return nil, fmt.Errorf("Unsupported compression codec %q", codec)
+	}
+}
@@ -0,0 +1,166 @@
+package compression_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/josenarvaezp/displ/internal/compression"
+	"github.com/josenarvaezp/displ/internal/compression/compressiontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openBytes returns an OpenFunction that reads the ranges of data
+func openBytes(data []byte) compression.OpenFunction {
+	return func(initialByte, finalByte int64) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data[initialByte : finalByte+1])), nil
+	}
+}
+
+func Test_FromKeyAndContentEncoding(t *testing.T) {
+	assert.Equal(t, compression.Gzip, compression.FromKey("logs/2022/01/01.log.gz"))
+	assert.Equal(t, compression.Zstd, compression.FromKey("logs/events.ZST"))
+	assert.Equal(t, compression.Bzip2, compression.FromKey("lineitem.tbl.bz2"))
+	assert.Equal(t, compression.None, compression.FromKey("lineitem.tbl"))
+
+	assert.Equal(t, compression.Gzip, compression.FromContentEncoding("x-gzip"))
+	assert.Equal(t, compression.Zstd, compression.FromContentEncoding("gzip, zstd"))
+	assert.Equal(t, compression.None, compression.FromContentEncoding("identity"))
+	assert.Equal(t, compression.None, compression.FromContentEncoding(""))
+}
+
+func Test_ReadSeekTable(t *testing.T) {
+	chunks := []string{"the quick brown\nfox jum", "ps over the\nlazy dog\n"}
+
+	for _, checksums := range []bool{false, true} {
+		object, err := compressiontest.SeekableZstd(chunks, checksums)
+		require.Nil(t, err)
+
+		footer := object[len(object)-compression.SeekTableFooterSize:]
+		seekTableSize := compression.SeekTableSize(footer)
+		require.NotZero(t, seekTableSize)
+
+		frames, err := compression.ReadSeekTable(object[int64(len(object))-seekTableSize:])
+		require.Nil(t, err)
+		require.Len(t, frames, 2)
+
+		assert.Equal(t, int64(0), frames[0].Offset)
+		assert.Equal(t, frames[0].CompressedSize, frames[1].Offset)
+		assert.Equal(t, int64(len(object))-seekTableSize, frames[1].Offset+frames[1].CompressedSize)
+		assert.Equal(t, int64(len(chunks[0])), frames[0].DecompressedSize)
+		assert.Equal(t, int64(len(chunks[1])), frames[1].DecompressedSize)
+	}
+
+	// objects without a seek table are not seekable
+	gzipped, err := compressiontest.Gzip("the quick brown fox")
+	require.Nil(t, err)
+	assert.Zero(t, compression.SeekTableSize(gzipped[len(gzipped)-compression.SeekTableFooterSize:]))
+
+	_, err = compression.ReadSeekTable(gzipped)
+	assert.EqualError(t, err, "Invalid zstd seek table")
+}
+
+func Test_LastBzip2Stream(t *testing.T) {
+	object := compressiontest.Bzip2()
+	first := len(compressiontest.Bzip2Streams[0])
+	second := first + len(compressiontest.Bzip2Streams[1])
+
+	assert.Equal(t, second, compression.LastBzip2Stream(object))
+	assert.Equal(t, first, compression.LastBzip2Stream(object[:second]))
+	assert.Equal(t, 0, compression.LastBzip2Stream(object[:first]))
+	assert.Equal(t, -1, compression.LastBzip2Stream(object[1:first]))
+
+	// the header of the stream must be complete
+	assert.Equal(t, first, compression.LastBzip2Stream(object[:second+compression.Bzip2HeaderSize-1]))
+}
+
+func Test_OpenRange(t *testing.T) {
+	lines := "the quick brown\nfox jumps over the\nlazy dog\nand the cat\n"
+
+	zstdObject, err := compressiontest.SeekableZstd([]string{
+		"the quick brown\nfox jum",
+		"ps over the\nlazy dog\nand the c",
+		"at\n",
+	}, false)
+	require.Nil(t, err)
+
+	footer := zstdObject[len(zstdObject)-compression.SeekTableFooterSize:]
+	frames, err := compression.ReadSeekTable(zstdObject[int64(len(zstdObject))-compression.SeekTableSize(footer):])
+	require.Nil(t, err)
+
+	gzipObject, err := compressiontest.Gzip(lines)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		codec  compression.Codec
+		object []byte
+		// boundaries are the starts of the ranges
+		boundaries []int64
+		// size is the size of the compressed data
+		size int64
+	}{
+		{
+			name:       "bzip2 split by streams",
+			codec:      compression.Bzip2,
+			object:     compressiontest.Bzip2(),
+			boundaries: []int64{0, int64(len(compressiontest.Bzip2Streams[0])), int64(len(compressiontest.Bzip2Streams[0]) + len(compressiontest.Bzip2Streams[1]))},
+			size:       int64(len(compressiontest.Bzip2())),
+		},
+		{
+			name:       "zstd split by frames",
+			codec:      compression.Zstd,
+			object:     zstdObject,
+			boundaries: []int64{frames[0].Offset, frames[1].Offset, frames[2].Offset},
+			size:       frames[2].Offset + frames[2].CompressedSize,
+		},
+		{
+			name:       "zstd with a range of two frames",
+			codec:      compression.Zstd,
+			object:     zstdObject,
+			boundaries: []int64{frames[0].Offset, frames[2].Offset},
+			size:       frames[2].Offset + frames[2].CompressedSize,
+		},
+		{
+			name:       "zstd whole object with the seek table",
+			codec:      compression.Zstd,
+			object:     zstdObject,
+			boundaries: []int64{0},
+			size:       int64(len(zstdObject)),
+		},
+		{
+			name:       "gzip whole object",
+			codec:      compression.Gzip,
+			object:     gzipObject,
+			boundaries: []int64{0},
+			size:       int64(len(gzipObject)),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// each line is read by a single range
+			var output []string
+			for i, initialByte := range test.boundaries {
+				finalByte := test.size - 1
+				if i < len(test.boundaries)-1 {
+					finalByte = test.boundaries[i+1] - 1
+				}
+
+				reader, err := compression.OpenRange(test.codec, initialByte, finalByte, test.size, openBytes(test.object))
+				require.Nil(t, err)
+
+				data, err := ioutil.ReadAll(reader)
+				require.Nil(t, err)
+				require.Nil(t, reader.Close())
+
+				output = append(output, string(data))
+			}
+
+			assert.Equal(t, lines, strings.Join(output, ""))
+		})
+	}
+}
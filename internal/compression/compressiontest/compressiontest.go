@@ -0,0 +1,105 @@
+// Package compressiontest writes small compressed objects used to test how
+// the framework splits and decompresses them
+package compressiontest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Bzip2Streams are bzip2 streams, each one compressed on its own, that decompress
+// to "the quick brown\nfox jum", "ps over the\nlazy dog\nand the c" and "at\n".
+// Concatenated they are a multi-stream object like the ones written by pbzip2
+var Bzip2Streams = [][]byte{
+	{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xc8, 0x78,
+		0x28, 0x2c, 0x00, 0x00, 0x09, 0x51, 0x80, 0x00, 0x10, 0x40, 0x00, 0x1b,
+		0x7b, 0xb6, 0xc0, 0x20, 0x00, 0x22, 0x81, 0xa6, 0x8c, 0x99, 0x33, 0x50,
+		0xa6, 0x4c, 0x4c, 0x83, 0x23, 0x15, 0x01, 0x92, 0x21, 0xc4, 0x92, 0xdc,
+		0x07, 0x27, 0x81, 0x22, 0x9f, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0xc8,
+		0x78, 0x28, 0x2c,
+	},
+	{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x35, 0x6a,
+		0x8c, 0xe0, 0x00, 0x00, 0x0b, 0x51, 0x80, 0x00, 0x10, 0x40, 0x00, 0x2e,
+		0xc5, 0xdd, 0x30, 0x20, 0x00, 0x31, 0x4d, 0x32, 0x31, 0x31, 0x31, 0x08,
+		0x80, 0xc2, 0x34, 0xf5, 0x3d, 0x42, 0xaa, 0x10, 0x21, 0x76, 0xf3, 0x59,
+		0x42, 0x0a, 0x30, 0x86, 0xe8, 0x37, 0x47, 0xf3, 0x7c, 0x5d, 0xc9, 0x14,
+		0xe1, 0x42, 0x40, 0xd5, 0xaa, 0x33, 0x80,
+	},
+	{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x1f, 0x67,
+		0xa9, 0x5e, 0x00, 0x00, 0x00, 0xc1, 0x80, 0x00, 0x10, 0x20, 0x00, 0x04,
+		0x00, 0x20, 0x00, 0x21, 0x98, 0x19, 0x84, 0x61, 0x77, 0x24, 0x53, 0x85,
+		0x09, 0x01, 0xf6, 0x7a, 0x95, 0xe0,
+	},
+}
+
+// Bzip2 returns the concatenated Bzip2Streams
+func Bzip2() []byte {
+	return bytes.Join(Bzip2Streams, nil)
+}
+
+// Gzip returns the data compressed with gzip
+func Gzip(data string) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// SeekableZstd returns a seekable zstd object with a frame per chunk and the seek
+// table at the end, where the entries of the seek table have checksums if checksums
+// is true. The checksums are not verified by the framework so they are zero
+func SeekableZstd(chunks []string, checksums bool) ([]byte, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Close()
+
+	var object, entries []byte
+	for _, chunk := range chunks {
+		frame := encoder.EncodeAll([]byte(chunk), nil)
+		object = append(object, frame...)
+
+		entries = appendUint32(entries, uint32(len(frame)))
+		entries = appendUint32(entries, uint32(len(chunk)))
+		if checksums {
+			entries = appendUint32(entries, 0)
+		}
+	}
+
+	// the footer has the number of frames, the descriptor and the seekable magic number
+	descriptor := byte(0)
+	if checksums {
+		descriptor = 1 << 7
+	}
+	footer := appendUint32(nil, uint32(len(chunks)))
+	footer = append(footer, descriptor)
+	footer = appendUint32(footer, 0x8F92EAB1)
+
+	// the seek table is a skippable frame
+	object = appendUint32(object, 0x184D2A5E)
+	object = appendUint32(object, uint32(len(entries)+len(footer)))
+	object = append(object, entries...)
+	object = append(object, footer...)
+
+	return object, nil
+}
+
+// appendUint32 appends the little endian value to data
+func appendUint32(data []byte, value uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], value)
+	return append(data, buf[:]...)
+}
@@ -0,0 +1,156 @@
+package compression
+
+import (
+	"bufio"
+	"io"
+)
+
+// OpenFunction opens the bytes of an object from initialByte to finalByte
+type OpenFunction func(initialByte, finalByte int64) (io.ReadCloser, error)
+
+// OpenRange returns a reader of the lines in the decompressed range of a compressed
+// object, where the range starts and ends at frame boundaries and size is the size
+// of the compressed data. Lines don't end at frame boundaries, so a range that doesn't
+// start the object skips the line that starts in the previous range, and a range that
+// doesn't end the object reads its last line until its end in the next ranges
+func OpenRange(codec Codec, initialByte, finalByte, size int64, open OpenFunction) (io.ReadCloser, error) {
+	reader, err := decompressRange(codec, initialByte, finalByte, open)
+	if err != nil {
+		return nil, err
+	}
+
+	if finalByte+1 < size {
+		// the last line ends in the next ranges, which are only opened if needed
+		tail := &firstLineReader{
+			open: func() (io.ReadCloser, error) {
+				return decompressRange(codec, finalByte+1, size-1, open)
+			},
+		}
+		reader.Reader = io.MultiReader(reader.Reader, tail)
+		reader.closers = append(reader.closers, tail)
+	}
+
+	if initialByte > 0 {
+		// the first line is read by the previous range
+		reader.Reader = &skipFirstLineReader{reader: bufio.NewReader(reader.Reader)}
+	}
+
+	return reader, nil
+}
+
+// decompressRange returns a reader that decompresses the bytes of the range
+func decompressRange(codec Codec, initialByte, finalByte int64, open OpenFunction) (*rangeReader, error) {
+	body, err := open(initialByte, finalByte)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := NewReader(codec, body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	return &rangeReader{
+		Reader:  decompressed,
+		closers: []io.Closer{decompressed, body},
+	}, nil
+}
+
+// rangeReader reads a range and closes the readers it is made of
+type rangeReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the readers of the range
+func (r *rangeReader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// skipFirstLineReader discards the first line, including its end of line, before reading
+type skipFirstLineReader struct {
+	reader  *bufio.Reader
+	skipped bool
+}
+
+func (r *skipFirstLineReader) Read(p []byte) (int, error) {
+	for !r.skipped {
+		_, err := r.reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// the line is longer than the buffer
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.skipped = true
+	}
+
+	return r.reader.Read(p)
+}
+
+// firstLineReader reads the first line, including its end of line,
+// of a reader that is opened on the first read
+type firstLineReader struct {
+	open   func() (io.ReadCloser, error)
+	body   io.ReadCloser
+	reader *bufio.Reader
+	done   bool
+}
+
+func (r *firstLineReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	if r.reader == nil {
+		body, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+		r.reader = bufio.NewReader(body)
+	}
+
+	n := 0
+	for n < len(p) {
+		b, err := r.reader.ReadByte()
+		if err == io.EOF {
+			r.done = true
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+
+		p[n] = b
+		n++
+		if b == '\n' {
+			r.done = true
+			break
+		}
+	}
+
+	if n == 0 && r.done {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// Close closes the reader if it was opened
+func (r *firstLineReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	return r.body.Close()
+}
@@ -0,0 +1,80 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// SeekTableFooterSize is the size of the footer at the end of seekable zstd objects
+	SeekTableFooterSize = 9
+
+	// skippableFrameMagic is the magic number of the skippable frame with the seek table
+	skippableFrameMagic uint32 = 0x184D2A5E
+	// seekableMagic is the magic number at the end of the seek table footer
+	seekableMagic uint32 = 0x8F92EAB1
+	// skippableFrameHeaderSize is the size of the magic number and the frame size
+	skippableFrameHeaderSize = 8
+	// checksumFlag is the bit of the descriptor set when the entries have checksums
+	checksumFlag = 1 << 7
+)
+
+var errInvalidSeekTable = errors.New("Invalid zstd seek table")
+
+// Frame is a frame of a seekable zstd object
+type Frame struct {
+	Offset           int64
+	CompressedSize   int64
+	DecompressedSize int64
+}
+
+// SeekTableSize returns the size of the skippable frame with the seek table given the
+// footer at the end of the object. Zero is returned for objects that are not seekable
+func SeekTableSize(footer []byte) int64 {
+	if len(footer) != SeekTableFooterSize || binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return 0
+	}
+
+	numFrames := int64(binary.LittleEndian.Uint32(footer))
+	return skippableFrameHeaderSize + numFrames*entrySize(footer[4]) + SeekTableFooterSize
+}
+
+// ReadSeekTable returns the frames described by the skippable frame with the seek table,
+// see https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+func ReadSeekTable(seekTable []byte) ([]Frame, error) {
+	if len(seekTable) < skippableFrameHeaderSize+SeekTableFooterSize {
+		return nil, errInvalidSeekTable
+	}
+
+	footer := seekTable[len(seekTable)-SeekTableFooterSize:]
+	if SeekTableSize(footer) != int64(len(seekTable)) ||
+		binary.LittleEndian.Uint32(seekTable) != skippableFrameMagic ||
+		int(binary.LittleEndian.Uint32(seekTable[4:])) != len(seekTable)-skippableFrameHeaderSize {
+		return nil, errInvalidSeekTable
+	}
+
+	// the entries have the compressed and decompressed size of each frame
+	size := entrySize(footer[4])
+	numFrames := int(binary.LittleEndian.Uint32(footer))
+	frames := make([]Frame, numFrames)
+	offset := int64(0)
+	for i := range frames {
+		entry := seekTable[skippableFrameHeaderSize+int64(i)*size:]
+		frames[i] = Frame{
+			Offset:           offset,
+			CompressedSize:   int64(binary.LittleEndian.Uint32(entry)),
+			DecompressedSize: int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		offset += frames[i].CompressedSize
+	}
+
+	return frames, nil
+}
+
+// entrySize returns the size of the entries of the seek table given its descriptor
+func entrySize(descriptor byte) int64 {
+	if descriptor&checksumFlag != 0 {
+		return 12
+	}
+	return 8
+}
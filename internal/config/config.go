@@ -17,17 +17,31 @@ const (
 	INTERNAL_LOCALSTACK_HOST_NAME = "localstack"
 	EXTERNAL_LOCALSTACK_HOST_NAME = "localhost"
 	LOCALSTACK_PORT               = 4566
+
+	// ParquetFormat is the format of Parquet input, which is split by row groups
+	ParquetFormat = "parquet"
 )
 
 // Config represents the configuration file specified by the user
 type Config struct {
 	InputBuckets []string `yaml:"input"`
+	OutputBucket string   `yaml:"output"`
+	OutputPrefix string   `yaml:"outputPrefix"`
 	Region       string   `yaml:"region"`
 	Local        bool     `yaml:"local"`
 	LogLevel     int      `yaml:"logLevel"`
 	AccountID    string   `yaml:"accountID"`
 	Username     string   `yaml:"username"`
 	LogicalSplit bool     `yaml:"logicalSplit"`
+	Records      Records  `yaml:"records,omitempty"`
+	// DetectContentEncoding reads the content encoding of the input objects
+	// without a known compression extension to find if they are compressed
+	DetectContentEncoding bool `yaml:"detectContentEncoding,omitempty"`
+}
+
+// Records describes the records of the input objects
+type Records struct {
+	Format string `yaml:"format,omitempty"`
 }
 
 // ReadLocalConfigFile reads the config file from the driver's file system
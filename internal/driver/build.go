@@ -2,8 +2,11 @@ package driver
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/josenarvaezp/displ/internal/generators"
@@ -49,6 +52,41 @@ func (d *Driver) GenerateResourcesFromBinary() error {
 	return nil
 }
 
+// RunBinaryInProcess runs the binary generated from BuildJobGenerationBinary so that
+// the job runs in-process with the given local input and data directory, which can
+// be empty. A zero number of reducers or poll interval uses the defaults of the job.
+// The output and logs of the job are streamed to stdout and stderr
+func (d *Driver) RunBinaryInProcess(input, dataDir string, numReducers int, pollInterval time.Duration) error {
+	jobBinaryName := fmt.Sprintf( // BUILD_DIR/gen_job
+		"%s/%s",
+		d.BuildData.BuildDir,
+		generators.BinaryNameToBuildJob,
+	)
+	args := []string{
+		generators.InProcessFlag,
+		generators.JobIdFlag,
+		d.JobID.String(),
+	}
+	if input != "" {
+		args = append(args, generators.InputFlag, input)
+	}
+	if dataDir != "" {
+		args = append(args, generators.DataDirFlag, dataDir)
+	}
+	if numReducers != 0 {
+		args = append(args, generators.ReducersFlag, strconv.Itoa(numReducers))
+	}
+	if pollInterval != 0 {
+		args = append(args, generators.PollIntervalFlag, pollInterval.String())
+	}
+
+	cmd := exec.Command(jobBinaryName, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
 func (d *Driver) BuildAggregatorImages() error {
 	_, err := exec.Command(generators.ScriptToBuildAggregatorImages).Output()
 	if err != nil {
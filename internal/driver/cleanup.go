@@ -0,0 +1,291 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	logsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrTypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxDeleteObjects is the maximum number of keys that can be deleted in one request
+	maxDeleteObjects = 1000
+	// maxListQueues is the maximum number of queues returned in one request
+	maxListQueues = 1000
+)
+
+// Cleanup deletes the resources created for the job: the job bucket, the queues, the
+// lambda functions, the image repositories and the log group and stream. If keepOutput
+// is true the output objects and the job bucket are kept. Resources that don't exist are
+// ignored so cleanup can be run again after a partial failure. Every resource is tried
+// even if deleting a previous one fails
+func (d *Driver) Cleanup(ctx context.Context, keepOutput bool) error {
+	steps := []struct {
+		name    string
+		cleanup func(ctx context.Context) error
+	}{
+		{"job bucket", func(ctx context.Context) error { return d.DeleteJobBucket(ctx, keepOutput) }},
+		{"queues", d.DeleteQueues},
+		{"lambda functions", d.DeleteLambdaFunctions},
+		{"image repositories", d.DeleteImageRepos},
+		{"logs", d.DeleteLogsInfra},
+	}
+
+	failed := []string{}
+	for _, step := range steps {
+		if err := step.cleanup(ctx); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"Job ID":   d.JobID.String(),
+				"Resource": step.name,
+			}).WithError(err).Error("Error deleting job resources")
+			failed = append(failed, step.name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Error deleting job resources: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// DeleteJobBucket deletes the objects in the job bucket, including the checkpoints,
+// and the bucket itself. If keepOutput is true the output objects and the bucket are kept
+func (d *Driver) DeleteJobBucket(ctx context.Context, keepOutput bool) error {
+	bucket := d.JobID.String()
+
+	// get all keys to delete
+	keys := []s3Types.ObjectIdentifier{}
+	keptOutput := false
+	var continuationToken *string
+	for {
+		output, err := d.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &bucket,
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			if noSuchBucket(err) {
+				return nil
+			}
+			return err
+		}
+
+		for _, object := range output.Contents {
+			if keepOutput && d.isOutputKey(aws.ToString(object.Key)) {
+				keptOutput = true
+				continue
+			}
+			keys = append(keys, s3Types.ObjectIdentifier{Key: object.Key})
+		}
+
+		if !output.IsTruncated {
+			break
+		}
+		continuationToken = output.NextContinuationToken
+	}
+
+	// delete keys in batches
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		output, err := d.ObjectStoreAPI.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &bucket,
+			Delete: &s3Types.Delete{
+				Objects: keys[start:end],
+				Quiet:   true,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			return fmt.Errorf(
+				"Error deleting object %s: %s",
+				aws.ToString(output.Errors[0].Key),
+				aws.ToString(output.Errors[0].Message),
+			)
+		}
+	}
+
+	// the bucket can't be deleted while it has objects
+	if keptOutput {
+		return nil
+	}
+
+	_, err := d.ObjectStoreAPI.DeleteBucket(ctx, &s3.DeleteBucketInput{
+		Bucket: &bucket,
+	})
+	if err != nil && !noSuchBucket(err) {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteQueues deletes all the queues of the job. All the queues created for a
+// job have the job id as prefix, which includes the data and metadata queues, the
+// done queues, the final aggregator queues and both dead-letter queues
+func (d *Driver) DeleteQueues(ctx context.Context) error {
+	// get all queues of the job
+	queueURLs := []string{}
+	var nextToken *string
+	for {
+		output, err := d.QueuesAPI.ListQueues(ctx, &sqs.ListQueuesInput{
+			QueueNamePrefix: aws.String(d.JobID.String()),
+			MaxResults:      aws.Int32(maxListQueues),
+			NextToken:       nextToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		queueURLs = append(queueURLs, output.QueueUrls...)
+
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+
+	for _, queueURL := range queueURLs {
+		_, err := d.QueuesAPI.DeleteQueue(ctx, &sqs.DeleteQueueInput{
+			QueueUrl: aws.String(queueURL),
+		})
+		if err != nil && !queueDoesNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteLambdaFunctions deletes the mapper, coordinator and reducer functions of the job
+func (d *Driver) DeleteLambdaFunctions(ctx context.Context) error {
+	for _, functionName := range d.jobImageNames() {
+		_, err := d.FaasAPI.DeleteFunction(ctx, &lambda.DeleteFunctionInput{
+			FunctionName: aws.String(functionName),
+		})
+		if err != nil && !functionNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteImageRepos deletes the ECR repositories of the job functions and their images
+func (d *Driver) DeleteImageRepos(ctx context.Context) error {
+	for _, repoName := range d.jobImageNames() {
+		_, err := d.ImageRepoAPI.DeleteRepository(ctx, &ecr.DeleteRepositoryInput{
+			RepositoryName: aws.String(repoName),
+			RegistryId:     &d.Config.AccountID,
+			Force:          true,
+		})
+		if err != nil && !repoNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteLogsInfra deletes the log stream and the log group of the job
+func (d *Driver) DeleteLogsInfra(ctx context.Context) error {
+	logGroupName := fmt.Sprintf("%s-log-group", d.JobID.String())
+	logStreamName := fmt.Sprintf("%s-log-stream", d.JobID.String())
+
+	_, err := d.LogsAPI.DeleteLogStream(ctx, &cloudwatchlogs.DeleteLogStreamInput{
+		LogGroupName:  &logGroupName,
+		LogStreamName: &logStreamName,
+	})
+	if err != nil && !logsNotFound(err) {
+		return err
+	}
+
+	_, err = d.LogsAPI.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{
+		LogGroupName: &logGroupName,
+	})
+	if err != nil && !logsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// jobImageNames returns the image names of the job functions, which are
+// also the names of the lambda functions and the ECR repositories
+func (d *Driver) jobImageNames() []string {
+	if d.BuildData == nil {
+		return nil
+	}
+
+	names := []string{}
+	if d.BuildData.MapperData != nil {
+		names = append(names, d.BuildData.MapperData.ImageName)
+	}
+	if d.BuildData.CoordinatorData != nil {
+		names = append(names, d.BuildData.CoordinatorData.ImageName)
+	}
+	for _, reducer := range d.BuildData.ReducerData {
+		names = append(names, reducer.ImageName)
+	}
+
+	return names
+}
+
+// isOutputKey checks if the key of the job bucket is an output object of the job.
+// Jobs with an output bucket have no output objects in the job bucket
+func (d *Driver) isOutputKey(key string) bool {
+	bucket, prefix := d.getOutputLocation()
+	if bucket != d.JobID.String() {
+		return false
+	}
+
+	return key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// noSuchBucket checks if the s3 bucket doesn't exist
+func noSuchBucket(err error) bool {
+	var notFound *s3Types.NoSuchBucket
+	return errors.As(err, &notFound)
+}
+
+// queueDoesNotExist checks if the queue doesn't exist
+func queueDoesNotExist(err error) bool {
+	var notFound *sqsTypes.QueueDoesNotExist
+	return errors.As(err, &notFound)
+}
+
+// functionNotFound checks if the lambda function doesn't exist
+func functionNotFound(err error) bool {
+	var notFound *lambdaTypes.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
+
+// repoNotFound checks if the ecr repo doesn't exist
+func repoNotFound(err error) bool {
+	var notFound *ecrTypes.RepositoryNotFoundException
+	return errors.As(err, &notFound)
+}
+
+// logsNotFound checks if the log group or stream doesn't exist
+func logsNotFound(err error) bool {
+	var notFound *logsTypes.ResourceNotFoundException
+	return errors.As(err, &notFound)
+}
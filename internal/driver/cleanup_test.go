@@ -0,0 +1,161 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/internal/generators"
+	"github.com/josenarvaezp/displ/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newCleanupTestDriver(t *testing.T) *Driver {
+	ctx := context.Background()
+	jobID := uuid.New()
+
+	jobDriver := NewLocalDriver(jobID, &config.Config{})
+	jobDriver.BuildData = &generators.BuildData{
+		MapperData:      &generators.FunctionData{ImageName: fmt.Sprintf("wordcount_%s", jobID)},
+		CoordinatorData: &generators.CoordinatorData{ImageName: fmt.Sprintf("coordinator_%s", jobID)},
+		ReducerData: []*generators.ReducerFunctionData{
+			{ImageName: fmt.Sprintf("map_aggregator_%s", jobID)},
+		},
+	}
+
+	// create the resources upload creates
+	require.Nil(t, jobDriver.CreateJobBucket(ctx))
+	for _, key := range []string{"mappings", "done", "checkpoints/reducer/1-main", "output/reducer-1", "output/reducer-2"} {
+		_, err := jobDriver.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(jobID.String()),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(key)),
+		})
+		require.Nil(t, err)
+	}
+	require.Nil(t, jobDriver.CreateQueues(ctx, 2))
+	_, err := jobDriver.CreateLambdaDLQ(ctx)
+	require.Nil(t, err)
+	require.Nil(t, jobDriver.CreateLogsInfra(ctx))
+
+	// queue of another job must not be deleted
+	_, err = jobDriver.QueuesAPI.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("%s-0", uuid.New())),
+	})
+	require.Nil(t, err)
+
+	// functions and repos are mocked
+	lambdaMock := new(mocks.FaasAPI)
+	ecrMock := new(mocks.ImageRepoAPI)
+	for _, name := range jobDriver.jobImageNames() {
+		lambdaMock.On("DeleteFunction", mock.Anything, &lambda.DeleteFunctionInput{
+			FunctionName: aws.String(name),
+		}).Return(&lambda.DeleteFunctionOutput{}, nil).Once()
+		ecrMock.On("DeleteRepository", mock.Anything, &ecr.DeleteRepositoryInput{
+			RepositoryName: aws.String(name),
+			RegistryId:     aws.String(localAccountID),
+			Force:          true,
+		}).Return(&ecr.DeleteRepositoryOutput{}, nil).Once()
+	}
+	jobDriver.FaasAPI = lambdaMock
+	jobDriver.ImageRepoAPI = ecrMock
+
+	return jobDriver
+}
+
+func listJobQueues(t *testing.T, jobDriver *Driver, prefix string) []string {
+	output, err := jobDriver.QueuesAPI.ListQueues(context.Background(), &sqs.ListQueuesInput{
+		QueueNamePrefix: aws.String(prefix),
+	})
+	require.Nil(t, err)
+
+	return output.QueueUrls
+}
+
+func Test_Cleanup_HappyPath(t *testing.T) {
+	ctx := context.Background()
+	jobDriver := newCleanupTestDriver(t)
+	require.Len(t, listJobQueues(t, jobDriver, jobDriver.JobID.String()), 10)
+
+	err := jobDriver.Cleanup(ctx, false)
+	require.Nil(t, err)
+
+	// job bucket is deleted
+	_, err = jobDriver.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(jobDriver.JobID.String()),
+	})
+	assert.True(t, noSuchBucket(err))
+
+	// only the queues of the job are deleted
+	assert.Len(t, listJobQueues(t, jobDriver, jobDriver.JobID.String()), 0)
+	assert.Len(t, listJobQueues(t, jobDriver, ""), 1)
+
+	jobDriver.FaasAPI.(*mocks.FaasAPI).AssertExpectations(t)
+	jobDriver.ImageRepoAPI.(*mocks.ImageRepoAPI).AssertExpectations(t)
+
+	// cleaning up again is not an error
+	lambdaMock := new(mocks.FaasAPI)
+	lambdaMock.On("DeleteFunction", mock.Anything, mock.Anything).Return(
+		nil,
+		&lambdaTypes.ResourceNotFoundException{},
+	)
+	jobDriver.FaasAPI = lambdaMock
+	jobDriver.ImageRepoAPI.(*mocks.ImageRepoAPI).On("DeleteRepository", mock.Anything, mock.Anything).Return(
+		&ecr.DeleteRepositoryOutput{},
+		nil,
+	)
+
+	err = jobDriver.Cleanup(ctx, false)
+	assert.Nil(t, err)
+}
+
+func Test_Cleanup_KeepOutput(t *testing.T) {
+	ctx := context.Background()
+	jobDriver := newCleanupTestDriver(t)
+
+	err := jobDriver.Cleanup(ctx, true)
+	require.Nil(t, err)
+
+	// only the output objects are kept
+	output, err := jobDriver.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(jobDriver.JobID.String()),
+	})
+	require.Nil(t, err)
+	keys := []string{}
+	for _, object := range output.Contents {
+		keys = append(keys, *object.Key)
+	}
+	assert.Equal(t, []string{"output/reducer-1", "output/reducer-2"}, keys)
+
+	assert.Len(t, listJobQueues(t, jobDriver, jobDriver.JobID.String()), 0)
+}
+
+func Test_Cleanup_ContinuesAfterError(t *testing.T) {
+	ctx := context.Background()
+	jobDriver := newCleanupTestDriver(t)
+
+	lambdaMock := new(mocks.FaasAPI)
+	lambdaMock.On("DeleteFunction", mock.Anything, mock.Anything).Return(
+		nil,
+		&lambdaTypes.TooManyRequestsException{},
+	)
+	jobDriver.FaasAPI = lambdaMock
+
+	err := jobDriver.Cleanup(ctx, false)
+	assert.NotNil(t, err)
+
+	// the rest of the resources are deleted
+	assert.Len(t, listJobQueues(t, jobDriver, jobDriver.JobID.String()), 0)
+	jobDriver.ImageRepoAPI.(*mocks.ImageRepoAPI).AssertExpectations(t)
+}
@@ -63,6 +63,9 @@ type Driver struct {
 	// user config
 	Config    config.Config
 	BuildData *generators.BuildData
+	// queuesReadyOnCreate is set when the queues can be used as soon as they
+	// are created, like the in-memory queues of jobs that run in-process
+	queuesReadyOnCreate bool
 }
 
 // NewSetupDriver creates a new dirver used to setup a role
@@ -0,0 +1,344 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/internal/faas"
+	"github.com/josenarvaezp/displ/internal/generators"
+	"github.com/josenarvaezp/displ/internal/logs"
+	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/queues"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+)
+
+const (
+	// localAccountID is the account id used when running jobs in-process
+	localAccountID = "000000000000"
+	// localRegion is the region used when the job config doesn't define one
+	localRegion = "local"
+)
+
+// LocalJob defines the user functions and options of a job that runs in-process
+type LocalJob struct {
+	// Mapper is a lambdas.MapFunction or a lambdas.StreamMapFunction,
+	// or their versions that can fail
+	Mapper              interface{}
+	Filter              func(aggregators.MapAggregator) aggregators.MapAggregator
+	Sort                func(aggregators.MapAggregator) sort.Interface
+	RandomizedPartition bool
+	// NumReducers is the number of reducers to use, if 0 it is
+	// set to half the number of mappers
+	NumReducers int
+	// InputPath is a local file or directory that is loaded into
+	// the first input bucket before the job starts
+	InputPath string
+	// MapperFailurePolicy decides if a split where the mapper fails
+	// fails the job or is skipped
+	MapperFailurePolicy lambdas.MapperFailurePolicy
+	// PollInterval is the time between checks of the coordinator,
+	// if 0 it is lambdas.DefaultPollInterval
+	PollInterval time.Duration
+}
+
+// NewLocalDriver creates a driver whose clients are in-memory implementations
+// of the cloud services. It is used to run a job in-process without AWS or localstack
+func NewLocalDriver(jobID uuid.UUID, conf *config.Config) *Driver {
+	store := objectstore.NewMemoryObjectStore()
+
+	driver := &Driver{
+		JobID:          jobID,
+		Config:         *conf,
+		ObjectStoreAPI: store,
+		DownloaderAPI:  store,
+		UploaderAPI:    store,
+		QueuesAPI:      queues.NewMemoryQueues(),
+		LogsAPI:        logs.NewLocalLogs(),
+
+		queuesReadyOnCreate: true,
+	}
+	driver.Config.AccountID = localAccountID
+	if driver.Config.Region == "" {
+		driver.Config.Region = localRegion
+	}
+
+	return driver
+}
+
+// NewLocalFileDriver creates a driver like NewLocalDriver whose object store is backed
+// by the local data directory, where each bucket is a directory. Input buckets are read
+// from the directory and the job bucket, checkpoints and output are written to it
+func NewLocalFileDriver(jobID uuid.UUID, conf *config.Config, dataDir string) (*Driver, error) {
+	store, err := objectstore.NewFileObjectStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := NewLocalDriver(jobID, conf)
+	driver.ObjectStoreAPI = store
+	driver.DownloaderAPI = store
+	driver.UploaderAPI = store
+
+	return driver, nil
+}
+
+// RunInProcess runs the whole job in the current process. The coordinator, mappers
+// and reducers run as goroutines using the driver clients and the output of the
+// job is written to w once all reducers are done
+func (d *Driver) RunInProcess(ctx context.Context, job *LocalJob, w io.Writer) error {
+	// create job bucket
+	if err := d.CreateJobBucket(ctx); err != nil {
+		return err
+	}
+
+	if err := d.CreateOutputBucket(ctx); err != nil {
+		return err
+	}
+
+	// load local input
+	if job.InputPath != "" {
+		if err := d.UploadLocalInput(ctx, job.InputPath); err != nil {
+			return err
+		}
+	}
+
+	// generate mappings from the input buckets
+	mappings, err := d.GenerateMappings(ctx)
+	if err != nil {
+		return err
+	}
+	if len(mappings) == 0 || len(mappings[0].Objects) == 0 {
+		return errors.New("No input objects found for the job")
+	}
+
+	// get number of reducers
+	numReducers := job.NumReducers
+	if numReducers == 0 {
+		numReducers = (len(mappings) + 1) / 2
+	}
+
+	if err := d.WriteMappings(ctx, mappings); err != nil {
+		return err
+	}
+
+	if err := d.CreateQueues(ctx, numReducers); err != nil {
+		return err
+	}
+
+	if err := d.CreateLogsInfra(ctx); err != nil {
+		return err
+	}
+
+	// set the function names the coordinator invokes
+	jobID := d.JobID.String()
+	mapperName := fmt.Sprintf("mapper_%s", jobID)
+	coordinatorName := fmt.Sprintf("coordinator_%s", jobID)
+	reducerName := lambdas.ECRMapAggregator
+	if job.RandomizedPartition {
+		reducerName = lambdas.ECRRandomMapAggregator
+	}
+
+	d.BuildData = &generators.BuildData{
+		NumMappers:      len(mappings),
+		NumReducers:     numReducers,
+		MapperData:      &generators.FunctionData{ImageName: mapperName},
+		CoordinatorData: &generators.CoordinatorData{ImageName: coordinatorName},
+	}
+
+	// register the job functions
+	localFaas := faas.NewLocalFaas(ctx, d.Config.Region)
+	d.FaasAPI = localFaas
+
+	localFaas.Register(coordinatorName, func(ctx context.Context, payload []byte) error {
+		var request lambdas.CoordinatorInput
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return err
+		}
+
+		coordinator := d.newLocalCoordinator()
+		coordinator.PollInterval = job.PollInterval
+		if job.RandomizedPartition {
+			return coordinator.HandleRandomCoordinator(ctx, request, reducerName, lambdas.ECRFinalMapAggregator)
+		}
+		return coordinator.HandleCoordinator(ctx, request, reducerName)
+	})
+
+	localFaas.Register(mapperName, func(ctx context.Context, payload []byte) error {
+		var request lambdas.MapperInput
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return err
+		}
+
+		mapper := d.newLocalMapper()
+		mapper.FailurePolicy = job.MapperFailurePolicy
+		if job.RandomizedPartition {
+			return mapper.HandleRandomMap(ctx, request, job.Mapper)
+		}
+		return mapper.HandleMap(ctx, request, job.Mapper)
+	})
+
+	localFaas.Register(fmt.Sprintf("%s_%s", reducerName, jobID), func(ctx context.Context, payload []byte) error {
+		var request lambdas.ReducerInput
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return err
+		}
+
+		reducer := d.newLocalReducer(job.RandomizedPartition)
+		if job.RandomizedPartition {
+			return reducer.HandleRandomMapAggregator(ctx, request)
+		}
+		return reducer.HandleMapAggregator(ctx, request, job.Filter, job.Sort)
+	})
+
+	if job.RandomizedPartition {
+		localFaas.Register(fmt.Sprintf("%s_%s", lambdas.ECRFinalMapAggregator, jobID), func(ctx context.Context, payload []byte) error {
+			var request lambdas.ReducerInput
+			if err := json.Unmarshal(payload, &request); err != nil {
+				return err
+			}
+
+			return d.newLocalReducer(true).HandleFinalMapAggregator(ctx, request, job.Filter, job.Sort)
+		})
+	}
+
+	// start coordinator and wait until all functions are done
+	if err := d.StartCoordinator(ctx); err != nil {
+		return err
+	}
+	if err := localFaas.Wait(); err != nil {
+		return err
+	}
+
+	return d.WriteLocalOutput(ctx, w)
+}
+
+// UploadLocalInput uploads a local file, or all the files in a local directory,
+// to the first input bucket of the job. The bucket is created if it doesn't exist
+func (d *Driver) UploadLocalInput(ctx context.Context, inputPath string) error {
+	if len(d.Config.InputBuckets) == 0 {
+		return errors.New("The job config must define at least one input bucket")
+	}
+	bucket := d.Config.InputBuckets[0]
+
+	_, err := d.ObjectStoreAPI.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: &bucket,
+		CreateBucketConfiguration: &s3Types.CreateBucketConfiguration{
+			LocationConstraint: s3Types.BucketLocationConstraint(d.Config.Region),
+		},
+	})
+	if err != nil && !bucketAlreadyExists(err) {
+		return err
+	}
+
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return err
+	}
+
+	// the keys of the objects are the paths relative to the input directory
+	root := filepath.Dir(inputPath)
+	if info.IsDir() {
+		root = inputPath
+	}
+
+	return filepath.Walk(inputPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		key, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		_, err = d.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+			Bucket:        &bucket,
+			Key:           aws.String(filepath.ToSlash(key)),
+			Body:          bytes.NewReader(data),
+			ContentLength: int64(len(data)),
+		})
+		return err
+	})
+}
+
+// WriteLocalOutput writes every output object of the job to w, one per line
+func (d *Driver) WriteLocalOutput(ctx context.Context, w io.Writer) error {
+	outputs, err := d.DownloadOutputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, output := range outputs {
+		if _, err := fmt.Fprintf(w, "%s\n", output); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newLocalCoordinator creates a coordinator that uses the driver clients
+func (d *Driver) newLocalCoordinator() *lambdas.Coordinator {
+	return &lambdas.Coordinator{
+		QueuesAPI:      d.QueuesAPI,
+		FaasAPI:        d.FaasAPI,
+		DownloaderAPI:  d.DownloaderAPI,
+		UploaderAPI:    d.UploaderAPI,
+		LogsAPI:        d.LogsAPI,
+		ObjectStoreAPI: d.ObjectStoreAPI,
+		Region:         d.Config.Region,
+	}
+}
+
+// newLocalMapper creates a mapper that uses the driver clients
+func (d *Driver) newLocalMapper() *lambdas.Mapper {
+	return &lambdas.Mapper{
+		ObjectStoreAPI: d.ObjectStoreAPI,
+		DownloaderAPI:  d.DownloaderAPI,
+		UploaderAPI:    d.UploaderAPI,
+		QueuesAPI:      d.QueuesAPI,
+		Region:         d.Config.Region,
+	}
+}
+
+// newLocalReducer creates a reducer that uses the driver clients. Reducers
+// that receive single values use the simple dedupe
+func (d *Driver) newLocalReducer(singleValues bool) *lambdas.Reducer {
+	reducer := &lambdas.Reducer{
+		ObjectStoreAPI: d.ObjectStoreAPI,
+		DownloaderAPI:  d.DownloaderAPI,
+		UploaderAPI:    d.UploaderAPI,
+		QueuesAPI:      d.QueuesAPI,
+		FaasAPI:        d.FaasAPI,
+		Region:         d.Config.Region,
+		Output:         make(aggregators.MapAggregator),
+	}
+	if singleValues {
+		reducer.DedupeSimple = lambdas.InitDedupeSimple()
+	} else {
+		reducer.Dedupe = lambdas.InitDedupe()
+	}
+
+	return reducer
+}
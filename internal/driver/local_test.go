@@ -0,0 +1,397 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/compression/compressiontest"
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/internal/parquet"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPollInterval is the poll interval of the coordinator in the tests
+const testPollInterval = 10 * time.Millisecond
+
+// runLocalJob runs the job in-process with the poll interval of the tests
+func runLocalJob(ctx context.Context, jobDriver *Driver, job *LocalJob, w io.Writer) error {
+	job.PollInterval = testPollInterval
+	return jobDriver.RunInProcess(ctx, job, w)
+}
+
+func wordCount(filename string) aggregators.MapAggregator {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	output := aggregators.NewMap()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, word := range strings.Fields(scanner.Text()) {
+			output.AddSum(word, 1)
+		}
+	}
+
+	return output
+}
+
+func streamWordCount(reader io.Reader, object lambdas.InputObject) aggregators.MapAggregator {
+	output := aggregators.NewMap()
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		for _, word := range strings.Fields(scanner.Text()) {
+			output.AddSum(word, 1)
+		}
+	}
+
+	return output
+}
+
+func writeLocalInput(t *testing.T) string {
+	inputDir, err := ioutil.TempDir("", "ribble-input")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(inputDir) })
+
+	err = ioutil.WriteFile(filepath.Join(inputDir, "a.txt"), []byte("hello world\nhello ribble\n"), 0666)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(inputDir, "b.txt"), []byte("world hello\n"), 0666)
+	require.Nil(t, err)
+
+	return inputDir
+}
+
+func readWordCounts(t *testing.T, output []byte) map[string]float64 {
+	counts := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		var reducerOutput map[string]map[string]string
+		require.Nil(t, json.Unmarshal([]byte(line), &reducerOutput))
+		for key, aggregator := range reducerOutput {
+			value, err := strconv.ParseFloat(aggregator["Sum"], 64)
+			require.Nil(t, err)
+			counts[key] += value
+		}
+	}
+
+	return counts
+}
+
+func Test_RunInProcess_HappyPath(t *testing.T) {
+	tests := []struct {
+		name                string
+		mapper              interface{}
+		randomizedPartition bool
+		numReducers         int
+	}{
+		{"map partition", wordCount, false, 2},
+		{"randomized partition", wordCount, true, 2},
+		{"streaming mapper", streamWordCount, false, 2},
+		{"randomized partition streaming mapper", streamWordCount, true, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+				InputBuckets: []string{"input-bucket"},
+			})
+
+			var output bytes.Buffer
+			err := runLocalJob(ctx, jobDriver, &LocalJob{
+				Mapper:              test.mapper,
+				RandomizedPartition: test.randomizedPartition,
+				NumReducers:         test.numReducers,
+				InputPath:           writeLocalInput(t),
+			}, &output)
+			require.Nil(t, err)
+
+			expected := map[string]float64{
+				"hello":  3,
+				"world":  2,
+				"ribble": 1,
+			}
+			assert.Equal(t, expected, readWordCounts(t, output.Bytes()))
+		})
+	}
+}
+
+// this function runs a mapper that fails on the input with the word ribble,
+// the failed split stops the job or is skipped and reported in the job status
+func Test_RunInProcess_MapperFailurePolicy(t *testing.T) {
+	failingWordCount := func(filename string) (aggregators.MapAggregator, error) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(string(data), "ribble") {
+			return nil, errors.New("Unexpected word ribble")
+		}
+
+		return wordCount(filename), nil
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+			InputBuckets: []string{"input-bucket"},
+		})
+
+		var output bytes.Buffer
+		err := runLocalJob(ctx, jobDriver, &LocalJob{
+			Mapper:              failingWordCount,
+			NumReducers:         2,
+			InputPath:           writeLocalInput(t),
+			MapperFailurePolicy: lambdas.FailJob,
+		}, &output)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Map function failed on bytes")
+		assert.Contains(t, err.Error(), "s3://input-bucket/a.txt in mapping")
+		assert.Contains(t, err.Error(), "Unexpected word ribble")
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+			InputBuckets: []string{"input-bucket"},
+		})
+
+		var output bytes.Buffer
+		err := runLocalJob(ctx, jobDriver, &LocalJob{
+			Mapper:              failingWordCount,
+			NumReducers:         2,
+			InputPath:           writeLocalInput(t),
+			MapperFailurePolicy: lambdas.SkipFailedSplits,
+		}, &output)
+		require.Nil(t, err)
+
+		expected := map[string]float64{
+			"hello": 1,
+			"world": 1,
+		}
+		assert.Equal(t, expected, readWordCounts(t, output.Bytes()))
+
+		status, err := jobDriver.GetJobStatus(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, PhaseCompleted, status.Phase)
+		require.Len(t, status.SkippedSplits, 1)
+		assert.Equal(t, "input-bucket", status.SkippedSplits[0].Bucket)
+		assert.Equal(t, "a.txt", status.SkippedSplits[0].Key)
+		assert.Equal(t, "Unexpected word ribble", status.SkippedSplits[0].Message)
+		_, err = uuid.Parse(status.SkippedSplits[0].MappingID)
+		assert.Nil(t, err)
+	})
+}
+
+// this function runs a stream mapper on Parquet input, where each range
+// has the row groups of an object and the mapper gets the object footer
+func Test_RunInProcess_Parquet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	inputDir, err := ioutil.TempDir("", "ribble-input")
+	require.Nil(t, err)
+	defer os.RemoveAll(inputDir)
+
+	words := strings.Fields("hello world hello ribble world hello")
+	err = ioutil.WriteFile(filepath.Join(inputDir, "words.parquet"), writeParquetWords(t, words, 4), 0666)
+	require.Nil(t, err)
+
+	parquetWordCount := func(reader io.Reader, object lambdas.InputObject) aggregators.MapAggregator {
+		metadata, err := parquet.ReadMetadata(object.Footer)
+		if err != nil {
+			return nil
+		}
+
+		rows, err := parquet.NewRowReader(reader, metadata, object.InitialByte, object.RowGroups.First, object.RowGroups.Count, nil)
+		if err != nil {
+			return nil
+		}
+
+		output := aggregators.NewMap()
+		for {
+			row, err := rows.Read()
+			if err != nil {
+				break
+			}
+			output.AddSum(row[0], 1)
+		}
+
+		return output
+	}
+
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets: []string{"input-bucket"},
+		Records:      config.Records{Format: config.ParquetFormat},
+	})
+
+	var output bytes.Buffer
+	err = runLocalJob(ctx, jobDriver, &LocalJob{
+		Mapper:    parquetWordCount,
+		InputPath: inputDir,
+	}, &output)
+	require.Nil(t, err)
+
+	expected := map[string]float64{
+		"hello":  3,
+		"world":  2,
+		"ribble": 1,
+	}
+	assert.Equal(t, expected, readWordCounts(t, output.Bytes()))
+}
+
+// this function runs mappers on compressed input, which is decompressed before the
+// mapper reads it, with and without logical splits
+func Test_RunInProcess_Compressed(t *testing.T) {
+	inputDir, err := ioutil.TempDir("", "ribble-input")
+	require.Nil(t, err)
+	defer os.RemoveAll(inputDir)
+
+	gzipped, err := compressiontest.Gzip("hello world\nhello ribble\n")
+	require.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(inputDir, "a.txt.gz"), gzipped, 0666)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(inputDir, "b.txt.bz2"), compressiontest.Bzip2(), 0666)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name         string
+		mapper       interface{}
+		logicalSplit bool
+	}{
+		{"file mapper", wordCount, false},
+		{"streaming mapper", streamWordCount, false},
+		{"file mapper with logical split", wordCount, true},
+		{"streaming mapper with logical split", streamWordCount, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+				InputBuckets: []string{"input-bucket"},
+				LogicalSplit: test.logicalSplit,
+			})
+
+			var output bytes.Buffer
+			err := runLocalJob(ctx, jobDriver, &LocalJob{
+				Mapper:    test.mapper,
+				InputPath: inputDir,
+			}, &output)
+			require.Nil(t, err)
+
+			expected := map[string]float64{
+				"hello":  2,
+				"world":  1,
+				"ribble": 1,
+				"the":    3,
+				"quick":  1,
+				"brown":  1,
+				"fox":    1,
+				"jumps":  1,
+				"over":   1,
+				"lazy":   1,
+				"dog":    1,
+				"and":    1,
+				"cat":    1,
+			}
+			assert.Equal(t, expected, readWordCounts(t, output.Bytes()))
+		})
+	}
+}
+
+func Test_RunInProcess_NoInput(t *testing.T) {
+	inputDir, err := ioutil.TempDir("", "ribble-input")
+	require.Nil(t, err)
+	defer os.RemoveAll(inputDir)
+
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets: []string{"input-bucket"},
+	})
+
+	var output bytes.Buffer
+	err = runLocalJob(context.Background(), jobDriver, &LocalJob{
+		Mapper:    wordCount,
+		InputPath: inputDir,
+	}, &output)
+	assert.NotNil(t, err)
+}
+
+func Test_RunInProcess_DataDir(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ribble-data")
+	require.Nil(t, err)
+	defer os.RemoveAll(dataDir)
+
+	// input bucket is a directory in the data directory
+	err = os.MkdirAll(filepath.Join(dataDir, "tpch", "lineitem"), os.ModePerm)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(
+		filepath.Join(dataDir, "tpch", "lineitem", "lineitem.tbl"),
+		[]byte("hello|world|\nhello|ribble|\n"),
+		0666,
+	)
+	require.Nil(t, err)
+
+	pipeWordCount := func(filename string) aggregators.MapAggregator {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil
+		}
+
+		output := aggregators.NewMap()
+		for _, word := range strings.FieldsFunc(string(data), func(r rune) bool { return r == '|' || r == '\n' }) {
+			output.AddSum(word, 1)
+		}
+
+		return output
+	}
+
+	jobID := uuid.New()
+	jobDriver, err := NewLocalFileDriver(jobID, &config.Config{
+		InputBuckets: []string{"tpch"},
+		LogicalSplit: true,
+	}, dataDir)
+	require.Nil(t, err)
+
+	var output bytes.Buffer
+	err = runLocalJob(context.Background(), jobDriver, &LocalJob{
+		Mapper: pipeWordCount,
+	}, &output)
+	require.Nil(t, err)
+
+	expected := map[string]float64{
+		"hello":  2,
+		"world":  1,
+		"ribble": 1,
+	}
+	assert.Equal(t, expected, readWordCounts(t, output.Bytes()))
+
+	// job bucket is written to the data directory
+	_, err = os.Stat(filepath.Join(dataDir, jobID.String(), "mappings"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dataDir, jobID.String(), "done"))
+	assert.Nil(t, err)
+}
@@ -7,11 +7,12 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
 	"github.com/sirupsen/logrus"
 )
 
 // ReadRibbleLogs reads the coordinator logs and prints
-// them the as logs
+// them the as logs. It returns a JobFailedError once the job has failed
 func (d *Driver) ReadRibbleLogs(ctx context.Context, sleepTime int32) error {
 	logGroupName := fmt.Sprintf("%s-log-group", d.JobID.String())
 	logStreamName := fmt.Sprintf("%s-log-stream", d.JobID.String())
@@ -38,6 +39,15 @@ func (d *Driver) ReadRibbleLogs(ctx context.Context, sleepTime int32) error {
 			}).Info(*event.Message)
 		}
 
+		// stop tracking the job once it has failed
+		status, err := d.GetJobStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if status.Failure != nil {
+			return &lambdas.JobFailedError{Failure: status.Failure}
+		}
+
 		// sleep before fetching more logs
 		time.Sleep(time.Duration(sleepTime) * time.Second)
 	}
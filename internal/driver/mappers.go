@@ -9,7 +9,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
+	"github.com/josenarvaezp/displ/internal/compression"
+	"github.com/josenarvaezp/displ/internal/config"
 	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/parquet"
 	"github.com/josenarvaezp/displ/pkg/lambdas"
 )
 
@@ -22,9 +25,10 @@ const (
 	SUCCESS_CODE int32 = 202     // sucessful code for asynchronous lambda invokation
 )
 
-// GenerateMappings generates batches of input data. If logicalSplit is true
-// it genererates logical splits (currently only EOL splits are supportes), otherwise
-// the mappings are generetated one per file
+// GenerateMappings generates batches of input data. Parquet input is split by row groups.
+// If logicalSplit is true it genererates logical splits (currently only EOL splits are supportes),
+// otherwise the mappings are generetated one per file. Compressed objects are detected by their
+// extension, or their content encoding if enabled, and they are only split at the boundaries of their frames
 func (d *Driver) GenerateMappings(ctx context.Context) ([]*lambdas.Mapping, error) {
 	// init mappings
 	mappings := []*lambdas.Mapping{}
@@ -66,13 +70,26 @@ func (d *Driver) GenerateMappings(ctx context.Context) ([]*lambdas.Mapping, erro
 			var partialMappings []*lambdas.Mapping
 			var mappingErr error
 
-			if d.Config.LogicalSplit {
-				partialMappings, mappingErr = d.generateMappingsForPartialObjects(objects, lastMapping)
+			if d.Config.Records.Format == config.ParquetFormat {
+				partialMappings, mappingErr = d.generateMappingsForParquetObjects(ctx, objects, lastMapping)
 				if mappingErr != nil {
-					return nil, err
+					return nil, mappingErr
 				}
 			} else {
-				partialMappings = generateMappingsForCompleteObjects(objects, lastMapping)
+				// the mappers decompress compressed objects
+				mappingErr = d.detectCompression(ctx, objects)
+				if mappingErr != nil {
+					return nil, mappingErr
+				}
+
+				if d.Config.LogicalSplit {
+					partialMappings, mappingErr = d.generateMappingsForPartialObjects(ctx, objects, lastMapping)
+					if mappingErr != nil {
+						return nil, mappingErr
+					}
+				} else {
+					partialMappings = generateMappingsForCompleteObjects(objects, lastMapping)
+				}
 			}
 
 			if !moreObjects && i == len(d.Config.InputBuckets)-1 {
@@ -118,7 +135,13 @@ func generateMappingsForCompleteObjects(objects []objectstore.Object, lastMappin
 		}
 
 		// add current object to mapping
-		objectWithRange := objectstore.NewObjectWithRange(object, 1, object.Size)
+		objectWithRange := objectstore.NewObjectWithRange(object, 0, object.Size)
+		if object.Compression != compression.None {
+			objectWithRange.Compression = &objectstore.CompressedRange{
+				Codec: object.Compression,
+				Size:  object.Size,
+			}
+		}
 		partialMappings[currentMapping].Objects = append(partialMappings[currentMapping].Objects, objectWithRange)
 		partialMappings[currentMapping].Size = partialMappings[currentMapping].Size + object.Size
 	}
@@ -195,15 +218,43 @@ func (d *Driver) split(ctx context.Context, object objectstore.Object, initialBy
 
 // generateMappingsForPartialObjects is a helper function that generates batches where each file
 // needs to fit in a single batch
-func (d *Driver) generateMappingsForPartialObjects(objects []objectstore.Object, lastMapping *lambdas.Mapping) ([]*lambdas.Mapping, error) {
+func (d *Driver) generateMappingsForPartialObjects(
+	ctx context.Context,
+	objects []objectstore.Object,
+	lastMapping *lambdas.Mapping,
+) ([]*lambdas.Mapping, error) {
 	partialMappings := []*lambdas.Mapping{lastMapping}
 	currentMapping := 0
 
 	for _, object := range objects {
+		if object.Compression != compression.None {
+			// compressed objects can't be split at an end of line
+			compressedRanges, err := d.splitCompressedObject(ctx, object, CHUNK_SIZE)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, compressedRange := range compressedRanges {
+				rangeSize := compressedRange.FinalByte - compressedRange.InitialByte + 1
+
+				availableSpace := CHUNK_SIZE - partialMappings[currentMapping].Size
+				if rangeSize > availableSpace && partialMappings[currentMapping].Size > 0 {
+					// range doesn't fit in mapping
+					nextMapping := lambdas.NewMapping()
+					partialMappings = append(partialMappings, nextMapping)
+					currentMapping++
+				}
+
+				partialMappings[currentMapping].Objects = append(partialMappings[currentMapping].Objects, compressedRange)
+				partialMappings[currentMapping].Size = partialMappings[currentMapping].Size + rangeSize
+			}
+			continue
+		}
+
 		availableSpace := CHUNK_SIZE - partialMappings[currentMapping].Size
 
 		// split object to fit the current mapping
-		splitObjectWithRange, err := d.split(context.Background(), object, 1, availableSpace)
+		splitObjectWithRange, err := d.split(ctx, object, 0, availableSpace)
 		if err != nil {
 			return nil, err
 		}
@@ -220,7 +271,7 @@ func (d *Driver) generateMappingsForPartialObjects(objects []objectstore.Object,
 			partialMappings = append(partialMappings, nextMapping)
 			currentMapping++
 
-			splitObjectWithRange, err = d.split(context.Background(), object, splitObjectWithRange.FinalByte, CHUNK_SIZE)
+			splitObjectWithRange, err = d.split(ctx, object, splitObjectWithRange.FinalByte, CHUNK_SIZE)
 			if err != nil {
 				return nil, err
 			}
@@ -236,3 +287,272 @@ func (d *Driver) generateMappingsForPartialObjects(objects []objectstore.Object,
 
 	return partialMappings, nil
 }
+
+// detectCompression sets the codec of compressed objects, which is given by the extension
+// of their key. Keys without a known extension are only compressed by their content encoding
+// if DetectContentEncoding is set in the config, since it takes a request per object
+func (d *Driver) detectCompression(ctx context.Context, objects []objectstore.Object) error {
+	for i, object := range objects {
+		objects[i].Compression = compression.FromKey(object.Key)
+		if objects[i].Compression != compression.None || !d.Config.DetectContentEncoding {
+			continue
+		}
+
+		// the content encoding is not listed with the objects
+		headObjectOutput, err := d.ObjectStoreAPI.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(object.Bucket),
+			Key:    aws.String(object.Key),
+		})
+		if err != nil {
+			return err
+		}
+		objects[i].Compression = compression.FromContentEncoding(aws.ToString(headObjectOutput.ContentEncoding))
+	}
+
+	return nil
+}
+
+// splitCompressedObject splits a compressed object in ranges of at most maxSize bytes that start
+// and end at the boundaries of its frames. Seekable zstd objects are split by the frames in their
+// seek table and bzip2 objects by their streams, a frame larger than maxSize is a range on its own.
+// Objects of other codecs, like gzip, can't be split so the whole object is a single range
+func (d *Driver) splitCompressedObject(ctx context.Context, object objectstore.Object, maxSize int64) ([]objectstore.ObjectRange, error) {
+	if object.Size == 0 {
+		// nothing to decompress
+		return nil, nil
+	}
+
+	switch object.Compression {
+	case compression.Zstd:
+		frames, err := d.readSeekTable(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+		if frames == nil {
+			// the object is not seekable
+			break
+		}
+
+		// add consecutive frames to a range while they fit
+		lastFrame := frames[len(frames)-1]
+		dataSize := lastFrame.Offset + lastFrame.CompressedSize
+		ranges := []objectstore.ObjectRange{}
+		for _, frame := range frames {
+			frameEnd := frame.Offset + frame.CompressedSize
+			if len(ranges) > 0 && frameEnd-ranges[len(ranges)-1].InitialByte <= maxSize {
+				ranges[len(ranges)-1].FinalByte = frameEnd - 1
+				continue
+			}
+			ranges = append(ranges, newCompressedRange(object, frame.Offset, frameEnd-1, dataSize))
+		}
+		return ranges, nil
+	case compression.Bzip2:
+		ranges := []objectstore.ObjectRange{}
+		for initialByte := int64(0); initialByte < object.Size; {
+			nextStream, err := d.nextBzip2Stream(ctx, object, initialByte, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, newCompressedRange(object, initialByte, nextStream-1, object.Size))
+			initialByte = nextStream
+		}
+		return ranges, nil
+	}
+
+	return []objectstore.ObjectRange{newCompressedRange(object, 0, object.Size-1, object.Size)}, nil
+}
+
+// newCompressedRange creates the range of a compressed object where
+// size is the size of the compressed data of the object
+func newCompressedRange(object objectstore.Object, initialByte, finalByte, size int64) objectstore.ObjectRange {
+	objectRange := objectstore.NewObjectWithRange(object, initialByte, finalByte)
+	objectRange.Compression = &objectstore.CompressedRange{
+		Codec: object.Compression,
+		Size:  size,
+	}
+
+	return objectRange
+}
+
+// readSeekTable reads the frames in the seek table at the end of a seekable zstd
+// object. If the object doesn't have a seek table no frames are returned
+func (d *Driver) readSeekTable(ctx context.Context, object objectstore.Object) ([]compression.Frame, error) {
+	if object.Size < compression.SeekTableFooterSize {
+		return nil, nil
+	}
+
+	footer, err := d.downloadRange(ctx, object, object.Size-compression.SeekTableFooterSize, object.Size-1)
+	if err != nil {
+		return nil, err
+	}
+
+	seekTableSize := compression.SeekTableSize(footer)
+	if seekTableSize == 0 || seekTableSize > object.Size {
+		return nil, nil
+	}
+
+	seekTable, err := d.downloadRange(ctx, object, object.Size-seekTableSize, object.Size-1)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := compression.ReadSeekTable(seekTable)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the seek table of s3://%s/%s: %v", object.Bucket, object.Key, err)
+	}
+
+	// the frames must be followed by the seek table
+	if len(frames) == 0 || frames[len(frames)-1].Offset+frames[len(frames)-1].CompressedSize != object.Size-seekTableSize {
+		return nil, fmt.Errorf("Error reading the seek table of s3://%s/%s: the frames don't match the size of the object", object.Bucket, object.Key)
+	}
+
+	return frames, nil
+}
+
+// nextBzip2Stream returns the start of the last bzip2 stream that starts after initialByte and
+// at most maxSize bytes after it, which is where the range that starts at initialByte ends.
+// The size of the object is returned if the object ends before or if no stream starts in the
+// range, which is the case of objects with a single stream
+func (d *Driver) nextBzip2Stream(ctx context.Context, object objectstore.Object, initialByte, maxSize int64) (int64, error) {
+	lastStart := initialByte + maxSize
+	if lastStart >= object.Size {
+		return object.Size, nil
+	}
+
+	// usually we should be able to find a stream in the last
+	// megabyte but if not, then double value until we do
+	for lookupRange := MB; ; lookupRange = lookupRange * 2 {
+		startLookupByte := lastStart - lookupRange
+		if startLookupByte <= initialByte {
+			startLookupByte = initialByte + 1
+		}
+
+		// the header of a stream that starts at the last byte must be complete
+		data, err := d.downloadRange(ctx, object, startLookupByte, lastStart+compression.Bzip2HeaderSize-1)
+		if err != nil {
+			return 0, err
+		}
+
+		if index := compression.LastBzip2Stream(data); index != -1 {
+			return startLookupByte + int64(index), nil
+		}
+
+		if startLookupByte == initialByte+1 {
+			// no stream starts in the range
+			return object.Size, nil
+		}
+	}
+}
+
+// generateMappingsForParquetObjects is a helper function that generates batches splitting
+// Parquet objects by their row groups, which are read from the footer of each object.
+// Consecutive row groups in the same batch are a single range and a row group that
+// doesn't fit in a batch starts a new one, so row groups larger than a batch are not split
+func (d *Driver) generateMappingsForParquetObjects(
+	ctx context.Context,
+	objects []objectstore.Object,
+	lastMapping *lambdas.Mapping,
+) ([]*lambdas.Mapping, error) {
+	partialMappings := []*lambdas.Mapping{lastMapping}
+	currentMapping := 0
+
+	for _, object := range objects {
+		metadata, footerOffset, footerLength, err := d.readParquetFooter(ctx, object)
+		if err != nil {
+			return nil, err
+		}
+
+		// the range of the object in the current mapping
+		var objectRange *objectstore.ObjectRange
+		for i, rowGroup := range metadata.RowGroups {
+			start, end := rowGroup.ByteRange()
+			rowGroupSize := end - start
+
+			availableSpace := CHUNK_SIZE - partialMappings[currentMapping].Size
+			if rowGroupSize > availableSpace && partialMappings[currentMapping].Size > 0 {
+				// row group doesn't fit in mapping
+				nextMapping := lambdas.NewMapping()
+				partialMappings = append(partialMappings, nextMapping)
+				currentMapping++
+				objectRange = nil
+			}
+
+			if objectRange == nil {
+				// add a range that starts at the current row group
+				mapping := partialMappings[currentMapping]
+				mapping.Objects = append(mapping.Objects, objectstore.ObjectRange{
+					Bucket:      object.Bucket,
+					Key:         object.Key,
+					InitialByte: start,
+					RowGroups: &objectstore.RowGroupRange{
+						First:        i,
+						FooterOffset: footerOffset,
+						FooterLength: footerLength,
+					},
+				})
+				objectRange = &mapping.Objects[len(mapping.Objects)-1]
+			}
+
+			// extend the range with the row group
+			objectRange.FinalByte = end - 1
+			objectRange.RowGroups.Count++
+			partialMappings[currentMapping].Size = partialMappings[currentMapping].Size + rowGroupSize
+		}
+	}
+
+	return partialMappings, nil
+}
+
+// readParquetFooter reads the metadata in the footer of a Parquet object
+// and returns it with the offset and the length of the footer
+func (d *Driver) readParquetFooter(ctx context.Context, object objectstore.Object) (*parquet.FileMetaData, int64, int64, error) {
+	invalidObjectErr := fmt.Errorf("Object s3://%s/%s is not a Parquet file", object.Bucket, object.Key)
+	if object.Size < int64(len(parquet.Magic)+parquet.TailSize) {
+		return nil, 0, 0, invalidObjectErr
+	}
+
+	// the tail has the length of the footer
+	tail, err := d.downloadRange(ctx, object, object.Size-parquet.TailSize, object.Size-1)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	footerLength, err := parquet.FooterLength(tail)
+	if err != nil {
+		return nil, 0, 0, invalidObjectErr
+	}
+
+	footerOffset := object.Size - parquet.TailSize - footerLength
+	if footerLength == 0 || footerOffset < int64(len(parquet.Magic)) {
+		return nil, 0, 0, invalidObjectErr
+	}
+
+	footer, err := d.downloadRange(ctx, object, footerOffset, footerOffset+footerLength-1)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	metadata, err := parquet.ReadMetadata(footer)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("Error reading the footer of s3://%s/%s: %v", object.Bucket, object.Key, err)
+	}
+
+	return metadata, footerOffset, footerLength, nil
+}
+
+// downloadRange downloads the bytes of the object from initialByte to finalByte
+func (d *Driver) downloadRange(ctx context.Context, object objectstore.Object, initialByte, finalByte int64) ([]byte, error) {
+	var buf []byte
+	writeAt := manager.NewWriteAtBuffer(buf)
+
+	_, err := d.DownloaderAPI.Download(ctx, writeAt, &s3.GetObjectInput{
+		Bucket: &object.Bucket,
+		Key:    &object.Key,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", initialByte, finalByte)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return writeAt.Bytes(), nil
+}
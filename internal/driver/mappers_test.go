@@ -0,0 +1,351 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/compression"
+	"github.com/josenarvaezp/displ/internal/compression/compressiontest"
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/parquet"
+	"github.com/josenarvaezp/displ/internal/parquet/parquettest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeParquetWords returns a Parquet file with a row per word
+func writeParquetWords(t *testing.T, words []string, rowGroupSize int) []byte {
+	rows := make([][]interface{}, len(words))
+	for i, word := range words {
+		rows[i] = []interface{}{word}
+	}
+
+	var file bytes.Buffer
+	err := parquettest.Write(
+		&file,
+		[]parquettest.Column{{Name: "word", Type: parquettest.String}},
+		rows,
+		parquettest.Options{RowGroupSize: rowGroupSize, Codec: parquet.Snappy},
+	)
+	require.Nil(t, err)
+
+	return file.Bytes()
+}
+
+// uploadObjects creates the bucket and uploads the objects to it
+func uploadObjects(t *testing.T, d *Driver, bucket string, objects map[string][]byte) {
+	ctx := context.Background()
+	_, err := d.ObjectStoreAPI.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	require.Nil(t, err)
+
+	for key, data := range objects {
+		_, err = d.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		require.Nil(t, err)
+	}
+}
+
+func Test_GenerateMappings_Parquet(t *testing.T) {
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets: []string{"warehouse"},
+		Records:      config.Records{Format: config.ParquetFormat},
+	})
+
+	first := writeParquetWords(t, strings.Fields("a b c d e f g"), 3)
+	second := writeParquetWords(t, strings.Fields("h i"), 0)
+	uploadObjects(t, jobDriver, "warehouse", map[string][]byte{
+		"exports/1.parquet": first,
+		"exports/2.parquet": second,
+	})
+
+	mappings, err := jobDriver.GenerateMappings(context.Background())
+	require.Nil(t, err)
+
+	// the objects fit in a mapping and each of them is a range of all its row groups
+	require.Len(t, mappings, 1)
+	require.Len(t, mappings[0].Objects, 2)
+
+	for i, file := range [][]byte{first, second} {
+		footerLength, err := parquet.FooterLength(file[len(file)-parquet.TailSize:])
+		require.Nil(t, err)
+		footerOffset := int64(len(file)) - parquet.TailSize - footerLength
+		metadata, err := parquet.ReadMetadata(file[footerOffset : len(file)-parquet.TailSize])
+		require.Nil(t, err)
+
+		_, end := metadata.RowGroups[len(metadata.RowGroups)-1].ByteRange()
+
+		objectRange := mappings[0].Objects[i]
+		assert.Equal(t, int64(len(parquet.Magic)), objectRange.InitialByte)
+		assert.Equal(t, end-1, objectRange.FinalByte)
+		require.NotNil(t, objectRange.RowGroups)
+		assert.Equal(t, 0, objectRange.RowGroups.First)
+		assert.Equal(t, len(metadata.RowGroups), objectRange.RowGroups.Count)
+		assert.Equal(t, footerOffset, objectRange.RowGroups.FooterOffset)
+		assert.Equal(t, footerLength, objectRange.RowGroups.FooterLength)
+	}
+	assert.Equal(t, 3, mappings[0].Objects[0].RowGroups.Count)
+}
+
+func Test_GenerateMappings_InvalidParquet(t *testing.T) {
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets: []string{"warehouse"},
+		Records:      config.Records{Format: config.ParquetFormat},
+	})
+
+	uploadObjects(t, jobDriver, "warehouse", map[string][]byte{
+		"lineitem.tbl": []byte("1|R|F|1998-09-02|\n"),
+	})
+
+	_, err := jobDriver.GenerateMappings(context.Background())
+	assert.EqualError(t, err, "Object s3://warehouse/lineitem.tbl is not a Parquet file")
+}
+
+func Test_GenerateMappings_Compressed(t *testing.T) {
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets:          []string{"logs"},
+		LogicalSplit:          true,
+		DetectContentEncoding: true,
+	})
+
+	gzipped, err := compressiontest.Gzip("GET /index.html 200\nGET /missing 404\n")
+	require.Nil(t, err)
+	seekable, err := compressiontest.SeekableZstd([]string{"GET /index.html 200\n", "GET /missing 404\n"}, true)
+	require.Nil(t, err)
+
+	uploadObjects(t, jobDriver, "logs", map[string][]byte{
+		"1.log.gz":  gzipped,
+		"2.log.zst": seekable,
+		"3.log":     []byte("GET /index.html 200\n"),
+	})
+
+	// the codec of objects without a known extension is their content encoding
+	_, err = jobDriver.UploaderAPI.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket:          aws.String("logs"),
+		Key:             aws.String("4.log"),
+		Body:            bytes.NewReader(gzipped),
+		ContentEncoding: aws.String("gzip"),
+	})
+	require.Nil(t, err)
+
+	mappings, err := jobDriver.GenerateMappings(context.Background())
+	require.Nil(t, err)
+	require.Len(t, mappings, 1)
+	require.Len(t, mappings[0].Objects, 4)
+
+	// gzip objects are a single range
+	gzipRange := &objectstore.CompressedRange{Codec: compression.Gzip, Size: int64(len(gzipped))}
+	assert.Equal(t, objectstore.ObjectRange{
+		Bucket:      "logs",
+		Key:         "1.log.gz",
+		FinalByte:   int64(len(gzipped)) - 1,
+		Compression: gzipRange,
+	}, mappings[0].Objects[0])
+	assert.Equal(t, objectstore.ObjectRange{
+		Bucket:      "logs",
+		Key:         "4.log",
+		FinalByte:   int64(len(gzipped)) - 1,
+		Compression: gzipRange,
+	}, mappings[0].Objects[3])
+
+	// the range of seekable zstd objects doesn't have the seek table
+	footer := seekable[len(seekable)-compression.SeekTableFooterSize:]
+	dataSize := int64(len(seekable)) - compression.SeekTableSize(footer)
+	assert.Equal(t, objectstore.ObjectRange{
+		Bucket:      "logs",
+		Key:         "2.log.zst",
+		FinalByte:   dataSize - 1,
+		Compression: &objectstore.CompressedRange{Codec: compression.Zstd, Size: dataSize},
+	}, mappings[0].Objects[1])
+
+	// uncompressed objects are split at an end of line
+	assert.Nil(t, mappings[0].Objects[2].Compression)
+}
+
+// headObjectCounter counts the HeadObject requests to the object store
+type headObjectCounter struct {
+	objectstore.ObjectStoreAPI
+	count int
+}
+
+func (c *headObjectCounter) HeadObject(
+	ctx context.Context,
+	params *s3.HeadObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.HeadObjectOutput, error) {
+	c.count++
+	return c.ObjectStoreAPI.HeadObject(ctx, params, optFns...)
+}
+
+func Test_GenerateMappings_ContentEncodingNotDetected(t *testing.T) {
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets: []string{"logs"},
+		LogicalSplit: true,
+	})
+	counter := &headObjectCounter{ObjectStoreAPI: jobDriver.ObjectStoreAPI}
+	jobDriver.ObjectStoreAPI = counter
+
+	gzipped, err := compressiontest.Gzip("GET /index.html 200\n")
+	require.Nil(t, err)
+
+	uploadObjects(t, jobDriver, "logs", map[string][]byte{
+		"1.txt":    []byte("GET /index.html 200\n"),
+		"2.csv":    []byte("GET,/missing,404\n"),
+		"3.log.gz": gzipped,
+	})
+
+	// uncompressed input doesn't need a request per object
+	mappings, err := jobDriver.GenerateMappings(context.Background())
+	require.Nil(t, err)
+	require.Len(t, mappings, 1)
+	require.Len(t, mappings[0].Objects, 3)
+	assert.Equal(t, 0, counter.count)
+
+	assert.Nil(t, mappings[0].Objects[0].Compression)
+	assert.Nil(t, mappings[0].Objects[1].Compression)
+	assert.Equal(t, compression.Gzip, mappings[0].Objects[2].Compression.Codec)
+}
+
+func Test_SplitCompressedObject(t *testing.T) {
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{})
+
+	seekable, err := compressiontest.SeekableZstd([]string{"the quick brown\nfox jum", "ps over the\n", "lazy dog\n"}, false)
+	require.Nil(t, err)
+	gzipped, err := compressiontest.Gzip("the quick brown\nfox jumps over the\nlazy dog\n")
+	require.Nil(t, err)
+	bzipped := compressiontest.Bzip2()
+
+	uploadObjects(t, jobDriver, "logs", map[string][]byte{
+		"1.log.zst": seekable,
+		"2.log.gz":  gzipped,
+		"3.log.bz2": bzipped,
+	})
+
+	footer := seekable[len(seekable)-compression.SeekTableFooterSize:]
+	frames, err := compression.ReadSeekTable(seekable[int64(len(seekable))-compression.SeekTableSize(footer):])
+	require.Nil(t, err)
+
+	first := int64(len(compressiontest.Bzip2Streams[0]))
+	second := first + int64(len(compressiontest.Bzip2Streams[1]))
+
+	tests := []struct {
+		name    string
+		object  objectstore.Object
+		maxSize int64
+		// expected are the initial and final bytes of the ranges
+		expected [][2]int64
+	}{
+		{
+			name:    "zstd frames that fit in a range are grouped",
+			object:  objectstore.Object{Bucket: "logs", Key: "1.log.zst", Size: int64(len(seekable)), Compression: compression.Zstd},
+			maxSize: frames[0].CompressedSize + frames[1].CompressedSize,
+			expected: [][2]int64{
+				{0, frames[1].Offset + frames[1].CompressedSize - 1},
+				{frames[2].Offset, frames[2].Offset + frames[2].CompressedSize - 1},
+			},
+		},
+		{
+			name:    "gzip objects are not split",
+			object:  objectstore.Object{Bucket: "logs", Key: "2.log.gz", Size: int64(len(gzipped)), Compression: compression.Gzip},
+			maxSize: 10,
+			expected: [][2]int64{
+				{0, int64(len(gzipped)) - 1},
+			},
+		},
+		{
+			name:    "bzip2 objects are split by streams",
+			object:  objectstore.Object{Bucket: "logs", Key: "3.log.bz2", Size: int64(len(bzipped)), Compression: compression.Bzip2},
+			maxSize: first + 10,
+			expected: [][2]int64{
+				{0, first - 1},
+				{first, second - 1},
+				{second, int64(len(bzipped)) - 1},
+			},
+		},
+		{
+			name:    "bzip2 streams that fit in a range are grouped",
+			object:  objectstore.Object{Bucket: "logs", Key: "3.log.bz2", Size: int64(len(bzipped)), Compression: compression.Bzip2},
+			maxSize: second,
+			expected: [][2]int64{
+				{0, second - 1},
+				{second, int64(len(bzipped)) - 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges, err := jobDriver.splitCompressedObject(context.Background(), test.object, test.maxSize)
+			require.Nil(t, err)
+			require.Len(t, ranges, len(test.expected))
+
+			for i, objectRange := range ranges {
+				assert.Equal(t, test.expected[i][0], objectRange.InitialByte)
+				assert.Equal(t, test.expected[i][1], objectRange.FinalByte)
+				assert.Equal(t, test.object.Compression, objectRange.Compression.Codec)
+			}
+		})
+	}
+}
+
+func Test_GenerateMappings_FirstByte(t *testing.T) {
+	tests := []struct {
+		name         string
+		logicalSplit bool
+	}{
+		{
+			name: "complete objects",
+		},
+		{
+			name:         "logical split",
+			logicalSplit: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+				InputBuckets: []string{"words"},
+				LogicalSplit: test.logicalSplit,
+			})
+
+			_, err := jobDriver.ObjectStoreAPI.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("words")})
+			require.Nil(t, err)
+			_, err = jobDriver.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+				Bucket: aws.String("words"),
+				Key:    aws.String("words.txt"),
+				Body:   strings.NewReader("the cat\nthe dog\n"),
+			})
+			require.Nil(t, err)
+
+			mappings, err := jobDriver.GenerateMappings(ctx)
+			require.Nil(t, err)
+			require.Len(t, mappings, 1)
+			require.Len(t, mappings[0].Objects, 1)
+
+			// ranges are zero-based so the first byte of the object is read by the mapper
+			objectRange := mappings[0].Objects[0]
+			assert.Equal(t, int64(0), objectRange.InitialByte)
+
+			buf := manager.NewWriteAtBuffer([]byte{})
+			_, err = jobDriver.DownloaderAPI.Download(ctx, buf, &s3.GetObjectInput{
+				Bucket: aws.String(objectRange.Bucket),
+				Key:    aws.String(objectRange.Key),
+				Range:  aws.String(fmt.Sprintf("bytes=%d-%d", objectRange.InitialByte, objectRange.FinalByte)),
+			})
+			require.Nil(t, err)
+			assert.Equal(t, "the cat\nthe dog\n", string(buf.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,563 @@
+package driver
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+)
+
+// OutputFormat is the format the job output is written in
+type OutputFormat string
+
+const (
+	// OutputFormatJSON writes the output as a JSON array of records
+	OutputFormatJSON OutputFormat = "json"
+	// OutputFormatJSONLines writes one JSON record per line
+	OutputFormatJSONLines OutputFormat = "jsonl"
+	// OutputFormatCSV writes a key,value header followed by one row per record,
+	// the columns of group keys are written as key1,key2,... cells and the
+	// aggregates of a group as a cell per aggregate
+	OutputFormatCSV OutputFormat = "csv"
+)
+
+// SortBy is the field used to merge the sorted outputs of the reducers
+type SortBy string
+
+const (
+	// SortByKey merges sorted outputs in ascending key order
+	SortByKey SortBy = "key"
+	// SortByValue merges sorted outputs in ascending value order
+	SortByValue SortBy = "value"
+)
+
+// OutputRecord is a key and its aggregated value in the job output. Columns
+// holds the columns of the key if the key is a group key. Fields holds the
+// aggregates of a group by their name if they are keyed with GroupKey.Field,
+// in which case the value of the record is the object of its fields
+type OutputRecord struct {
+	Key     string                 `json:"key"`
+	Value   interface{}            `json:"value"`
+	Columns aggregators.GroupKey   `json:"-"`
+	Fields  map[string]interface{} `json:"-"`
+}
+
+// newOutputRecord returns the record of a key and its value
+func newOutputRecord(key string, value interface{}) OutputRecord {
+	record := OutputRecord{Key: key, Value: flattenValue(value)}
+	columns, field, err := aggregators.ParseGroupKeyField(key)
+	if err != nil {
+		return record
+	}
+
+	record.Columns = columns
+	if field != "" {
+		record.Key = columns.String()
+		record.Fields = map[string]interface{}{field: record.Value}
+		record.Value = record.Fields
+	}
+
+	return record
+}
+
+// MarshalJSON encodes the record, the key of records with
+// a group key is written as the list of its columns
+func (r OutputRecord) MarshalJSON() ([]byte, error) {
+	if r.Columns == nil {
+		return json.Marshal(struct {
+			Key   string      `json:"key"`
+			Value interface{} `json:"value"`
+		}{r.Key, r.Value})
+	}
+
+	return json.Marshal(struct {
+		Key   []interface{} `json:"key"`
+		Value interface{}   `json:"value"`
+	}{r.Columns.Columns(), r.Value})
+}
+
+// GetJobOutput downloads the output of every reducer of the job and merges them into a
+// single list of records. Outputs written by a sort function are merged keeping their
+// order by sortBy, otherwise the records are ordered by key
+func (d *Driver) GetJobOutput(ctx context.Context, sortBy SortBy) ([]OutputRecord, error) {
+	outputs, err := d.DownloadOutputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return MergeOutputs(outputs, sortBy)
+}
+
+// DownloadOutputs downloads the part files listed in the success manifest of the job.
+// If the job has no manifest every object under the output prefix is downloaded
+func (d *Driver) DownloadOutputs(ctx context.Context) ([][]byte, error) {
+	bucket, prefix := d.getOutputLocation()
+
+	keys, err := d.GetOutputKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("The job has no output at s3://%s/%s", bucket, prefix)
+	}
+
+	outputs := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		buf := manager.NewWriteAtBuffer([]byte{})
+		_, err := d.DownloaderAPI.Download(ctx, buf, &s3.GetObjectInput{
+			Bucket: &bucket,
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+
+	return outputs, nil
+}
+
+// GetOutputKeys returns the keys of the part files in the output location of the job.
+// The keys are read from the success manifest and, for jobs without one, from the
+// objects under the output prefix
+func (d *Driver) GetOutputKeys(ctx context.Context) ([]string, error) {
+	manifest, err := d.GetSuccessManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, prefix := d.getOutputLocation()
+	if manifest != nil {
+		// the output location can be shared by several jobs
+		if manifest.JobID != d.JobID {
+			return nil, fmt.Errorf(
+				"The output at s3://%s/%s has been overwritten by job %s",
+				bucket,
+				prefix,
+				manifest.JobID.String(),
+			)
+		}
+		return manifest.Parts, nil
+	}
+
+	listPrefix := prefix
+	if listPrefix != "" {
+		listPrefix = listPrefix + "/"
+	}
+
+	keys := []string{}
+	var continuationToken *string
+	for {
+		listOutput, err := d.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &bucket,
+			Prefix:            aws.String(listPrefix),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range listOutput.Contents {
+			key := aws.ToString(object.Key)
+			if key == lambdas.GetOutputKey(prefix, lambdas.SuccessManifestName) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+
+		if !listOutput.IsTruncated {
+			break
+		}
+		continuationToken = listOutput.NextContinuationToken
+	}
+
+	return keys, nil
+}
+
+// GetSuccessManifest reads the success manifest from the output location of the job.
+// It returns nil if the job hasn't written the manifest
+func (d *Driver) GetSuccessManifest(ctx context.Context) (*lambdas.SuccessManifest, error) {
+	bucket, prefix := d.getOutputLocation()
+
+	output, err := d.ObjectStoreAPI.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    aws.String(lambdas.GetOutputKey(prefix, lambdas.SuccessManifestName)),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	var manifest lambdas.SuccessManifest
+	if err := json.NewDecoder(output.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// getOutputLocation returns the bucket and key prefix the job output is written to
+func (d *Driver) getOutputLocation() (string, string) {
+	return lambdas.GetOutputLocation(d.JobID, d.Config.OutputBucket, d.Config.OutputPrefix)
+}
+
+// MergeOutputs merges the reducer outputs into a single list of records. Reducers without
+// a sort function write an object from key to aggregator, these are merged and ordered by
+// key. Reducers with a sort function write a list of key and value pairs, these lists are
+// merged assuming each of them is sorted in ascending order by sortBy
+func MergeOutputs(outputs [][]byte, sortBy SortBy) ([]OutputRecord, error) {
+	if sortBy != SortByKey && sortBy != SortByValue {
+		return nil, fmt.Errorf("Invalid sort by %q, it must be key or value", sortBy)
+	}
+
+	sortedLists := [][]OutputRecord{}
+	unsorted := []OutputRecord{}
+	for _, output := range outputs {
+		trimmed := bytes.TrimSpace(output)
+		if len(trimmed) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+
+		// sorted outputs are lists
+		if trimmed[0] == '[' {
+			var records []OutputRecord
+			if err := decoder.Decode(&records); err != nil {
+				return nil, err
+			}
+			for i := range records {
+				records[i] = newOutputRecord(records[i].Key, records[i].Value)
+			}
+			sortedLists = append(sortedLists, records)
+			continue
+		}
+
+		var aggregators map[string]interface{}
+		if err := decoder.Decode(&aggregators); err != nil {
+			return nil, err
+		}
+		for key, value := range aggregators {
+			unsorted = append(unsorted, newOutputRecord(key, value))
+		}
+	}
+
+	if len(sortedLists) > 0 && len(unsorted) > 0 {
+		return nil, errors.New("The job output mixes sorted and unsorted reducer outputs")
+	}
+
+	if len(sortedLists) > 0 {
+		return combineFields(mergeSortedOutputs(sortedLists, sortBy)), nil
+	}
+
+	sort.Slice(unsorted, func(i, j int) bool {
+		return lessRecords(unsorted[i], unsorted[j], SortByKey)
+	})
+
+	return combineFields(unsorted), nil
+}
+
+// combineFields combines the records of the aggregates of a group, which can be
+// written by different reducers, into a single record with a field per aggregate.
+// The record takes the position of the first aggregate of the group
+func combineFields(records []OutputRecord) []OutputRecord {
+	combined := make([]OutputRecord, 0, len(records))
+	groups := make(map[string]int)
+	for _, record := range records {
+		if record.Fields == nil {
+			combined = append(combined, record)
+			continue
+		}
+
+		if i, ok := groups[record.Key]; ok {
+			for field, value := range record.Fields {
+				combined[i].Fields[field] = value
+			}
+			continue
+		}
+
+		groups[record.Key] = len(combined)
+		combined = append(combined, record)
+	}
+
+	return combined
+}
+
+// WriteOutput writes the records to w in the given format
+func WriteOutput(w io.Writer, records []OutputRecord, format OutputFormat) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case OutputFormatJSONLines:
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputFormatCSV:
+		// group keys are written as a cell per column and
+		// the aggregates of a group as a cell per field
+		numColumns := 0
+		hasValues := false
+		fieldSet := make(map[string]bool)
+		for _, record := range records {
+			if len(record.Columns) > numColumns {
+				numColumns = len(record.Columns)
+			}
+			if record.Fields == nil {
+				hasValues = true
+			}
+			for field := range record.Fields {
+				fieldSet[field] = true
+			}
+		}
+		fields := make([]string, 0, len(fieldSet))
+		for field := range fieldSet {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		header := []string{"key"}
+		if numColumns > 0 {
+			header = []string{}
+			for i := 1; i <= numColumns; i++ {
+				header = append(header, fmt.Sprintf("key%d", i))
+			}
+		}
+		if hasValues {
+			header = append(header, "value")
+		}
+		header = append(header, fields...)
+
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(header); err != nil {
+			return err
+		}
+		for _, record := range records {
+			row, err := csvKey(record, numColumns)
+			if err != nil {
+				return err
+			}
+
+			if hasValues {
+				value := ""
+				if record.Fields == nil {
+					if value, err = csvValue(record.Value); err != nil {
+						return err
+					}
+				}
+				row = append(row, value)
+			}
+
+			for _, field := range fields {
+				value := ""
+				if fieldValue, ok := record.Fields[field]; ok {
+					if value, err = csvValue(fieldValue); err != nil {
+						return err
+					}
+				}
+				row = append(row, value)
+			}
+
+			if err := csvWriter.Write(row); err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	default:
+		return fmt.Errorf("Invalid output format %q, it must be json, jsonl or csv", format)
+	}
+}
+
+// flattenValue converts the JSON encoding of an aggregator into its value. Aggregators
+// with a single field, like {"Sum":"3"}, are replaced by the value of the field, and
+// numbers encoded as strings in the field are converted to numbers. Aggregators omit
+// zero values so an empty object is a zero. Aggregators with typed values, like First,
+// are encoded as their native JSON type and are kept as they are
+func flattenValue(value interface{}) interface{} {
+	if fields, ok := value.(map[string]interface{}); ok {
+		switch len(fields) {
+		case 0:
+			return json.Number("0")
+		case 1:
+			for _, field := range fields {
+				value = field
+			}
+		default:
+			return value
+		}
+
+		if str, ok := value.(string); ok {
+			if _, err := strconv.ParseFloat(str, 64); err == nil {
+				return json.Number(str)
+			}
+		}
+	}
+
+	if value == nil {
+		return json.Number("0")
+	}
+
+	return value
+}
+
+// csvValue returns the value as a csv cell, values that are not
+// numbers or strings are written as JSON
+func csvValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case string:
+		return v, nil
+	default:
+		p, err := json.Marshal(v)
+		return string(p), err
+	}
+}
+
+// csvKey returns the cells of the key of a record. Records without
+// a group key are written in the first of numColumns cells
+func csvKey(record OutputRecord, numColumns int) ([]string, error) {
+	if numColumns == 0 {
+		return []string{record.Key}, nil
+	}
+
+	row := make([]string, numColumns)
+	if record.Columns == nil {
+		row[0] = record.Key
+		return row, nil
+	}
+
+	for i, column := range record.Columns {
+		cell, err := csvValue(column.Interface())
+		if err != nil {
+			return nil, err
+		}
+		row[i] = cell
+	}
+
+	return row, nil
+}
+
+// lessRecords compares two records by the sort by field. Numeric values are
+// compared as numbers and any other value as JSON. Group keys are compared
+// column by column
+func lessRecords(a, b OutputRecord, sortBy SortBy) bool {
+	if sortBy == SortByKey {
+		if a.Columns != nil && b.Columns != nil {
+			return aggregators.CompareGroupKeys(a.Columns, b.Columns) < 0
+		}
+		return a.Key < b.Key
+	}
+
+	aNumber, aErr := toFloat(a.Value)
+	bNumber, bErr := toFloat(b.Value)
+	if aErr == nil && bErr == nil {
+		return aNumber < bNumber
+	}
+
+	aValue, _ := json.Marshal(a.Value)
+	bValue, _ := json.Marshal(b.Value)
+	return string(aValue) < string(bValue)
+}
+
+// toFloat converts a numeric output value to a float
+func toFloat(value interface{}) (float64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, errors.New("value is not a number")
+	}
+
+	return number.Float64()
+}
+
+// mergeSortedOutputs merges sorted lists of records with a k-way merge
+func mergeSortedOutputs(lists [][]OutputRecord, sortBy SortBy) []OutputRecord {
+	total := 0
+	h := &recordHeap{sortBy: sortBy}
+	for i, list := range lists {
+		total += len(list)
+		if len(list) > 0 {
+			h.items = append(h.items, recordHeapItem{list: i})
+		}
+	}
+	h.lists = lists
+	heap.Init(h)
+
+	merged := make([]OutputRecord, 0, total)
+	for h.Len() > 0 {
+		item := h.items[0]
+		merged = append(merged, lists[item.list][item.index])
+
+		if item.index+1 < len(lists[item.list]) {
+			h.items[0].index++
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return merged
+}
+
+// recordHeapItem points to the next record of a list
+type recordHeapItem struct {
+	list  int
+	index int
+}
+
+// recordHeap is a min heap of the next record of each sorted list.
+// Ties are broken by list so that the merge is stable
+type recordHeap struct {
+	lists  [][]OutputRecord
+	items  []recordHeapItem
+	sortBy SortBy
+}
+
+func (h *recordHeap) Len() int { return len(h.items) }
+
+func (h *recordHeap) Less(i, j int) bool {
+	a := h.lists[h.items[i].list][h.items[i].index]
+	b := h.lists[h.items[j].list][h.items[j].index]
+	if lessRecords(a, b, h.sortBy) {
+		return true
+	}
+	if lessRecords(b, a, h.sortBy) {
+		return false
+	}
+	return h.items[i].list < h.items[j].list
+}
+
+func (h *recordHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *recordHeap) Push(x interface{}) { h.items = append(h.items, x.(recordHeapItem)) }
+
+func (h *recordHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
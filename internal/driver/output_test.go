@@ -0,0 +1,329 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MergeOutputs_Unsorted(t *testing.T) {
+	outputs := [][]byte{
+		[]byte(`{"dog":{"Sum":"2"},"ant":{"Max":"1.5"}}`),
+		[]byte(`{"cat":{"Avg":"0.25"},"zero":{}}`),
+	}
+
+	records, err := MergeOutputs(outputs, SortByKey)
+	require.Nil(t, err)
+	assert.Equal(t, []OutputRecord{
+		{Key: "ant", Value: json.Number("1.5")},
+		{Key: "cat", Value: json.Number("0.25")},
+		{Key: "dog", Value: json.Number("2")},
+		{Key: "zero", Value: json.Number("0")},
+	}, records)
+}
+
+func Test_MergeOutputs_TypedValues(t *testing.T) {
+	output := aggregators.NewMap()
+	require.Nil(t, output.AddLast("status", "123", 1))
+	require.Nil(t, output.AddAny("shipped", true))
+	require.Nil(t, output.AddFirst("line", int64(9007199254740993), 1))
+	require.Nil(t, output.AddSum("total", 2))
+	p, err := json.Marshal(output)
+	require.Nil(t, err)
+
+	records, err := MergeOutputs([][]byte{p}, SortByKey)
+	require.Nil(t, err)
+	assert.Equal(t, []OutputRecord{
+		{Key: "line", Value: json.Number("9007199254740993")},
+		{Key: "shipped", Value: true},
+		{Key: "status", Value: "123"},
+		{Key: "total", Value: json.Number("2")},
+	}, records)
+}
+
+func Test_MergeOutputs_Sorted(t *testing.T) {
+	outputs := [][]byte{
+		[]byte(`[{"key":"a","value":3},{"key":"d","value":1},{"key":"e","value":5}]`),
+		[]byte(`[{"key":"b","value":2},{"key":"c","value":4}]`),
+		[]byte(`[]`),
+	}
+
+	records, err := MergeOutputs(outputs, SortByKey)
+	require.Nil(t, err)
+	keys := []string{}
+	for _, record := range records {
+		keys = append(keys, record.Key)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+
+	// lists sorted by value
+	outputs = [][]byte{
+		[]byte(`[{"key":"d","value":1},{"key":"a","value":3},{"key":"e","value":10}]`),
+		[]byte(`[{"key":"zero"},{"key":"b","value":2},{"key":"c","value":4}]`),
+	}
+
+	records, err = MergeOutputs(outputs, SortByValue)
+	require.Nil(t, err)
+	keys = []string{}
+	for _, record := range records {
+		keys = append(keys, record.Key)
+	}
+	assert.Equal(t, []string{"zero", "d", "b", "a", "c", "e"}, keys)
+
+	// sorted and unsorted outputs can't be merged
+	_, err = MergeOutputs([][]byte{[]byte(`[]`), []byte(`{"a":{}}`)}, SortByKey)
+	assert.NotNil(t, err)
+}
+
+// this function checks that the columns of group keys are written as
+// separate fields and that records are ordered column by column
+func Test_MergeOutputs_GroupKeys(t *testing.T) {
+	output := aggregators.NewMap()
+	for _, key := range []aggregators.GroupKey{
+		aggregators.NewStringGroupKey("R", "F"),
+		aggregators.NewStringGroupKey("A", "F"),
+		aggregators.NewStringGroupKey("N", "O"),
+	} {
+		require.Nil(t, output.AddSum(key.String(), 2))
+	}
+	p, err := json.Marshal(output)
+	require.Nil(t, err)
+
+	records, err := MergeOutputs([][]byte{p}, SortByKey)
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, aggregators.NewStringGroupKey("A", "F"), records[0].Columns)
+	assert.Equal(t, aggregators.NewStringGroupKey("N", "O"), records[1].Columns)
+	assert.Equal(t, aggregators.NewStringGroupKey("R", "F"), records[2].Columns)
+
+	var buf bytes.Buffer
+	require.Nil(t, WriteOutput(&buf, records[:2], OutputFormatJSONLines))
+	assert.Equal(t, "{\"key\":[\"A\",\"F\"],\"value\":2}\n{\"key\":[\"N\",\"O\"],\"value\":2}\n", buf.String())
+
+	buf.Reset()
+	key, err := aggregators.NewGroupKey("A", 1998)
+	require.Nil(t, err)
+	records = append(records[:1], OutputRecord{Key: "total", Value: json.Number("6")}, OutputRecord{Key: key.String(), Value: json.Number("1"), Columns: key})
+	require.Nil(t, WriteOutput(&buf, records, OutputFormatCSV))
+	assert.Equal(t, "key1,key2,value\nA,F,2\ntotal,,6\nA,1998,1\n", buf.String())
+}
+
+// this function checks that the aggregates of a group written by different
+// reducers are combined into a single record with a field per aggregate
+func Test_MergeOutputs_GroupFields(t *testing.T) {
+	af := aggregators.NewStringGroupKey("A", "F")
+	no := aggregators.NewStringGroupKey("N", "O")
+
+	first := aggregators.NewMap()
+	require.Nil(t, first.AddSum(af.Field("sum_qty"), 3))
+	require.Nil(t, first.AddCount(no.Field("count_order")))
+	second := aggregators.NewMap()
+	require.Nil(t, second.AddCount(af.Field("count_order")))
+	require.Nil(t, second.AddSum(no.Field("sum_qty"), 5))
+	// user keys are never read as group keys
+	require.Nil(t, second.AddSum(`["x"]`, 1))
+
+	outputs := [][]byte{}
+	for _, output := range []aggregators.MapAggregator{first, second} {
+		p, err := json.Marshal(output)
+		require.Nil(t, err)
+		outputs = append(outputs, p)
+	}
+
+	records, err := MergeOutputs(outputs, SortByKey)
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, af, records[0].Columns)
+	assert.Equal(t, no, records[1].Columns)
+	assert.Nil(t, records[2].Columns)
+
+	var buf bytes.Buffer
+	require.Nil(t, WriteOutput(&buf, records, OutputFormatJSONLines))
+	assert.Equal(
+		t,
+		"{\"key\":[\"A\",\"F\"],\"value\":{\"count_order\":1,\"sum_qty\":3}}\n"+
+			"{\"key\":[\"N\",\"O\"],\"value\":{\"count_order\":1,\"sum_qty\":5}}\n"+
+			"{\"key\":\"[\\\"x\\\"]\",\"value\":1}\n",
+		buf.String(),
+	)
+
+	buf.Reset()
+	require.Nil(t, WriteOutput(&buf, records, OutputFormatCSV))
+	assert.Equal(t, "key1,key2,value,count_order,sum_qty\nA,F,,1,3\nN,O,,1,5\n\"[\"\"x\"\"]\",,1,,\n", buf.String())
+
+	// sorted outputs are combined in the order of their first aggregate
+	sorted := [][]aggregators.AggregatorPair{
+		{{Key: af.Field("count_order"), Value: 1}, {Key: no.Field("sum_qty"), Value: 2}},
+		{{Key: af.Field("sum_qty"), Value: 3}},
+	}
+	outputs = [][]byte{}
+	for _, output := range sorted {
+		p, err := json.Marshal(output)
+		require.Nil(t, err)
+		outputs = append(outputs, p)
+	}
+	records, err = MergeOutputs(outputs, SortByKey)
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, map[string]interface{}{"count_order": json.Number("1"), "sum_qty": json.Number("3")}, records[0].Fields)
+	assert.Equal(t, map[string]interface{}{"sum_qty": json.Number("2")}, records[1].Fields)
+}
+
+func Test_WriteOutput_Formats(t *testing.T) {
+	records := []OutputRecord{
+		{Key: "a,b", Value: json.Number("1")},
+		{Key: "c", Value: json.Number("2.5")},
+	}
+
+	tests := []struct {
+		format   OutputFormat
+		expected string
+	}{
+		{OutputFormatJSON, "[\n  {\n    \"key\": \"a,b\",\n    \"value\": 1\n  },\n  {\n    \"key\": \"c\",\n    \"value\": 2.5\n  }\n]\n"},
+		{OutputFormatJSONLines, "{\"key\":\"a,b\",\"value\":1}\n{\"key\":\"c\",\"value\":2.5}\n"},
+		{OutputFormatCSV, "key,value\n\"a,b\",1\nc,2.5\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteOutput(&buf, records, test.format)
+			require.Nil(t, err)
+			assert.Equal(t, test.expected, buf.String())
+		})
+	}
+
+	err := WriteOutput(&bytes.Buffer{}, records, "xml")
+	assert.NotNil(t, err)
+}
+
+type testPairList []aggregators.AggregatorPair
+
+func (p testPairList) Len() int           { return len(p) }
+func (p testPairList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p testPairList) Less(i, j int) bool { return p[i].Key < p[j].Key }
+
+func Test_GetJobOutput_AfterRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sortByKey := func(ma aggregators.MapAggregator) sort.Interface {
+		pairs := make(testPairList, 0, len(ma))
+		for key, value := range ma {
+			pairs = append(pairs, aggregators.AggregatorPair{Key: key, Value: value.ToNum()})
+		}
+		sort.Sort(pairs)
+		return pairs
+	}
+
+	tests := []struct {
+		name string
+		sort func(aggregators.MapAggregator) sort.Interface
+	}{
+		{"unsorted", nil},
+		{"sorted", sortByKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+				InputBuckets: []string{"input-bucket"},
+			})
+
+			var output bytes.Buffer
+			err := runLocalJob(ctx, jobDriver, &LocalJob{
+				Mapper:      wordCount,
+				Sort:        test.sort,
+				NumReducers: 2,
+				InputPath:   writeLocalInput(t),
+			}, &output)
+			require.Nil(t, err)
+
+			records, err := jobDriver.GetJobOutput(ctx, SortByKey)
+			require.Nil(t, err)
+			assert.Equal(t, []OutputRecord{
+				{Key: "hello", Value: json.Number("3")},
+				{Key: "ribble", Value: json.Number("1")},
+				{Key: "world", Value: json.Number("2")},
+			}, records)
+		})
+	}
+}
+
+func Test_GetJobOutput_OutputBucket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name                string
+		randomizedPartition bool
+		numParts            int
+	}{
+		{"map", false, 2},
+		{"randomized partition", true, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jobID := uuid.New()
+			jobDriver := NewLocalDriver(jobID, &config.Config{
+				InputBuckets: []string{"input-bucket"},
+				OutputBucket: "output-bucket",
+				OutputPrefix: "results/wordcount/",
+			})
+
+			var output bytes.Buffer
+			err := runLocalJob(ctx, jobDriver, &LocalJob{
+				Mapper:              wordCount,
+				NumReducers:         2,
+				RandomizedPartition: test.randomizedPartition,
+				InputPath:           writeLocalInput(t),
+			}, &output)
+			require.Nil(t, err)
+
+			// the manifest lists the part files in the output bucket
+			manifest, err := jobDriver.GetSuccessManifest(ctx)
+			require.Nil(t, err)
+			require.NotNil(t, manifest)
+			assert.Equal(t, jobID, manifest.JobID)
+			assert.Equal(t, "output-bucket", manifest.Bucket)
+			expectedParts := []string{"results/wordcount/part-00000", "results/wordcount/part-00001"}
+			assert.Equal(t, expectedParts[:test.numParts], manifest.Parts)
+
+			// no output is written to the job bucket
+			listOutput, err := jobDriver.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket: aws.String(jobID.String()),
+				Prefix: aws.String(lambdas.DefaultOutputPrefix),
+			})
+			require.Nil(t, err)
+			assert.Empty(t, listOutput.Contents)
+
+			records, err := jobDriver.GetJobOutput(ctx, SortByKey)
+			require.Nil(t, err)
+			assert.Equal(t, []OutputRecord{
+				{Key: "hello", Value: json.Number("3")},
+				{Key: "ribble", Value: json.Number("1")},
+				{Key: "world", Value: json.Number("2")},
+			}, records)
+
+			// a job reading the location after it was overwritten fails
+			otherDriver := NewLocalDriver(uuid.New(), &jobDriver.Config)
+			otherDriver.ObjectStoreAPI = jobDriver.ObjectStoreAPI
+			otherDriver.DownloaderAPI = jobDriver.DownloaderAPI
+			_, err = otherDriver.GetJobOutput(ctx, SortByKey)
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -39,6 +39,31 @@ func (d *Driver) CreateJobBucket(ctx context.Context) error {
 	return nil
 }
 
+// CreateOutputBucket creates the bucket the job output is written to when the
+// job config sets one. Buckets that already exist are used as they are
+func (d *Driver) CreateOutputBucket(ctx context.Context) error {
+	if d.Config.OutputBucket == "" {
+		return nil
+	}
+
+	params := &s3.CreateBucketInput{
+		Bucket: aws.String(d.Config.OutputBucket),
+		CreateBucketConfiguration: &s3Types.CreateBucketConfiguration{
+			LocationConstraint: s3Types.BucketLocationConstraint(d.Config.Region),
+		},
+	}
+
+	_, err := d.ObjectStoreAPI.CreateBucket(ctx, params)
+	if err != nil {
+		// ignore buckets that already exist
+		if !bucketAlreadyExists(err) && !bucketAlreadyOwned(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // CreateDQL creates the dead-letter queue for the service
 func (d *Driver) CreateLambdaDLQ(ctx context.Context) (*string, error) {
 	// create dead-letter queue
@@ -168,7 +193,9 @@ func (d *Driver) CreateQueues(ctx context.Context, numQueues int) error {
 	}
 
 	// wait one second before the queues can be used
-	time.Sleep(1 * time.Second)
+	if !d.queuesReadyOnCreate {
+		time.Sleep(1 * time.Second)
+	}
 
 	return nil
 }
@@ -181,6 +208,8 @@ func (d *Driver) StartCoordinator(ctx context.Context) error {
 		NumMappers:   d.BuildData.NumMappers,
 		NumQueues:    d.BuildData.NumReducers,
 		FunctionName: d.BuildData.MapperData.ImageName,
+		OutputBucket: d.Config.OutputBucket,
+		OutputPrefix: d.Config.OutputPrefix,
 	}
 
 	// create payload
@@ -224,3 +253,9 @@ func bucketAlreadyExists(err error) bool {
 	var alreadyExists *s3Types.BucketAlreadyExists
 	return errors.As(err, &alreadyExists)
 }
+
+// bucketAlreadyOwned checks if the s3 bucket being created is already owned by the account
+func bucketAlreadyOwned(err error) bool {
+	var alreadyOwned *s3Types.BucketAlreadyOwnedByYou
+	return errors.As(err, &alreadyOwned)
+}
@@ -0,0 +1,389 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+)
+
+// JobPhase is the phase a job is in
+type JobPhase string
+
+const (
+	// PhaseNotFound means the job bucket doesn't exist
+	PhaseNotFound JobPhase = "NOT_FOUND"
+	// PhaseNotStarted means the job has been uploaded but the mappers have not been invoked
+	PhaseNotStarted JobPhase = "NOT_STARTED"
+	// PhaseMapping means the mappers have been invoked
+	PhaseMapping JobPhase = "MAPPING"
+	// PhaseReducing means the reducers have been invoked
+	PhaseReducing JobPhase = "REDUCING"
+	// PhaseFinalReducing means the final reducer has been invoked
+	PhaseFinalReducing JobPhase = "FINAL_REDUCING"
+	// PhaseCompleted means the job has finished and its output is available
+	PhaseCompleted JobPhase = "COMPLETED"
+	// PhaseFailed means a function or message of the job has failed
+	PhaseFailed JobPhase = "FAILED"
+)
+
+// exit codes of the status command for each phase
+const (
+	ExitCodeCompleted  = 0
+	ExitCodeError      = 1
+	ExitCodeInProgress = 2
+	ExitCodeNotFound   = 3
+	ExitCodeFailed     = 4
+)
+
+// marker objects written by the coordinator to the job bucket
+const (
+	mappersInvokedObject      = "mappers-invoked"
+	reducersInvokedObject     = "reducers-invoked"
+	finalReducerInvokedObject = "final-reducer-invoked"
+	doneObject                = "done"
+)
+
+// JobStatus holds the current state of a job
+type JobStatus struct {
+	JobID        string              `json:"jobId"`
+	Phase        JobPhase            `json:"phase"`
+	NumMappers   int                 `json:"numMappers"`
+	NumReducers  int                 `json:"numReducers"`
+	MappersDone  int                 `json:"mappersDone"`
+	ReducersDone int                 `json:"reducersDone"`
+	Failure      *lambdas.JobFailure `json:"failure,omitempty"`
+	// SkippedSplits are the splits where the map function failed and
+	// were skipped because of the mapper failure policy of the job
+	SkippedSplits []lambdas.MapperError `json:"skippedSplits,omitempty"`
+	Queues        []QueueStatus         `json:"queues"`
+}
+
+// QueueStatus holds the approximate number of messages in a queue
+type QueueStatus struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	InFlight int    `json:"inFlight"`
+	Delayed  int    `json:"delayed"`
+	Exists   bool   `json:"exists"`
+}
+
+// ExitCode returns the exit code of the status command for the job phase
+func (s *JobStatus) ExitCode() int {
+	switch s.Phase {
+	case PhaseCompleted:
+		return ExitCodeCompleted
+	case PhaseNotFound:
+		return ExitCodeNotFound
+	case PhaseFailed:
+		return ExitCodeFailed
+	default:
+		return ExitCodeInProgress
+	}
+}
+
+// IsFinished checks if the job won't make any more progress
+func (s *JobStatus) IsFinished() bool {
+	return s.Phase == PhaseCompleted || s.Phase == PhaseNotFound || s.Phase == PhaseFailed
+}
+
+// WaitForJob polls the status of the job every pollInterval until the job is finished.
+// If ctx is done first the last status read is returned together with the context error
+func (d *Driver) WaitForJob(ctx context.Context, pollInterval time.Duration) (*JobStatus, error) {
+	for {
+		status, err := d.GetJobStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if status.IsFinished() {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// GetJobStatus reads the marker objects the coordinator writes to the job bucket and
+// the depth of the job queues to get the current phase of the job. A job that hasn't
+// completed has failed if the coordinator recorded a failure or if the dead-letter
+// queues have messages. It doesn't wait for the job so it can be polled
+func (d *Driver) GetJobStatus(ctx context.Context) (*JobStatus, error) {
+	status := &JobStatus{
+		JobID:  d.JobID.String(),
+		Queues: []QueueStatus{},
+	}
+	if d.BuildData != nil {
+		status.NumMappers = d.BuildData.NumMappers
+		status.NumReducers = d.BuildData.NumReducers
+	}
+
+	// get phase from the marker objects, the last one written wins
+	phase, err := d.getJobPhase(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Phase = phase
+	if phase == PhaseNotFound {
+		return status, nil
+	}
+
+	// get splits skipped by the mappers
+	status.SkippedSplits, err = d.GetSkippedSplits(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the done queues hold one message per completed function
+	mappersDone, err := d.getQueueStatus(ctx, fmt.Sprintf("%s-mappers-done", d.JobID.String()))
+	if err != nil {
+		return nil, err
+	}
+	status.MappersDone = mappersDone.Messages + mappersDone.InFlight
+
+	reducersDone, err := d.getQueueStatus(ctx, fmt.Sprintf("%s-reducers-done", d.JobID.String()))
+	if err != nil {
+		return nil, err
+	}
+	status.ReducersDone = reducersDone.Messages + reducersDone.InFlight
+
+	// done messages can be delivered more than once
+	if status.NumMappers > 0 && status.MappersDone > status.NumMappers {
+		status.MappersDone = status.NumMappers
+	}
+	if status.NumReducers > 0 && status.ReducersDone > status.NumReducers {
+		status.ReducersDone = status.NumReducers
+	}
+
+	// get depth of the queues that hold the data sent to the reducers
+	queueNames := make([]string, 0, status.NumReducers+1)
+	for i := 0; i < status.NumReducers; i++ {
+		queueNames = append(queueNames, fmt.Sprintf("%s-%d", d.JobID.String(), i))
+	}
+	queueNames = append(queueNames, fmt.Sprintf("%s-final-aggregator", d.JobID.String()))
+
+	// get depth of the dead-letter queues that hold the failed events and messages
+	dlqNames := []string{
+		fmt.Sprintf("%s-%s", d.JobID.String(), lambdas.LambdaDLQName),
+		fmt.Sprintf("%s-%s", d.JobID.String(), lambdas.MessagesDLQName),
+	}
+	queueNames = append(queueNames, dlqNames...)
+
+	for _, queueName := range queueNames {
+		queueStatus, err := d.getQueueStatus(ctx, queueName)
+		if err != nil {
+			return nil, err
+		}
+		status.Queues = append(status.Queues, *queueStatus)
+	}
+
+	if phase == PhaseCompleted {
+		return status, nil
+	}
+
+	// the failure recorded by the coordinator stops the job
+	failure, err := d.getRecordedFailure(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the coordinator may not have seen the failed events yet, or may have failed itself
+	if failure == nil {
+		for _, queueStatus := range status.Queues[len(status.Queues)-len(dlqNames):] {
+			if count := queueStatus.Messages + queueStatus.InFlight; count > 0 {
+				failure = &lambdas.JobFailure{
+					Queue: queueStatus.Name,
+					Error: fmt.Sprintf("%d messages in the dead-letter queue %s", count, queueStatus.Name),
+				}
+				break
+			}
+		}
+	}
+
+	if failure != nil {
+		status.Phase = PhaseFailed
+		status.Failure = failure
+	}
+
+	return status, nil
+}
+
+// getRecordedFailure reads the failure written to the job bucket by the
+// coordinator. It returns nil if the job hasn't failed
+func (d *Driver) getRecordedFailure(ctx context.Context) (*lambdas.JobFailure, error) {
+	bucket := d.JobID.String()
+	output, err := d.ObjectStoreAPI.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    aws.String(lambdas.FailedObject),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	var failure lambdas.JobFailure
+	if err := json.NewDecoder(output.Body).Decode(&failure); err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// GetSkippedSplits reads the errors of the splits that the mappers skipped
+// because the map function failed on them
+func (d *Driver) GetSkippedSplits(ctx context.Context) ([]lambdas.MapperError, error) {
+	bucket := d.JobID.String()
+
+	skippedSplits := []lambdas.MapperError{}
+	var continuationToken *string
+	for {
+		listOutput, err := d.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &bucket,
+			Prefix:            aws.String(lambdas.SkippedSplitsPrefix),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range listOutput.Contents {
+			output, err := d.ObjectStoreAPI.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: &bucket,
+				Key:    object.Key,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			var skippedSplit lambdas.MapperError
+			err = json.NewDecoder(output.Body).Decode(&skippedSplit)
+			output.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			skippedSplits = append(skippedSplits, skippedSplit)
+		}
+
+		if !listOutput.IsTruncated {
+			break
+		}
+		continuationToken = listOutput.NextContinuationToken
+	}
+
+	return skippedSplits, nil
+}
+
+// getJobPhase returns the phase of the job from the marker objects in the job bucket
+func (d *Driver) getJobPhase(ctx context.Context) (JobPhase, error) {
+	markers := []struct {
+		object string
+		phase  JobPhase
+	}{
+		{doneObject, PhaseCompleted},
+		{finalReducerInvokedObject, PhaseFinalReducing},
+		{reducersInvokedObject, PhaseReducing},
+		{mappersInvokedObject, PhaseMapping},
+	}
+
+	for _, marker := range markers {
+		exists, err := d.objectExists(ctx, marker.object)
+		if err != nil {
+			if noSuchBucket(err) {
+				return PhaseNotFound, nil
+			}
+			return "", err
+		}
+
+		if exists {
+			return marker.phase, nil
+		}
+	}
+
+	// make sure the job bucket exists
+	bucket := d.JobID.String()
+	_, err := d.ObjectStoreAPI.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  &bucket,
+		MaxKeys: 1,
+	})
+	if err != nil {
+		if noSuchBucket(err) {
+			return PhaseNotFound, nil
+		}
+		return "", err
+	}
+
+	return PhaseNotStarted, nil
+}
+
+// objectExists checks if the object exists in the job bucket
+func (d *Driver) objectExists(ctx context.Context, key string) (bool, error) {
+	bucket := d.JobID.String()
+	output, err := d.ObjectStoreAPI.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return false, nil
+		}
+		return false, err
+	}
+	output.Body.Close()
+
+	return true, nil
+}
+
+// getQueueStatus gets the approximate number of messages in the queue. Queues that
+// don't exist are reported as empty
+func (d *Driver) getQueueStatus(ctx context.Context, queueName string) (*QueueStatus, error) {
+	queueStatus := &QueueStatus{
+		Name: queueName,
+	}
+
+	urlOutput, err := d.QueuesAPI.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: &queueName,
+	})
+	if err != nil {
+		if queueDoesNotExist(err) {
+			return queueStatus, nil
+		}
+		return nil, err
+	}
+	queueStatus.Exists = true
+
+	output, err := d.QueuesAPI.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: urlOutput.QueueUrl,
+		AttributeNames: []sqsTypes.QueueAttributeName{
+			sqsTypes.QueueAttributeNameApproximateNumberOfMessages,
+			sqsTypes.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			sqsTypes.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	queueStatus.Messages, _ = strconv.Atoi(output.Attributes["ApproximateNumberOfMessages"])
+	queueStatus.InFlight, _ = strconv.Atoi(output.Attributes["ApproximateNumberOfMessagesNotVisible"])
+	queueStatus.Delayed, _ = strconv.Atoi(output.Attributes["ApproximateNumberOfMessagesDelayed"])
+
+	return queueStatus, nil
+}
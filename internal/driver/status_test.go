@@ -0,0 +1,220 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/internal/generators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetJobStatus_NotFound(t *testing.T) {
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{})
+
+	status, err := jobDriver.GetJobStatus(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, PhaseNotFound, status.Phase)
+	assert.Equal(t, ExitCodeNotFound, status.ExitCode())
+}
+
+func Test_GetJobStatus_Phases(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+	jobDriver := NewLocalDriver(jobID, &config.Config{})
+	jobDriver.BuildData = &generators.BuildData{
+		NumMappers:  3,
+		NumReducers: 2,
+	}
+
+	require.Nil(t, jobDriver.CreateJobBucket(ctx))
+	require.Nil(t, jobDriver.CreateQueues(ctx, 2))
+
+	status, err := jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseNotStarted, status.Phase)
+	assert.Equal(t, ExitCodeInProgress, status.ExitCode())
+
+	writeMarker := func(key string) {
+		_, err := jobDriver.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(jobID.String()),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte{}),
+		})
+		require.Nil(t, err)
+	}
+	sendMessage := func(queueName, body string) {
+		output, err := jobDriver.QueuesAPI.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+			QueueName: aws.String(fmt.Sprintf("%s-%s", jobID.String(), queueName)),
+		})
+		require.Nil(t, err)
+		_, err = jobDriver.QueuesAPI.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:    output.QueueUrl,
+			MessageBody: aws.String(body),
+		})
+		require.Nil(t, err)
+	}
+
+	// two mappers done and one batch waiting in the first queue
+	writeMarker("mappers-invoked")
+	sendMessage("mappers-done", "mapper-1")
+	sendMessage("mappers-done", "mapper-2")
+	sendMessage("0", "batch")
+
+	status, err = jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseMapping, status.Phase)
+	assert.Equal(t, 2, status.MappersDone)
+	assert.Equal(t, 3, status.NumMappers)
+	require.Len(t, status.Queues, 5)
+	assert.Equal(t, fmt.Sprintf("%s-0", jobID.String()), status.Queues[0].Name)
+	assert.Equal(t, 1, status.Queues[0].Messages)
+	assert.Equal(t, 0, status.Queues[1].Messages)
+
+	writeMarker("reducers-invoked")
+	status, err = jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseReducing, status.Phase)
+
+	writeMarker("final-reducer-invoked")
+	status, err = jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseFinalReducing, status.Phase)
+
+	writeMarker("done")
+	status, err = jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseCompleted, status.Phase)
+	assert.Equal(t, ExitCodeCompleted, status.ExitCode())
+}
+
+func Test_GetJobStatus_Failed(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+	jobDriver := NewLocalDriver(jobID, &config.Config{})
+	jobDriver.BuildData = &generators.BuildData{
+		NumMappers:  2,
+		NumReducers: 1,
+	}
+
+	require.Nil(t, jobDriver.CreateJobBucket(ctx))
+	require.Nil(t, jobDriver.CreateQueues(ctx, 1))
+	_, err := jobDriver.CreateLambdaDLQ(ctx)
+	require.Nil(t, err)
+
+	_, err = jobDriver.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(jobID.String()),
+		Key:    aws.String("mappers-invoked"),
+		Body:   bytes.NewReader([]byte{}),
+	})
+	require.Nil(t, err)
+
+	// a failed event in the lambda dead-letter queue fails the job
+	dlqName := fmt.Sprintf("%s-lambda-dlq", jobID.String())
+	urlOutput, err := jobDriver.QueuesAPI.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(dlqName),
+	})
+	require.Nil(t, err)
+	_, err = jobDriver.QueuesAPI.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    urlOutput.QueueUrl,
+		MessageBody: aws.String(`{"jobID":"` + jobID.String() + `"}`),
+	})
+	require.Nil(t, err)
+
+	status, err := jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseFailed, status.Phase)
+	assert.Equal(t, ExitCodeFailed, status.ExitCode())
+	assert.True(t, status.IsFinished())
+	require.NotNil(t, status.Failure)
+	assert.Equal(t, dlqName, status.Failure.Queue)
+
+	// the failure recorded by the coordinator is reported
+	failure := &lambdas.JobFailure{
+		Queue:     dlqName,
+		Function:  "Mapper",
+		MappingID: uuid.New().String(),
+		Error:     "out of memory",
+	}
+	p, err := json.Marshal(failure)
+	require.Nil(t, err)
+	_, err = jobDriver.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(jobID.String()),
+		Key:    aws.String("failed"),
+		Body:   bytes.NewReader(p),
+	})
+	require.Nil(t, err)
+
+	status, err = jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseFailed, status.Phase)
+	assert.Equal(t, failure, status.Failure)
+}
+
+func Test_GetJobStatus_AfterRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	jobDriver := NewLocalDriver(uuid.New(), &config.Config{
+		InputBuckets: []string{"input-bucket"},
+	})
+
+	var output bytes.Buffer
+	err := runLocalJob(ctx, jobDriver, &LocalJob{
+		Mapper:      wordCount,
+		NumReducers: 2,
+		InputPath:   writeLocalInput(t),
+	}, &output)
+	require.Nil(t, err)
+
+	status, err := jobDriver.GetJobStatus(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseCompleted, status.Phase)
+	assert.Equal(t, status.NumMappers, status.MappersDone)
+	assert.Equal(t, 2, status.ReducersDone)
+}
+
+func Test_WaitForJob(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	jobID := uuid.New()
+	jobDriver := NewLocalDriver(jobID, &config.Config{})
+	jobDriver.BuildData = &generators.BuildData{
+		NumMappers:  1,
+		NumReducers: 1,
+	}
+	require.Nil(t, jobDriver.CreateJobBucket(ctx))
+
+	// the job is not done before the timeout
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	status, err := jobDriver.WaitForJob(waitCtx, 10*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	require.NotNil(t, status)
+	assert.Equal(t, PhaseNotStarted, status.Phase)
+
+	// the job is done while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		jobDriver.UploaderAPI.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(jobID.String()),
+			Key:    aws.String("done"),
+			Body:   bytes.NewReader([]byte{}),
+		})
+	}()
+
+	status, err = jobDriver.WaitForJob(ctx, 10*time.Millisecond)
+	require.Nil(t, err)
+	assert.Equal(t, PhaseCompleted, status.Phase)
+}
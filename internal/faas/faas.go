@@ -33,4 +33,9 @@ type FaasAPI interface {
 		params *lambda.PutProvisionedConcurrencyConfigInput,
 		optFns ...func(*lambda.Options),
 	) (*lambda.PutProvisionedConcurrencyConfigOutput, error)
+	DeleteFunction(
+		ctx context.Context,
+		params *lambda.DeleteFunctionInput,
+		optFns ...func(*lambda.Options),
+	) (*lambda.DeleteFunctionOutput, error)
 }
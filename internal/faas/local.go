@@ -0,0 +1,209 @@
+package faas
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/google/uuid"
+)
+
+const (
+	// localAccountID is the account id used in the arn of local functions
+	localAccountID = "000000000000"
+	// asyncSuccessCode is the status code returned by event invocations
+	asyncSuccessCode int32 = 202
+	// syncSuccessCode is the status code returned by request response invocations
+	syncSuccessCode int32 = 200
+)
+
+// LocalHandler is a function that runs in-process when a local function is invoked
+type LocalHandler func(ctx context.Context, payload []byte) error
+
+// LocalFaas is an implementation of FaasAPI that runs registered handlers in
+// goroutines instead of invoking lambda functions. It is used to run jobs in-process
+type LocalFaas struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	region   string
+	handlers map[string]LocalHandler
+	wg       sync.WaitGroup
+	err      error
+	mu       sync.Mutex
+}
+
+// NewLocalFaas creates a LocalFaas. The invoked handlers are cancelled once
+// ctx is done or as soon as any handler returns an error
+func NewLocalFaas(ctx context.Context, region string) *LocalFaas {
+	ctx, cancel := context.WithCancel(ctx)
+	return &LocalFaas{
+		ctx:      ctx,
+		cancel:   cancel,
+		region:   region,
+		handlers: make(map[string]LocalHandler),
+	}
+}
+
+// Register adds a handler for the function with the given name
+func (f *LocalFaas) Register(functionName string, handler LocalHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.handlers[functionName] = handler
+}
+
+// Wait blocks until all invoked handlers have returned and returns the first
+// error returned by any of them
+func (f *LocalFaas) Wait() error {
+	f.wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.err
+}
+
+// Invoke runs the handler registered for the function name. Event invocations
+// run in the background and return straight away, any other invocation type
+// waits for the handler to return
+func (f *LocalFaas) Invoke(
+	ctx context.Context,
+	params *lambda.InvokeInput,
+	optFns ...func(*lambda.Options),
+) (*lambda.InvokeOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	functionName := localFunctionName(params.FunctionName)
+
+	f.mu.Lock()
+	handler, ok := f.handlers[functionName]
+	f.mu.Unlock()
+	if !ok {
+		return nil, &types.ResourceNotFoundException{
+			Message: aws.String(fmt.Sprintf("Function not found: %s", functionName)),
+		}
+	}
+
+	// handlers run with the lambda context so that they can read the account id
+	lc := &lambdacontext.LambdaContext{
+		AwsRequestID: uuid.New().String(),
+		InvokedFunctionArn: fmt.Sprintf(
+			"arn:aws:lambda:%s:%s:function:%s",
+			f.region,
+			localAccountID,
+			functionName,
+		),
+	}
+	handlerCtx := lambdacontext.NewContext(f.ctx, lc)
+
+	if params.InvocationType == types.InvocationTypeEvent {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.setErr(functionName, handler(handlerCtx, params.Payload))
+		}()
+
+		return &lambda.InvokeOutput{StatusCode: asyncSuccessCode}, nil
+	}
+
+	f.wg.Add(1)
+	defer f.wg.Done()
+	if err := handler(handlerCtx, params.Payload); err != nil {
+		f.setErr(functionName, err)
+		return &lambda.InvokeOutput{
+			StatusCode:    syncSuccessCode,
+			FunctionError: aws.String(err.Error()),
+		}, nil
+	}
+
+	return &lambda.InvokeOutput{StatusCode: syncSuccessCode}, nil
+}
+
+// setErr records the first handler error and cancels the rest of the handlers
+func (f *LocalFaas) setErr(functionName string, err error) {
+	if err == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err == nil {
+		f.err = fmt.Errorf("%s: %w", functionName, err)
+		f.cancel()
+	}
+}
+
+// DeleteFunction removes the handler registered for the function name
+func (f *LocalFaas) DeleteFunction(
+	ctx context.Context,
+	params *lambda.DeleteFunctionInput,
+	optFns ...func(*lambda.Options),
+) (*lambda.DeleteFunctionOutput, error) {
+	functionName := localFunctionName(params.FunctionName)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.handlers[functionName]; !ok {
+		return nil, &types.ResourceNotFoundException{
+			Message: aws.String(fmt.Sprintf("Function not found: %s", functionName)),
+		}
+	}
+	delete(f.handlers, functionName)
+
+	return &lambda.DeleteFunctionOutput{}, nil
+}
+
+// AddPermission is a no-op for local functions
+func (f *LocalFaas) AddPermission(
+	ctx context.Context,
+	params *lambda.AddPermissionInput,
+	optFns ...func(*lambda.Options),
+) (*lambda.AddPermissionOutput, error) {
+	return &lambda.AddPermissionOutput{}, nil
+}
+
+// CreateFunction is not supported for local functions, handlers are added with Register
+func (f *LocalFaas) CreateFunction(
+	ctx context.Context,
+	params *lambda.CreateFunctionInput,
+	optFns ...func(*lambda.Options),
+) (*lambda.CreateFunctionOutput, error) {
+	return nil, fmt.Errorf("CreateFunction is not supported locally, register a handler for %s", aws.ToString(params.FunctionName))
+}
+
+// PutFunctionConcurrency is a no-op for local functions
+func (f *LocalFaas) PutFunctionConcurrency(
+	ctx context.Context,
+	params *lambda.PutFunctionConcurrencyInput,
+	optFns ...func(*lambda.Options),
+) (*lambda.PutFunctionConcurrencyOutput, error) {
+	return &lambda.PutFunctionConcurrencyOutput{}, nil
+}
+
+// PutProvisionedConcurrencyConfig is a no-op for local functions
+func (f *LocalFaas) PutProvisionedConcurrencyConfig(
+	ctx context.Context,
+	params *lambda.PutProvisionedConcurrencyConfigInput,
+	optFns ...func(*lambda.Options),
+) (*lambda.PutProvisionedConcurrencyConfigOutput, error) {
+	return &lambda.PutProvisionedConcurrencyConfigOutput{}, nil
+}
+
+// localFunctionName returns the function name of a name or function arn
+func localFunctionName(name *string) string {
+	functionName := aws.ToString(name)
+	if index := strings.LastIndex(functionName, "function:"); index != -1 {
+		functionName = functionName[index+len("function:"):]
+	}
+
+	return functionName
+}
@@ -15,6 +15,11 @@ const (
 	WorkspaceFlag                 = "--workspace"
 	JobIdFlag                     = "--job-id"
 	JobLocalFlag                  = "--local"
+	InProcessFlag                 = "--in-process"
+	InputFlag                     = "--input"
+	DataDirFlag                   = "--data-dir"
+	ReducersFlag                  = "--reducers"
+	PollIntervalFlag              = "--poll-interval"
 	ScriptToGenerateGoFiles       = "./build/generate_lambda_files.sh"
 	ScriptToBuildImages           = "./build/build_dockerfiles.sh"
 	ScriptToBuildAggregatorImages = "./build/build_aggregators.sh"
@@ -3,6 +3,7 @@ package generators
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"runtime"
@@ -10,12 +11,16 @@ import (
 	"text/template"
 
 	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
 )
 
 var (
 	// vars used to compare user data type
 	stringType        = reflect.TypeOf("")
+	readerType        = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	inputObjectType   = reflect.TypeOf(lambdas.InputObject{})
 	mapAggregatorType = reflect.TypeOf(make(aggregators.MapAggregator))
+	errorType         = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 // FunctionData defines the data needed for the template
@@ -29,6 +34,14 @@ type FunctionData struct {
 	Dockefile     string `yaml:"Dockerfile,omitempty"`
 	Aggregator    string `yaml:"Aggregator,omitempty"`
 	Local         bool   `yaml:"Local,omitempty"`
+	// Streaming is true if the function streams its input instead of reading a file
+	Streaming bool `yaml:"Streaming,omitempty"`
+	// RecordFormat is the go literal of the record format of record mappers
+	RecordFormat string `yaml:"RecordFormat,omitempty"`
+	// ReturnsError is true if the function returns an error with its output
+	ReturnsError bool `yaml:"ReturnsError,omitempty"`
+	// FailurePolicy is the policy of the job for splits where the function fails
+	FailurePolicy string `yaml:"FailurePolicy,omitempty"`
 }
 
 // GetFunctionData gets as input an interface that should be a function
@@ -63,41 +76,52 @@ func GetFunctionData(i interface{}, jobID string, local bool) *FunctionData {
 			jobID,
 			functionName,
 		),
-		Local: local,
+		Local:        local,
+		Streaming:    reflect.TypeOf(i).In(0) == readerType,
+		ReturnsError: reflect.TypeOf(i).NumOut() == 2,
 	}
 }
 
 // ValidateMapper gets a mapper function as input and check that its
-// return type is a valid aggregator type
+// return type is a valid aggregator type. The mapper can take the
+// filename as input or a reader and the object it streams, and it
+// can return an error after the aggregator
 func ValidateMapper(mapper interface{}) error {
 	mapperType := reflect.TypeOf(mapper)
-
-	// validate that the input of the function gets one argument
-	// which should be the filename
-	if mapperType.NumIn() != 1 {
-		return errors.New("Invalid error signature. The mapper function can only take the filename as input")
+	if mapperType == nil || mapperType.Kind() != reflect.Func {
+		return errors.New("The mapper should be a function")
 	}
 
-	// validate the input of function is a string
-	if !mapperType.In(0).ConvertibleTo(stringType) {
-		return errors.New("Invalid error signature. The input to the function should be a string")
+	switch mapperType.NumIn() {
+	case 1:
+		// validate the input of function is a string
+		if mapperType.In(0) != stringType {
+			return errors.New("Invalid error signature. The input to the function should be a string")
+		}
+	case 2:
+		// validate the input of streaming functions is a reader and the object
+		if mapperType.In(0) != readerType || mapperType.In(1) != inputObjectType {
+			return errors.New("Invalid error signature. The input to the function should be an io.Reader and a lambdas.InputObject")
+		}
+	default:
+		return errors.New("Invalid error signature. The mapper function can only take the filename, or a reader and the object, as input")
 	}
 
-	// validate that the function returns a single value
-	if mapperType.NumOut() != 1 {
-		return errors.New("The mapper function can only have one output")
+	// validate that the function returns the aggregator and optionally an error
+	if mapperType.NumOut() != 1 && mapperType.NumOut() != 2 {
+		return errors.New("The mapper function can only return the aggregator, or the aggregator and an error")
+	}
+	if mapperType.NumOut() == 2 && mapperType.Out(1) != errorType {
+		return errors.New("The second output of the mapper function should be an error")
 	}
 
 	// return the aggregator specified or return error if the output
 	// is not a valid aggregator
-	aggregatorType := mapperType.Out(0)
-
-	switch aggregatorType.Name() {
-	case "MapAggregator":
-		return nil
-	default:
+	if mapperType.Out(0) != mapAggregatorType {
 		return errors.New("Invalid aggregator used")
 	}
+
+	return nil
 }
 
 // ExecuteMapGenerator generates a go file with the auto generated code
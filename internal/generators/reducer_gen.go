@@ -28,6 +28,9 @@ type ReducerFunctionData struct {
 	ImageTag       string `yaml:"ImageTag,omitempty"`
 	Dockefile      string `yaml:"Dockerfile,omitempty"`
 	Local          bool   `yaml:"Local,omitempty"`
+	// JobPackagePath is the package of the mapper, which is imported by the
+	// reducers so that the aggregators registered in the package are available
+	JobPackagePath string `yaml:"JobPackagePath,omitempty"`
 }
 
 // GetReducerData gets as input an interface that should be a function
@@ -38,6 +41,7 @@ func GetReducerData(
 	randomizedPartition bool,
 	jobID string,
 	local bool,
+	jobPackagePath string,
 ) []*ReducerFunctionData {
 	functionData := []*ReducerFunctionData{}
 	if randomizedPartition {
@@ -133,6 +137,10 @@ func GetReducerData(
 		functionData[0].WithSort = false
 	}
 
+	for i := range functionData {
+		functionData[i].JobPackagePath = jobPackagePath
+	}
+
 	return functionData
 }
 
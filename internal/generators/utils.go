@@ -3,18 +3,19 @@ package generators
 import (
 	"errors"
 
+	"github.com/josenarvaezp/displ/pkg/aggregators"
 	"github.com/josenarvaezp/displ/pkg/lambdas"
 )
 
 // AggregatorTypeToCoordinatorData generates the data needed for the coordinator
 // template according to the aggregator type
-func AggregatorTypeToCoordinatorData(aggregatorType lambdas.AggregatorType) (*CoordinatorData, error) {
+func AggregatorTypeToCoordinatorData(aggregatorType aggregators.AggregatorType) (*CoordinatorData, error) {
 	coordinatorData := &CoordinatorData{}
 
 	switch aggregatorType {
-	case lambdas.MapAggregator:
+	case aggregators.MapAggregatorType:
 		coordinatorData.LambdaAggregator = lambdas.ECRMapAggregator
-	case lambdas.SumAggregator:
+	case aggregators.SumAggregatorType:
 		coordinatorData.LambdaAggregator = lambdas.ECRMapAggregator
 		// coordinatorData.LambdaFinalAggregator = lambdas.ECRAggregatorSumFinal
 	default:
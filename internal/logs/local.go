@@ -0,0 +1,123 @@
+package logs
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// LocalLogs is an implementation of LogsAPI that writes the log events
+// to the standard logger and keeps them in memory. It is used to run jobs in-process
+type LocalLogs struct {
+	events []types.OutputLogEvent
+	mu     sync.Mutex
+}
+
+// NewLocalLogs creates an empty LocalLogs
+func NewLocalLogs() *LocalLogs {
+	return &LocalLogs{}
+}
+
+// CreateLogGroup is a no-op as all local events are kept in a single stream
+func (l *LocalLogs) CreateLogGroup(
+	ctx context.Context,
+	params *cloudwatchlogs.CreateLogGroupInput,
+	optFns ...func(*cloudwatchlogs.Options),
+) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+// CreateLogStream is a no-op as all local events are kept in a single stream
+func (l *LocalLogs) CreateLogStream(
+	ctx context.Context,
+	params *cloudwatchlogs.CreateLogStreamInput,
+	optFns ...func(*cloudwatchlogs.Options),
+) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+// PutLogEvents logs the events and stores them
+func (l *LocalLogs) PutLogEvents(
+	ctx context.Context,
+	params *cloudwatchlogs.PutLogEventsInput,
+	optFns ...func(*cloudwatchlogs.Options),
+) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, event := range params.LogEvents {
+		log.WithField(
+			"Timestamp", time.Unix(0, aws.ToInt64(event.Timestamp)*int64(time.Millisecond)).Format(time.RFC3339),
+		).Info(aws.ToString(event.Message))
+
+		l.events = append(l.events, types.OutputLogEvent{
+			Message:       event.Message,
+			Timestamp:     event.Timestamp,
+			IngestionTime: aws.Int64(time.Now().UnixNano() / int64(time.Millisecond)),
+		})
+	}
+
+	return &cloudwatchlogs.PutLogEventsOutput{
+		NextSequenceToken: aws.String(strconv.Itoa(len(l.events))),
+	}, nil
+}
+
+// GetLogEvents returns the stored events after the given token
+func (l *LocalLogs) GetLogEvents(
+	ctx context.Context,
+	params *cloudwatchlogs.GetLogEventsInput,
+	optFns ...func(*cloudwatchlogs.Options),
+) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := 0
+	if params.NextToken != nil {
+		start, _ = strconv.Atoi(*params.NextToken)
+	}
+	if start > len(l.events) {
+		start = len(l.events)
+	}
+
+	events := make([]types.OutputLogEvent, len(l.events)-start)
+	copy(events, l.events[start:])
+
+	return &cloudwatchlogs.GetLogEventsOutput{
+		Events:           events,
+		NextForwardToken: aws.String(strconv.Itoa(len(l.events))),
+	}, nil
+}
+
+// DeleteLogStream deletes the stored events
+func (l *LocalLogs) DeleteLogStream(
+	ctx context.Context,
+	params *cloudwatchlogs.DeleteLogStreamInput,
+	optFns ...func(*cloudwatchlogs.Options),
+) (*cloudwatchlogs.DeleteLogStreamOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = nil
+
+	return &cloudwatchlogs.DeleteLogStreamOutput{}, nil
+}
+
+// DeleteLogGroup deletes the stored events
+func (l *LocalLogs) DeleteLogGroup(
+	ctx context.Context,
+	params *cloudwatchlogs.DeleteLogGroupInput,
+	optFns ...func(*cloudwatchlogs.Options),
+) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = nil
+
+	return &cloudwatchlogs.DeleteLogGroupOutput{}, nil
+}
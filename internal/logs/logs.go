@@ -28,4 +28,14 @@ type LogsAPI interface {
 		params *cloudwatchlogs.GetLogEventsInput,
 		optFns ...func(*cloudwatchlogs.Options),
 	) (*cloudwatchlogs.GetLogEventsOutput, error)
+	DeleteLogStream(
+		ctx context.Context,
+		params *cloudwatchlogs.DeleteLogStreamInput,
+		optFns ...func(*cloudwatchlogs.Options),
+	) (*cloudwatchlogs.DeleteLogStreamOutput, error)
+	DeleteLogGroup(
+		ctx context.Context,
+		params *cloudwatchlogs.DeleteLogGroupInput,
+		optFns ...func(*cloudwatchlogs.Options),
+	) (*cloudwatchlogs.DeleteLogGroupOutput, error)
 }
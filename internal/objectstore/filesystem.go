@@ -0,0 +1,481 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// tmpFilePrefix is the prefix of the files used while uploading objects
+	tmpFilePrefix = ".ribble-tmp-"
+	// downloadPartSize is the size of the parts copied when downloading an object
+	downloadPartSize = 5 * 1024 * 1024
+)
+
+// FileObjectStore is an implementation of ObjectStoreAPI, ManagerDownloaderAPI
+// and ManagerUploaderAPI backed by a local directory. Each bucket is a directory
+// under the root directory and each object is a file in its bucket directory,
+// where the slashes in the object key are nested directories
+type FileObjectStore struct {
+	root string
+}
+
+// NewFileObjectStore creates an object store rooted at the given directory.
+// The directory is created if it doesn't exist
+func NewFileObjectStore(root string) (*FileObjectStore, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &FileObjectStore{
+		root: root,
+	}, nil
+}
+
+// CreateBucket creates the directory for the bucket. Creating a bucket that
+// already exists is not an error
+func (s *FileObjectStore) CreateBucket(
+	ctx context.Context,
+	params *s3.CreateBucketInput,
+	optFns ...func(*s3.Options),
+) (*s3.CreateBucketOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bucketDir, err := s.bucketPath(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(bucketDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &s3.CreateBucketOutput{
+		Location: aws.String(fmt.Sprintf("/%s", aws.ToString(params.Bucket))),
+	}, nil
+}
+
+// ListObjectsV2 lists the files in a bucket directory in lexicographical order of
+// their keys. It supports the Prefix, StartAfter, MaxKeys and ContinuationToken parameters
+func (s *FileObjectStore) ListObjectsV2(
+	ctx context.Context,
+	params *s3.ListObjectsV2Input,
+	optFns ...func(*s3.Options),
+) (*s3.ListObjectsV2Output, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bucket := aws.ToString(params.Bucket)
+	bucketDir, err := s.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(bucketDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+		}
+		return nil, err
+	}
+
+	// the continuation token is the last key returned in the previous page
+	startAfter := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		startAfter = *params.ContinuationToken
+	}
+	prefix := aws.ToString(params.Prefix)
+
+	// get all keys in the bucket
+	sizes := make(map[string]int64)
+	keys := []string{}
+	err = filepath.Walk(bucketDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(bucketDir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+			sizes[key] = info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	maxKeys := int(params.MaxKeys)
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:              params.Bucket,
+		Prefix:            params.Prefix,
+		MaxKeys:           int32(maxKeys),
+		ContinuationToken: params.ContinuationToken,
+	}
+
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		output.IsTruncated = true
+		output.NextContinuationToken = aws.String(keys[len(keys)-1])
+	}
+
+	output.Contents = make([]types.Object, len(keys))
+	for i, key := range keys {
+		output.Contents[i] = types.Object{
+			Key:  aws.String(key),
+			Size: sizes[key],
+		}
+	}
+	output.KeyCount = int32(len(keys))
+
+	return output, nil
+}
+
+// GetObject returns a reader for the object file. The Range parameter is supported
+// in the form bytes=start-end where both ends are inclusive, in which case only the
+// requested range is read from disk
+func (s *FileObjectStore) GetObject(
+	ctx context.Context,
+	params *s3.GetObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, start, end, err := s.openRange(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body: &sectionReadCloser{
+			SectionReader: io.NewSectionReader(file, start, end-start+1),
+			file:          file,
+		},
+		ContentLength: end - start + 1,
+	}, nil
+}
+
+// HeadObject returns the size of the object file. Files don't have metadata
+// so the content encoding is never set
+func (s *FileObjectStore) HeadObject(
+	ctx context.Context,
+	params *s3.HeadObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.HeadObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, start, end, err := s.openRange(&s3.GetObjectInput{
+		Bucket: params.Bucket,
+		Key:    params.Key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return &s3.HeadObjectOutput{
+		ContentLength: end - start + 1,
+	}, nil
+}
+
+// Download writes the object, or the requested range of it, into w
+func (s *FileObjectStore) Download(
+	ctx context.Context,
+	w io.WriterAt,
+	input *s3.GetObjectInput,
+	options ...func(*manager.Downloader),
+) (n int64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	file, start, end, err := s.openRange(input)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// copy the range in parts so that big objects are not read into memory at once
+	buf := make([]byte, downloadPartSize)
+	reader := io.NewSectionReader(file, start, end-start+1)
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		read, readErr := reader.Read(buf)
+		if read > 0 {
+			written, err := w.WriteAt(buf[:read], n)
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		if readErr == io.EOF {
+			return n, nil
+		}
+		if readErr != nil {
+			return n, readErr
+		}
+	}
+}
+
+// Upload writes the body of the input to the object file. The body is written to a
+// temporary file first so that readers never see a partially written object
+func (s *FileObjectStore) Upload(
+	ctx context.Context,
+	input *s3.PutObjectInput,
+	opts ...func(*manager.Uploader),
+) (*manager.UploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bucket := aws.ToString(input.Bucket)
+	bucketDir, err := s.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(bucketDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+		}
+		return nil, err
+	}
+
+	objectPath, err := s.objectPath(bucket, aws.ToString(input.Key))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(objectPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// write object to temporary file
+	tmpFile, err := ioutil.TempFile(filepath.Dir(objectPath), tmpFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if input.Body != nil {
+		if _, err := io.Copy(tmpFile, input.Body); err != nil {
+			tmpFile.Close()
+			return nil, err
+		}
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+
+	// move temporary file to the object path
+	if err := os.Rename(tmpFile.Name(), objectPath); err != nil {
+		return nil, err
+	}
+
+	return &manager.UploadOutput{
+		Location: fmt.Sprintf("/%s/%s", bucket, aws.ToString(input.Key)),
+	}, nil
+}
+
+// DeleteObjects deletes the object files and the directories left empty by
+// them. As in S3 deleting a key that doesn't exist succeeds
+func (s *FileObjectStore) DeleteObjects(
+	ctx context.Context,
+	params *s3.DeleteObjectsInput,
+	optFns ...func(*s3.Options),
+) (*s3.DeleteObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := validateDelete(params.Delete); err != nil {
+		return nil, err
+	}
+
+	bucket := aws.ToString(params.Bucket)
+	bucketDir, err := s.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(bucketDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+		}
+		return nil, err
+	}
+
+	output := &s3.DeleteObjectsOutput{}
+	for _, object := range params.Delete.Objects {
+		objectPath, err := s.objectPath(bucket, aws.ToString(object.Key))
+		if err == nil {
+			err = os.Remove(objectPath)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			output.Errors = append(output.Errors, types.Error{
+				Key:     object.Key,
+				Code:    aws.String("InternalError"),
+				Message: aws.String(err.Error()),
+			})
+			continue
+		}
+
+		// remove parent directories until the bucket directory or a non empty directory
+		if err == nil {
+			for dir := filepath.Dir(objectPath); dir != bucketDir; dir = filepath.Dir(dir) {
+				if os.Remove(dir) != nil {
+					break
+				}
+			}
+		}
+
+		output.Deleted = append(output.Deleted, types.DeletedObject{Key: object.Key})
+	}
+
+	return output, nil
+}
+
+// DeleteBucket deletes the bucket directory. As in S3 the bucket must be empty
+func (s *FileObjectStore) DeleteBucket(
+	ctx context.Context,
+	params *s3.DeleteBucketInput,
+	optFns ...func(*s3.Options),
+) (*s3.DeleteBucketOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bucket := aws.ToString(params.Bucket)
+	bucketDir, err := s.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  params.Bucket,
+		MaxKeys: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Contents) > 0 {
+		return nil, &BucketNotEmptyError{Bucket: bucket}
+	}
+
+	// the bucket may still hold empty directories
+	if err := os.RemoveAll(bucketDir); err != nil {
+		return nil, err
+	}
+
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+// openRange opens the object file and returns the first and last byte of the requested range
+func (s *FileObjectStore) openRange(params *s3.GetObjectInput) (*os.File, int64, int64, error) {
+	bucket := aws.ToString(params.Bucket)
+	key := aws.ToString(params.Key)
+
+	objectPath, err := s.objectPath(bucket, key)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			bucketDir, _ := s.bucketPath(bucket)
+			if _, statErr := os.Stat(bucketDir); os.IsNotExist(statErr) {
+				return nil, 0, 0, &types.NoSuchBucket{Message: aws.String(bucket)}
+			}
+			return nil, 0, 0, &types.NoSuchKey{Message: aws.String(key)}
+		}
+		return nil, 0, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, 0, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, 0, 0, &types.NoSuchKey{Message: aws.String(key)}
+	}
+
+	start, end := int64(0), info.Size()-1
+	if params.Range != nil {
+		start, end, err = parseRange(*params.Range, info.Size())
+		if err != nil {
+			file.Close()
+			return nil, 0, 0, err
+		}
+	}
+
+	return file, start, end, nil
+}
+
+// bucketPath returns the directory of the bucket
+func (s *FileObjectStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("InvalidBucketName: %q", bucket)
+	}
+
+	return filepath.Join(s.root, bucket), nil
+}
+
+// objectPath returns the path of the object file. Keys that would point
+// outside of the bucket directory are rejected
+func (s *FileObjectStore) objectPath(bucket, key string) (string, error) {
+	bucketDir, err := s.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+
+	objectPath := filepath.Join(bucketDir, filepath.FromSlash(key))
+	if key == "" || !strings.HasPrefix(objectPath, bucketDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("InvalidKey: %q", key)
+	}
+
+	return objectPath, nil
+}
+
+// sectionReadCloser closes the object file once the body has been read
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *sectionReadCloser) Close() error {
+	return r.file.Close()
+}
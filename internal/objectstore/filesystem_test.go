@@ -0,0 +1,166 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileObjectStore(t *testing.T, bucket string, objects map[string]string) *FileObjectStore {
+	root, err := ioutil.TempDir("", "ribble-data")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	store, err := NewFileObjectStore(root)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	_, err = store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	require.Nil(t, err)
+
+	for key, body := range objects {
+		_, err = store.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(body)),
+		})
+		require.Nil(t, err)
+	}
+
+	return store
+}
+
+func Test_FileObjectStore_GetObjectRange(t *testing.T) {
+	store := newTestFileObjectStore(t, "bucket", map[string]string{
+		"lineitem.tbl": "0123456789",
+	})
+
+	tests := []struct {
+		name          string
+		byteRange     *string
+		expectedBody  string
+		expectedError bool
+	}{
+		{"no range", nil, "0123456789", false},
+		{"inclusive range", aws.String("bytes=0-3"), "0123", false},
+		{"middle range", aws.String("bytes=4-6"), "456", false},
+		{"end after object size", aws.String("bytes=8-100"), "89", false},
+		{"open ended range", aws.String("bytes=7-"), "789", false},
+		{"start after object size", aws.String("bytes=10-12"), "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("lineitem.tbl"),
+				Range:  test.byteRange,
+			})
+			if test.expectedError {
+				var rangeErr *InvalidRangeError
+				assert.True(t, errors.As(err, &rangeErr))
+				return
+			}
+			require.Nil(t, err)
+			defer output.Body.Close()
+
+			body, err := ioutil.ReadAll(output.Body)
+			require.Nil(t, err)
+			assert.Equal(t, test.expectedBody, string(body))
+			assert.Equal(t, int64(len(test.expectedBody)), output.ContentLength)
+
+			// the downloader returns the same bytes
+			buf := manager.NewWriteAtBuffer([]byte{})
+			n, err := store.Download(context.Background(), buf, &s3.GetObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("lineitem.tbl"),
+				Range:  test.byteRange,
+			})
+			require.Nil(t, err)
+			assert.Equal(t, int64(len(test.expectedBody)), n)
+			assert.Equal(t, test.expectedBody, string(buf.Bytes()))
+		})
+	}
+}
+
+func Test_FileObjectStore_GetObjectMissing(t *testing.T) {
+	store := newTestFileObjectStore(t, "bucket", nil)
+
+	_, err := store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("missing"),
+	})
+	var noSuchKey *types.NoSuchKey
+	assert.True(t, errors.As(err, &noSuchKey))
+
+	_, err = store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("missing-bucket"),
+		Key:    aws.String("missing"),
+	})
+	var noSuchBucket *types.NoSuchBucket
+	assert.True(t, errors.As(err, &noSuchBucket))
+
+	_, err = store.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("../../etc/passwd"),
+	})
+	assert.NotNil(t, err)
+}
+
+func Test_FileObjectStore_ListObjectsV2Pagination(t *testing.T) {
+	store := newTestFileObjectStore(t, "bucket", map[string]string{
+		"a.tbl":                "a",
+		"b.tbl":                "bb",
+		"output/reducer-1":     "ccc",
+		"output/reducer-2":     "dddd",
+		"checkpoints/r/1-main": "e",
+	})
+
+	// list all objects two at a time
+	keys := []string{}
+	sizes := []int64{}
+	var continuationToken *string
+	for {
+		output, err := store.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String("bucket"),
+			MaxKeys:           2,
+			ContinuationToken: continuationToken,
+		})
+		require.Nil(t, err)
+		assert.LessOrEqual(t, len(output.Contents), 2)
+
+		for _, object := range output.Contents {
+			keys = append(keys, *object.Key)
+			sizes = append(sizes, object.Size)
+		}
+
+		if !output.IsTruncated {
+			break
+		}
+		continuationToken = output.NextContinuationToken
+	}
+
+	assert.Equal(t, []string{"a.tbl", "b.tbl", "checkpoints/r/1-main", "output/reducer-1", "output/reducer-2"}, keys)
+	assert.Equal(t, []int64{1, 2, 1, 3, 4}, sizes)
+
+	// list with prefix
+	output, err := store.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String("output/"),
+	})
+	require.Nil(t, err)
+	require.Len(t, output.Contents, 2)
+	assert.Equal(t, "output/reducer-1", *output.Contents[0].Key)
+	assert.Equal(t, "output/reducer-2", *output.Contents[1].Key)
+	assert.False(t, output.IsTruncated)
+}
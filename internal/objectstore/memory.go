@@ -0,0 +1,407 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// defaultMaxKeys is the maximum number of keys returned by ListObjectsV2
+	// when MaxKeys is not set, which matches S3
+	defaultMaxKeys = 1000
+	// maxDeleteKeys is the maximum number of keys DeleteObjects accepts, which matches S3
+	maxDeleteKeys = 1000
+)
+
+// MemoryObjectStore is an in-memory implementation of ObjectStoreAPI,
+// ManagerDownloaderAPI and ManagerUploaderAPI. It is used to run jobs
+// in-process without S3 or localstack
+type MemoryObjectStore struct {
+	buckets map[string]map[string]*memoryObject
+	mu      sync.RWMutex
+}
+
+// memoryObject is the data of an object and its metadata
+type memoryObject struct {
+	data            []byte
+	contentEncoding string
+}
+
+// NewMemoryObjectStore creates an empty in-memory object store
+func NewMemoryObjectStore() *MemoryObjectStore {
+	return &MemoryObjectStore{
+		buckets: make(map[string]map[string]*memoryObject),
+	}
+}
+
+// CreateBucket creates an empty bucket. Creating a bucket that already
+// exists is not an error
+func (s *MemoryObjectStore) CreateBucket(
+	ctx context.Context,
+	params *s3.CreateBucketInput,
+	optFns ...func(*s3.Options),
+) (*s3.CreateBucketOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := aws.ToString(params.Bucket)
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = make(map[string]*memoryObject)
+	}
+
+	return &s3.CreateBucketOutput{
+		Location: aws.String(fmt.Sprintf("/%s", bucket)),
+	}, nil
+}
+
+// ListObjectsV2 lists the objects in a bucket in lexicographical order. It supports
+// the Prefix, StartAfter, MaxKeys and ContinuationToken parameters
+func (s *MemoryObjectStore) ListObjectsV2(
+	ctx context.Context,
+	params *s3.ListObjectsV2Input,
+	optFns ...func(*s3.Options),
+) (*s3.ListObjectsV2Output, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bucket := aws.ToString(params.Bucket)
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+	}
+
+	// the continuation token is the last key returned in the previous page
+	startAfter := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		startAfter = *params.ContinuationToken
+	}
+
+	prefix := aws.ToString(params.Prefix)
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	maxKeys := int(params.MaxKeys)
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:              params.Bucket,
+		Prefix:            params.Prefix,
+		MaxKeys:           int32(maxKeys),
+		ContinuationToken: params.ContinuationToken,
+	}
+
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		output.IsTruncated = true
+		output.NextContinuationToken = aws.String(keys[len(keys)-1])
+	}
+
+	output.Contents = make([]types.Object, len(keys))
+	for i, key := range keys {
+		output.Contents[i] = types.Object{
+			Key:  aws.String(key),
+			Size: int64(len(objects[key].data)),
+		}
+	}
+	output.KeyCount = int32(len(keys))
+
+	return output, nil
+}
+
+// GetObject returns the object body. The Range parameter is supported
+// in the form bytes=start-end where both ends are inclusive
+func (s *MemoryObjectStore) GetObject(
+	ctx context.Context,
+	params *s3.GetObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	object, err := s.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	data := object.data
+	if params.Range != nil {
+		data, err = applyRange(data, *params.Range)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3.GetObjectOutput{
+		Body:            ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength:   int64(len(data)),
+		ContentEncoding: aws.String(object.contentEncoding),
+	}, nil
+}
+
+// HeadObject returns the size and the content encoding of the object
+func (s *MemoryObjectStore) HeadObject(
+	ctx context.Context,
+	params *s3.HeadObjectInput,
+	optFns ...func(*s3.Options),
+) (*s3.HeadObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	object, err := s.get(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength:   int64(len(object.data)),
+		ContentEncoding: aws.String(object.contentEncoding),
+	}, nil
+}
+
+// Download writes the object, or the requested range of it, into w
+func (s *MemoryObjectStore) Download(
+	ctx context.Context,
+	w io.WriterAt,
+	input *s3.GetObjectInput,
+	options ...func(*manager.Downloader),
+) (n int64, err error) {
+	output, err := s.GetObject(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	defer output.Body.Close()
+
+	data, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := w.WriteAt(data, 0)
+	return int64(written), err
+}
+
+// Upload stores the body of the input as an object. Existing objects are overwritten
+func (s *MemoryObjectStore) Upload(
+	ctx context.Context,
+	input *s3.PutObjectInput,
+	opts ...func(*manager.Uploader),
+) (*manager.UploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if input.Body != nil {
+		var err error
+		data, err = ioutil.ReadAll(input.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := aws.ToString(input.Bucket)
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+	}
+	objects[aws.ToString(input.Key)] = &memoryObject{
+		data:            data,
+		contentEncoding: aws.ToString(input.ContentEncoding),
+	}
+
+	return &manager.UploadOutput{
+		Location: fmt.Sprintf("/%s/%s", bucket, aws.ToString(input.Key)),
+	}, nil
+}
+
+// DeleteObjects deletes the given keys from the bucket. As in S3 deleting
+// a key that doesn't exist succeeds
+func (s *MemoryObjectStore) DeleteObjects(
+	ctx context.Context,
+	params *s3.DeleteObjectsInput,
+	optFns ...func(*s3.Options),
+) (*s3.DeleteObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := validateDelete(params.Delete); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := aws.ToString(params.Bucket)
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+	}
+
+	output := &s3.DeleteObjectsOutput{}
+	for _, object := range params.Delete.Objects {
+		delete(objects, aws.ToString(object.Key))
+		output.Deleted = append(output.Deleted, types.DeletedObject{Key: object.Key})
+	}
+
+	return output, nil
+}
+
+// DeleteBucket deletes the bucket. As in S3 the bucket must be empty
+func (s *MemoryObjectStore) DeleteBucket(
+	ctx context.Context,
+	params *s3.DeleteBucketInput,
+	optFns ...func(*s3.Options),
+) (*s3.DeleteBucketOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := aws.ToString(params.Bucket)
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+	}
+	if len(objects) > 0 {
+		return nil, &BucketNotEmptyError{Bucket: bucket}
+	}
+	delete(s.buckets, bucket)
+
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+// get returns a copy of the object
+func (s *MemoryObjectStore) get(bucket, key string) (*memoryObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String(bucket)}
+	}
+
+	object, ok := objects[key]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String(key)}
+	}
+
+	return &memoryObject{
+		data:            append([]byte(nil), object.data...),
+		contentEncoding: object.contentEncoding,
+	}, nil
+}
+
+// BucketNotEmptyError is returned when deleting a bucket that still has objects
+type BucketNotEmptyError struct {
+	Bucket string
+}
+
+func (e *BucketNotEmptyError) Error() string {
+	return fmt.Sprintf("BucketNotEmpty: the bucket %s is not empty", e.Bucket)
+}
+
+// InvalidRangeError is returned when the requested range starts after the end of the object
+type InvalidRangeError struct {
+	Range string
+	Size  int64
+}
+
+func (e *InvalidRangeError) Error() string {
+	return fmt.Sprintf("InvalidRange: range %s not satisfiable for object of size %d", e.Range, e.Size)
+}
+
+// validateDelete checks that a DeleteObjects request has between 1 and 1000 keys
+func validateDelete(params *types.Delete) error {
+	if params == nil || len(params.Objects) == 0 || len(params.Objects) > maxDeleteKeys {
+		return fmt.Errorf("MalformedXML: DeleteObjects accepts between 1 and %d keys", maxDeleteKeys)
+	}
+
+	return nil
+}
+
+// applyRange returns the bytes of data selected by an http range header
+func applyRange(data []byte, byteRange string) ([]byte, error) {
+	start, end, err := parseRange(byteRange, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	return data[start : end+1], nil
+}
+
+// parseRange returns the first and last byte selected by an http range header of the form
+// bytes=start-end for an object of the given size. The end is clamped to the size of the
+// object and ranges that cannot be parsed are ignored, in which case the whole object is
+// selected as S3 does
+func parseRange(byteRange string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(byteRange, "bytes=") {
+		return 0, size - 1, nil
+	}
+
+	bounds := strings.SplitN(strings.TrimPrefix(byteRange, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, size - 1, nil
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, size - 1, nil
+	}
+
+	end := size - 1
+	if bounds[1] != "" {
+		end, err = strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			return 0, size - 1, nil
+		}
+	}
+
+	if end < start {
+		return 0, size - 1, nil
+	}
+
+	if start >= size {
+		return 0, 0, &InvalidRangeError{Range: byteRange, Size: size}
+	}
+
+	if end >= size {
+		end = size - 1
+	}
+
+	return start, end, nil
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/josenarvaezp/displ/internal/compression"
 )
 
 // ObjectStoreAPI is an interface used to mock API calls made to the aws S3 service
@@ -24,6 +26,21 @@ type ObjectStoreAPI interface {
 		params *s3.GetObjectInput,
 		optFns ...func(*s3.Options),
 	) (*s3.GetObjectOutput, error)
+	HeadObject(
+		ctx context.Context,
+		params *s3.HeadObjectInput,
+		optFns ...func(*s3.Options),
+	) (*s3.HeadObjectOutput, error)
+	DeleteObjects(
+		ctx context.Context,
+		params *s3.DeleteObjectsInput,
+		optFns ...func(*s3.Options),
+	) (*s3.DeleteObjectsOutput, error)
+	DeleteBucket(
+		ctx context.Context,
+		params *s3.DeleteBucketInput,
+		optFns ...func(*s3.Options),
+	) (*s3.DeleteBucketOutput, error)
 }
 
 // Object represent a cloud object
@@ -31,6 +48,8 @@ type Object struct {
 	Bucket string
 	Key    string
 	Size   int64
+	// Compression is the codec of compressed objects
+	Compression compression.Codec
 }
 
 // ObjectRange represents an cloud object with its range specified
@@ -39,6 +58,29 @@ type ObjectRange struct {
 	Key         string `json:"objectKey"`
 	InitialByte int64  `json:"initialByte,string"`
 	FinalByte   int64  `json:"finalByte,string"`
+	// RowGroups is set for Parquet objects, where the
+	// range is the bytes of consecutive row groups
+	RowGroups *RowGroupRange `json:"rowGroups,omitempty"`
+	// Compression is set for compressed objects, which
+	// are decompressed before the map function reads them
+	Compression *CompressedRange `json:"compression,omitempty"`
+}
+
+// RowGroupRange represents consecutive row groups of a Parquet object
+// and the location of the footer that describes them
+type RowGroupRange struct {
+	First        int   `json:"first"`
+	Count        int   `json:"count"`
+	FooterOffset int64 `json:"footerOffset,string"`
+	FooterLength int64 `json:"footerLength,string"`
+}
+
+// CompressedRange represents the codec of a compressed object. Ranges of compressed
+// objects start and end at frame boundaries and Size is the size of the compressed
+// data, which is used to read the end of the last line of a range in the next ranges
+type CompressedRange struct {
+	Codec compression.Codec `json:"codec"`
+	Size  int64             `json:"size,string"`
 }
 
 // Bucket represents a cloud bucket
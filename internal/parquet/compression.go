@@ -0,0 +1,48 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDecoder decodes the pages of every ZSTD column, it is
+// safe to use concurrently when decoding whole pages
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+
+// decompress returns the uncompressed data of a page
+func decompress(codec Codec, data []byte, uncompressedSize int32) ([]byte, error) {
+	var uncompressed []byte
+	var err error
+
+	switch codec {
+	case Uncompressed:
+		return data, nil
+	case Snappy:
+		uncompressed, err = snappy.Decode(nil, data)
+	case Gzip:
+		var reader *gzip.Reader
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		uncompressed, err = ioutil.ReadAll(reader)
+	case Zstd:
+		uncompressed, err = zstdDecoder.DecodeAll(data, make([]byte, 0, uncompressedSize))
+	default:
+		return nil, fmt.Errorf("Unsupported compression codec %s", codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(uncompressed) != int(uncompressedSize) {
+		return nil, fmt.Errorf("Expected a page of %d bytes but got %d", uncompressedSize, len(uncompressed))
+	}
+
+	return uncompressed, nil
+}
@@ -0,0 +1,19 @@
+package parquet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DecodeRLEHybrid(t *testing.T) {
+	// a run of five 3s and a bit-packed group of 1, 2, 0, 3, 1, 1, 1, 1
+	data := []byte{5 << 1, 3, 1<<1 | 1, 0xc9, 0x55}
+	values, err := decodeRLEHybrid(data, 2, 13)
+	require.Nil(t, err)
+	assert.Equal(t, []uint32{3, 3, 3, 3, 3, 1, 2, 0, 3, 1, 1, 1, 1}, values)
+
+	_, err = decodeRLEHybrid(data, 2, 14)
+	assert.Equal(t, errInvalidRLE, err)
+}
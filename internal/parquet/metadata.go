@@ -0,0 +1,521 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Magic is the magic number at the start and at the end of Parquet files
+	Magic = "PAR1"
+	// TailSize is the size of the tail of Parquet files, which is the
+	// length of the footer followed by the magic number
+	TailSize = 8
+)
+
+// Type is the physical type of a column
+type Type int32
+
+const (
+	Boolean Type = iota
+	Int32
+	Int64
+	Int96
+	Float
+	Double
+	ByteArray
+	FixedLenByteArray
+)
+
+func (t Type) String() string {
+	switch t {
+	case Boolean:
+		return "BOOLEAN"
+	case Int32:
+		return "INT32"
+	case Int64:
+		return "INT64"
+	case Int96:
+		return "INT96"
+	case Float:
+		return "FLOAT"
+	case Double:
+		return "DOUBLE"
+	case ByteArray:
+		return "BYTE_ARRAY"
+	case FixedLenByteArray:
+		return "FIXED_LEN_BYTE_ARRAY"
+	default:
+		return fmt.Sprintf("Type(%d)", int32(t))
+	}
+}
+
+// ConvertedType defines how the physical type of a column is interpreted
+type ConvertedType int32
+
+const (
+	// NoConvertedType is used for columns without a converted type
+	NoConvertedType ConvertedType = -1
+
+	UTF8            ConvertedType = 0
+	Decimal         ConvertedType = 5
+	Date            ConvertedType = 6
+	TimestampMillis ConvertedType = 9
+	TimestampMicros ConvertedType = 10
+)
+
+// Repetition defines if a column is required, optional or repeated
+type Repetition int32
+
+const (
+	Required Repetition = iota
+	Optional
+	Repeated
+)
+
+// Codec is the compression codec of the pages of a column
+type Codec int32
+
+const (
+	Uncompressed Codec = iota
+	Snappy
+	Gzip
+	LZO
+	Brotli
+	LZ4
+	Zstd
+	LZ4Raw
+)
+
+func (c Codec) String() string {
+	switch c {
+	case Uncompressed:
+		return "UNCOMPRESSED"
+	case Snappy:
+		return "SNAPPY"
+	case Gzip:
+		return "GZIP"
+	case LZO:
+		return "LZO"
+	case Brotli:
+		return "BROTLI"
+	case LZ4:
+		return "LZ4"
+	case Zstd:
+		return "ZSTD"
+	case LZ4Raw:
+		return "LZ4_RAW"
+	default:
+		return fmt.Sprintf("Codec(%d)", int32(c))
+	}
+}
+
+// Encoding is the encoding of the values or levels of a page
+type Encoding int32
+
+const (
+	Plain                Encoding = 0
+	PlainDictionary      Encoding = 2
+	RLE                  Encoding = 3
+	BitPacked            Encoding = 4
+	DeltaBinaryPacked    Encoding = 5
+	DeltaLengthByteArray Encoding = 6
+	DeltaByteArray       Encoding = 7
+	RLEDictionary        Encoding = 8
+	ByteStreamSplit      Encoding = 9
+)
+
+// PageType is the type of a page of a column
+type PageType int32
+
+const (
+	DataPage PageType = iota
+	IndexPage
+	DictionaryPage
+	DataPageV2
+)
+
+// FileMetaData is the metadata in the footer of a Parquet file
+type FileMetaData struct {
+	Version   int32
+	Schema    []SchemaElement
+	NumRows   int64
+	RowGroups []RowGroup
+}
+
+// SchemaElement is an element of the schema of the file. The first element
+// is the root of the schema and the columns are its children
+type SchemaElement struct {
+	Type           Type
+	TypeLength     int32
+	RepetitionType Repetition
+	Name           string
+	NumChildren    int32
+	ConvertedType  ConvertedType
+	Scale          int32
+	Precision      int32
+}
+
+// RowGroup is a group of rows, stored as a chunk per column
+type RowGroup struct {
+	Columns       []ColumnChunk
+	TotalByteSize int64
+	NumRows       int64
+}
+
+// ColumnChunk is the chunk of a column in a row group
+type ColumnChunk struct {
+	FilePath   string
+	FileOffset int64
+	MetaData   ColumnMetaData
+}
+
+// ColumnMetaData describes the pages of a column chunk
+type ColumnMetaData struct {
+	Type                  Type
+	Encodings             []Encoding
+	PathInSchema          []string
+	Codec                 Codec
+	NumValues             int64
+	TotalUncompressedSize int64
+	TotalCompressedSize   int64
+	DataPageOffset        int64
+	DictionaryPageOffset  int64
+}
+
+// FooterLength returns the length of the footer given the tail of a Parquet file
+func FooterLength(tail []byte) (int64, error) {
+	if len(tail) != TailSize || string(tail[4:]) != Magic {
+		return 0, errors.New("Invalid Parquet file, the magic number is missing")
+	}
+
+	return int64(binary.LittleEndian.Uint32(tail)), nil
+}
+
+// ReadMetadata decodes the footer of a Parquet file
+func ReadMetadata(footer []byte) (*FileMetaData, error) {
+	metadata := &FileMetaData{}
+	r := newCompactReader(footer)
+
+	err := r.readStruct(func(id int16, fieldType byte) error {
+		var err error
+		switch id {
+		case 1:
+			metadata.Version, err = r.readI32()
+		case 2:
+			err = r.readList(func(elementType byte) error {
+				element, err := readSchemaElement(r)
+				metadata.Schema = append(metadata.Schema, element)
+				return err
+			})
+		case 3:
+			metadata.NumRows, err = r.readI64()
+		case 4:
+			err = r.readList(func(elementType byte) error {
+				rowGroup, err := readRowGroup(r)
+				metadata.RowGroups = append(metadata.RowGroups, rowGroup)
+				return err
+			})
+		default:
+			err = r.skip(fieldType)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Parquet metadata: %v", err)
+	}
+
+	return metadata, nil
+}
+
+func readSchemaElement(r *compactReader) (SchemaElement, error) {
+	element := SchemaElement{
+		ConvertedType: NoConvertedType,
+	}
+
+	err := r.readStruct(func(id int16, fieldType byte) error {
+		var err error
+		var value int32
+		switch id {
+		case 1:
+			value, err = r.readI32()
+			element.Type = Type(value)
+		case 2:
+			element.TypeLength, err = r.readI32()
+		case 3:
+			value, err = r.readI32()
+			element.RepetitionType = Repetition(value)
+		case 4:
+			element.Name, err = r.readString()
+		case 5:
+			element.NumChildren, err = r.readI32()
+		case 6:
+			value, err = r.readI32()
+			element.ConvertedType = ConvertedType(value)
+		case 7:
+			element.Scale, err = r.readI32()
+		case 8:
+			element.Precision, err = r.readI32()
+		default:
+			err = r.skip(fieldType)
+		}
+		return err
+	})
+
+	return element, err
+}
+
+func readRowGroup(r *compactReader) (RowGroup, error) {
+	rowGroup := RowGroup{}
+
+	err := r.readStruct(func(id int16, fieldType byte) error {
+		var err error
+		switch id {
+		case 1:
+			err = r.readList(func(elementType byte) error {
+				column, err := readColumnChunk(r)
+				rowGroup.Columns = append(rowGroup.Columns, column)
+				return err
+			})
+		case 2:
+			rowGroup.TotalByteSize, err = r.readI64()
+		case 3:
+			rowGroup.NumRows, err = r.readI64()
+		default:
+			err = r.skip(fieldType)
+		}
+		return err
+	})
+
+	return rowGroup, err
+}
+
+func readColumnChunk(r *compactReader) (ColumnChunk, error) {
+	column := ColumnChunk{}
+
+	err := r.readStruct(func(id int16, fieldType byte) error {
+		var err error
+		switch id {
+		case 1:
+			column.FilePath, err = r.readString()
+		case 2:
+			column.FileOffset, err = r.readI64()
+		case 3:
+			column.MetaData, err = readColumnMetaData(r)
+		default:
+			err = r.skip(fieldType)
+		}
+		return err
+	})
+
+	return column, err
+}
+
+func readColumnMetaData(r *compactReader) (ColumnMetaData, error) {
+	metadata := ColumnMetaData{}
+
+	err := r.readStruct(func(id int16, fieldType byte) error {
+		var err error
+		var value int32
+		switch id {
+		case 1:
+			value, err = r.readI32()
+			metadata.Type = Type(value)
+		case 2:
+			var encodings []int32
+			encodings, err = r.readI32List()
+			for _, encoding := range encodings {
+				metadata.Encodings = append(metadata.Encodings, Encoding(encoding))
+			}
+		case 3:
+			metadata.PathInSchema, err = r.readStringList()
+		case 4:
+			value, err = r.readI32()
+			metadata.Codec = Codec(value)
+		case 5:
+			metadata.NumValues, err = r.readI64()
+		case 6:
+			metadata.TotalUncompressedSize, err = r.readI64()
+		case 7:
+			metadata.TotalCompressedSize, err = r.readI64()
+		case 9:
+			metadata.DataPageOffset, err = r.readI64()
+		case 11:
+			metadata.DictionaryPageOffset, err = r.readI64()
+		default:
+			err = r.skip(fieldType)
+		}
+		return err
+	})
+
+	return metadata, err
+}
+
+// Columns returns the columns of the file. Only flat schemas, where every
+// column is a required or optional child of the root, are supported
+func (m *FileMetaData) Columns() ([]SchemaElement, error) {
+	if len(m.Schema) == 0 {
+		return nil, errors.New("Invalid Parquet metadata, the schema is empty")
+	}
+
+	columns := m.Schema[1:]
+	if int(m.Schema[0].NumChildren) != len(columns) {
+		return nil, errors.New("Nested columns are not supported")
+	}
+
+	for _, column := range columns {
+		if column.NumChildren > 0 {
+			return nil, fmt.Errorf("Nested column %s is not supported", column.Name)
+		}
+		if column.RepetitionType == Repeated {
+			return nil, fmt.Errorf("Repeated column %s is not supported", column.Name)
+		}
+	}
+
+	return columns, nil
+}
+
+// ByteRange returns the first byte of the column chunk and the byte after its last byte
+func (c ColumnChunk) ByteRange() (int64, int64) {
+	start := c.MetaData.DataPageOffset
+	if c.MetaData.DictionaryPageOffset > 0 && c.MetaData.DictionaryPageOffset < start {
+		start = c.MetaData.DictionaryPageOffset
+	}
+
+	return start, start + c.MetaData.TotalCompressedSize
+}
+
+// ByteRange returns the first byte of the row group and the byte after its last byte
+func (rg RowGroup) ByteRange() (int64, int64) {
+	var start, end int64
+	for i, column := range rg.Columns {
+		columnStart, columnEnd := column.ByteRange()
+		if i == 0 || columnStart < start {
+			start = columnStart
+		}
+		if columnEnd > end {
+			end = columnEnd
+		}
+	}
+
+	return start, end
+}
+
+// pageHeader is the header of a page of a column chunk
+type pageHeader struct {
+	Type             PageType
+	UncompressedSize int32
+	CompressedSize   int32
+	DataPage         *dataPageHeader
+	DictionaryPage   *dictionaryPageHeader
+	DataPageV2       *dataPageHeaderV2
+}
+
+type dataPageHeader struct {
+	NumValues               int32
+	Encoding                Encoding
+	DefinitionLevelEncoding Encoding
+}
+
+type dictionaryPageHeader struct {
+	NumValues int32
+	Encoding  Encoding
+}
+
+type dataPageHeaderV2 struct {
+	NumValues                  int32
+	NumNulls                   int32
+	NumRows                    int32
+	Encoding                   Encoding
+	DefinitionLevelsByteLength int32
+	RepetitionLevelsByteLength int32
+	IsCompressed               bool
+}
+
+// readPageHeader decodes a page header and returns it with its size
+func readPageHeader(data []byte) (*pageHeader, int, error) {
+	header := &pageHeader{}
+	r := newCompactReader(data)
+
+	err := r.readStruct(func(id int16, fieldType byte) error {
+		var err error
+		var value int32
+		switch id {
+		case 1:
+			value, err = r.readI32()
+			header.Type = PageType(value)
+		case 2:
+			header.UncompressedSize, err = r.readI32()
+		case 3:
+			header.CompressedSize, err = r.readI32()
+		case 5:
+			header.DataPage = &dataPageHeader{}
+			err = r.readStruct(func(id int16, fieldType byte) error {
+				var err error
+				switch id {
+				case 1:
+					header.DataPage.NumValues, err = r.readI32()
+				case 2:
+					value, err = r.readI32()
+					header.DataPage.Encoding = Encoding(value)
+				case 3:
+					value, err = r.readI32()
+					header.DataPage.DefinitionLevelEncoding = Encoding(value)
+				default:
+					err = r.skip(fieldType)
+				}
+				return err
+			})
+		case 7:
+			header.DictionaryPage = &dictionaryPageHeader{}
+			err = r.readStruct(func(id int16, fieldType byte) error {
+				var err error
+				switch id {
+				case 1:
+					header.DictionaryPage.NumValues, err = r.readI32()
+				case 2:
+					value, err = r.readI32()
+					header.DictionaryPage.Encoding = Encoding(value)
+				default:
+					err = r.skip(fieldType)
+				}
+				return err
+			})
+		case 8:
+			// pages are compressed unless the header says otherwise
+			header.DataPageV2 = &dataPageHeaderV2{IsCompressed: true}
+			err = r.readStruct(func(id int16, fieldType byte) error {
+				var err error
+				switch id {
+				case 1:
+					header.DataPageV2.NumValues, err = r.readI32()
+				case 2:
+					header.DataPageV2.NumNulls, err = r.readI32()
+				case 3:
+					header.DataPageV2.NumRows, err = r.readI32()
+				case 4:
+					value, err = r.readI32()
+					header.DataPageV2.Encoding = Encoding(value)
+				case 5:
+					header.DataPageV2.DefinitionLevelsByteLength, err = r.readI32()
+				case 6:
+					header.DataPageV2.RepetitionLevelsByteLength, err = r.readI32()
+				case 7:
+					header.DataPageV2.IsCompressed = fieldType == compactBooleanTrue
+				default:
+					err = r.skip(fieldType)
+				}
+				return err
+			})
+		default:
+			err = r.skip(fieldType)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("Invalid page header: %v", err)
+	}
+
+	return header, r.pos, nil
+}
@@ -0,0 +1,571 @@
+// Package parquettest writes small Parquet files used to test the readers of
+// the framework. Files have a flat schema and their values are plain or
+// dictionary encoded
+package parquettest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/josenarvaezp/displ/internal/parquet"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ColumnType is the type of the values of a column
+type ColumnType int
+
+const (
+	// String columns have string values
+	String ColumnType = iota
+	// Int64 columns have int64 values
+	Int64
+	// Double columns have float64 values
+	Double
+	// Bool columns have bool values
+	Bool
+	// Date columns have time.Time values
+	Date
+	// Decimal columns have the unscaled int64 values of decimals with the scale of the column
+	Decimal
+)
+
+// Column is a column of the file
+type Column struct {
+	Name string
+	Type ColumnType
+	// Optional columns can have nil values
+	Optional bool
+	// Dictionary encodes the values of the column with a dictionary
+	Dictionary bool
+	// Scale is the scale of decimal columns
+	Scale int32
+}
+
+// Options are the options used to write the file
+type Options struct {
+	// RowGroupSize is the number of rows of each row group, all rows are in one row group if 0
+	RowGroupSize int
+	// PageSize is the number of rows of each page, all rows are in one page if 0
+	PageSize int
+	// Codec compresses the pages, it can be Uncompressed, Snappy, Gzip or Zstd
+	Codec parquet.Codec
+	// DataPageV2 writes data pages of version 2
+	DataPageV2 bool
+}
+
+// Write writes a Parquet file with the rows, which have a value per column
+func Write(w io.Writer, columns []Column, rows [][]interface{}, options Options) error {
+	rowGroupSize := options.RowGroupSize
+	if rowGroupSize == 0 {
+		rowGroupSize = len(rows)
+	}
+
+	var file bytes.Buffer
+	file.WriteString(parquet.Magic)
+
+	footer := &compactWriter{}
+	footer.i32(1, 1)
+
+	// schema
+	footer.listHeader(2, compactStruct, len(columns)+1)
+	footer.beginElement()
+	footer.binary(4, []byte("schema"))
+	footer.i32(5, int32(len(columns)))
+	footer.endStruct()
+	for _, column := range columns {
+		writeSchemaElement(footer, column)
+	}
+
+	footer.i64(3, int64(len(rows)))
+
+	// row groups
+	numRowGroups := 0
+	if len(rows) > 0 {
+		numRowGroups = (len(rows) + rowGroupSize - 1) / rowGroupSize
+	}
+	footer.listHeader(4, compactStruct, numRowGroups)
+	for start := 0; start < len(rows); start = start + rowGroupSize {
+		end := start + rowGroupSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		footer.beginElement()
+		footer.listHeader(1, compactStruct, len(columns))
+		totalSize := 0
+		for i, column := range columns {
+			values := make([]interface{}, end-start)
+			for j, row := range rows[start:end] {
+				values[j] = row[i]
+			}
+
+			chunkStart := file.Len()
+			if err := writeColumnChunk(&file, footer, column, values, options); err != nil {
+				return err
+			}
+			totalSize = totalSize + file.Len() - chunkStart
+		}
+		footer.i64(2, int64(totalSize))
+		footer.i64(3, int64(end-start))
+		footer.endStruct()
+	}
+	footer.endStruct()
+
+	// the footer is followed by its length and the magic number
+	file.Write(footer.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(footer.buf.Len()))
+	file.WriteString(parquet.Magic)
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// writeSchemaElement writes the schema element of a column
+func writeSchemaElement(footer *compactWriter, column Column) {
+	footer.beginElement()
+	footer.i32(1, int32(physicalType(column.Type)))
+	repetition := parquet.Required
+	if column.Optional {
+		repetition = parquet.Optional
+	}
+	footer.i32(3, int32(repetition))
+	footer.binary(4, []byte(column.Name))
+	switch column.Type {
+	case String:
+		footer.i32(6, int32(parquet.UTF8))
+	case Date:
+		footer.i32(6, int32(parquet.Date))
+	case Decimal:
+		footer.i32(6, int32(parquet.Decimal))
+		footer.i32(7, column.Scale)
+		footer.i32(8, 18)
+	}
+	footer.endStruct()
+}
+
+// writeColumnChunk writes the pages of the column to the file and its metadata to the footer
+func writeColumnChunk(file *bytes.Buffer, footer *compactWriter, column Column, values []interface{}, options Options) error {
+	pageSize := options.PageSize
+	if pageSize == 0 || pageSize > len(values) {
+		pageSize = len(values)
+	}
+
+	// the dictionary has the distinct values of the chunk
+	var dictionary map[string]int
+	var dictionaryOffset int64
+	encoding := parquet.Plain
+	if column.Dictionary {
+		dictionary = make(map[string]int)
+		var dictionaryPage bytes.Buffer
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			var encoded bytes.Buffer
+			if err := writePlain(&encoded, column, value); err != nil {
+				return err
+			}
+			if _, ok := dictionary[encoded.String()]; !ok {
+				dictionary[encoded.String()] = len(dictionary)
+				dictionaryPage.Write(encoded.Bytes())
+			}
+		}
+
+		dictionaryOffset = int64(file.Len())
+		compressed, err := compress(options.Codec, dictionaryPage.Bytes())
+		if err != nil {
+			return err
+		}
+
+		header := &compactWriter{}
+		header.i32(1, int32(parquet.DictionaryPage))
+		header.i32(2, int32(dictionaryPage.Len()))
+		header.i32(3, int32(len(compressed)))
+		header.beginStruct(7)
+		header.i32(1, int32(len(dictionary)))
+		header.i32(2, int32(parquet.PlainDictionary))
+		header.endStruct()
+		header.endStruct()
+		file.Write(header.buf.Bytes())
+		file.Write(compressed)
+
+		encoding = parquet.PlainDictionary
+	}
+
+	dataPageOffset := int64(file.Len())
+	uncompressedSize := 0
+	for start := 0; start < len(values); start = start + pageSize {
+		end := start + pageSize
+		if end > len(values) {
+			end = len(values)
+		}
+
+		size, err := writeDataPage(file, column, values[start:end], dictionary, encoding, options)
+		if err != nil {
+			return err
+		}
+		uncompressedSize = uncompressedSize + size
+	}
+
+	// column chunk metadata
+	footer.beginElement()
+	footer.i64(2, dataPageOffset)
+	footer.beginStruct(3)
+	footer.i32(1, int32(physicalType(column.Type)))
+	footer.listHeader(2, compactI32, 2)
+	footer.varint(zigzag(int64(encoding)))
+	footer.varint(zigzag(int64(parquet.RLE)))
+	footer.listHeader(3, compactBinary, 1)
+	footer.varint(uint64(len(column.Name)))
+	footer.buf.WriteString(column.Name)
+	footer.i32(4, int32(options.Codec))
+	footer.i64(5, int64(len(values)))
+	footer.i64(6, int64(uncompressedSize))
+	chunkStart := dataPageOffset
+	if column.Dictionary {
+		chunkStart = dictionaryOffset
+	}
+	footer.i64(7, int64(file.Len())-chunkStart)
+	footer.i64(9, dataPageOffset)
+	if column.Dictionary {
+		footer.i64(11, dictionaryOffset)
+	}
+	footer.endStruct()
+	footer.endStruct()
+
+	return nil
+}
+
+// writeDataPage writes a data page with the values and returns its uncompressed size
+func writeDataPage(
+	file *bytes.Buffer,
+	column Column,
+	values []interface{},
+	dictionary map[string]int,
+	encoding parquet.Encoding,
+	options Options,
+) (int, error) {
+	// definition levels, 0 for nulls
+	var levels []byte
+	numNulls := 0
+	if column.Optional {
+		definitions := make([]uint32, len(values))
+		for i, value := range values {
+			if value != nil {
+				definitions[i] = 1
+			} else {
+				numNulls++
+			}
+		}
+		levels = encodeBitPacked(definitions, 1)
+	} else {
+		for _, value := range values {
+			if value == nil {
+				return 0, fmt.Errorf("Column %s is not optional", column.Name)
+			}
+		}
+	}
+
+	// values
+	var data bytes.Buffer
+	if dictionary != nil {
+		indexes := []uint32{}
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			var encoded bytes.Buffer
+			if err := writePlain(&encoded, column, value); err != nil {
+				return 0, err
+			}
+			indexes = append(indexes, uint32(dictionary[encoded.String()]))
+		}
+
+		bitWidth := 0
+		for 1<<uint(bitWidth) < len(dictionary) {
+			bitWidth++
+		}
+		data.WriteByte(byte(bitWidth))
+		data.Write(encodeBitPacked(indexes, bitWidth))
+	} else {
+		booleans := []uint32{}
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+			if column.Type == Bool {
+				// booleans are bit-packed
+				boolean := uint32(0)
+				if value.(bool) {
+					boolean = 1
+				}
+				booleans = append(booleans, boolean)
+				continue
+			}
+			if err := writePlain(&data, column, value); err != nil {
+				return 0, err
+			}
+		}
+		if column.Type == Bool {
+			packed := encodeBitPacked(booleans, 1)
+			// skip the header of the run
+			_, n := binary.Uvarint(packed)
+			data.Write(packed[n:])
+		}
+	}
+
+	header := &compactWriter{}
+	var page []byte
+	var uncompressedSize int
+	if options.DataPageV2 {
+		compressed, err := compress(options.Codec, data.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		page = append(append([]byte{}, levels...), compressed...)
+		uncompressedSize = len(levels) + data.Len()
+
+		header.i32(1, int32(parquet.DataPageV2))
+		header.i32(2, int32(uncompressedSize))
+		header.i32(3, int32(len(page)))
+		header.beginStruct(8)
+		header.i32(1, int32(len(values)))
+		header.i32(2, int32(numNulls))
+		header.i32(3, int32(len(values)))
+		header.i32(4, int32(encoding))
+		header.i32(5, int32(len(levels)))
+		header.i32(6, 0)
+		header.endStruct()
+	} else {
+		// the levels are prefixed with their length
+		var uncompressed bytes.Buffer
+		if column.Optional {
+			binary.Write(&uncompressed, binary.LittleEndian, uint32(len(levels)))
+			uncompressed.Write(levels)
+		}
+		uncompressed.Write(data.Bytes())
+
+		compressed, err := compress(options.Codec, uncompressed.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		page = compressed
+		uncompressedSize = uncompressed.Len()
+
+		header.i32(1, int32(parquet.DataPage))
+		header.i32(2, int32(uncompressedSize))
+		header.i32(3, int32(len(page)))
+		header.beginStruct(5)
+		header.i32(1, int32(len(values)))
+		header.i32(2, int32(encoding))
+		header.i32(3, int32(parquet.RLE))
+		header.i32(4, int32(parquet.RLE))
+		header.endStruct()
+	}
+	header.endStruct()
+
+	file.Write(header.buf.Bytes())
+	file.Write(page)
+
+	return uncompressedSize, nil
+}
+
+// writePlain writes a value with the plain encoding
+func writePlain(w *bytes.Buffer, column Column, value interface{}) error {
+	switch column.Type {
+	case String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Expected a string in column %s but got %T", column.Name, value)
+		}
+		binary.Write(w, binary.LittleEndian, uint32(len(s)))
+		w.WriteString(s)
+	case Int64, Decimal:
+		i, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("Expected an int64 in column %s but got %T", column.Name, value)
+		}
+		binary.Write(w, binary.LittleEndian, i)
+	case Double:
+		f, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("Expected a float64 in column %s but got %T", column.Name, value)
+		}
+		binary.Write(w, binary.LittleEndian, math.Float64bits(f))
+	case Date:
+		t, ok := value.(time.Time)
+		if !ok {
+			return fmt.Errorf("Expected a time.Time in column %s but got %T", column.Name, value)
+		}
+		binary.Write(w, binary.LittleEndian, int32(t.Unix()/(24*60*60)))
+	case Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("Expected a bool in column %s but got %T", column.Name, value)
+		}
+		if b {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	default:
+		return errors.New("Unknown column type")
+	}
+
+	return nil
+}
+
+// physicalType returns the Parquet type of a column type
+func physicalType(columnType ColumnType) parquet.Type {
+	switch columnType {
+	case Int64, Decimal:
+		return parquet.Int64
+	case Double:
+		return parquet.Double
+	case Bool:
+		return parquet.Boolean
+	case Date:
+		return parquet.Int32
+	default:
+		return parquet.ByteArray
+	}
+}
+
+// encodeBitPacked encodes values as a single bit-packed run of the RLE hybrid encoding
+func encodeBitPacked(values []uint32, bitWidth int) []byte {
+	groups := (len(values) + 7) / 8
+	packed := make([]byte, groups*bitWidth)
+	for i, value := range values {
+		for bit := 0; bit < bitWidth; bit++ {
+			if value>>uint(bit)&1 == 1 {
+				position := i*bitWidth + bit
+				packed[position/8] = packed[position/8] | 1<<uint(position%8)
+			}
+		}
+	}
+
+	header := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(header, uint64(groups)<<1|1)
+
+	return append(header[:n], packed...)
+}
+
+// compress compresses a page with the codec
+func compress(codec parquet.Codec, data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	switch codec {
+	case parquet.Uncompressed:
+		return data, nil
+	case parquet.Snappy:
+		return snappy.Encode(nil, data), nil
+	case parquet.Gzip:
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case parquet.Zstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("Unsupported compression codec %s", codec)
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// types of the thrift compact protocol
+const (
+	compactI32    byte = 5
+	compactI64    byte = 6
+	compactBinary byte = 8
+	compactList   byte = 9
+	compactStruct byte = 12
+)
+
+// compactWriter encodes structs with the thrift compact protocol
+type compactWriter struct {
+	buf     bytes.Buffer
+	lastID  int16
+	lastIDs []int16
+}
+
+func zigzag(value int64) uint64 {
+	return uint64(value<<1) ^ uint64(value>>63)
+}
+
+func (w *compactWriter) varint(value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.buf.Write(buf[:binary.PutUvarint(buf, value)])
+}
+
+func (w *compactWriter) fieldHeader(id int16, fieldType byte) {
+	delta := id - w.lastID
+	if delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		w.varint(zigzag(int64(id)))
+	}
+	w.lastID = id
+}
+
+func (w *compactWriter) i32(id int16, value int32) {
+	w.fieldHeader(id, compactI32)
+	w.varint(zigzag(int64(value)))
+}
+
+func (w *compactWriter) i64(id int16, value int64) {
+	w.fieldHeader(id, compactI64)
+	w.varint(zigzag(value))
+}
+
+func (w *compactWriter) binary(id int16, value []byte) {
+	w.fieldHeader(id, compactBinary)
+	w.varint(uint64(len(value)))
+	w.buf.Write(value)
+}
+
+func (w *compactWriter) listHeader(id int16, elementType byte, size int) {
+	w.fieldHeader(id, compactList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elementType)
+		return
+	}
+	w.buf.WriteByte(0xf0 | elementType)
+	w.varint(uint64(size))
+}
+
+// beginStruct starts a struct field
+func (w *compactWriter) beginStruct(id int16) {
+	w.fieldHeader(id, compactStruct)
+	w.beginElement()
+}
+
+// beginElement starts a struct in a list
+func (w *compactWriter) beginElement() {
+	w.lastIDs = append(w.lastIDs, w.lastID)
+	w.lastID = 0
+}
+
+// endStruct ends a struct, the top level struct is ended without being started
+func (w *compactWriter) endStruct() {
+	w.buf.WriteByte(0)
+	if len(w.lastIDs) > 0 {
+		w.lastID = w.lastIDs[len(w.lastIDs)-1]
+		w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+	}
+}
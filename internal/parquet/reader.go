@@ -0,0 +1,597 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dateLayout is the layout of the values of date columns
+	dateLayout = "2006-01-02"
+	// julianDayOfEpoch is the julian day of 1970-01-01, used by INT96 timestamps
+	julianDayOfEpoch = 2440588
+)
+
+var (
+	errUnexpectedEndOfPage = errors.New("Unexpected end of page")
+	errInvalidRLE          = errors.New("Invalid RLE data")
+)
+
+// RowReader reads the rows of consecutive row groups of a Parquet file from a
+// reader that streams the bytes of the row groups. Only the chunks of the read
+// columns are decoded and their values are converted to strings, where nulls are empty
+type RowReader struct {
+	reader   io.Reader
+	metadata *FileMetaData
+	// position is the byte of the file the reader is at
+	position int64
+	// rowGroup is the next row group to read and lastRowGroup the row group after the last one
+	rowGroup     int
+	lastRowGroup int
+	// columns are the columns read and indexes their index in the row groups
+	columns []SchemaElement
+	indexes []int
+	// values are the values of each column in the current row group
+	values    [][]string
+	row       int64
+	numRows   int64
+	rowNumber int64
+}
+
+// NewRowReader returns a reader of the rows of numRowGroups row groups starting at firstRowGroup.
+// The reader streams the bytes of the file from initialByte, which is the first byte of the first
+// row group. If columns is empty all the columns are read
+func NewRowReader(
+	reader io.Reader,
+	metadata *FileMetaData,
+	initialByte int64,
+	firstRowGroup int,
+	numRowGroups int,
+	columns []string,
+) (*RowReader, error) {
+	if firstRowGroup < 0 || numRowGroups < 0 || firstRowGroup+numRowGroups > len(metadata.RowGroups) {
+		return nil, fmt.Errorf(
+			"Row groups %d to %d out of range, the file has %d row groups",
+			firstRowGroup,
+			firstRowGroup+numRowGroups-1,
+			len(metadata.RowGroups),
+		)
+	}
+
+	fileColumns, err := metadata.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RowReader{
+		reader:       reader,
+		metadata:     metadata,
+		position:     initialByte,
+		rowGroup:     firstRowGroup,
+		lastRowGroup: firstRowGroup + numRowGroups,
+	}
+
+	// get the columns to read
+	if len(columns) == 0 {
+		for i, column := range fileColumns {
+			r.columns = append(r.columns, column)
+			r.indexes = append(r.indexes, i)
+		}
+	} else {
+		indexes := make(map[string]int, len(fileColumns))
+		for i, column := range fileColumns {
+			indexes[column.Name] = i
+		}
+
+		for _, name := range columns {
+			index, ok := indexes[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown column %q", name)
+			}
+			r.columns = append(r.columns, fileColumns[index])
+			r.indexes = append(r.indexes, index)
+		}
+	}
+
+	// rows are numbered from the start of the file
+	for _, rowGroup := range metadata.RowGroups[:firstRowGroup] {
+		r.rowNumber = r.rowNumber + rowGroup.NumRows
+	}
+
+	return r, nil
+}
+
+// Columns returns the names of the columns that are read
+func (r *RowReader) Columns() []string {
+	names := make([]string, len(r.columns))
+	for i, column := range r.columns {
+		names[i] = column.Name
+	}
+
+	return names
+}
+
+// RowNumber returns the number of the last row read in the file, starting at 1
+func (r *RowReader) RowNumber() int64 {
+	return r.rowNumber
+}
+
+// Read returns the values of the next row in the order of the columns.
+// It returns io.EOF when there are no more rows
+func (r *RowReader) Read() ([]string, error) {
+	for r.row >= r.numRows {
+		if r.rowGroup >= r.lastRowGroup {
+			return nil, io.EOF
+		}
+
+		if err := r.readRowGroup(r.rowGroup); err != nil {
+			return nil, err
+		}
+		r.rowGroup++
+	}
+
+	row := make([]string, len(r.columns))
+	for i := range r.columns {
+		row[i] = r.values[i][r.row]
+	}
+	r.row++
+	r.rowNumber++
+
+	return row, nil
+}
+
+// readRowGroup reads the chunks of the columns in the row group and decodes their values
+func (r *RowReader) readRowGroup(index int) error {
+	rowGroup := r.metadata.RowGroups[index]
+	if len(rowGroup.Columns) != len(r.metadata.Schema)-1 {
+		return fmt.Errorf("Expected %d columns in row group %d but got %d", len(r.metadata.Schema)-1, index, len(rowGroup.Columns))
+	}
+
+	// chunks are read in the order they are in the file
+	chunks := make([]int, 0, len(r.indexes))
+	values := make(map[int][]string, len(r.indexes))
+	for _, column := range r.indexes {
+		if _, ok := values[column]; !ok {
+			values[column] = nil
+			chunks = append(chunks, column)
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		startI, _ := rowGroup.Columns[chunks[i]].ByteRange()
+		startJ, _ := rowGroup.Columns[chunks[j]].ByteRange()
+		return startI < startJ
+	})
+
+	for _, column := range chunks {
+		chunk := rowGroup.Columns[column]
+		schema := r.metadata.Schema[column+1]
+		if chunk.FilePath != "" {
+			return fmt.Errorf("Column %s is in the file %s, columns in other files are not supported", schema.Name, chunk.FilePath)
+		}
+
+		// skip the bytes before the chunk
+		start, end := chunk.ByteRange()
+		if start < r.position {
+			return fmt.Errorf("Column %s of row group %d starts at byte %d, before the current byte %d", schema.Name, index, start, r.position)
+		}
+		if _, err := io.CopyN(ioutil.Discard, r.reader, start-r.position); err != nil {
+			return err
+		}
+
+		data := make([]byte, end-start)
+		if _, err := io.ReadFull(r.reader, data); err != nil {
+			return err
+		}
+		r.position = end
+
+		columnValues, err := decodeColumnChunk(data, chunk.MetaData, schema, rowGroup.NumRows)
+		if err != nil {
+			return fmt.Errorf("Error reading column %s of row group %d: %v", schema.Name, index, err)
+		}
+		values[column] = columnValues
+	}
+
+	r.values = make([][]string, len(r.indexes))
+	for i, column := range r.indexes {
+		r.values[i] = values[column]
+	}
+	r.row = 0
+	r.numRows = rowGroup.NumRows
+
+	return nil
+}
+
+// decodeColumnChunk decodes the values of the pages of a column chunk
+func decodeColumnChunk(data []byte, metadata ColumnMetaData, column SchemaElement, numRows int64) ([]string, error) {
+	values := make([]string, 0, numRows)
+	var dictionary []string
+
+	for len(data) > 0 && int64(len(values)) < metadata.NumValues {
+		header, n, err := readPageHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+
+		if header.CompressedSize < 0 || int(header.CompressedSize) > len(data) {
+			return nil, fmt.Errorf("Page of %d bytes out of the column chunk", header.CompressedSize)
+		}
+		page := data[:header.CompressedSize]
+		data = data[header.CompressedSize:]
+
+		switch header.Type {
+		case DictionaryPage:
+			if header.DictionaryPage == nil {
+				return nil, errors.New("Missing dictionary page header")
+			}
+
+			uncompressed, err := decompress(metadata.Codec, page, header.UncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+
+			dictionary, err = decodePlain(uncompressed, int(header.DictionaryPage.NumValues), column)
+			if err != nil {
+				return nil, err
+			}
+		case DataPage:
+			if header.DataPage == nil {
+				return nil, errors.New("Missing data page header")
+			}
+
+			uncompressed, err := decompress(metadata.Codec, page, header.UncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+
+			// the definition levels are prefixed with their length
+			numValues := int(header.DataPage.NumValues)
+			var levels []uint32
+			if column.RepetitionType == Optional {
+				if header.DataPage.DefinitionLevelEncoding != RLE {
+					return nil, fmt.Errorf("Unsupported definition level encoding %d", header.DataPage.DefinitionLevelEncoding)
+				}
+				if len(uncompressed) < 4 {
+					return nil, errUnexpectedEndOfPage
+				}
+				length := int(binary.LittleEndian.Uint32(uncompressed))
+				if length > len(uncompressed)-4 {
+					return nil, errUnexpectedEndOfPage
+				}
+
+				levels, err = decodeRLEHybrid(uncompressed[4:4+length], 1, numValues)
+				if err != nil {
+					return nil, err
+				}
+				uncompressed = uncompressed[4+length:]
+			}
+
+			values, err = appendValues(values, uncompressed, levels, numValues, header.DataPage.Encoding, dictionary, column)
+			if err != nil {
+				return nil, err
+			}
+		case DataPageV2:
+			pageHeader := header.DataPageV2
+			if pageHeader == nil {
+				return nil, errors.New("Missing data page header")
+			}
+			if pageHeader.RepetitionLevelsByteLength != 0 {
+				return nil, errors.New("Repeated values are not supported")
+			}
+
+			// the definition levels are not compressed and don't have a length prefix
+			levelsLength := int(pageHeader.DefinitionLevelsByteLength)
+			if levelsLength < 0 || levelsLength > len(page) {
+				return nil, errUnexpectedEndOfPage
+			}
+
+			numValues := int(pageHeader.NumValues)
+			var levels []uint32
+			if column.RepetitionType == Optional {
+				levels, err = decodeRLEHybrid(page[:levelsLength], 1, numValues)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			valuesData := page[levelsLength:]
+			if pageHeader.IsCompressed {
+				valuesData, err = decompress(metadata.Codec, valuesData, header.UncompressedSize-int32(levelsLength))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			values, err = appendValues(values, valuesData, levels, numValues, pageHeader.Encoding, dictionary, column)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			// index pages are skipped
+		}
+	}
+
+	if int64(len(values)) != numRows {
+		return nil, fmt.Errorf("Expected %d values but got %d", numRows, len(values))
+	}
+
+	return values, nil
+}
+
+// appendValues decodes the values of a data page and appends them to values. Levels are
+// the definition levels of optional columns, where 0 is a null, and nil for required columns
+func appendValues(
+	values []string,
+	data []byte,
+	levels []uint32,
+	numValues int,
+	encoding Encoding,
+	dictionary []string,
+	column SchemaElement,
+) ([]string, error) {
+	numNonNulls := numValues
+	if levels != nil {
+		numNonNulls = 0
+		for _, level := range levels {
+			if level > 0 {
+				numNonNulls++
+			}
+		}
+	}
+
+	var decoded []string
+	var err error
+	switch encoding {
+	case Plain:
+		decoded, err = decodePlain(data, numNonNulls, column)
+		if err != nil {
+			return nil, err
+		}
+	case PlainDictionary, RLEDictionary:
+		if numNonNulls == 0 {
+			break
+		}
+		if dictionary == nil {
+			return nil, errors.New("Missing dictionary page")
+		}
+		if len(data) == 0 {
+			return nil, errUnexpectedEndOfPage
+		}
+
+		// the indexes are prefixed with their bit width
+		indexes, err := decodeRLEHybrid(data[1:], int(data[0]), numNonNulls)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded = make([]string, len(indexes))
+		for i, index := range indexes {
+			if int(index) >= len(dictionary) {
+				return nil, fmt.Errorf("Dictionary index %d out of range, the dictionary has %d values", index, len(dictionary))
+			}
+			decoded[i] = dictionary[index]
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported encoding %d", encoding)
+	}
+
+	// nulls are empty values
+	next := 0
+	for i := 0; i < numValues; i++ {
+		if levels != nil && levels[i] == 0 {
+			values = append(values, "")
+			continue
+		}
+		values = append(values, decoded[next])
+		next++
+	}
+
+	return values, nil
+}
+
+// decodeRLEHybrid decodes count values of the RLE and bit-packing hybrid encoding,
+// which is a sequence of runs of a repeated value and of bit-packed values
+func decodeRLEHybrid(data []byte, bitWidth int, count int) ([]uint32, error) {
+	if bitWidth > 32 {
+		return nil, errInvalidRLE
+	}
+
+	values := make([]uint32, 0, count)
+	byteWidth := (bitWidth + 7) / 8
+	for len(values) < count {
+		header, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errInvalidRLE
+		}
+		data = data[n:]
+
+		if header&1 == 0 {
+			// repeated value
+			if len(data) < byteWidth {
+				return nil, errInvalidRLE
+			}
+			var value uint32
+			for i := 0; i < byteWidth; i++ {
+				value = value | uint32(data[i])<<(8*i)
+			}
+			data = data[byteWidth:]
+
+			for i := uint64(0); i < header>>1 && len(values) < count; i++ {
+				values = append(values, value)
+			}
+			continue
+		}
+
+		// groups of 8 bit-packed values, the last group can be truncated
+		numPacked := int(header>>1) * 8
+		size := int(header>>1) * bitWidth
+		if size > len(data) {
+			size = len(data)
+		}
+		for i := 0; i < numPacked && len(values) < count; i++ {
+			values = append(values, readBits(data[:size], i*bitWidth, bitWidth))
+		}
+		data = data[size:]
+	}
+
+	return values, nil
+}
+
+// readBits reads a value of bitWidth bits packed from the least significant bit
+func readBits(data []byte, bitOffset int, bitWidth int) uint32 {
+	var value uint32
+	for i := 0; i < bitWidth; i++ {
+		bit := bitOffset + i
+		if bit/8 < len(data) && data[bit/8]>>(uint(bit)%8)&1 == 1 {
+			value = value | 1<<uint(i)
+		}
+	}
+
+	return value
+}
+
+// decodePlain decodes count values of the plain encoding
+func decodePlain(data []byte, count int, column SchemaElement) ([]string, error) {
+	values := make([]string, count)
+
+	switch column.Type {
+	case Boolean:
+		if len(data) < (count+7)/8 {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			values[i] = strconv.FormatBool(readBits(data, i, 1) == 1)
+		}
+	case Int32:
+		if len(data) < 4*count {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			values[i] = formatInt(int64(int32(binary.LittleEndian.Uint32(data[4*i:]))), column)
+		}
+	case Int64:
+		if len(data) < 8*count {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			values[i] = formatInt(int64(binary.LittleEndian.Uint64(data[8*i:])), column)
+		}
+	case Int96:
+		if len(data) < 12*count {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			values[i] = formatInt96(data[12*i : 12*i+12])
+		}
+	case Float:
+		if len(data) < 4*count {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			value := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+			values[i] = strconv.FormatFloat(float64(value), 'g', -1, 32)
+		}
+	case Double:
+		if len(data) < 8*count {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			value := math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+			values[i] = strconv.FormatFloat(value, 'g', -1, 64)
+		}
+	case ByteArray:
+		// each value is prefixed with its length
+		for i := range values {
+			if len(data) < 4 {
+				return nil, errUnexpectedEndOfPage
+			}
+			length := int(binary.LittleEndian.Uint32(data))
+			if length > len(data)-4 {
+				return nil, errUnexpectedEndOfPage
+			}
+			values[i] = formatBytes(data[4:4+length], column)
+			data = data[4+length:]
+		}
+	case FixedLenByteArray:
+		length := int(column.TypeLength)
+		if length <= 0 {
+			return nil, fmt.Errorf("Invalid length %d of column %s", length, column.Name)
+		}
+		if len(data) < length*count {
+			return nil, errUnexpectedEndOfPage
+		}
+		for i := range values {
+			values[i] = formatBytes(data[length*i:length*i+length], column)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported type %s", column.Type)
+	}
+
+	return values, nil
+}
+
+// formatInt converts an integer to a string given the converted type of the column
+func formatInt(value int64, column SchemaElement) string {
+	switch column.ConvertedType {
+	case Date:
+		// days since the epoch
+		return time.Unix(value*24*60*60, 0).UTC().Format(dateLayout)
+	case TimestampMillis:
+		return time.Unix(0, value*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	case TimestampMicros:
+		return time.Unix(0, value*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano)
+	case Decimal:
+		return formatDecimal(big.NewInt(value), column.Scale)
+	default:
+		return strconv.FormatInt(value, 10)
+	}
+}
+
+// formatInt96 converts a legacy timestamp, the nanoseconds of the day followed by the julian day
+func formatInt96(value []byte) string {
+	nanos := int64(binary.LittleEndian.Uint64(value))
+	day := int64(binary.LittleEndian.Uint32(value[8:]))
+
+	return time.Unix((day-julianDayOfEpoch)*24*60*60, nanos).UTC().Format(time.RFC3339Nano)
+}
+
+// formatBytes converts a byte array to a string given the converted type of the column
+func formatBytes(value []byte, column SchemaElement) string {
+	if column.ConvertedType != Decimal {
+		return string(value)
+	}
+
+	// decimals are big endian two's complement integers
+	unscaled := new(big.Int).SetBytes(value)
+	if len(value) > 0 && value[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(value))))
+	}
+
+	return formatDecimal(unscaled, column.Scale)
+}
+
+// formatDecimal returns the decimal representation of unscaled*10^-scale
+func formatDecimal(unscaled *big.Int, scale int32) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if len(digits) <= int(scale) {
+			digits = strings.Repeat("0", int(scale)-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-int(scale)] + "." + digits[len(digits)-int(scale):]
+	}
+
+	if unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+
+	return digits
+}
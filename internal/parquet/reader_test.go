@@ -0,0 +1,246 @@
+package parquet_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/josenarvaezp/displ/internal/parquet"
+	"github.com/josenarvaezp/displ/internal/parquet/parquettest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testColumns = []parquettest.Column{
+	{Name: "l_orderkey", Type: parquettest.Int64},
+	{Name: "l_returnflag", Type: parquettest.String, Dictionary: true},
+	{Name: "l_quantity", Type: parquettest.Double},
+	{Name: "l_discount", Type: parquettest.Decimal, Scale: 2},
+	{Name: "l_shipdate", Type: parquettest.Date},
+	{Name: "l_returned", Type: parquettest.Bool},
+	{Name: "l_comment", Type: parquettest.String, Optional: true},
+}
+
+// testRows returns the rows of the test file and the values they are read as
+func testRows(numRows int) ([][]interface{}, [][]string) {
+	rows := [][]interface{}{}
+	expected := [][]string{}
+	for i := 0; i < numRows; i++ {
+		flag := []string{"A", "N", "R"}[i%3]
+		shipDate := time.Date(1998, 9, 2, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i)
+
+		var comment interface{}
+		expectedComment := ""
+		if i%4 != 0 {
+			comment = fmt.Sprintf("comment %d", i)
+			expectedComment = comment.(string)
+		}
+
+		rows = append(rows, []interface{}{int64(i), flag, float64(i) + 0.5, int64(i - 5), shipDate, i%2 == 0, comment})
+		expected = append(expected, []string{
+			strconv.Itoa(i),
+			flag,
+			strconv.FormatFloat(float64(i)+0.5, 'g', -1, 64),
+			fmt.Sprintf("%.2f", float64(i-5)/100),
+			shipDate.Format("2006-01-02"),
+			strconv.FormatBool(i%2 == 0),
+			expectedComment,
+		})
+	}
+
+	return rows, expected
+}
+
+// readMetadata reads the metadata of a file from its tail and footer
+func readMetadata(t *testing.T, file []byte) *parquet.FileMetaData {
+	footerLength, err := parquet.FooterLength(file[len(file)-parquet.TailSize:])
+	require.Nil(t, err)
+
+	footerStart := int64(len(file)) - parquet.TailSize - footerLength
+	metadata, err := parquet.ReadMetadata(file[footerStart : len(file)-parquet.TailSize])
+	require.Nil(t, err)
+
+	return metadata
+}
+
+// readRows reads all the rows of the row groups
+func readRows(t *testing.T, file []byte, metadata *parquet.FileMetaData, first, count int, columns []string) [][]string {
+	start, _ := metadata.RowGroups[first].ByteRange()
+	_, end := metadata.RowGroups[first+count-1].ByteRange()
+
+	reader, err := parquet.NewRowReader(bytes.NewReader(file[start:end]), metadata, start, first, count, columns)
+	require.Nil(t, err)
+
+	rows := [][]string{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func Test_RowReader_HappyPath(t *testing.T) {
+	rows, expected := testRows(25)
+
+	tests := []struct {
+		name    string
+		options parquettest.Options
+	}{
+		{"uncompressed", parquettest.Options{RowGroupSize: 10, PageSize: 4}},
+		{"snappy", parquettest.Options{RowGroupSize: 10, PageSize: 3, Codec: parquet.Snappy}},
+		{"gzip data page v2", parquettest.Options{RowGroupSize: 7, PageSize: 5, Codec: parquet.Gzip, DataPageV2: true}},
+		{"zstd", parquettest.Options{RowGroupSize: 10, PageSize: 4, Codec: parquet.Zstd}},
+		{"single row group", parquettest.Options{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var file bytes.Buffer
+			require.Nil(t, parquettest.Write(&file, testColumns, rows, test.options))
+
+			metadata := readMetadata(t, file.Bytes())
+			assert.Equal(t, int64(25), metadata.NumRows)
+
+			numRowGroups := len(metadata.RowGroups)
+			assert.Equal(t, expected, readRows(t, file.Bytes(), metadata, 0, numRowGroups, nil))
+		})
+	}
+}
+
+// this function reads files written by other Parquet writers, see testdata/README.md
+func Test_RowReader_OtherWriters(t *testing.T) {
+	decimals := [][]string{}
+	for i := 1; i <= 24; i++ {
+		decimals = append(decimals, []string{fmt.Sprintf("%d.00", i)})
+	}
+
+	lineitems := [][]string{}
+	for i := 0; i < 10; i++ {
+		lineitems = append(lineitems, []string{
+			strconv.Itoa(i + 1),
+			[]string{"A", "N", "R"}[i%3],
+			strconv.FormatFloat(float64(i)+0.5, 'g', -1, 64),
+			[]string{"", "quickly final", "blithely even"}[i%3],
+		})
+	}
+
+	tests := []struct {
+		file         string
+		codec        parquet.Codec
+		numRowGroups int
+		expected     [][]string
+	}{
+		{
+			file:         "pyarrow_snappy.parquet",
+			codec:        parquet.Snappy,
+			numRowGroups: 1,
+			expected:     [][]string{{"test1", ""}, {"test2", ""}, {"test3", ""}, {"test4", ""}},
+		},
+		{
+			file:         "spark_decimal.parquet",
+			codec:        parquet.Uncompressed,
+			numRowGroups: 1,
+			expected:     decimals,
+		},
+		{
+			file:         "parquet_mr_gzip.parquet",
+			codec:        parquet.Gzip,
+			numRowGroups: 1,
+			expected: [][]string{
+				{"Hello"}, {"This is"}, {"a"}, {"test"}, {"How"}, {"are you"}, {"doing "},
+				{"today"}, {"the quick"}, {"brown fox"}, {"jumps"}, {"over"}, {"the lazy"}, {"dog"},
+			},
+		},
+		{
+			file:         "parquet_go_zstd.parquet",
+			codec:        parquet.Zstd,
+			numRowGroups: 2,
+			expected:     lineitems,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			file, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+			require.Nil(t, err)
+
+			metadata := readMetadata(t, file)
+			require.Len(t, metadata.RowGroups, test.numRowGroups)
+			assert.Equal(t, test.codec, metadata.RowGroups[0].Columns[0].MetaData.Codec)
+			assert.Equal(t, int64(len(test.expected)), metadata.NumRows)
+
+			assert.Equal(t, test.expected, readRows(t, file, metadata, 0, test.numRowGroups, nil))
+		})
+	}
+}
+
+func Test_RowReader_RowGroupsAndProjection(t *testing.T) {
+	rows, expected := testRows(25)
+
+	var file bytes.Buffer
+	require.Nil(t, parquettest.Write(&file, testColumns, rows, parquettest.Options{RowGroupSize: 10, Codec: parquet.Snappy}))
+	metadata := readMetadata(t, file.Bytes())
+	require.Len(t, metadata.RowGroups, 3)
+
+	// the row groups are consecutive
+	_, end := metadata.RowGroups[0].ByteRange()
+	start, _ := metadata.RowGroups[1].ByteRange()
+	assert.Equal(t, end, start)
+
+	// read the second row group with the columns in a different order
+	start, end = metadata.RowGroups[1].ByteRange()
+	reader, err := parquet.NewRowReader(bytes.NewReader(file.Bytes()[start:end]), metadata, start, 1, 1, []string{"l_comment", "l_orderkey"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"l_comment", "l_orderkey"}, reader.Columns())
+
+	for i := 10; i < 20; i++ {
+		row, err := reader.Read()
+		require.Nil(t, err)
+		assert.Equal(t, []string{expected[i][6], expected[i][0]}, row)
+		assert.Equal(t, int64(i+1), reader.RowNumber())
+	}
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func Test_RowReader_UnhappyPath(t *testing.T) {
+	rows, _ := testRows(5)
+
+	var file bytes.Buffer
+	require.Nil(t, parquettest.Write(&file, testColumns, rows, parquettest.Options{}))
+	metadata := readMetadata(t, file.Bytes())
+
+	_, err := parquet.NewRowReader(bytes.NewReader(nil), metadata, 4, 0, 1, []string{"unknown"})
+	assert.EqualError(t, err, `Unknown column "unknown"`)
+
+	_, err = parquet.NewRowReader(bytes.NewReader(nil), metadata, 4, 1, 1, nil)
+	assert.EqualError(t, err, "Row groups 1 to 1 out of range, the file has 1 row groups")
+
+	_, err = parquet.FooterLength([]byte("12345678"))
+	assert.EqualError(t, err, "Invalid Parquet file, the magic number is missing")
+
+	_, err = parquet.ReadMetadata([]byte{0x15})
+	assert.EqualError(t, err, "Invalid Parquet metadata: Unexpected end of thrift data")
+
+	// the chunks are truncated
+	start, end := metadata.RowGroups[0].ByteRange()
+	reader, err := parquet.NewRowReader(bytes.NewReader(file.Bytes()[start:end-10]), metadata, start, 0, 1, nil)
+	require.Nil(t, err)
+	_, err = reader.Read()
+	assert.Error(t, err)
+
+	// nested columns are not supported
+	metadata.Schema[1].NumChildren = 1
+	_, err = metadata.Columns()
+	assert.EqualError(t, err, "Nested column l_orderkey is not supported")
+}
@@ -0,0 +1,248 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// types of the thrift compact protocol
+const (
+	compactStop         byte = 0
+	compactBooleanTrue  byte = 1
+	compactBooleanFalse byte = 2
+	compactByte         byte = 3
+	compactI16          byte = 4
+	compactI32          byte = 5
+	compactI64          byte = 6
+	compactDouble       byte = 7
+	compactBinary       byte = 8
+	compactList         byte = 9
+	compactSet          byte = 10
+	compactMap          byte = 11
+	compactStruct       byte = 12
+)
+
+var errUnexpectedEnd = errors.New("Unexpected end of thrift data")
+
+// compactReader decodes the thrift compact protocol used by the
+// metadata of Parquet files and the headers of their pages
+type compactReader struct {
+	data []byte
+	pos  int
+}
+
+func newCompactReader(data []byte) *compactReader {
+	return &compactReader{
+		data: data,
+	}
+}
+
+func (r *compactReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errUnexpectedEnd
+	}
+	b := r.data[r.pos]
+	r.pos++
+
+	return b, nil
+}
+
+func (r *compactReader) readVarint() (uint64, error) {
+	value, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errUnexpectedEnd
+	}
+	r.pos = r.pos + n
+
+	return value, nil
+}
+
+// readZigzag reads a signed integer, which are zigzag encoded
+func (r *compactReader) readZigzag() (int64, error) {
+	value, err := r.readVarint()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(value>>1) ^ -int64(value&1), nil
+}
+
+func (r *compactReader) readI32() (int32, error) {
+	value, err := r.readZigzag()
+	return int32(value), err
+}
+
+func (r *compactReader) readI64() (int64, error) {
+	return r.readZigzag()
+}
+
+func (r *compactReader) readBinary() ([]byte, error) {
+	length, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(r.data)-r.pos) {
+		return nil, errUnexpectedEnd
+	}
+
+	value := r.data[r.pos : r.pos+int(length)]
+	r.pos = r.pos + int(length)
+
+	return value, nil
+}
+
+func (r *compactReader) readString() (string, error) {
+	value, err := r.readBinary()
+	return string(value), err
+}
+
+// readStruct reads the fields of a struct calling readField for each of them.
+// Boolean fields have their value in the type, which is compactBooleanTrue or
+// compactBooleanFalse. readField must read or skip the value of the field
+func (r *compactReader) readStruct(readField func(id int16, fieldType byte) error) error {
+	lastID := int16(0)
+	for {
+		header, err := r.readByte()
+		if err != nil {
+			return err
+		}
+
+		fieldType := header & 0x0f
+		if fieldType == compactStop {
+			return nil
+		}
+
+		// the id is a delta from the previous field or follows the header
+		id := lastID + int16(header>>4)
+		if header>>4 == 0 {
+			value, err := r.readZigzag()
+			if err != nil {
+				return err
+			}
+			id = int16(value)
+		}
+		lastID = id
+
+		if err := readField(id, fieldType); err != nil {
+			return err
+		}
+	}
+}
+
+// readList reads the elements of a list calling readElement for each of them
+func (r *compactReader) readList(readElement func(elementType byte) error) error {
+	header, err := r.readByte()
+	if err != nil {
+		return err
+	}
+
+	size := int(header >> 4)
+	if size == 15 {
+		value, err := r.readVarint()
+		if err != nil {
+			return err
+		}
+		if value > uint64(len(r.data)) {
+			return errUnexpectedEnd
+		}
+		size = int(value)
+	}
+
+	for i := 0; i < size; i++ {
+		if err := readElement(header & 0x0f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skip skips a value of the given type
+func (r *compactReader) skip(fieldType byte) error {
+	var err error
+	switch fieldType {
+	case compactBooleanTrue, compactBooleanFalse:
+		// the value is in the field type
+	case compactByte:
+		_, err = r.readByte()
+	case compactI16, compactI32, compactI64:
+		_, err = r.readVarint()
+	case compactDouble:
+		if len(r.data)-r.pos < 8 {
+			return errUnexpectedEnd
+		}
+		r.pos = r.pos + 8
+	case compactBinary:
+		_, err = r.readBinary()
+	case compactList, compactSet:
+		err = r.readList(func(elementType byte) error {
+			if elementType == compactBooleanTrue || elementType == compactBooleanFalse {
+				// booleans in lists are a byte
+				_, err := r.readByte()
+				return err
+			}
+			return r.skip(elementType)
+		})
+	case compactMap:
+		err = r.skipMap()
+	case compactStruct:
+		err = r.readStruct(func(id int16, fieldType byte) error {
+			return r.skip(fieldType)
+		})
+	default:
+		err = fmt.Errorf("Invalid thrift type %d", fieldType)
+	}
+
+	return err
+}
+
+func (r *compactReader) skipMap() error {
+	size, err := r.readVarint()
+	if err != nil || size == 0 {
+		return err
+	}
+	if size > uint64(len(r.data)) {
+		return errUnexpectedEnd
+	}
+
+	types, err := r.readByte()
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < size; i++ {
+		if err := r.skip(types >> 4); err != nil {
+			return err
+		}
+		if err := r.skip(types & 0x0f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readI32List reads a list of integers
+func (r *compactReader) readI32List() ([]int32, error) {
+	values := []int32{}
+	err := r.readList(func(elementType byte) error {
+		value, err := r.readI32()
+		values = append(values, value)
+		return err
+	})
+
+	return values, err
+}
+
+// readStringList reads a list of strings
+func (r *compactReader) readStringList() ([]string, error) {
+	values := []string{}
+	err := r.readList(func(elementType byte) error {
+		value, err := r.readString()
+		values = append(values, value)
+		return err
+	})
+
+	return values, err
+}
@@ -0,0 +1,683 @@
+package queues
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+)
+
+const (
+	// memoryQueueURLPrefix is the prefix of the url of in-memory queues
+	memoryQueueURLPrefix = "https://localhost:4566/000000000000/"
+	// memoryQueueArnPrefix is the prefix of the arn of in-memory queues
+	memoryQueueArnPrefix = "arn:aws:sqs:local:000000000000:"
+	// pollInterval is the time between checks for new messages while long polling
+	pollInterval = 10 * time.Millisecond
+
+	// limits enforced by SQS
+	MaxBatchEntries          = 10
+	MaxBatchPayloadSize      = 256 * 1024
+	DefaultVisibilityTimeout = 30
+	MaxVisibilityTimeout     = 12 * 60 * 60
+	MaxWaitTimeSeconds       = 20
+	MaxDelaySeconds          = 15 * 60
+	MaxListQueuesResults     = 1000
+)
+
+// MemoryQueues is an in-memory implementation of QueuesAPI that follows SQS semantics.
+// Received messages are hidden for the visibility timeout of the queue, or the one given
+// in the request, and are delivered again if they are not deleted before it expires.
+// Messages received more times than the maxReceiveCount of the queue RedrivePolicy are
+// moved to its dead-letter queue. It is used to run jobs in-process without SQS or
+// localstack and to test at-least-once delivery deterministically
+type MemoryQueues struct {
+	queues map[string]*memoryQueue
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+// memoryQueue holds the messages of a single queue in the order they were sent
+type memoryQueue struct {
+	name              string
+	attributes        map[string]string
+	visibilityTimeout time.Duration
+	delay             time.Duration
+	deadLetterQueue   string
+	maxReceiveCount   int
+	messages          []*memoryMessage
+}
+
+// memoryMessage is a message stored in a queue
+type memoryMessage struct {
+	id                    string
+	body                  string
+	attributes            map[string]types.MessageAttributeValue
+	sentAt                time.Time
+	visibleAt             time.Time
+	receiptHandle         string
+	receiveCount          int
+	firstReceiveTimestamp time.Time
+}
+
+// NewMemoryQueues creates an empty set of in-memory queues
+func NewMemoryQueues() *MemoryQueues {
+	return NewMemoryQueuesWithClock(time.Now)
+}
+
+// NewMemoryQueuesWithClock creates an empty set of in-memory queues that use
+// the given clock for visibility timeouts and delays. This allows tests to make
+// messages visible again without waiting for the timeout
+func NewMemoryQueuesWithClock(now func() time.Time) *MemoryQueues {
+	return &MemoryQueues{
+		queues: make(map[string]*memoryQueue),
+		now:    now,
+	}
+}
+
+// CreateQueue creates a queue. Creating a queue that already exists returns its url
+func (q *MemoryQueues) CreateQueue(
+	ctx context.Context,
+	params *sqs.CreateQueueInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.CreateQueueOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	name := aws.ToString(params.QueueName)
+	if name == "" {
+		return nil, fmt.Errorf("InvalidParameterValue: queue name is required")
+	}
+
+	if _, ok := q.queues[name]; !ok {
+		queue, err := newMemoryQueue(name, params.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		q.queues[name] = queue
+	}
+
+	return &sqs.CreateQueueOutput{
+		QueueUrl: aws.String(memoryQueueURLPrefix + name),
+	}, nil
+}
+
+// GetQueueUrl returns the url of an existing queue
+func (q *MemoryQueues) GetQueueUrl(
+	ctx context.Context,
+	params *sqs.GetQueueUrlInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.GetQueueUrlOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	name := aws.ToString(params.QueueName)
+	if _, ok := q.queues[name]; !ok {
+		return nil, &types.QueueDoesNotExist{Message: aws.String(name)}
+	}
+
+	return &sqs.GetQueueUrlOutput{
+		QueueUrl: aws.String(memoryQueueURLPrefix + name),
+	}, nil
+}
+
+// GetQueueAttributes returns the attributes set when creating the queue together
+// with the QueueArn and the approximate number of messages attributes
+func (q *MemoryQueues) GetQueueAttributes(
+	ctx context.Context,
+	params *sqs.GetQueueAttributesInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.GetQueueAttributesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue, err := q.getQueue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// count messages by state
+	now := q.now()
+	visible, notVisible, delayed := 0, 0, 0
+	for _, message := range queue.messages {
+		switch {
+		case message.receiveCount == 0 && now.Before(message.visibleAt):
+			delayed++
+		case now.Before(message.visibleAt):
+			notVisible++
+		default:
+			visible++
+		}
+	}
+
+	all := make(map[string]string, len(queue.attributes)+4)
+	for key, value := range queue.attributes {
+		all[key] = value
+	}
+	all[string(types.QueueAttributeNameQueueArn)] = memoryQueueArnPrefix + queue.name
+	all[string(types.QueueAttributeNameVisibilityTimeout)] = strconv.Itoa(int(queue.visibilityTimeout / time.Second))
+	all[string(types.QueueAttributeNameApproximateNumberOfMessages)] = strconv.Itoa(visible)
+	all[string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)] = strconv.Itoa(notVisible)
+	all[string(types.QueueAttributeNameApproximateNumberOfMessagesDelayed)] = strconv.Itoa(delayed)
+
+	attributes := make(map[string]string)
+	for _, name := range params.AttributeNames {
+		if name == types.QueueAttributeNameAll {
+			attributes = all
+			break
+		}
+		if value, ok := all[string(name)]; ok {
+			attributes[string(name)] = value
+		}
+	}
+
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: attributes,
+	}, nil
+}
+
+// SendMessage adds a message to the queue
+func (q *MemoryQueues) SendMessage(
+	ctx context.Context,
+	params *sqs.SendMessageInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.SendMessageOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(aws.ToString(params.MessageBody)) > MaxBatchPayloadSize {
+		return nil, &types.InvalidMessageContents{Message: aws.String("message must be shorter than 262144 bytes")}
+	}
+	if params.DelaySeconds < 0 || params.DelaySeconds > MaxDelaySeconds {
+		return nil, fmt.Errorf("InvalidParameterValue: DelaySeconds must be between 0 and %d", MaxDelaySeconds)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue, err := q.getQueue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	message := queue.send(q.now(), params.MessageBody, params.MessageAttributes, params.DelaySeconds)
+
+	return &sqs.SendMessageOutput{
+		MessageId: aws.String(message.id),
+	}, nil
+}
+
+// SendMessageBatch adds the messages of the batch to the queue. As in SQS a batch
+// can't have more than 10 entries, the ids of the entries must be distinct and the
+// total size of the batch can't be larger than 256 KiB
+func (q *MemoryQueues) SendMessageBatch(
+	ctx context.Context,
+	params *sqs.SendMessageBatchInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.SendMessageBatchOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// validate batch
+	ids := make([]*string, len(params.Entries))
+	payloadSize := 0
+	for i, entry := range params.Entries {
+		ids[i] = entry.Id
+		payloadSize += len(aws.ToString(entry.MessageBody))
+	}
+	if err := validateBatch(ids); err != nil {
+		return nil, err
+	}
+	if payloadSize > MaxBatchPayloadSize {
+		return nil, &types.BatchRequestTooLong{
+			Message: aws.String(fmt.Sprintf("batch size %d is larger than %d bytes", payloadSize, MaxBatchPayloadSize)),
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue, err := q.getQueue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &sqs.SendMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		if entry.DelaySeconds < 0 || entry.DelaySeconds > MaxDelaySeconds {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("InvalidParameterValue"),
+				SenderFault: true,
+			})
+			continue
+		}
+
+		message := queue.send(q.now(), entry.MessageBody, entry.MessageAttributes, entry.DelaySeconds)
+		output.Successful = append(output.Successful, types.SendMessageBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: aws.String(message.id),
+		})
+	}
+
+	return output, nil
+}
+
+// ReceiveMessage receives up to MaxNumberOfMessages visible messages from the queue
+// and hides them for the visibility timeout. If WaitTimeSeconds is set it waits for
+// messages to arrive until the wait time expires
+func (q *MemoryQueues) ReceiveMessage(
+	ctx context.Context,
+	params *sqs.ReceiveMessageInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.ReceiveMessageOutput, error) {
+	if params.MaxNumberOfMessages < 0 || params.MaxNumberOfMessages > MaxBatchEntries {
+		return nil, fmt.Errorf("InvalidParameterValue: MaxNumberOfMessages must be between 1 and %d", MaxBatchEntries)
+	}
+	if params.WaitTimeSeconds < 0 || params.WaitTimeSeconds > MaxWaitTimeSeconds {
+		return nil, fmt.Errorf("InvalidParameterValue: WaitTimeSeconds must be between 0 and %d", MaxWaitTimeSeconds)
+	}
+	if params.VisibilityTimeout < 0 || params.VisibilityTimeout > MaxVisibilityTimeout {
+		return nil, fmt.Errorf("InvalidParameterValue: VisibilityTimeout must be between 0 and %d", MaxVisibilityTimeout)
+	}
+
+	// the wait time uses the wall clock as it is the time the caller is blocked
+	deadline := time.Now().Add(time.Duration(params.WaitTimeSeconds) * time.Second)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		messages, err := q.receive(params)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(messages) > 0 || !time.Now().Before(deadline) {
+			return &sqs.ReceiveMessageOutput{
+				Messages: messages,
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// DeleteMessageBatch deletes received messages from the queue. As in SQS only the
+// receipt handle of the last time the message was received deletes the message,
+// older receipt handles succeed without deleting it
+func (q *MemoryQueues) DeleteMessageBatch(
+	ctx context.Context,
+	params *sqs.DeleteMessageBatchInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.DeleteMessageBatchOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]*string, len(params.Entries))
+	for i, entry := range params.Entries {
+		ids[i] = entry.Id
+	}
+	if err := validateBatch(ids); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue, err := q.getQueue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		receiptHandle := aws.ToString(entry.ReceiptHandle)
+		messageID, ok := parseReceiptHandle(receiptHandle)
+		if !ok {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("ReceiptHandleIsInvalid"),
+				SenderFault: true,
+			})
+			continue
+		}
+
+		for i, message := range queue.messages {
+			if message.id == messageID && message.receiptHandle == receiptHandle {
+				queue.messages = append(queue.messages[:i], queue.messages[i+1:]...)
+				break
+			}
+		}
+
+		output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{
+			Id: entry.Id,
+		})
+	}
+
+	return output, nil
+}
+
+// ListQueues returns the urls of the queues whose name starts with QueueNamePrefix
+// in lexicographical order. NextToken is only returned when MaxResults is set, as in SQS
+func (q *MemoryQueues) ListQueues(
+	ctx context.Context,
+	params *sqs.ListQueuesInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.ListQueuesOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// the next token is the last queue name returned in the previous page
+	prefix := aws.ToString(params.QueueNamePrefix)
+	startAfter := aws.ToString(params.NextToken)
+	names := []string{}
+	for name := range q.queues {
+		if strings.HasPrefix(name, prefix) && name > startAfter {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	maxResults := MaxListQueuesResults
+	if params.MaxResults != nil {
+		maxResults = int(*params.MaxResults)
+	}
+
+	output := &sqs.ListQueuesOutput{}
+	if len(names) > maxResults {
+		names = names[:maxResults]
+		if params.MaxResults != nil {
+			output.NextToken = aws.String(names[len(names)-1])
+		}
+	}
+
+	output.QueueUrls = make([]string, len(names))
+	for i, name := range names {
+		output.QueueUrls[i] = memoryQueueURLPrefix + name
+	}
+
+	return output, nil
+}
+
+// DeleteQueue deletes the queue and all of its messages
+func (q *MemoryQueues) DeleteQueue(
+	ctx context.Context,
+	params *sqs.DeleteQueueInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.DeleteQueueOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue, err := q.getQueue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	delete(q.queues, queue.name)
+
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+// receive hides up to MaxNumberOfMessages visible messages and returns them. Messages that
+// have reached the maxReceiveCount of the queue are moved to the dead-letter queue instead
+func (q *MemoryQueues) receive(params *sqs.ReceiveMessageInput) ([]types.Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queue, err := q.getQueue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	maxMessages := int(params.MaxNumberOfMessages)
+	if maxMessages == 0 {
+		maxMessages = 1
+	}
+
+	visibilityTimeout := queue.visibilityTimeout
+	if params.VisibilityTimeout != 0 {
+		visibilityTimeout = time.Duration(params.VisibilityTimeout) * time.Second
+	}
+
+	now := q.now()
+	messages := []types.Message{}
+	remaining := queue.messages[:0]
+	for _, message := range queue.messages {
+		if len(messages) == maxMessages || now.Before(message.visibleAt) {
+			remaining = append(remaining, message)
+			continue
+		}
+
+		// move message to the dead-letter queue
+		if queue.maxReceiveCount > 0 && message.receiveCount >= queue.maxReceiveCount {
+			if deadLetterQueue, ok := q.queues[queue.deadLetterQueue]; ok {
+				message.visibleAt = now
+				message.receiptHandle = ""
+				deadLetterQueue.messages = append(deadLetterQueue.messages, message)
+				continue
+			}
+		}
+
+		message.receiveCount++
+		if message.receiveCount == 1 {
+			message.firstReceiveTimestamp = now
+		}
+		message.visibleAt = now.Add(visibilityTimeout)
+		message.receiptHandle = newReceiptHandle(message.id)
+		messages = append(messages, message.toMessage(params.AttributeNames, params.MessageAttributeNames))
+
+		remaining = append(remaining, message)
+	}
+	queue.messages = remaining
+
+	return messages, nil
+}
+
+// getQueue returns the queue for the given url, the queue name is the last segment of the url
+func (q *MemoryQueues) getQueue(queueURL *string) (*memoryQueue, error) {
+	url := aws.ToString(queueURL)
+	name := url[strings.LastIndex(url, "/")+1:]
+
+	queue, ok := q.queues[name]
+	if !ok {
+		return nil, &types.QueueDoesNotExist{Message: aws.String(name)}
+	}
+
+	return queue, nil
+}
+
+// newMemoryQueue creates a queue from the CreateQueue attributes
+func newMemoryQueue(name string, attributes map[string]string) (*memoryQueue, error) {
+	queue := &memoryQueue{
+		name:              name,
+		attributes:        make(map[string]string, len(attributes)),
+		visibilityTimeout: DefaultVisibilityTimeout * time.Second,
+	}
+
+	for key, value := range attributes {
+		queue.attributes[key] = value
+	}
+
+	if value, ok := attributes[string(types.QueueAttributeNameVisibilityTimeout)]; ok {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || seconds > MaxVisibilityTimeout {
+			return nil, &types.InvalidAttributeName{Message: aws.String("invalid VisibilityTimeout " + value)}
+		}
+		queue.visibilityTimeout = time.Duration(seconds) * time.Second
+	}
+
+	if value, ok := attributes[string(types.QueueAttributeNameDelaySeconds)]; ok {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || seconds > MaxDelaySeconds {
+			return nil, &types.InvalidAttributeName{Message: aws.String("invalid DelaySeconds " + value)}
+		}
+		queue.delay = time.Duration(seconds) * time.Second
+	}
+
+	if value, ok := attributes[string(types.QueueAttributeNameRedrivePolicy)]; ok {
+		// maxReceiveCount can be encoded as a string or as a number
+		var policy struct {
+			DeadLetterTargetArn string          `json:"deadLetterTargetArn"`
+			MaxReceiveCount     json.RawMessage `json:"maxReceiveCount"`
+		}
+		if err := json.Unmarshal([]byte(value), &policy); err != nil {
+			return nil, &types.InvalidAttributeName{Message: aws.String("invalid RedrivePolicy " + value)}
+		}
+
+		maxReceiveCount, err := strconv.Atoi(strings.Trim(string(policy.MaxReceiveCount), `"`))
+		if err != nil || maxReceiveCount < 1 {
+			return nil, &types.InvalidAttributeName{Message: aws.String("invalid RedrivePolicy " + value)}
+		}
+
+		queue.deadLetterQueue = policy.DeadLetterTargetArn[strings.LastIndex(policy.DeadLetterTargetArn, ":")+1:]
+		queue.maxReceiveCount = maxReceiveCount
+	}
+
+	return queue, nil
+}
+
+// send adds a message to the queue, the message is visible once the delay has passed
+func (queue *memoryQueue) send(
+	now time.Time,
+	body *string,
+	attributes map[string]types.MessageAttributeValue,
+	delaySeconds int32,
+) *memoryMessage {
+	delay := queue.delay
+	if delaySeconds != 0 {
+		delay = time.Duration(delaySeconds) * time.Second
+	}
+
+	message := &memoryMessage{
+		id:         uuid.New().String(),
+		body:       aws.ToString(body),
+		attributes: attributes,
+		sentAt:     now,
+		visibleAt:  now.Add(delay),
+	}
+	queue.messages = append(queue.messages, message)
+
+	return message
+}
+
+// toMessage converts the stored message to an sqs message including only
+// the requested system and message attributes
+func (m *memoryMessage) toMessage(attributeNames []types.QueueAttributeName, messageAttributeNames []string) types.Message {
+	message := types.Message{
+		MessageId:     aws.String(m.id),
+		ReceiptHandle: aws.String(m.receiptHandle),
+		Body:          aws.String(m.body),
+	}
+
+	systemAttributes := map[string]string{
+		string(types.MessageSystemAttributeNameApproximateReceiveCount):          strconv.Itoa(m.receiveCount),
+		string(types.MessageSystemAttributeNameSentTimestamp):                    strconv.FormatInt(m.sentAt.UnixNano()/int64(time.Millisecond), 10),
+		string(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp): strconv.FormatInt(m.firstReceiveTimestamp.UnixNano()/int64(time.Millisecond), 10),
+	}
+	for _, name := range attributeNames {
+		if name == types.QueueAttributeNameAll {
+			message.Attributes = systemAttributes
+			break
+		}
+
+		if value, ok := systemAttributes[string(name)]; ok {
+			if message.Attributes == nil {
+				message.Attributes = make(map[string]string)
+			}
+			message.Attributes[string(name)] = value
+		}
+	}
+
+	for _, name := range messageAttributeNames {
+		if name == "All" || name == ".*" {
+			message.MessageAttributes = m.attributes
+			break
+		}
+
+		if value, ok := m.attributes[name]; ok {
+			if message.MessageAttributes == nil {
+				message.MessageAttributes = make(map[string]types.MessageAttributeValue)
+			}
+			message.MessageAttributes[name] = value
+		}
+	}
+
+	return message
+}
+
+// validateBatch checks that a batch has between 1 and 10 entries with distinct ids
+func validateBatch(ids []*string) error {
+	if len(ids) == 0 {
+		return &types.EmptyBatchRequest{Message: aws.String("batch has no entries")}
+	}
+	if len(ids) > MaxBatchEntries {
+		return &types.TooManyEntriesInBatchRequest{
+			Message: aws.String(fmt.Sprintf("batch has %d entries, the maximum is %d", len(ids), MaxBatchEntries)),
+		}
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id == nil || *id == "" {
+			return &types.InvalidBatchEntryId{Message: aws.String("batch entry id is required")}
+		}
+		if seen[*id] {
+			return &types.BatchEntryIdsNotDistinct{Message: id}
+		}
+		seen[*id] = true
+	}
+
+	return nil
+}
+
+// newReceiptHandle creates a receipt handle for a message. Each receive creates a new handle
+func newReceiptHandle(messageID string) string {
+	return fmt.Sprintf("%s#%s", messageID, uuid.New().String())
+}
+
+// parseReceiptHandle returns the message id of the receipt handle
+func parseReceiptHandle(receiptHandle string) (string, bool) {
+	parts := strings.SplitN(receiptHandle, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+
+	return parts[0], true
+}
@@ -0,0 +1,302 @@
+package queues
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock is a clock that only moves when the test advances it
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestQueue(t *testing.T, name string, attributes map[string]string) (*MemoryQueues, *testClock, *string) {
+	clock := &testClock{now: time.Unix(1640995200, 0)}
+	queues := NewMemoryQueuesWithClock(clock.Now)
+
+	output, err := queues.CreateQueue(context.Background(), &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: attributes,
+	})
+	require.Nil(t, err)
+
+	return queues, clock, output.QueueUrl
+}
+
+func sendTestMessages(t *testing.T, queues *MemoryQueues, queueURL *string, numMessages int) {
+	for i := 0; i < numMessages; i++ {
+		_, err := queues.SendMessage(context.Background(), &sqs.SendMessageInput{
+			QueueUrl:    queueURL,
+			MessageBody: aws.String(strconv.Itoa(i)),
+		})
+		require.Nil(t, err)
+	}
+}
+
+func getApproximateCounts(t *testing.T, queues *MemoryQueues, queueURL *string) (int, int, int) {
+	output, err := queues.GetQueueAttributes(context.Background(), &sqs.GetQueueAttributesInput{
+		QueueUrl: queueURL,
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+			types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			types.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		},
+	})
+	require.Nil(t, err)
+
+	visible, err := strconv.Atoi(output.Attributes["ApproximateNumberOfMessages"])
+	require.Nil(t, err)
+	notVisible, err := strconv.Atoi(output.Attributes["ApproximateNumberOfMessagesNotVisible"])
+	require.Nil(t, err)
+	delayed, err := strconv.Atoi(output.Attributes["ApproximateNumberOfMessagesDelayed"])
+	require.Nil(t, err)
+
+	return visible, notVisible, delayed
+}
+
+func Test_MemoryQueues_VisibilityTimeout(t *testing.T) {
+	ctx := context.Background()
+	queues, clock, queueURL := newTestQueue(t, "job-1", map[string]string{
+		"VisibilityTimeout": "60",
+	})
+	sendTestMessages(t, queues, queueURL, 3)
+
+	// receive two messages
+	output, err := queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            queueURL,
+		MaxNumberOfMessages: 2,
+		AttributeNames:      []types.QueueAttributeName{types.QueueAttributeNameAll},
+	})
+	require.Nil(t, err)
+	require.Len(t, output.Messages, 2)
+	assert.Equal(t, "0", *output.Messages[0].Body)
+	assert.Equal(t, "1", output.Messages[0].Attributes["ApproximateReceiveCount"])
+	firstReceiptHandle := output.Messages[0].ReceiptHandle
+
+	visible, notVisible, _ := getApproximateCounts(t, queues, queueURL)
+	assert.Equal(t, 1, visible)
+	assert.Equal(t, 2, notVisible)
+
+	// messages stay hidden until the visibility timeout expires
+	clock.Advance(59 * time.Second)
+	output, err = queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            queueURL,
+		MaxNumberOfMessages: 10,
+	})
+	require.Nil(t, err)
+	require.Len(t, output.Messages, 1)
+	assert.Equal(t, "2", *output.Messages[0].Body)
+
+	// the messages that were not deleted are delivered again
+	clock.Advance(time.Second)
+	output, err = queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            queueURL,
+		MaxNumberOfMessages: 10,
+		AttributeNames:      []types.QueueAttributeName{"ApproximateReceiveCount"},
+	})
+	require.Nil(t, err)
+	require.Len(t, output.Messages, 2)
+	assert.Equal(t, "0", *output.Messages[0].Body)
+	assert.Equal(t, "2", output.Messages[0].Attributes["ApproximateReceiveCount"])
+	assert.NotEqual(t, *firstReceiptHandle, *output.Messages[0].ReceiptHandle)
+
+	// deleting with an old receipt handle does not delete the message
+	deleteOutput, err := queues.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: queueURL,
+		Entries: []types.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("0"), ReceiptHandle: firstReceiptHandle},
+			{Id: aws.String("1"), ReceiptHandle: output.Messages[1].ReceiptHandle},
+			{Id: aws.String("2"), ReceiptHandle: aws.String("invalid")},
+		},
+	})
+	require.Nil(t, err)
+	assert.Len(t, deleteOutput.Successful, 2)
+	require.Len(t, deleteOutput.Failed, 1)
+	assert.Equal(t, "ReceiptHandleIsInvalid", *deleteOutput.Failed[0].Code)
+
+	visible, notVisible, _ = getApproximateCounts(t, queues, queueURL)
+	assert.Equal(t, 0, visible)
+	assert.Equal(t, 2, notVisible)
+}
+
+func Test_MemoryQueues_ReceiveVisibilityTimeoutOverride(t *testing.T) {
+	ctx := context.Background()
+	queues, clock, queueURL := newTestQueue(t, "job-1-meta", nil)
+	sendTestMessages(t, queues, queueURL, 1)
+
+	output, err := queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:          queueURL,
+		VisibilityTimeout: 5,
+	})
+	require.Nil(t, err)
+	require.Len(t, output.Messages, 1)
+
+	clock.Advance(5 * time.Second)
+	output, err = queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl: queueURL,
+	})
+	require.Nil(t, err)
+	assert.Len(t, output.Messages, 1)
+}
+
+func Test_MemoryQueues_RedrivePolicy(t *testing.T) {
+	ctx := context.Background()
+	queues, clock, queueURL := newTestQueue(t, "job-messages-dlq", nil)
+	dlqURL := queueURL
+
+	// create queue that moves messages to the dlq after 3 receives
+	output, err := queues.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String("job-1"),
+		Attributes: map[string]string{
+			"RedrivePolicy":     `{"deadLetterTargetArn": "arn:aws:sqs:local:000000000000:job-messages-dlq", "maxReceiveCount":"3"}`,
+			"VisibilityTimeout": "60",
+		},
+	})
+	require.Nil(t, err)
+	queueURL = output.QueueUrl
+	sendTestMessages(t, queues, queueURL, 1)
+
+	for i := 1; i <= 3; i++ {
+		output, err := queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:       queueURL,
+			AttributeNames: []types.QueueAttributeName{"ApproximateReceiveCount"},
+		})
+		require.Nil(t, err)
+		require.Len(t, output.Messages, 1)
+		assert.Equal(t, strconv.Itoa(i), output.Messages[0].Attributes["ApproximateReceiveCount"])
+		clock.Advance(time.Minute)
+	}
+
+	// the fourth receive moves the message to the dlq
+	receiveOutput, err := queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl: queueURL,
+	})
+	require.Nil(t, err)
+	assert.Len(t, receiveOutput.Messages, 0)
+
+	visible, notVisible, _ := getApproximateCounts(t, queues, queueURL)
+	assert.Equal(t, 0, visible+notVisible)
+	visible, _, _ = getApproximateCounts(t, queues, dlqURL)
+	assert.Equal(t, 1, visible)
+
+	receiveOutput, err = queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl: dlqURL,
+	})
+	require.Nil(t, err)
+	require.Len(t, receiveOutput.Messages, 1)
+	assert.Equal(t, "0", *receiveOutput.Messages[0].Body)
+}
+
+func Test_MemoryQueues_DelaySeconds(t *testing.T) {
+	ctx := context.Background()
+	queues, clock, queueURL := newTestQueue(t, "job-1", nil)
+
+	_, err := queues.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     queueURL,
+		MessageBody:  aws.String("delayed"),
+		DelaySeconds: 10,
+	})
+	require.Nil(t, err)
+
+	_, _, delayed := getApproximateCounts(t, queues, queueURL)
+	assert.Equal(t, 1, delayed)
+
+	output, err := queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL})
+	require.Nil(t, err)
+	assert.Len(t, output.Messages, 0)
+
+	clock.Advance(10 * time.Second)
+	output, err = queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL})
+	require.Nil(t, err)
+	assert.Len(t, output.Messages, 1)
+}
+
+func Test_MemoryQueues_BatchLimits(t *testing.T) {
+	ctx := context.Background()
+	queues, _, queueURL := newTestQueue(t, "job-1", nil)
+
+	entries := func(n int, id func(i int) string) []types.SendMessageBatchRequestEntry {
+		batch := make([]types.SendMessageBatchRequestEntry, n)
+		for i := range batch {
+			batch[i] = types.SendMessageBatchRequestEntry{
+				Id:          aws.String(id(i)),
+				MessageBody: aws.String("body"),
+			}
+		}
+		return batch
+	}
+
+	// more than 10 entries
+	_, err := queues.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: queueURL,
+		Entries:  entries(11, strconv.Itoa),
+	})
+	var tooManyEntries *types.TooManyEntriesInBatchRequest
+	assert.True(t, errors.As(err, &tooManyEntries))
+
+	// no entries
+	_, err = queues.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: queueURL,
+	})
+	var emptyBatch *types.EmptyBatchRequest
+	assert.True(t, errors.As(err, &emptyBatch))
+
+	// repeated ids
+	_, err = queues.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: queueURL,
+		Entries:  entries(2, func(i int) string { return "id" }),
+	})
+	var notDistinct *types.BatchEntryIdsNotDistinct
+	assert.True(t, errors.As(err, &notDistinct))
+
+	// receive more than 10 messages
+	_, err = queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            queueURL,
+		MaxNumberOfMessages: 11,
+	})
+	assert.NotNil(t, err)
+
+	// a batch of 10 is accepted
+	output, err := queues.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: queueURL,
+		Entries:  entries(10, func(i int) string { return fmt.Sprintf("message-%d", i) }),
+	})
+	require.Nil(t, err)
+	assert.Len(t, output.Successful, 10)
+
+	visible, _, _ := getApproximateCounts(t, queues, queueURL)
+	assert.Equal(t, 10, visible)
+}
+
+func Test_MemoryQueues_QueueDoesNotExist(t *testing.T) {
+	queues := NewMemoryQueues()
+
+	_, err := queues.GetQueueUrl(context.Background(), &sqs.GetQueueUrlInput{
+		QueueName: aws.String("missing"),
+	})
+	var doesNotExist *types.QueueDoesNotExist
+	assert.True(t, errors.As(err, &doesNotExist))
+
+	_, err = queues.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl: aws.String(memoryQueueURLPrefix + "missing"),
+	})
+	assert.True(t, errors.As(err, &doesNotExist))
+}
@@ -43,4 +43,14 @@ type QueuesAPI interface {
 		params *sqs.DeleteMessageBatchInput,
 		optFns ...func(*sqs.Options),
 	) (*sqs.DeleteMessageBatchOutput, error)
+	ListQueues(
+		ctx context.Context,
+		params *sqs.ListQueuesInput,
+		optFns ...func(*sqs.Options),
+	) (*sqs.ListQueuesOutput, error)
+	DeleteQueue(
+		ctx context.Context,
+		params *sqs.DeleteQueueInput,
+		optFns ...func(*sqs.Options),
+	) (*sqs.DeleteQueueOutput, error)
 }
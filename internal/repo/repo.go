@@ -12,4 +12,9 @@ type ImageRepoAPI interface {
 		params *ecr.CreateRepositoryInput,
 		optFns ...func(*ecr.Options),
 	) (*ecr.CreateRepositoryOutput, error)
+	DeleteRepository(
+		ctx context.Context,
+		params *ecr.DeleteRepositoryInput,
+		optFns ...func(*ecr.Options),
+	) (*ecr.DeleteRepositoryOutput, error)
 }
@@ -164,3 +164,33 @@ func (_m *FaasAPI) PutProvisionedConcurrencyConfig(ctx context.Context, params *
 
 	return r0, r1
 }
+
+// DeleteFunction provides a mock function with given fields: ctx, params, optFns
+func (_m *FaasAPI) DeleteFunction(ctx context.Context, params *lambda.DeleteFunctionInput, optFns ...func(*lambda.Options)) (*lambda.DeleteFunctionOutput, error) {
+	_va := make([]interface{}, len(optFns))
+	for _i := range optFns {
+		_va[_i] = optFns[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, params)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *lambda.DeleteFunctionOutput
+	if rf, ok := ret.Get(0).(func(context.Context, *lambda.DeleteFunctionInput, ...func(*lambda.Options)) *lambda.DeleteFunctionOutput); ok {
+		r0 = rf(ctx, params, optFns...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*lambda.DeleteFunctionOutput)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *lambda.DeleteFunctionInput, ...func(*lambda.Options)) error); ok {
+		r1 = rf(ctx, params, optFns...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
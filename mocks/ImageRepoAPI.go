@@ -43,3 +43,33 @@ func (_m *ImageRepoAPI) CreateRepository(ctx context.Context, params *ecr.Create
 
 	return r0, r1
 }
+
+// DeleteRepository provides a mock function with given fields: ctx, params, optFns
+func (_m *ImageRepoAPI) DeleteRepository(ctx context.Context, params *ecr.DeleteRepositoryInput, optFns ...func(*ecr.Options)) (*ecr.DeleteRepositoryOutput, error) {
+	_va := make([]interface{}, len(optFns))
+	for _i := range optFns {
+		_va[_i] = optFns[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, params)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *ecr.DeleteRepositoryOutput
+	if rf, ok := ret.Get(0).(func(context.Context, *ecr.DeleteRepositoryInput, ...func(*ecr.Options)) *ecr.DeleteRepositoryOutput); ok {
+		r0 = rf(ctx, params, optFns...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*ecr.DeleteRepositoryOutput)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *ecr.DeleteRepositoryInput, ...func(*ecr.Options)) error); ok {
+		r1 = rf(ctx, params, optFns...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -16,6 +16,18 @@ const (
 	MaxAggregatorType
 	MinAggregatorType
 	AvgAggregatorType
+	CountAggregatorType
+	HyperLogLogAggregatorType
+	QuantileAggregatorType
+	TopKAggregatorType
+	VarianceAggregatorType
+	StdDevAggregatorType
+	FirstAggregatorType
+	LastAggregatorType
+	AnyAggregatorType
+	CollectSetAggregatorType
+	CollectListAggregatorType
+	CustomAggregatorType
 )
 
 // Aggregator is an interface used to define new aggregators
@@ -46,6 +58,11 @@ func (ma MapAggregator) Reduce(message *ReduceMessage) error {
 		return nil
 	}
 
+	if message.Type == int64(CustomAggregatorType) {
+		// user-defined aggregators are reduced with their registered definition
+		return ma.reduceCustom(message)
+	}
+
 	_, ok := ma[message.Key]
 	if !ok {
 		// aggregator has not been initialized
@@ -62,6 +79,55 @@ func (ma MapAggregator) Reduce(message *ReduceMessage) error {
 		case int64(AvgAggregatorType):
 			ma[message.Key] = InitAvg(message.Value, message.Count)
 			return nil
+		case int64(CountAggregatorType):
+			ma[message.Key] = InitCount(int64(message.Count))
+			return nil
+		case int64(HyperLogLogAggregatorType):
+			h, err := InitHyperLogLogWithRegisters(message.Sketch)
+			if err != nil {
+				return err
+			}
+			ma[message.Key] = h
+			return nil
+		case int64(QuantileAggregatorType):
+			q, err := DecodeQuantile(message.Sketch)
+			if err != nil {
+				return err
+			}
+			ma[message.Key] = q
+			return nil
+		case int64(TopKAggregatorType):
+			t, err := DecodeTopK(message.Sketch)
+			if err != nil {
+				return err
+			}
+			ma[message.Key] = t
+			return nil
+		case int64(VarianceAggregatorType):
+			ma[message.Key] = InitVariance(message.Count, message.Mean, message.M2)
+			return nil
+		case int64(StdDevAggregatorType):
+			ma[message.Key] = InitStdDev(message.Count, message.Mean, message.M2)
+			return nil
+		case int64(FirstAggregatorType), int64(LastAggregatorType), int64(AnyAggregatorType):
+			if message.TypedValue == nil {
+				return errors.New("Missing typed value")
+			}
+			switch AggregatorType(message.Type) {
+			case FirstAggregatorType:
+				ma[message.Key] = InitFirst(*message.TypedValue, message.Order)
+			case LastAggregatorType:
+				ma[message.Key] = InitLast(*message.TypedValue, message.Order)
+			default:
+				ma[message.Key] = InitAny(*message.TypedValue)
+			}
+			return nil
+		case int64(CollectSetAggregatorType):
+			ma[message.Key] = InitCollectSet(message.Values, message.Limit)
+			return nil
+		case int64(CollectListAggregatorType):
+			ma[message.Key] = InitCollectList(message.Values, message.Limit)
+			return nil
 		default:
 			errMessage := fmt.Sprintf("Invalid aggregator used, got: %d for value %f", message.Type, message.Value)
 			return errors.New(errMessage)
@@ -85,6 +151,13 @@ func (ma MapAggregator) UpdateOutput(intermediate interface{}, wg *sync.WaitGrou
 
 	for key, aggregator := range intermediateValueCast {
 		if _, ok := ma[key]; ok {
+			if definition, ok := LookupAggregator(ma[key]); ok {
+				// user-defined aggregators are merged with their registered definition
+				if err := definition.Merge(ma[key], aggregator); err != nil {
+					return err
+				}
+				continue
+			}
 			ma[key].UpdateOutput(aggregator, nil)
 		} else {
 			ma[key] = aggregator
@@ -187,6 +260,25 @@ func (ma MapAggregator) AddAvg(key string, value float64) error {
 	return nil
 }
 
+// AddCount is a helper function the user can use to count
+// one more occurrence of the key in the aggregator map
+func (ma MapAggregator) AddCount(key string) error {
+	currentCount, ok := ma[key]
+	if !ok {
+		ma[key] = InitCount(1)
+	} else {
+		// cast intermediate map
+		castCount, ok := currentCount.(*Count)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castCount.Add(1)
+	}
+
+	return nil
+}
+
 // -------------------
 // SUM AGGREGATOR
 // -------------------
@@ -409,6 +501,63 @@ func (a *Avg) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) er
 	return nil
 }
 
+// -------------------
+// COUNT AGGREGATOR
+// -------------------
+
+// Count aggregates values emitted by counting them. The count is
+// kept as an integer so that it is exact for any number of values
+type Count struct {
+	Count int64 `json:",string,omitempty"`
+}
+
+// InitCount initializes a Count value to the given count
+func InitCount(count int64) *Count {
+	return &Count{
+		Count: count,
+	}
+}
+
+// Add updates the count by adding the new count
+func (c *Count) Add(count int64) {
+	c.Count = c.Count + count
+}
+
+// GetCount returns the count as an integer
+func (c *Count) GetCount() int64 {
+	return c.Count
+}
+
+// ToNum converts the Count value to a float
+func (c *Count) ToNum() float64 {
+	return float64(c.Count)
+}
+
+func (c *Count) Type() AggregatorType {
+	return CountAggregatorType
+}
+
+// Reduce aggregates the counts emitted by adding them up. The
+// count is sent in the count field of the message
+func (c *Count) Reduce(message *ReduceMessage) error {
+	c.Add(int64(message.Count))
+	return nil
+}
+
+// UpdateOutput merges the previous Count value by adding the new intermediate value
+func (c *Count) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*Count)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	// update output map values
+	c.Add(intermediateValueCast.Count)
+
+	return nil
+}
+
 // ReduceMessage represent a value emmited
 type ReduceMessage struct {
 	Key      string  `json:"key,omitempty"`
@@ -416,6 +565,22 @@ type ReduceMessage struct {
 	Count    int     `json:"count,omitempty"`
 	Type     int64   `json:"type,omitempty"`
 	EmptyVal bool    `json:"empty,omitempty"`
+	// Mean and M2 hold the partial state of the Variance and StdDev aggregators
+	Mean float64 `json:"mean,omitempty"`
+	M2   float64 `json:"m2,omitempty"`
+	// TypedValue and Order hold the value of aggregators like First, Last
+	// and Any, whose result can be a string, an integer, a float or a bool
+	TypedValue *Value `json:"typedValue,omitempty"`
+	Order      int64  `json:"order,omitempty"`
+	// Values and Limit hold the values of the CollectSet and CollectList aggregators,
+	// the values of a key can be split across messages to fit the size of a message
+	Values []Value `json:"values,omitempty"`
+	Limit  int     `json:"limit,omitempty"`
+	// Sketch holds the encoded sketch of sketch aggregators like HyperLogLog, Quantile and TopK
+	Sketch []byte `json:"sketch,omitempty"`
+	// Custom and State hold the registered name and the encoded partial state of user-defined aggregators
+	Custom string `json:"custom,omitempty"`
+	State  []byte `json:"state,omitempty"`
 }
 
 // AggregatorPair can be used to implemented sort.Interface
@@ -1,6 +1,7 @@
 package aggregators
 
 import (
+	"encoding/json"
 	"sync"
 	"testing"
 
@@ -166,6 +167,9 @@ func Test_MapAggregatorAdd_UnhappyPath(t *testing.T) {
 
 	err = aggregatorMap.AddAvg("same key", 7)
 	assert.EqualError(t, err, "Mixed aggregators used")
+
+	err = aggregatorMap.AddCount("same key")
+	assert.EqualError(t, err, "Mixed aggregators used")
 }
 
 func Test_MapAggregatorUpdate_HappyPath(t *testing.T) {
@@ -204,3 +208,47 @@ func Test_MapAggregatorUpdate_HappyPath(t *testing.T) {
 	assert.Equal(t, MinAggregatorType, aggregatorMap["a min key"].Type())
 	assert.Equal(t, AvgAggregatorType, aggregatorMap["an avg key"].Type())
 }
+
+// this function counts keys and merges the counts of the mappers and reducers
+func Test_CountAggregator_HappyPath(t *testing.T) {
+	aggregatorMap := NewMap()
+	for i := 0; i < 3; i++ {
+		err := aggregatorMap.AddCount("a count key")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, CountAggregatorType, aggregatorMap["a count key"].Type())
+	assert.Equal(t, float64(3), aggregatorMap["a count key"].ToNum())
+
+	// counts bigger than the integers a float64 can represent are exact
+	largeCount := int64(1<<53 + 1)
+	p, err := json.Marshal(ReduceMessage{
+		Key:   "a count key",
+		Type:  int64(CountAggregatorType),
+		Count: int(largeCount),
+	})
+	assert.Nil(t, err)
+
+	var countReduceMessage *ReduceMessage
+	err = json.Unmarshal(p, &countReduceMessage)
+	assert.Nil(t, err)
+
+	reducedMap := NewMap()
+	err = reducedMap.Reduce(countReduceMessage)
+	assert.Nil(t, err)
+	err = reducedMap.Reduce(countReduceMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*largeCount, reducedMap["a count key"].(*Count).GetCount())
+
+	// merge the counts into the output
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err = reducedMap.UpdateOutput(aggregatorMap, &wg)
+	wg.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 2*largeCount+3, reducedMap["a count key"].(*Count).GetCount())
+
+	// the count is encoded as an integer
+	p, err = json.Marshal(reducedMap)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a count key":{"Count":"18014398509481989"}}`, string(p))
+}
@@ -0,0 +1,277 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// -------------------
+// COLLECT SET AND COLLECT LIST AGGREGATORS
+// -------------------
+
+// CollectSet collects the distinct values emitted for a key, like the documents
+// where a word appears in an inverted index. The values are written to the output
+// in order. If the set has a limit only the smallest values are kept, which are the
+// same regardless of how the values are split across mappers and reducers
+type CollectSet struct {
+	Values map[Value]struct{}
+	Limit  int
+}
+
+// InitCollectSet initializes a CollectSet with the given values and limit,
+// a limit of 0 keeps every value
+func InitCollectSet(values []Value, limit int) *CollectSet {
+	c := &CollectSet{
+		Values: make(map[Value]struct{}, len(values)),
+		Limit:  limit,
+	}
+	for _, value := range values {
+		c.Add(value)
+	}
+
+	return c
+}
+
+// Add adds a value to the set
+func (c *CollectSet) Add(value Value) {
+	c.Values[value] = struct{}{}
+
+	// values are trimmed once in a while instead of after every
+	// value to avoid sorting the set for each new value
+	if c.Limit > 0 && len(c.Values) > 2*c.Limit {
+		c.trim()
+	}
+}
+
+// GetValues returns the values of the set in order
+func (c *CollectSet) GetValues() []Value {
+	c.trim()
+
+	values := make([]Value, 0, len(c.Values))
+	for value := range c.Values {
+		values = append(values, value)
+	}
+	sortValues(values)
+
+	return values
+}
+
+// ToNum returns the number of values in the set
+func (c *CollectSet) ToNum() float64 {
+	c.trim()
+	return float64(len(c.Values))
+}
+
+func (c *CollectSet) Type() AggregatorType {
+	return CollectSetAggregatorType
+}
+
+// Reduce adds the values sent in the message to the set
+func (c *CollectSet) Reduce(message *ReduceMessage) error {
+	for _, value := range message.Values {
+		c.Add(value)
+	}
+
+	return nil
+}
+
+// UpdateOutput adds the values of the intermediate set to the set
+func (c *CollectSet) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*CollectSet)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	for value := range intermediateValueCast.Values {
+		c.Add(value)
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the values of the set as a list of native JSON types
+func (c *CollectSet) MarshalJSON() ([]byte, error) {
+	return marshalValues(c.GetValues())
+}
+
+// trim removes the greatest values if the set has more values than its limit
+func (c *CollectSet) trim() {
+	if c.Limit <= 0 || len(c.Values) <= c.Limit {
+		return
+	}
+
+	values := make([]Value, 0, len(c.Values))
+	for value := range c.Values {
+		values = append(values, value)
+	}
+	sortValues(values)
+
+	for _, value := range values[c.Limit:] {
+		delete(c.Values, value)
+	}
+}
+
+// CollectList collects every value emitted for a key, including repeated values.
+// Values from the same mapper keep the order they were emitted in, but the order
+// of values from different mappers depends on the order the reducers receive them.
+// If the list has a limit the values received after the list is full are dropped
+type CollectList struct {
+	Values []Value
+	Limit  int
+}
+
+// InitCollectList initializes a CollectList with the given values and limit,
+// a limit of 0 keeps every value
+func InitCollectList(values []Value, limit int) *CollectList {
+	c := &CollectList{
+		Limit: limit,
+	}
+	c.AddValues(values)
+
+	return c
+}
+
+// Add adds a value to the list if it is not full
+func (c *CollectList) Add(value Value) {
+	if c.Limit > 0 && len(c.Values) >= c.Limit {
+		return
+	}
+
+	c.Values = append(c.Values, value)
+}
+
+// AddValues adds the values to the list until it is full
+func (c *CollectList) AddValues(values []Value) {
+	for _, value := range values {
+		c.Add(value)
+	}
+}
+
+// GetValues returns the values of the list
+func (c *CollectList) GetValues() []Value {
+	return c.Values
+}
+
+// ToNum returns the number of values in the list
+func (c *CollectList) ToNum() float64 {
+	return float64(len(c.Values))
+}
+
+func (c *CollectList) Type() AggregatorType {
+	return CollectListAggregatorType
+}
+
+// Reduce appends the values sent in the message to the list
+func (c *CollectList) Reduce(message *ReduceMessage) error {
+	c.AddValues(message.Values)
+	return nil
+}
+
+// UpdateOutput appends the values of the intermediate list to the list
+func (c *CollectList) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*CollectList)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	c.AddValues(intermediateValueCast.Values)
+
+	return nil
+}
+
+// MarshalJSON encodes the values of the list as a list of native JSON types
+func (c *CollectList) MarshalJSON() ([]byte, error) {
+	return marshalValues(c.Values)
+}
+
+// AddCollectSet is a helper function the user can use to add a value to the set of
+// distinct values of a key in the aggregator map. The value can be a string, an
+// integer, a float or a bool
+func (ma MapAggregator) AddCollectSet(key string, value interface{}) error {
+	return ma.AddCollectSetWithLimit(key, value, 0)
+}
+
+// AddCollectSetWithLimit is like AddCollectSet but keeps at most limit values,
+// the smallest ones. A limit of 0 keeps every value
+func (ma MapAggregator) AddCollectSetWithLimit(key string, value interface{}, limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("Invalid limit %d, limit must not be negative", limit)
+	}
+
+	typedValue, err := NewValue(value)
+	if err != nil {
+		return err
+	}
+
+	currentSet, ok := ma[key]
+	if !ok {
+		ma[key] = InitCollectSet([]Value{typedValue}, limit)
+	} else {
+		// cast intermediate map
+		castSet, ok := currentSet.(*CollectSet)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castSet.Add(typedValue)
+	}
+
+	return nil
+}
+
+// AddCollectList is a helper function the user can use to add a value to the list
+// of values of a key in the aggregator map. The value can be a string, an integer,
+// a float or a bool
+func (ma MapAggregator) AddCollectList(key string, value interface{}) error {
+	return ma.AddCollectListWithLimit(key, value, 0)
+}
+
+// AddCollectListWithLimit is like AddCollectList but keeps at most limit
+// values, a limit of 0 keeps every value
+func (ma MapAggregator) AddCollectListWithLimit(key string, value interface{}, limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("Invalid limit %d, limit must not be negative", limit)
+	}
+
+	typedValue, err := NewValue(value)
+	if err != nil {
+		return err
+	}
+
+	currentList, ok := ma[key]
+	if !ok {
+		ma[key] = InitCollectList([]Value{typedValue}, limit)
+	} else {
+		// cast intermediate map
+		castList, ok := currentList.(*CollectList)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castList.Add(typedValue)
+	}
+
+	return nil
+}
+
+// sortValues sorts typed values in increasing order
+func sortValues(values []Value) {
+	sort.Slice(values, func(i, j int) bool {
+		return CompareValues(values[i], values[j]) < 0
+	})
+}
+
+// marshalValues encodes typed values as a list of native JSON types
+func marshalValues(values []Value) ([]byte, error) {
+	nativeValues := make([]interface{}, len(values))
+	for i, value := range values {
+		nativeValues[i] = value.Interface()
+	}
+
+	return json.Marshal(nativeValues)
+}
@@ -0,0 +1,92 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function builds an inverted index with two mappers
+func Test_Collect_HappyPath(t *testing.T) {
+	mapper1 := NewMap()
+	require.Nil(t, mapper1.AddCollectSet("cat", "doc-2"))
+	require.Nil(t, mapper1.AddCollectSet("cat", "doc-1"))
+	require.Nil(t, mapper1.AddCollectList("dog", "doc-1"))
+	require.Nil(t, mapper1.AddCollectList("dog", "doc-1"))
+
+	mapper2 := NewMap()
+	require.Nil(t, mapper2.AddCollectSet("cat", "doc-1"))
+	require.Nil(t, mapper2.AddCollectSet("cat", "doc-3"))
+	require.Nil(t, mapper2.AddCollectList("dog", "doc-3"))
+
+	// send the values to the reducer
+	reducer := NewMap()
+	for _, mapper := range []MapAggregator{mapper1, mapper2} {
+		for _, message := range []ReduceMessage{
+			{Key: "cat", Type: int64(CollectSetAggregatorType), Values: mapper["cat"].(*CollectSet).GetValues()},
+			{Key: "dog", Type: int64(CollectListAggregatorType), Values: mapper["dog"].(*CollectList).GetValues()},
+		} {
+			p, err := json.Marshal(message)
+			require.Nil(t, err)
+
+			var reduceMessage *ReduceMessage
+			require.Nil(t, json.Unmarshal(p, &reduceMessage))
+			require.Nil(t, reducer.Reduce(reduceMessage))
+		}
+	}
+
+	p, err := json.Marshal(reducer)
+	require.Nil(t, err)
+	assert.Equal(t, `{"cat":["doc-1","doc-2","doc-3"],"dog":["doc-1","doc-1","doc-3"]}`, string(p))
+	assert.Equal(t, float64(3), reducer["cat"].ToNum())
+
+	// merging is the same in the reducer output
+	output := NewMap()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	require.Nil(t, output.UpdateOutput(mapper1, &wg))
+	require.Nil(t, output.UpdateOutput(mapper2, &wg))
+	wg.Wait()
+	p, err = json.Marshal(output)
+	require.Nil(t, err)
+	assert.Equal(t, `{"cat":["doc-1","doc-2","doc-3"],"dog":["doc-1","doc-1","doc-3"]}`, string(p))
+}
+
+// this function checks that sets keep the smallest values and
+// lists the first values when they have a limit
+func Test_Collect_Limit(t *testing.T) {
+	aggregatorMap := NewMap()
+	for _, value := range []int{9, 3, 7, 1, 8, 2, 3} {
+		require.Nil(t, aggregatorMap.AddCollectSetWithLimit("set", value, 3))
+		require.Nil(t, aggregatorMap.AddCollectListWithLimit("list", value, 3))
+	}
+
+	set := aggregatorMap["set"].(*CollectSet)
+	assert.Equal(t, []Value{IntValue(1), IntValue(2), IntValue(3)}, set.GetValues())
+
+	// sets without limit keep every value and the limit sent in
+	// the message applies to the set the reducer creates
+	merged := InitCollectSet([]Value{IntValue(0), IntValue(10)}, 0)
+	require.Nil(t, merged.Reduce(&ReduceMessage{Values: set.GetValues()}))
+	assert.Len(t, merged.GetValues(), 5)
+	reduced := NewMap()
+	require.Nil(t, reduced.Reduce(&ReduceMessage{Key: "set", Type: int64(CollectSetAggregatorType), Values: merged.GetValues(), Limit: 3}))
+	assert.Equal(t, []Value{IntValue(0), IntValue(1), IntValue(2)}, reduced["set"].(*CollectSet).GetValues())
+
+	list := aggregatorMap["list"].(*CollectList)
+	assert.Equal(t, []Value{IntValue(9), IntValue(3), IntValue(7)}, list.GetValues())
+}
+
+func Test_Collect_UnhappyPath(t *testing.T) {
+	aggregatorMap := NewMap()
+
+	err := aggregatorMap.AddCollectSetWithLimit("a key", "a", -1)
+	assert.EqualError(t, err, "Invalid limit -1, limit must not be negative")
+
+	require.Nil(t, aggregatorMap.AddCollectSet("a key", "a"))
+	err = aggregatorMap.AddCollectList("a key", "b")
+	assert.EqualError(t, err, "Mixed aggregators used")
+}
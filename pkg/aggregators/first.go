@@ -0,0 +1,284 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// -------------------
+// FIRST, LAST AND ANY AGGREGATORS
+// -------------------
+
+// First keeps the value emitted with the smallest order, like the earliest
+// timestamp or line number. Values with the same order are broken by keeping
+// the smallest value so that the result doesn't depend on the order the
+// mappers and reducers process the values in
+type First struct {
+	Value Value
+	Order int64
+}
+
+// InitFirst initializes a First value
+func InitFirst(value Value, order int64) *First {
+	return &First{
+		Value: value,
+		Order: order,
+	}
+}
+
+// Add keeps the value if it goes before the current value
+func (f *First) Add(value Value, order int64) {
+	if order < f.Order || (order == f.Order && CompareValues(value, f.Value) < 0) {
+		f.Value = value
+		f.Order = order
+	}
+}
+
+// ToValue returns the first value
+func (f *First) ToValue() Value {
+	return f.Value
+}
+
+// ToNum converts the first value to a float
+func (f *First) ToNum() float64 {
+	return f.Value.ToNum()
+}
+
+func (f *First) Type() AggregatorType {
+	return FirstAggregatorType
+}
+
+// Reduce keeps the value sent in the message if it goes before the current value
+func (f *First) Reduce(message *ReduceMessage) error {
+	if message.TypedValue == nil {
+		return errors.New("Missing typed value")
+	}
+
+	f.Add(*message.TypedValue, message.Order)
+
+	return nil
+}
+
+// UpdateOutput merges the previous First value with the new intermediate value
+func (f *First) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*First)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	f.Add(intermediateValueCast.Value, intermediateValueCast.Order)
+
+	return nil
+}
+
+// MarshalJSON encodes the first value as its native JSON type
+func (f *First) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Value.Interface())
+}
+
+// Last keeps the value emitted with the greatest order, like the latest
+// timestamp or line number. Values with the same order are broken by keeping
+// the greatest value so that the result doesn't depend on the order the
+// mappers and reducers process the values in
+type Last struct {
+	Value Value
+	Order int64
+}
+
+// InitLast initializes a Last value
+func InitLast(value Value, order int64) *Last {
+	return &Last{
+		Value: value,
+		Order: order,
+	}
+}
+
+// Add keeps the value if it goes after the current value
+func (l *Last) Add(value Value, order int64) {
+	if order > l.Order || (order == l.Order && CompareValues(value, l.Value) > 0) {
+		l.Value = value
+		l.Order = order
+	}
+}
+
+// ToValue returns the last value
+func (l *Last) ToValue() Value {
+	return l.Value
+}
+
+// ToNum converts the last value to a float
+func (l *Last) ToNum() float64 {
+	return l.Value.ToNum()
+}
+
+func (l *Last) Type() AggregatorType {
+	return LastAggregatorType
+}
+
+// Reduce keeps the value sent in the message if it goes after the current value
+func (l *Last) Reduce(message *ReduceMessage) error {
+	if message.TypedValue == nil {
+		return errors.New("Missing typed value")
+	}
+
+	l.Add(*message.TypedValue, message.Order)
+
+	return nil
+}
+
+// UpdateOutput merges the previous Last value with the new intermediate value
+func (l *Last) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*Last)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	l.Add(intermediateValueCast.Value, intermediateValueCast.Order)
+
+	return nil
+}
+
+// MarshalJSON encodes the last value as its native JSON type
+func (l *Last) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Value.Interface())
+}
+
+// Any keeps one of the values emitted for a key, like an example line. The
+// smallest value is kept so that the same value is chosen in every run
+type Any struct {
+	Value Value
+}
+
+// InitAny initializes an Any value
+func InitAny(value Value) *Any {
+	return &Any{
+		Value: value,
+	}
+}
+
+// Add keeps the value if it is smaller than the current value
+func (a *Any) Add(value Value) {
+	if CompareValues(value, a.Value) < 0 {
+		a.Value = value
+	}
+}
+
+// ToValue returns the value
+func (a *Any) ToValue() Value {
+	return a.Value
+}
+
+// ToNum converts the value to a float
+func (a *Any) ToNum() float64 {
+	return a.Value.ToNum()
+}
+
+func (a *Any) Type() AggregatorType {
+	return AnyAggregatorType
+}
+
+// Reduce keeps the value sent in the message if it is smaller than the current value
+func (a *Any) Reduce(message *ReduceMessage) error {
+	if message.TypedValue == nil {
+		return errors.New("Missing typed value")
+	}
+
+	a.Add(*message.TypedValue)
+
+	return nil
+}
+
+// UpdateOutput merges the previous Any value with the new intermediate value
+func (a *Any) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*Any)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	a.Add(intermediateValueCast.Value)
+
+	return nil
+}
+
+// MarshalJSON encodes the value as its native JSON type
+func (a *Any) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Value.Interface())
+}
+
+// AddFirst is a helper function the user can use to keep the value with the smallest
+// order of a key in the aggregator map. The value can be a string, an integer, a float
+// or a bool and the order is usually a timestamp or a line number
+func (ma MapAggregator) AddFirst(key string, value interface{}, order int64) error {
+	typedValue, err := NewValue(value)
+	if err != nil {
+		return err
+	}
+
+	currentFirst, ok := ma[key]
+	if !ok {
+		ma[key] = InitFirst(typedValue, order)
+	} else {
+		// cast intermediate map
+		castFirst, ok := currentFirst.(*First)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castFirst.Add(typedValue, order)
+	}
+
+	return nil
+}
+
+// AddLast is a helper function the user can use to keep the value with the greatest
+// order of a key in the aggregator map. The value can be a string, an integer, a float
+// or a bool and the order is usually a timestamp or a line number
+func (ma MapAggregator) AddLast(key string, value interface{}, order int64) error {
+	typedValue, err := NewValue(value)
+	if err != nil {
+		return err
+	}
+
+	currentLast, ok := ma[key]
+	if !ok {
+		ma[key] = InitLast(typedValue, order)
+	} else {
+		// cast intermediate map
+		castLast, ok := currentLast.(*Last)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castLast.Add(typedValue, order)
+	}
+
+	return nil
+}
+
+// AddAny is a helper function the user can use to keep one of the values of a key
+// in the aggregator map. The value can be a string, an integer, a float or a bool
+func (ma MapAggregator) AddAny(key string, value interface{}) error {
+	typedValue, err := NewValue(value)
+	if err != nil {
+		return err
+	}
+
+	currentAny, ok := ma[key]
+	if !ok {
+		ma[key] = InitAny(typedValue)
+	} else {
+		// cast intermediate map
+		castAny, ok := currentAny.(*Any)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castAny.Add(typedValue)
+	}
+
+	return nil
+}
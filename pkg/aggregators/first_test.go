@@ -0,0 +1,94 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function reduces the values of two mappers in both orders
+// and checks that the result is the same
+func Test_FirstLastAny_HappyPath(t *testing.T) {
+	mapper1 := NewMap()
+	require.Nil(t, mapper1.AddFirst("first", "created", 10))
+	require.Nil(t, mapper1.AddLast("last", "created", 10))
+	require.Nil(t, mapper1.AddLast("last", "paid", 20))
+	require.Nil(t, mapper1.AddAny("any", "a line"))
+	require.Nil(t, mapper1.AddLast("big", int64(9007199254740993), 1))
+	require.Nil(t, mapper1.AddFirst("tie", "b", 5))
+
+	mapper2 := NewMap()
+	require.Nil(t, mapper2.AddFirst("first", "paid", 20))
+	require.Nil(t, mapper2.AddLast("last", "shipped", 30))
+	require.Nil(t, mapper2.AddAny("any", "another line"))
+	require.Nil(t, mapper2.AddLast("big", 1.5, 0))
+	require.Nil(t, mapper2.AddFirst("tie", "a", 5))
+
+	messages := []*ReduceMessage{}
+	for _, mapper := range []MapAggregator{mapper1, mapper2} {
+		for key, value := range mapper {
+			message := ReduceMessage{Key: key, Type: int64(value.Type())}
+			switch v := value.(type) {
+			case *First:
+				message.TypedValue = &v.Value
+				message.Order = v.Order
+			case *Last:
+				message.TypedValue = &v.Value
+				message.Order = v.Order
+			case *Any:
+				message.TypedValue = &v.Value
+			}
+
+			p, err := json.Marshal(message)
+			require.Nil(t, err)
+
+			var reduceMessage *ReduceMessage
+			require.Nil(t, json.Unmarshal(p, &reduceMessage))
+			messages = append(messages, reduceMessage)
+		}
+	}
+
+	reducer1 := NewMap()
+	reducer2 := NewMap()
+	for i := range messages {
+		require.Nil(t, reducer1.Reduce(messages[i]))
+		require.Nil(t, reducer2.Reduce(messages[len(messages)-1-i]))
+	}
+
+	expected := `{"any":"a line","big":9007199254740993,"first":"created","last":"shipped","tie":"a"}`
+	for _, reducer := range []MapAggregator{reducer1, reducer2} {
+		p, err := json.Marshal(reducer)
+		require.Nil(t, err)
+		assert.Equal(t, expected, string(p))
+	}
+
+	assert.Equal(t, LastAggregatorType, reducer1["last"].Type())
+	assert.Equal(t, StringValue("shipped"), reducer1["last"].(ValueAggregator).ToValue())
+	assert.Equal(t, float64(9007199254740992), reducer1["big"].ToNum())
+}
+
+func Test_Value_Compare(t *testing.T) {
+	assert.Equal(t, -1, CompareValues(StringValue("a"), StringValue("b")))
+	assert.Equal(t, 1, CompareValues(IntValue(2), IntValue(-9223372036854775808)))
+	assert.Equal(t, 0, CompareValues(FloatValue(1.5), FloatValue(1.5)))
+	assert.Equal(t, -1, CompareValues(BoolValue(false), BoolValue(true)))
+
+	// values of different types are ordered by their type
+	assert.Equal(t, -1, CompareValues(StringValue("z"), IntValue(1)))
+}
+
+func Test_FirstLastAny_UnhappyPath(t *testing.T) {
+	aggregatorMap := NewMap()
+
+	err := aggregatorMap.AddFirst("a key", []string{"a"}, 1)
+	assert.EqualError(t, err, "Unsupported value type []string")
+
+	require.Nil(t, aggregatorMap.AddFirst("a key", "a", 1))
+	err = aggregatorMap.AddLast("a key", "b", 2)
+	assert.EqualError(t, err, "Mixed aggregators used")
+
+	err = aggregatorMap.Reduce(&ReduceMessage{Key: "another key", Type: int64(AnyAggregatorType)})
+	assert.EqualError(t, err, "Missing typed value")
+}
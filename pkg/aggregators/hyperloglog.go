@@ -0,0 +1,173 @@
+package aggregators
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strconv"
+	"sync"
+)
+
+// -------------------
+// HYPERLOGLOG AGGREGATOR
+// -------------------
+
+const (
+	// HyperLogLogPrecision is the number of bits of the hash used to select a register.
+	// With 4096 registers the standard error of the estimate is about 1.6% and a sketch
+	// is small enough to send ten of them in a single SQS batch
+	HyperLogLogPrecision = 12
+	// HyperLogLogRegisters is the number of registers of a sketch
+	HyperLogLogRegisters = 1 << HyperLogLogPrecision
+)
+
+// HyperLogLog estimates the number of distinct values emitted for a key. It keeps
+// a fixed size sketch instead of the values so it can count any number of them.
+// Sketches are merged by keeping the maximum of each register
+type HyperLogLog struct {
+	Registers []byte
+}
+
+// InitHyperLogLog initializes an empty HyperLogLog sketch
+func InitHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{
+		Registers: make([]byte, HyperLogLogRegisters),
+	}
+}
+
+// InitHyperLogLogWithRegisters initializes a HyperLogLog sketch with a copy of the given registers
+func InitHyperLogLogWithRegisters(registers []byte) (*HyperLogLog, error) {
+	if len(registers) != HyperLogLogRegisters {
+		return nil, fmt.Errorf("Invalid HyperLogLog sketch with %d registers", len(registers))
+	}
+
+	h := InitHyperLogLog()
+	copy(h.Registers, registers)
+
+	return h, nil
+}
+
+// Add adds a value to the sketch
+func (h *HyperLogLog) Add(value string) {
+	hash := hashValue(value)
+
+	// the first bits select the register and the position of the
+	// first set bit in the rest of the hash is kept in the register
+	register := hash >> (64 - HyperLogLogPrecision)
+	rank := byte(bits.LeadingZeros64(hash<<HyperLogLogPrecision|1<<(HyperLogLogPrecision-1)) + 1)
+
+	if h.Registers[register] < rank {
+		h.Registers[register] = rank
+	}
+}
+
+// Merge merges the registers of another sketch into the sketch
+func (h *HyperLogLog) Merge(registers []byte) error {
+	if len(registers) != HyperLogLogRegisters {
+		return fmt.Errorf("Invalid HyperLogLog sketch with %d registers", len(registers))
+	}
+
+	for i, rank := range registers {
+		if h.Registers[i] < rank {
+			h.Registers[i] = rank
+		}
+	}
+
+	return nil
+}
+
+// GetRegisters returns the registers of the sketch
+func (h *HyperLogLog) GetRegisters() []byte {
+	return h.Registers
+}
+
+// Estimate returns the estimated number of distinct values added to the sketch
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(HyperLogLogRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.Registers {
+		sum = sum + math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+
+	// use linear counting for small cardinalities
+	if estimate <= 2.5*m && zeros != 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(estimate))
+}
+
+// ToNum returns the estimated number of distinct values as a float
+func (h *HyperLogLog) ToNum() float64 {
+	return float64(h.Estimate())
+}
+
+func (h *HyperLogLog) Type() AggregatorType {
+	return HyperLogLogAggregatorType
+}
+
+// Reduce merges the sketch sent in the message
+func (h *HyperLogLog) Reduce(message *ReduceMessage) error {
+	return h.Merge(message.Sketch)
+}
+
+// UpdateOutput merges the intermediate sketch into the sketch
+func (h *HyperLogLog) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*HyperLogLog)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	return h.Merge(intermediateValueCast.Registers)
+}
+
+// MarshalJSON encodes the estimate of the sketch, like the other aggregators
+// the registers are not part of the output of the job
+func (h *HyperLogLog) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"Distinct":"%s"}`, strconv.FormatUint(h.Estimate(), 10))), nil
+}
+
+// AddDistinct is a helper function the user can use to count
+// the distinct values of a key in the aggregator map
+func (ma MapAggregator) AddDistinct(key string, value string) error {
+	currentDistinct, ok := ma[key]
+	if !ok {
+		h := InitHyperLogLog()
+		h.Add(value)
+		ma[key] = h
+	} else {
+		// cast intermediate map
+		castDistinct, ok := currentDistinct.(*HyperLogLog)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castDistinct.Add(value)
+	}
+
+	return nil
+}
+
+// hashValue returns a 64 bit hash of the value. The FNV hash is the same in every
+// mapper, its bits are mixed so that similar values spread across the registers
+func hashValue(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	hash := hasher.Sum64()
+
+	// finalizer of splitmix64
+	hash = (hash ^ (hash >> 30)) * 0xbf58476d1ce4e5b9
+	hash = (hash ^ (hash >> 27)) * 0x94d049bb133111eb
+	return hash ^ (hash >> 31)
+}
@@ -0,0 +1,70 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function merges the sketches of two mappers with overlapping values
+func Test_HyperLogLog_HappyPath(t *testing.T) {
+	mapper1 := NewMap()
+	for i := 0; i < 60000; i++ {
+		require.Nil(t, mapper1.AddDistinct("a distinct key", fmt.Sprintf("value-%d", i)))
+	}
+
+	mapper2 := NewMap()
+	for i := 40000; i < 100000; i++ {
+		require.Nil(t, mapper2.AddDistinct("a distinct key", fmt.Sprintf("value-%d", i)))
+	}
+
+	// send the sketches to the reducer
+	reducer := NewMap()
+	for _, mapper := range []MapAggregator{mapper1, mapper2} {
+		p, err := json.Marshal(ReduceMessage{
+			Key:    "a distinct key",
+			Type:   int64(HyperLogLogAggregatorType),
+			Sketch: mapper["a distinct key"].(*HyperLogLog).GetRegisters(),
+		})
+		require.Nil(t, err)
+
+		var message *ReduceMessage
+		require.Nil(t, json.Unmarshal(p, &message))
+		require.Nil(t, reducer.Reduce(message))
+	}
+
+	assert.Equal(t, HyperLogLogAggregatorType, reducer["a distinct key"].Type())
+	assert.InEpsilon(t, 100000, reducer["a distinct key"].ToNum(), 0.05)
+
+	// merging the same values again doesn't change the estimate
+	estimate := reducer["a distinct key"].ToNum()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.Nil(t, reducer.UpdateOutput(mapper1, &wg))
+	wg.Wait()
+	assert.Equal(t, estimate, reducer["a distinct key"].ToNum())
+
+	// the output is the estimate
+	p, err := json.Marshal(reducer)
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf(`{"a distinct key":{"Distinct":"%d"}}`, uint64(estimate)), string(p))
+}
+
+// this function counts a few distinct values with repetitions
+func Test_HyperLogLog_SmallCardinality(t *testing.T) {
+	aggregatorMap := NewMap()
+	for i := 0; i < 1000; i++ {
+		require.Nil(t, aggregatorMap.AddDistinct("a distinct key", fmt.Sprintf("value-%d", i%100)))
+	}
+
+	assert.InDelta(t, 100, aggregatorMap["a distinct key"].ToNum(), 2)
+
+	err := aggregatorMap.AddSum("a distinct key", 1)
+	assert.EqualError(t, err, "Mixed aggregators used")
+	err = aggregatorMap.Reduce(&ReduceMessage{Key: "another key", Type: int64(HyperLogLogAggregatorType), Sketch: []byte{1}})
+	assert.EqualError(t, err, "Invalid HyperLogLog sketch with 1 registers")
+}
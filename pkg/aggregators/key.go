@@ -0,0 +1,195 @@
+package aggregators
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// -------------------
+// GROUP-BY KEYS
+// -------------------
+
+const (
+	// GroupKeyTag starts the encoding of every group key, so that user
+	// keys that happen to look like a JSON array are not read as group keys
+	GroupKeyTag = "\x1e"
+	// GroupKeyFieldSeparator separates the columns of a group key from
+	// the name of the aggregate in the keys returned by GroupKey.Field
+	GroupKeyFieldSeparator = "\x1f"
+)
+
+// GroupKey is a key made of several columns, like the (returnflag, linestatus) pair
+// of a group by. The aggregator map is keyed by strings so the key is used through
+// its encoding, which is GroupKeyTag followed by a JSON array of the columns like
+// ["R","F"]. Mappers partition the key by its encoding and the driver writes the
+// columns as separate fields
+type GroupKey []Value
+
+// NewGroupKey returns the key made of the given columns, which can
+// be strings, integers, floats or bools
+func NewGroupKey(columns ...interface{}) (GroupKey, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("A group key needs at least one column")
+	}
+
+	key := make(GroupKey, len(columns))
+	for i, column := range columns {
+		value, err := NewValue(column)
+		if err != nil {
+			return nil, err
+		}
+		key[i] = value
+	}
+
+	return key, nil
+}
+
+// NewStringGroupKey returns the key made of the given string columns
+func NewStringGroupKey(columns ...string) GroupKey {
+	key := make(GroupKey, len(columns))
+	for i, column := range columns {
+		key[i] = StringValue(column)
+	}
+
+	return key
+}
+
+// String encodes the key. Floats are always written with a decimal
+// point or an exponent so that they are decoded back as floats
+func (k GroupKey) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(GroupKeyTag)
+	buf.WriteByte('[')
+	for i, column := range k {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		if column.Type == FloatValueType {
+			float := strconv.FormatFloat(column.Float, 'g', -1, 64)
+			if !strings.ContainsAny(float, ".eE") {
+				float = float + ".0"
+			}
+			buf.WriteString(float)
+			continue
+		}
+
+		// strings, integers and bools can't fail to encode
+		p, _ := json.Marshal(column.Interface())
+		buf.Write(p)
+	}
+	buf.WriteByte(']')
+
+	return buf.String()
+}
+
+// Field encodes the key of one of the aggregates of the group, like
+// sum_qty. The driver writes all the aggregates of a group in a single
+// record that has a field for each of them
+func (k GroupKey) Field(name string) string {
+	return k.String() + GroupKeyFieldSeparator + name
+}
+
+// Columns returns the columns of the key as native go types
+func (k GroupKey) Columns() []interface{} {
+	columns := make([]interface{}, len(k))
+	for i, column := range k {
+		columns[i] = column.Interface()
+	}
+
+	return columns
+}
+
+// ParseGroupKey decodes a key encoded by GroupKey.String. It returns
+// an error if the key is not a group key
+func ParseGroupKey(key string) (GroupKey, error) {
+	groupKey, field, err := ParseGroupKeyField(key)
+	if err != nil {
+		return nil, err
+	}
+	if field != "" {
+		return nil, fmt.Errorf("Invalid group key %q", key)
+	}
+
+	return groupKey, nil
+}
+
+// ParseGroupKeyField decodes a key encoded by GroupKey.String or GroupKey.Field and
+// returns its columns and the name of the aggregate, which is empty for keys encoded
+// by GroupKey.String. It returns an error if the key is not a group key
+func ParseGroupKeyField(key string) (GroupKey, string, error) {
+	invalidKeyErr := fmt.Errorf("Invalid group key %q", key)
+	if !strings.HasPrefix(key, GroupKeyTag+"[") {
+		return nil, "", invalidKeyErr
+	}
+	encoded := strings.TrimPrefix(key, GroupKeyTag)
+
+	decoder := json.NewDecoder(strings.NewReader(encoded))
+	decoder.UseNumber()
+
+	var columns []interface{}
+	if err := decoder.Decode(&columns); err != nil || len(columns) == 0 {
+		return nil, "", invalidKeyErr
+	}
+
+	// the columns can only be followed by the name of the aggregate
+	field := ""
+	if rest := encoded[decoder.InputOffset():]; rest != "" {
+		if !strings.HasPrefix(rest, GroupKeyFieldSeparator) || len(rest) == len(GroupKeyFieldSeparator) {
+			return nil, "", invalidKeyErr
+		}
+		field = strings.TrimPrefix(rest, GroupKeyFieldSeparator)
+	}
+
+	groupKey := make(GroupKey, len(columns))
+	for i, column := range columns {
+		switch c := column.(type) {
+		case string:
+			groupKey[i] = StringValue(c)
+		case bool:
+			groupKey[i] = BoolValue(c)
+		case json.Number:
+			if !strings.ContainsAny(c.String(), ".eE") {
+				integer, err := c.Int64()
+				if err != nil {
+					return nil, "", invalidKeyErr
+				}
+				groupKey[i] = IntValue(integer)
+				continue
+			}
+
+			float, err := c.Float64()
+			if err != nil {
+				return nil, "", invalidKeyErr
+			}
+			groupKey[i] = FloatValue(float)
+		default:
+			return nil, "", invalidKeyErr
+		}
+	}
+
+	return groupKey, field, nil
+}
+
+// CompareGroupKeys returns -1, 0 or 1 if a is smaller, equal or greater than b.
+// Keys are compared column by column and a key is smaller than the keys it prefixes
+func CompareGroupKeys(a GroupKey, b GroupKey) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if cmp := CompareValues(a[i], b[i]); cmp != 0 {
+			return cmp
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,86 @@
+package aggregators
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GroupKey_HappyPath(t *testing.T) {
+	key, err := NewGroupKey("R", "F", int64(1998), 2.0, true)
+	require.Nil(t, err)
+	assert.Equal(t, GroupKeyTag+`["R","F",1998,2.0,true]`, key.String())
+
+	// the key is decoded back with the same column types
+	parsed, err := ParseGroupKey(key.String())
+	require.Nil(t, err)
+	assert.Equal(t, key, parsed)
+	assert.Equal(t, []interface{}{"R", "F", int64(1998), 2.0, true}, parsed.Columns())
+
+	// columns with separators don't make keys ambiguous
+	assert.NotEqual(t, NewStringGroupKey("a-b", "c").String(), NewStringGroupKey("a", "b-c").String())
+	assert.Equal(t, GroupKeyTag+`["a\"b","c"]`, NewStringGroupKey(`a"b`, "c").String())
+	parsed, err = ParseGroupKey(GroupKeyTag + `["a\"b","c"]`)
+	require.Nil(t, err)
+	assert.Equal(t, NewStringGroupKey(`a"b`, "c"), parsed)
+}
+
+func Test_GroupKey_Field(t *testing.T) {
+	key := NewStringGroupKey("R", "F")
+	assert.Equal(t, GroupKeyTag+`["R","F"]`+GroupKeyFieldSeparator+"sum_qty", key.Field("sum_qty"))
+
+	parsed, field, err := ParseGroupKeyField(key.Field("sum_qty"))
+	require.Nil(t, err)
+	assert.Equal(t, key, parsed)
+	assert.Equal(t, "sum_qty", field)
+
+	// keys without an aggregate have an empty field
+	parsed, field, err = ParseGroupKeyField(key.String())
+	require.Nil(t, err)
+	assert.Equal(t, key, parsed)
+	assert.Equal(t, "", field)
+
+	// the key of an aggregate is not the key of the group
+	_, err = ParseGroupKey(key.Field("sum_qty"))
+	assert.Error(t, err)
+}
+
+func Test_GroupKey_Compare(t *testing.T) {
+	assert.Equal(t, -1, CompareGroupKeys(NewStringGroupKey("A", "F"), NewStringGroupKey("N", "F")))
+	assert.Equal(t, 1, CompareGroupKeys(NewStringGroupKey("N", "O"), NewStringGroupKey("N", "F")))
+	assert.Equal(t, 0, CompareGroupKeys(NewStringGroupKey("N", "O"), NewStringGroupKey("N", "O")))
+
+	// a key is smaller than the keys it prefixes
+	assert.Equal(t, -1, CompareGroupKeys(NewStringGroupKey("N"), NewStringGroupKey("N", "F")))
+
+	// integers are compared as numbers
+	small, err := NewGroupKey(9)
+	require.Nil(t, err)
+	big, err := NewGroupKey(10)
+	require.Nil(t, err)
+	assert.Equal(t, -1, CompareGroupKeys(small, big))
+}
+
+func Test_GroupKey_UnhappyPath(t *testing.T) {
+	_, err := NewGroupKey()
+	assert.EqualError(t, err, "A group key needs at least one column")
+
+	_, err = NewGroupKey("a", []string{"b"})
+	assert.EqualError(t, err, "Unsupported value type []string")
+
+	for _, key := range []string{"a key", "[]", `["a"] ["b"]`, `[{"a":1}]`, "[1"} {
+		_, err = ParseGroupKey(GroupKeyTag + key)
+		assert.Error(t, err, key)
+	}
+
+	// user keys that look like a JSON array are not group keys
+	for _, key := range []string{`["a","b"]`, `[1]`} {
+		_, _, err = ParseGroupKeyField(key)
+		assert.EqualError(t, err, fmt.Sprintf("Invalid group key %q", key))
+	}
+
+	_, _, err = ParseGroupKeyField(GroupKeyTag + `["a"]` + GroupKeyFieldSeparator)
+	assert.Error(t, err)
+}
@@ -0,0 +1,392 @@
+package aggregators
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// -------------------
+// QUANTILE AGGREGATOR
+// -------------------
+
+const (
+	// QuantileRelativeAccuracy is the relative error of the quantiles estimated by the sketch
+	QuantileRelativeAccuracy = 0.01
+	// QuantileMaxBins is the maximum number of bins kept for positive and for negative
+	// values. With 1% accuracy it covers values across about nine orders of magnitude,
+	// when more are needed the bins of the smallest values are collapsed together
+	QuantileMaxBins = 1024
+)
+
+// DefaultQuantiles are the quantiles written to the output of a key
+// when the quantiles are not given to AddQuantile
+var DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+var (
+	quantileGamma    = (1 + QuantileRelativeAccuracy) / (1 - QuantileRelativeAccuracy)
+	quantileLogGamma = math.Log(quantileGamma)
+)
+
+// Quantile estimates quantiles of the values emitted for a key, like the
+// median or the 99th percentile. It keeps a DDSketch where values are counted
+// in bins with logarithmic boundaries so that the estimates have a relative
+// error of 1%. Sketches are merged by adding the counts of their bins
+type Quantile struct {
+	Quantiles []float64
+	Positive  map[int]uint64
+	Negative  map[int]uint64
+	Zeros     uint64
+	Count     uint64
+	// smallest indexes of the bins, used to collapse them once they are full
+	positiveMin binsMinimum
+	negativeMin binsMinimum
+}
+
+// binsMinimum is the smallest index of a set of bins. It is found when the bins
+// are full and then kept as bins are added so that they are collapsed without
+// sorting them. The smallest index only grows while the bins are full
+type binsMinimum struct {
+	index int
+	valid bool
+}
+
+// InitQuantile initializes an empty Quantile sketch that outputs the given
+// quantiles, or the default quantiles if none are given
+func InitQuantile(quantiles ...float64) *Quantile {
+	return &Quantile{
+		Quantiles: quantiles,
+		Positive:  make(map[int]uint64),
+		Negative:  make(map[int]uint64),
+	}
+}
+
+// Add adds a value to the sketch
+func (q *Quantile) Add(value float64) {
+	switch {
+	case value > 0:
+		addToBins(q.Positive, &q.positiveMin, quantileIndex(value), 1)
+	case value < 0:
+		addToBins(q.Negative, &q.negativeMin, quantileIndex(-value), 1)
+	default:
+		q.Zeros++
+	}
+	q.Count++
+}
+
+// Merge adds the counts of another sketch to the sketch. The quantiles
+// of the other sketch are used if the sketch doesn't have any
+func (q *Quantile) Merge(other *Quantile) {
+	for index, count := range other.Positive {
+		addToBins(q.Positive, &q.positiveMin, index, count)
+	}
+	for index, count := range other.Negative {
+		addToBins(q.Negative, &q.negativeMin, index, count)
+	}
+	q.Zeros = q.Zeros + other.Zeros
+	q.Count = q.Count + other.Count
+
+	if len(q.Quantiles) == 0 {
+		q.Quantiles = other.Quantiles
+	}
+}
+
+// GetQuantiles returns the quantiles written to the output
+func (q *Quantile) GetQuantiles() []float64 {
+	if len(q.Quantiles) == 0 {
+		return DefaultQuantiles
+	}
+
+	return q.Quantiles
+}
+
+// GetQuantile returns the estimated value at the given quantile, a number between 0 and 1
+func (q *Quantile) GetQuantile(quantile float64) float64 {
+	if q.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(quantile * float64(q.Count-1))
+
+	// negative values go from the largest to the smallest index
+	negativeIndexes := sortedIndexes(q.Negative)
+	seen := uint64(0)
+	for i := len(negativeIndexes) - 1; i >= 0; i-- {
+		seen = seen + q.Negative[negativeIndexes[i]]
+		if seen > rank {
+			return -quantileValue(negativeIndexes[i])
+		}
+	}
+
+	seen = seen + q.Zeros
+	if seen > rank {
+		return 0
+	}
+
+	positiveIndexes := sortedIndexes(q.Positive)
+	for _, index := range positiveIndexes {
+		seen = seen + q.Positive[index]
+		if seen > rank {
+			return quantileValue(index)
+		}
+	}
+
+	return 0
+}
+
+// ToNum returns the estimated value at the first quantile of the output,
+// the median when the default quantiles are used
+func (q *Quantile) ToNum() float64 {
+	return q.GetQuantile(q.GetQuantiles()[0])
+}
+
+func (q *Quantile) Type() AggregatorType {
+	return QuantileAggregatorType
+}
+
+// Reduce merges the sketch sent in the message
+func (q *Quantile) Reduce(message *ReduceMessage) error {
+	other, err := DecodeQuantile(message.Sketch)
+	if err != nil {
+		return err
+	}
+
+	q.Merge(other)
+
+	return nil
+}
+
+// UpdateOutput merges the intermediate sketch into the sketch
+func (q *Quantile) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*Quantile)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	q.Merge(intermediateValueCast)
+
+	return nil
+}
+
+// Encode encodes the sketch so that it can be sent in a ReduceMessage. The
+// quantiles are followed by the zero count and the bins of positive and
+// negative values, where each index is encoded as the difference with the last one
+func (q *Quantile) Encode() []byte {
+	var buf bytes.Buffer
+	scratch := make([]byte, binary.MaxVarintLen64)
+
+	buf.Write(scratch[:binary.PutUvarint(scratch, uint64(len(q.Quantiles)))])
+	for _, quantile := range q.Quantiles {
+		binary.LittleEndian.PutUint64(scratch, math.Float64bits(quantile))
+		buf.Write(scratch[:8])
+	}
+	buf.Write(scratch[:binary.PutUvarint(scratch, q.Zeros)])
+
+	for _, bins := range []map[int]uint64{q.Positive, q.Negative} {
+		buf.Write(scratch[:binary.PutUvarint(scratch, uint64(len(bins)))])
+		lastIndex := 0
+		for _, index := range sortedIndexes(bins) {
+			buf.Write(scratch[:binary.PutVarint(scratch, int64(index-lastIndex))])
+			buf.Write(scratch[:binary.PutUvarint(scratch, bins[index])])
+			lastIndex = index
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeQuantile decodes a sketch encoded with Encode
+func DecodeQuantile(sketch []byte) (*Quantile, error) {
+	invalidErr := errors.New("Invalid Quantile sketch")
+	reader := bytes.NewReader(sketch)
+
+	numQuantiles, err := binary.ReadUvarint(reader)
+	if err != nil || numQuantiles > uint64(reader.Len()/8) {
+		return nil, invalidErr
+	}
+	q := InitQuantile()
+	for i := uint64(0); i < numQuantiles; i++ {
+		var bits uint64
+		if err := binary.Read(reader, binary.LittleEndian, &bits); err != nil {
+			return nil, invalidErr
+		}
+		q.Quantiles = append(q.Quantiles, math.Float64frombits(bits))
+	}
+
+	q.Zeros, err = binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, invalidErr
+	}
+	q.Count = q.Zeros
+
+	for _, bins := range []map[int]uint64{q.Positive, q.Negative} {
+		numBins, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, invalidErr
+		}
+
+		index := int64(0)
+		for i := uint64(0); i < numBins; i++ {
+			delta, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, invalidErr
+			}
+			count, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return nil, invalidErr
+			}
+
+			index = index + delta
+			bins[int(index)] = count
+			q.Count = q.Count + count
+		}
+	}
+
+	if reader.Len() != 0 {
+		return nil, invalidErr
+	}
+
+	return q, nil
+}
+
+// MarshalJSON encodes the estimated value of each quantile, like {"p50":"12.1","p99":"80.4"}.
+// Like the other aggregators the bins are not part of the output of the job
+func (q *Quantile) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString("{")
+	for i, quantile := range q.GetQuantiles() {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(
+			&buf,
+			`"%s":"%s"`,
+			quantileName(quantile),
+			strconv.FormatFloat(q.GetQuantile(quantile), 'f', -1, 64),
+		)
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+// AddQuantile is a helper function the user can use to add a value to the quantiles
+// of a key in the aggregator map. The quantiles written to the output, numbers between
+// 0 and 1 like 0.5 and 0.99, can be given for each key, otherwise DefaultQuantiles are used
+func (ma MapAggregator) AddQuantile(key string, value float64, quantiles ...float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("Invalid quantile value %f", value)
+	}
+	for _, quantile := range quantiles {
+		if !(quantile >= 0 && quantile <= 1) {
+			return fmt.Errorf("Invalid quantile %f, quantiles must be between 0 and 1", quantile)
+		}
+	}
+
+	currentQuantile, ok := ma[key]
+	if !ok {
+		q := InitQuantile(quantiles...)
+		q.Add(value)
+		ma[key] = q
+	} else {
+		// cast intermediate map
+		castQuantile, ok := currentQuantile.(*Quantile)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		if len(quantiles) > 0 {
+			castQuantile.Quantiles = quantiles
+		}
+		castQuantile.Add(value)
+	}
+
+	return nil
+}
+
+// quantileIndex returns the index of the bin of a positive value
+func quantileIndex(value float64) int {
+	return int(math.Ceil(math.Log(value) / quantileLogGamma))
+}
+
+// quantileValue returns the value that represents a bin, which is
+// within the relative accuracy of every value in the bin
+func quantileValue(index int) float64 {
+	return 2 * math.Pow(quantileGamma, float64(index)) / (quantileGamma + 1)
+}
+
+// quantileName returns the name of a quantile in the output, like p99 for 0.99
+func quantileName(quantile float64) string {
+	percentile := math.Round(quantile*100*1e6) / 1e6
+	return "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+}
+
+// addToBins adds the count to the bin with the index. If there are too many bins
+// the bins with the smallest indexes are collapsed into a single bin
+func addToBins(bins map[int]uint64, minimum *binsMinimum, index int, count uint64) {
+	if _, ok := bins[index]; ok || len(bins) < QuantileMaxBins {
+		bins[index] = bins[index] + count
+		if minimum.valid && index < minimum.index {
+			minimum.index = index
+		}
+		return
+	}
+
+	if !minimum.valid {
+		minimum.index = smallestIndex(bins)
+		minimum.valid = true
+	}
+
+	smallest := minimum.index
+	if index < smallest {
+		bins[smallest] = bins[smallest] + count
+		return
+	}
+
+	// collapse the smallest bin into the next one to make space
+	next := smallest + 1
+	for {
+		if _, ok := bins[next]; ok {
+			break
+		}
+		next++
+	}
+	bins[next] = bins[next] + bins[smallest]
+	delete(bins, smallest)
+	bins[index] = bins[index] + count
+
+	minimum.index = next
+	if index < next {
+		minimum.index = index
+	}
+}
+
+// smallestIndex returns the smallest index of the bins
+func smallestIndex(bins map[int]uint64) int {
+	smallest, first := 0, true
+	for index := range bins {
+		if first || index < smallest {
+			smallest, first = index, false
+		}
+	}
+
+	return smallest
+}
+
+// sortedIndexes returns the indexes of the bins in increasing order
+func sortedIndexes(bins map[int]uint64) []int {
+	indexes := make([]int, 0, len(bins))
+	for index := range bins {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	return indexes
+}
@@ -0,0 +1,140 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function merges the sketches of two mappers that got every other value
+func Test_Quantile_HappyPath(t *testing.T) {
+	mapper1 := NewMap()
+	mapper2 := NewMap()
+	for i := 1; i <= 10000; i++ {
+		mapper := mapper1
+		if i%2 == 0 {
+			mapper = mapper2
+		}
+		require.Nil(t, mapper.AddQuantile("latency", float64(i)))
+	}
+
+	// send the sketches to the reducer
+	reducer := NewMap()
+	for _, mapper := range []MapAggregator{mapper1, mapper2} {
+		p, err := json.Marshal(ReduceMessage{
+			Key:    "latency",
+			Type:   int64(QuantileAggregatorType),
+			Sketch: mapper["latency"].(*Quantile).Encode(),
+		})
+		require.Nil(t, err)
+
+		var message *ReduceMessage
+		require.Nil(t, json.Unmarshal(p, &message))
+		require.Nil(t, reducer.Reduce(message))
+	}
+
+	quantile := reducer["latency"].(*Quantile)
+	assert.Equal(t, QuantileAggregatorType, quantile.Type())
+	assert.Equal(t, uint64(10000), quantile.Count)
+	assert.InEpsilon(t, 5000, quantile.GetQuantile(0.5), QuantileRelativeAccuracy)
+	assert.InEpsilon(t, 9000, quantile.GetQuantile(0.9), QuantileRelativeAccuracy)
+	assert.InEpsilon(t, 9900, quantile.GetQuantile(0.99), QuantileRelativeAccuracy)
+	assert.InEpsilon(t, 1, quantile.GetQuantile(0), QuantileRelativeAccuracy)
+	assert.InEpsilon(t, 10000, quantile.GetQuantile(1), QuantileRelativeAccuracy)
+	assert.Equal(t, quantile.GetQuantile(0.5), quantile.ToNum())
+
+	// merging is the same in the reducer output
+	output := NewMap()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	require.Nil(t, output.UpdateOutput(mapper1, &wg))
+	require.Nil(t, output.UpdateOutput(mapper2, &wg))
+	wg.Wait()
+	assert.Equal(t, quantile.Positive, output["latency"].(*Quantile).Positive)
+
+	// the output has the default quantiles
+	var outputValues map[string]string
+	p, err := json.Marshal(quantile)
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(p, &outputValues))
+	assert.Len(t, outputValues, 3)
+	assert.Contains(t, outputValues, "p50")
+	assert.Contains(t, outputValues, "p90")
+	assert.Contains(t, outputValues, "p99")
+}
+
+// this function sets the quantiles of a key and adds negative and zero values
+func Test_Quantile_CustomQuantiles(t *testing.T) {
+	aggregatorMap := NewMap()
+	for i := -50; i < 50; i++ {
+		require.Nil(t, aggregatorMap.AddQuantile("a key", float64(i), 0.25, 0.75, 0.999))
+	}
+
+	// quantiles survive the encoding
+	decoded, err := DecodeQuantile(aggregatorMap["a key"].(*Quantile).Encode())
+	require.Nil(t, err)
+	assert.Equal(t, []float64{0.25, 0.75, 0.999}, decoded.GetQuantiles())
+	assert.Equal(t, uint64(100), decoded.Count)
+	assert.Equal(t, uint64(1), decoded.Zeros)
+
+	assert.InEpsilon(t, -26, decoded.GetQuantile(0.25), QuantileRelativeAccuracy)
+	assert.InEpsilon(t, -1, decoded.GetQuantile(0.5), QuantileRelativeAccuracy)
+	assert.InEpsilon(t, 24, decoded.GetQuantile(0.75), QuantileRelativeAccuracy)
+
+	p, err := json.Marshal(decoded)
+	require.Nil(t, err)
+	var outputValues map[string]string
+	require.Nil(t, json.Unmarshal(p, &outputValues))
+	assert.Contains(t, outputValues, "p25")
+	assert.Contains(t, outputValues, "p75")
+	assert.Contains(t, outputValues, "p99.9")
+}
+
+func Test_Quantile_UnhappyPath(t *testing.T) {
+	aggregatorMap := NewMap()
+
+	err := aggregatorMap.AddQuantile("a key", 1, 1.5)
+	assert.EqualError(t, err, "Invalid quantile 1.500000, quantiles must be between 0 and 1")
+
+	require.Nil(t, aggregatorMap.AddQuantile("a key", 1))
+	err = aggregatorMap.AddSum("a key", 1)
+	assert.EqualError(t, err, "Mixed aggregators used")
+
+	err = aggregatorMap.Reduce(&ReduceMessage{Key: "another key", Type: int64(QuantileAggregatorType), Sketch: []byte{1}})
+	assert.EqualError(t, err, "Invalid Quantile sketch")
+}
+
+// this function adds values in more bins than the sketch keeps,
+// the bins of the smallest values are collapsed together
+func Test_Quantile_CollapseBins(t *testing.T) {
+	numBins := 3 * QuantileMaxBins
+	quantile := InitQuantile()
+	for i := numBins - 1; i >= 0; i-- {
+		quantile.Add(quantileValue(2 * i))
+	}
+	// a value smaller than every bin is added to the smallest bin
+	quantile.Add(quantileValue(-10))
+
+	require.Len(t, quantile.Positive, QuantileMaxBins)
+	total := uint64(0)
+	for _, count := range quantile.Positive {
+		total = total + count
+	}
+	assert.Equal(t, quantile.Count, total)
+	assert.Equal(t, uint64(numBins-QuantileMaxBins+2), quantile.Positive[smallestIndex(quantile.Positive)])
+
+	// the largest values keep their accuracy
+	assert.InEpsilon(t, quantileValue(2*(numBins-1)), quantile.GetQuantile(1), QuantileRelativeAccuracy)
+
+	// merged sketches keep the same number of bins
+	other := InitQuantile()
+	for i := 0; i < numBins; i++ {
+		other.Add(quantileValue(2*i + 1))
+	}
+	quantile.Merge(other)
+	assert.Len(t, quantile.Positive, QuantileMaxBins)
+	assert.InEpsilon(t, quantileValue(2*numBins-1), quantile.GetQuantile(1), QuantileRelativeAccuracy)
+}
@@ -0,0 +1,116 @@
+package aggregators
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// -------------------
+// USER-DEFINED AGGREGATORS
+// -------------------
+
+// AggregatorDefinition defines how the partial state of a user-defined aggregator
+// is sent from the mappers to the reducers and how partial states are merged
+type AggregatorDefinition struct {
+	// Name identifies the aggregator in the messages and checkpoints of the job
+	Name string
+	// Encode encodes the partial state of the aggregator
+	Encode func(value Aggregator) ([]byte, error)
+	// Decode decodes a partial state encoded by Encode
+	Decode func(state []byte) (Aggregator, error)
+	// Merge merges the partial state of other into value, which
+	// is the aggregator the reducer keeps for the key
+	Merge func(value Aggregator, other Aggregator) error
+}
+
+// registry holds the user-defined aggregators by name and by type
+var registry = struct {
+	sync.RWMutex
+	byName map[string]*AggregatorDefinition
+	byType map[reflect.Type]*AggregatorDefinition
+}{
+	byName: make(map[string]*AggregatorDefinition),
+	byType: make(map[reflect.Type]*AggregatorDefinition),
+}
+
+// RegisterAggregator registers a user-defined aggregator so that it can be used in the
+// aggregator map of a job. The prototype is a value of the aggregator, like &MyAggregator{},
+// and its Type method should return CustomAggregatorType. Reducers use the definition
+// instead of the Reduce and UpdateOutput methods of the aggregator. Aggregators must be
+// registered in an init function of the package of the mapper so that they are registered
+// in the mappers and reducers of the job
+func RegisterAggregator(prototype Aggregator, definition AggregatorDefinition) error {
+	if prototype == nil {
+		return errors.New("Missing aggregator prototype")
+	}
+	if definition.Name == "" {
+		return errors.New("Missing aggregator name")
+	}
+	if definition.Encode == nil || definition.Decode == nil || definition.Merge == nil {
+		return fmt.Errorf("Aggregator %s needs an encoder, a decoder and a merge function", definition.Name)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.byName[definition.Name]; ok {
+		return fmt.Errorf("Aggregator %s is already registered", definition.Name)
+	}
+
+	aggregatorType := reflect.TypeOf(prototype)
+	if registered, ok := registry.byType[aggregatorType]; ok {
+		return fmt.Errorf("Aggregator type %s is already registered as %s", aggregatorType, registered.Name)
+	}
+
+	registry.byName[definition.Name] = &definition
+	registry.byType[aggregatorType] = &definition
+
+	return nil
+}
+
+// LookupAggregator returns the definition of a user-defined aggregator
+// or false if the type of the value has not been registered
+func LookupAggregator(value Aggregator) (*AggregatorDefinition, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	definition, ok := registry.byType[reflect.TypeOf(value)]
+	return definition, ok
+}
+
+// lookupAggregatorByName returns the definition of a user-defined aggregator
+// or false if no aggregator has been registered with the name
+func lookupAggregatorByName(name string) (*AggregatorDefinition, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	definition, ok := registry.byName[name]
+	return definition, ok
+}
+
+// reduceCustom reduces a message with the partial state of a user-defined aggregator
+func (ma MapAggregator) reduceCustom(message *ReduceMessage) error {
+	definition, ok := lookupAggregatorByName(message.Custom)
+	if !ok {
+		return fmt.Errorf("Aggregator %s is not registered", message.Custom)
+	}
+
+	value, err := definition.Decode(message.State)
+	if err != nil {
+		return err
+	}
+
+	current, ok := ma[message.Key]
+	if !ok {
+		ma[message.Key] = value
+		return nil
+	}
+
+	if currentDefinition, ok := LookupAggregator(current); !ok || currentDefinition != definition {
+		return errors.New("Mixed aggregators used")
+	}
+
+	return definition.Merge(current, value)
+}
@@ -0,0 +1,141 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Range is a user-defined aggregator that keeps the smallest and greatest values
+type Range struct {
+	Min float64
+	Max float64
+}
+
+func (r *Range) Add(value float64) {
+	if value < r.Min {
+		r.Min = value
+	}
+	if value > r.Max {
+		r.Max = value
+	}
+}
+
+func (r *Range) Reduce(message *ReduceMessage) error {
+	return errors.New("Range is reduced with its definition")
+}
+
+func (r *Range) UpdateOutput(intermediate interface{}, wg *sync.WaitGroup) error {
+	return errors.New("Range is merged with its definition")
+}
+
+func (r *Range) ToNum() float64 {
+	return r.Max - r.Min
+}
+
+func (r *Range) Type() AggregatorType {
+	return CustomAggregatorType
+}
+
+var rangeDefinition = AggregatorDefinition{
+	Name: "range",
+	Encode: func(value Aggregator) ([]byte, error) {
+		return json.Marshal(value)
+	},
+	Decode: func(state []byte) (Aggregator, error) {
+		r := &Range{}
+		if err := json.Unmarshal(state, r); err != nil {
+			return nil, err
+		}
+		return r, nil
+	},
+	Merge: func(value Aggregator, other Aggregator) error {
+		r, ok := value.(*Range)
+		if !ok {
+			return errors.New("Error merging range")
+		}
+		otherRange, ok := other.(*Range)
+		if !ok {
+			return errors.New("Error merging range")
+		}
+
+		r.Add(otherRange.Min)
+		r.Add(otherRange.Max)
+		return nil
+	},
+}
+
+// this function sends the ranges of two mappers to a reducer and merges
+// the ranges of two reducers in the output
+func Test_Registry_HappyPath(t *testing.T) {
+	require.Nil(t, RegisterAggregator(&Range{}, rangeDefinition))
+
+	definition, ok := LookupAggregator(&Range{})
+	require.True(t, ok)
+	assert.Equal(t, "range", definition.Name)
+	_, ok = LookupAggregator(InitSum(0))
+	assert.False(t, ok)
+
+	mapper1 := NewMap()
+	mapper1["temperature"] = &Range{Min: 3, Max: 21}
+	mapper2 := NewMap()
+	mapper2["temperature"] = &Range{Min: -4, Max: 15}
+
+	// send the partial states to the reducer
+	reducer := NewMap()
+	for _, mapper := range []MapAggregator{mapper1, mapper2} {
+		state, err := definition.Encode(mapper["temperature"])
+		require.Nil(t, err)
+
+		p, err := json.Marshal(ReduceMessage{
+			Key:    "temperature",
+			Type:   int64(CustomAggregatorType),
+			Custom: definition.Name,
+			State:  state,
+		})
+		require.Nil(t, err)
+
+		var reduceMessage *ReduceMessage
+		require.Nil(t, json.Unmarshal(p, &reduceMessage))
+		require.Nil(t, reducer.Reduce(reduceMessage))
+	}
+	assert.Equal(t, &Range{Min: -4, Max: 21}, reducer["temperature"])
+
+	// merging is the same in the reducer output
+	output := NewMap()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	require.Nil(t, output.UpdateOutput(mapper1, &wg))
+	require.Nil(t, output.UpdateOutput(mapper2, &wg))
+	wg.Wait()
+	assert.Equal(t, &Range{Min: -4, Max: 21}, output["temperature"])
+	assert.Equal(t, float64(25), output["temperature"].ToNum())
+}
+
+func Test_Registry_UnhappyPath(t *testing.T) {
+	err := RegisterAggregator(&Range{}, AggregatorDefinition{})
+	assert.EqualError(t, err, "Missing aggregator name")
+
+	err = RegisterAggregator(&Range{}, AggregatorDefinition{Name: "incomplete"})
+	assert.EqualError(t, err, "Aggregator incomplete needs an encoder, a decoder and a merge function")
+
+	definition := rangeDefinition
+	definition.Name = "another range"
+	require.Nil(t, RegisterAggregator(&struct{ *Range }{}, definition))
+	err = RegisterAggregator(&struct{ *Range }{}, rangeDefinition)
+	assert.Error(t, err)
+	err = RegisterAggregator(&Range{}, definition)
+	assert.EqualError(t, err, "Aggregator another range is already registered")
+
+	reducer := NewMap()
+	err = reducer.Reduce(&ReduceMessage{Key: "a key", Type: int64(CustomAggregatorType), Custom: "unknown"})
+	assert.EqualError(t, err, "Aggregator unknown is not registered")
+
+	require.Nil(t, reducer.AddSum("a key", 1))
+	err = reducer.Reduce(&ReduceMessage{Key: "a key", Type: int64(CustomAggregatorType), Custom: "another range", State: []byte("{}")})
+	assert.EqualError(t, err, "Mixed aggregators used")
+}
@@ -0,0 +1,409 @@
+package aggregators
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// -------------------
+// TOP-K AGGREGATOR
+// -------------------
+
+const (
+	// DefaultTopKCapacityFactor is the number of counters kept for each of the K items
+	// when the capacity is not given. A count is overestimated by at most the number of
+	// items counted divided by the number of counters, and the errors of the sketches of
+	// every mapper add up when they are merged, so many more counters than K are kept
+	DefaultTopKCapacityFactor = 10
+	// MaxTopKItemSize is the largest size in bytes of an item counted by a sketch
+	MaxTopKItemSize = 128
+	// MaxTopKSketchSize is the largest size in bytes of an encoded sketch. Sketches can't
+	// be split across messages, so its base64 encoding takes 240 KiB of a message of the
+	// reduce queues and leaves the rest for the key and the other fields of the message
+	MaxTopKSketchSize = 180 * 1024
+	// MaxTopKCapacity is the largest number of counters of a sketch, a sketch with every
+	// counter used by an item of MaxTopKItemSize bytes is at most MaxTopKSketchSize bytes.
+	// Encoded sketches with larger values are rejected as they can't have been encoded
+	MaxTopKCapacity = (MaxTopKSketchSize - 3*binary.MaxVarintLen64) / (MaxTopKItemSize + 3*binary.MaxVarintLen64)
+)
+
+// TopK keeps the K most frequent items emitted for a key using the Space-Saving
+// algorithm. A fixed number of counters is kept, when a new item arrives and there
+// are no free counters it replaces the item with the smallest count and inherits
+// its count as error, so counts can be overestimated but frequent items are not lost
+type TopK struct {
+	K int
+	// Capacity is the number of counters kept, if 0 it is K times DefaultTopKCapacityFactor
+	Capacity int
+	Counters map[string]*TopKCounter
+	// counters ordered by count to find the one to replace, it
+	// is built from the counters when it doesn't have all of them
+	heap topKHeap
+}
+
+// TopKCounter is the count of an item and the maximum overestimation of the count
+type TopKCounter struct {
+	Count uint64
+	Error uint64
+	// item of the counter and its position in the heap
+	item  string
+	index int
+}
+
+// TopKItem is an item of the ranked list written to the output
+type TopKItem struct {
+	Item  string `json:"item"`
+	Count uint64 `json:"count,string"`
+}
+
+// InitTopK initializes an empty TopK sketch that keeps the k most frequent
+// items with the default capacity
+func InitTopK(k int) *TopK {
+	return InitTopKWithCapacity(k, defaultTopKCapacity(k))
+}
+
+// InitTopKWithCapacity initializes an empty TopK sketch that keeps the k most
+// frequent items using the given number of counters
+func InitTopKWithCapacity(k int, capacity int) *TopK {
+	return &TopK{
+		K:        k,
+		Capacity: capacity,
+		Counters: make(map[string]*TopKCounter),
+	}
+}
+
+// Add counts the item the given number of times
+func (t *TopK) Add(item string, count uint64) {
+	counters := t.counterHeap()
+
+	if counter, ok := t.Counters[item]; ok {
+		counter.Count = counter.Count + count
+		heap.Fix(counters, counter.index)
+		return
+	}
+
+	if len(t.Counters) < t.capacity() {
+		counter := &TopKCounter{Count: count, item: item}
+		t.Counters[item] = counter
+		heap.Push(counters, counter)
+		return
+	}
+
+	// replace the item with the smallest count
+	minCounter := (*counters)[0]
+	delete(t.Counters, minCounter.item)
+	counter := &TopKCounter{
+		Count: minCounter.Count + count,
+		Error: minCounter.Count,
+		item:  item,
+	}
+	t.Counters[item] = counter
+	(*counters)[0] = counter
+	heap.Fix(counters, 0)
+}
+
+// Merge merges another sketch into the sketch. Items missing from one of the
+// sketches may have been counted up to its smallest count, so that count is
+// added to them before keeping the items with the largest counts
+func (t *TopK) Merge(other *TopK) {
+	if other.K > t.K {
+		t.K = other.K
+	}
+	if other.capacity() > t.capacity() {
+		t.Capacity = other.capacity()
+	}
+
+	minCount := t.minCount()
+	otherMinCount := other.minCount()
+
+	merged := make(map[string]*TopKCounter, len(t.Counters)+len(other.Counters))
+	for item, counter := range t.Counters {
+		merged[item] = &TopKCounter{
+			Count: counter.Count + otherMinCount,
+			Error: counter.Error + otherMinCount,
+			item:  item,
+		}
+	}
+	for item, counter := range other.Counters {
+		if mergedCounter, ok := merged[item]; ok {
+			mergedCounter.Count = mergedCounter.Count - otherMinCount + counter.Count
+			mergedCounter.Error = mergedCounter.Error - otherMinCount + counter.Error
+		} else {
+			merged[item] = &TopKCounter{
+				Count: counter.Count + minCount,
+				Error: counter.Error + minCount,
+				item:  item,
+			}
+		}
+	}
+
+	// keep the items with the largest counts
+	t.Counters = merged
+	t.heap = nil
+	items := t.rankedItems()
+	for _, item := range items[minInt(len(items), t.capacity()):] {
+		delete(t.Counters, item.Item)
+	}
+}
+
+// GetTopK returns the K most frequent items ranked by their count
+func (t *TopK) GetTopK() []TopKItem {
+	items := t.rankedItems()
+	return items[:minInt(len(items), t.K)]
+}
+
+// ToNum returns the count of the most frequent item
+func (t *TopK) ToNum() float64 {
+	items := t.rankedItems()
+	if len(items) == 0 {
+		return 0
+	}
+
+	return float64(items[0].Count)
+}
+
+func (t *TopK) Type() AggregatorType {
+	return TopKAggregatorType
+}
+
+// Reduce merges the sketch sent in the message
+func (t *TopK) Reduce(message *ReduceMessage) error {
+	other, err := DecodeTopK(message.Sketch)
+	if err != nil {
+		return err
+	}
+
+	t.Merge(other)
+
+	return nil
+}
+
+// UpdateOutput merges the intermediate sketch into the sketch
+func (t *TopK) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*TopK)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	t.Merge(intermediateValueCast)
+
+	return nil
+}
+
+// Encode encodes the sketch so that it can be sent in a ReduceMessage. K and the
+// capacity are followed by the counters, each one with its item, count and error
+func (t *TopK) Encode() []byte {
+	var buf bytes.Buffer
+	scratch := make([]byte, binary.MaxVarintLen64)
+
+	buf.Write(scratch[:binary.PutUvarint(scratch, uint64(t.K))])
+	buf.Write(scratch[:binary.PutUvarint(scratch, uint64(t.capacity()))])
+	buf.Write(scratch[:binary.PutUvarint(scratch, uint64(len(t.Counters)))])
+	for _, item := range t.rankedItems() {
+		counter := t.Counters[item.Item]
+		buf.Write(scratch[:binary.PutUvarint(scratch, uint64(len(item.Item)))])
+		buf.WriteString(item.Item)
+		buf.Write(scratch[:binary.PutUvarint(scratch, counter.Count)])
+		buf.Write(scratch[:binary.PutUvarint(scratch, counter.Error)])
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeTopK decodes a sketch encoded with Encode
+func DecodeTopK(sketch []byte) (*TopK, error) {
+	invalidErr := errors.New("Invalid TopK sketch")
+	reader := bytes.NewReader(sketch)
+
+	k, err := binary.ReadUvarint(reader)
+	if err != nil || k == 0 || k > MaxTopKCapacity {
+		return nil, invalidErr
+	}
+	capacity, err := binary.ReadUvarint(reader)
+	if err != nil || capacity < k || capacity > MaxTopKCapacity {
+		return nil, invalidErr
+	}
+	t := InitTopKWithCapacity(int(k), int(capacity))
+
+	numCounters, err := binary.ReadUvarint(reader)
+	if err != nil || numCounters > capacity || numCounters > uint64(reader.Len()) {
+		return nil, invalidErr
+	}
+	for i := uint64(0); i < numCounters; i++ {
+		itemLen, err := binary.ReadUvarint(reader)
+		if err != nil || itemLen > MaxTopKItemSize || itemLen > uint64(reader.Len()) {
+			return nil, invalidErr
+		}
+		item := make([]byte, itemLen)
+		if _, err := io.ReadFull(reader, item); err != nil {
+			return nil, invalidErr
+		}
+
+		counter := &TopKCounter{item: string(item)}
+		if counter.Count, err = binary.ReadUvarint(reader); err != nil {
+			return nil, invalidErr
+		}
+		if counter.Error, err = binary.ReadUvarint(reader); err != nil {
+			return nil, invalidErr
+		}
+		t.Counters[string(item)] = counter
+	}
+
+	if reader.Len() != 0 {
+		return nil, invalidErr
+	}
+
+	return t, nil
+}
+
+// MarshalJSON encodes the K most frequent items as a ranked list. Like
+// the other aggregators the counters are not part of the output of the job
+func (t *TopK) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.GetTopK())
+}
+
+// AddTopK is a helper function the user can use to count an item in the k most
+// frequent items of a key in the aggregator map. Items can't be larger than MaxTopKItemSize
+func (ma MapAggregator) AddTopK(key string, item string, k int) error {
+	return ma.AddTopKWithCapacity(key, item, k, defaultTopKCapacity(k))
+}
+
+// AddTopKWithCapacity is like AddTopK but sets the number of counters of the key,
+// more counters make the counts of frequent items more accurate. It should be
+// the same in every mapper, the largest capacity is kept when sketches are merged
+func (ma MapAggregator) AddTopKWithCapacity(key string, item string, k int, capacity int) error {
+	if k <= 0 {
+		return fmt.Errorf("Invalid k %d, k must be greater than 0", k)
+	}
+	if capacity < k || capacity > MaxTopKCapacity {
+		return fmt.Errorf("Invalid capacity %d, it must be between k and %d", capacity, MaxTopKCapacity)
+	}
+	if len(item) > MaxTopKItemSize {
+		return fmt.Errorf("Invalid item of %d bytes, items can't be larger than %d bytes", len(item), MaxTopKItemSize)
+	}
+
+	currentTopK, ok := ma[key]
+	if !ok {
+		t := InitTopKWithCapacity(k, capacity)
+		t.Add(item, 1)
+		ma[key] = t
+	} else {
+		// cast intermediate map
+		castTopK, ok := currentTopK.(*TopK)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castTopK.Add(item, 1)
+	}
+
+	return nil
+}
+
+// capacity returns the number of counters kept by the sketch
+func (t *TopK) capacity() int {
+	if t.Capacity == 0 {
+		return defaultTopKCapacity(t.K)
+	}
+
+	return t.Capacity
+}
+
+// defaultTopKCapacity returns the number of counters kept for k items by default
+func defaultTopKCapacity(k int) int {
+	if k > MaxTopKCapacity/DefaultTopKCapacityFactor {
+		return MaxTopKCapacity
+	}
+
+	return k * DefaultTopKCapacityFactor
+}
+
+// minCount returns the smallest count of the sketch if all the
+// counters are used, otherwise items that are not counted have count 0
+func (t *TopK) minCount() uint64 {
+	if len(t.Counters) < t.capacity() {
+		return 0
+	}
+
+	return (*t.counterHeap())[0].Count
+}
+
+// counterHeap returns the heap of the counters, which is built again
+// when the counters have been replaced, like after merging sketches
+func (t *TopK) counterHeap() *topKHeap {
+	if t.heap == nil || len(t.heap) != len(t.Counters) {
+		t.heap = make(topKHeap, 0, len(t.Counters))
+		for item, counter := range t.Counters {
+			counter.item = item
+			counter.index = len(t.heap)
+			t.heap = append(t.heap, counter)
+		}
+		heap.Init(&t.heap)
+	}
+
+	return &t.heap
+}
+
+// topKHeap is a min-heap of counters ordered by count. Ties are broken
+// by the item so that the same item is replaced in every mapper
+type topKHeap []*TopKCounter
+
+func (h topKHeap) Len() int { return len(h) }
+
+func (h topKHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	return h[i].item > h[j].item
+}
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	counter := x.(*TopKCounter)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return counter
+}
+
+// rankedItems returns every counted item ordered by count and then by item
+func (t *TopK) rankedItems() []TopKItem {
+	items := make([]TopKItem, 0, len(t.Counters))
+	for item, counter := range t.Counters {
+		items = append(items, TopKItem{Item: item, Count: counter.Count})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Item < items[j].Item
+	})
+
+	return items
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
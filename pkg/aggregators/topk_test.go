@@ -0,0 +1,169 @@
+package aggregators
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function merges the sketches of three mappers, each one with a few
+// frequent urls and many urls that are seen once. The frequent urls are seen
+// more often than the number of values over the number of counters, which
+// Space-Saving guarantees to keep
+func Test_TopK_HappyPath(t *testing.T) {
+	mappers := []MapAggregator{NewMap(), NewMap(), NewMap()}
+	for i, mapper := range mappers {
+		for url := 1; url <= 5; url++ {
+			for count := 0; count < 1000*(6-url); count++ {
+				require.Nil(t, mapper.AddTopK("urls", fmt.Sprintf("url-%d", url), 3))
+			}
+		}
+		for noise := 0; noise < 2000; noise++ {
+			require.Nil(t, mapper.AddTopK("urls", fmt.Sprintf("noise-%d-%d", i, noise), 3))
+		}
+	}
+
+	// each mapper sends a single message with a bounded number of items
+	reducer := NewMap()
+	for _, mapper := range mappers {
+		assert.Len(t, mapper["urls"].(*TopK).Counters, 3*DefaultTopKCapacityFactor)
+
+		p, err := json.Marshal(ReduceMessage{
+			Key:    "urls",
+			Type:   int64(TopKAggregatorType),
+			Sketch: mapper["urls"].(*TopK).Encode(),
+		})
+		require.Nil(t, err)
+
+		var message *ReduceMessage
+		require.Nil(t, json.Unmarshal(p, &message))
+		require.Nil(t, reducer.Reduce(message))
+	}
+
+	topK := reducer["urls"].(*TopK)
+	assert.Equal(t, TopKAggregatorType, topK.Type())
+	items := topK.GetTopK()
+	require.Len(t, items, 3)
+	for i, item := range items {
+		// counts are never underestimated
+		expectedCount := uint64(3 * 1000 * (5 - i))
+		assert.Equal(t, fmt.Sprintf("url-%d", i+1), item.Item)
+		assert.GreaterOrEqual(t, item.Count, expectedCount)
+		assert.LessOrEqual(t, item.Count-topK.Counters[item.Item].Error, expectedCount)
+	}
+	assert.Equal(t, float64(items[0].Count), topK.ToNum())
+
+	// merging is the same in the reducer output
+	output := NewMap()
+	var wg sync.WaitGroup
+	wg.Add(len(mappers))
+	for _, mapper := range mappers {
+		require.Nil(t, output.UpdateOutput(mapper, &wg))
+	}
+	wg.Wait()
+	assert.Equal(t, items, output["urls"].(*TopK).GetTopK())
+
+	// the output is a ranked list
+	p, err := json.Marshal(topK)
+	require.Nil(t, err)
+	assert.Equal(
+		t,
+		fmt.Sprintf(
+			`[{"item":"url-1","count":"%d"},{"item":"url-2","count":"%d"},{"item":"url-3","count":"%d"}]`,
+			items[0].Count, items[1].Count, items[2].Count,
+		),
+		string(p),
+	)
+}
+
+// this function checks that counts are exact while there are free counters
+func Test_TopK_ExactCounts(t *testing.T) {
+	aggregatorMap := NewMap()
+	for _, item := range []string{"b", "a", "b", "c", "b", "a"} {
+		require.Nil(t, aggregatorMap.AddTopK("letters", item, 2))
+	}
+
+	decoded, err := DecodeTopK(aggregatorMap["letters"].(*TopK).Encode())
+	require.Nil(t, err)
+	assert.Equal(t, []TopKItem{{Item: "b", Count: 3}, {Item: "a", Count: 2}}, decoded.GetTopK())
+}
+
+// this function sets the number of counters, the item with the smallest
+// count is replaced when there are no free counters
+func Test_TopK_Capacity(t *testing.T) {
+	aggregatorMap := NewMap()
+	for _, item := range []string{"a", "a", "b", "c", "c"} {
+		require.Nil(t, aggregatorMap.AddTopKWithCapacity("letters", item, 1, 2))
+	}
+
+	topK := aggregatorMap["letters"].(*TopK)
+	require.Len(t, topK.Counters, 2)
+	assert.Equal(t, uint64(3), topK.Counters["c"].Count)
+	assert.Equal(t, uint64(1), topK.Counters["c"].Error)
+	assert.Equal(t, []TopKItem{{Item: "c", Count: 3}}, topK.GetTopK())
+
+	// the capacity is encoded with the sketch and the largest one is kept when merging
+	decoded, err := DecodeTopK(topK.Encode())
+	require.Nil(t, err)
+	assert.Equal(t, 2, decoded.Capacity)
+
+	other := InitTopK(1)
+	other.Add("d", 1)
+	decoded.Merge(other)
+	assert.Equal(t, DefaultTopKCapacityFactor, decoded.Capacity)
+	assert.Len(t, decoded.Counters, 3)
+}
+
+// this function checks that a sketch with every counter used by an item of
+// the largest size and the largest counts fits in the largest encoded size
+func Test_TopK_LargestSketch(t *testing.T) {
+	topK := InitTopKWithCapacity(MaxTopKCapacity, MaxTopKCapacity)
+	for i := 0; i < MaxTopKCapacity; i++ {
+		topK.Add(fmt.Sprintf("%0*d", MaxTopKItemSize, i), 1)
+	}
+	for _, counter := range topK.Counters {
+		counter.Count = math.MaxUint64
+		counter.Error = math.MaxUint64
+	}
+
+	sketch := topK.Encode()
+	assert.LessOrEqual(t, len(sketch), MaxTopKSketchSize)
+
+	decoded, err := DecodeTopK(sketch)
+	require.Nil(t, err)
+	assert.Len(t, decoded.Counters, MaxTopKCapacity)
+}
+
+func Test_TopK_UnhappyPath(t *testing.T) {
+	aggregatorMap := NewMap()
+
+	err := aggregatorMap.AddTopK("a key", "an item", 0)
+	assert.EqualError(t, err, "Invalid k 0, k must be greater than 0")
+
+	require.Nil(t, aggregatorMap.AddTopK("a key", "an item", 10))
+	err = aggregatorMap.AddSum("a key", 1)
+	assert.EqualError(t, err, "Mixed aggregators used")
+
+	err = aggregatorMap.AddTopKWithCapacity("another key", "an item", 10, 5)
+	assert.EqualError(t, err, "Invalid capacity 5, it must be between k and 1166")
+
+	err = aggregatorMap.AddTopK("a key", strings.Repeat("x", MaxTopKItemSize+1), 10)
+	assert.EqualError(t, err, "Invalid item of 129 bytes, items can't be larger than 128 bytes")
+
+	err = aggregatorMap.Reduce(&ReduceMessage{Key: "another key", Type: int64(TopKAggregatorType), Sketch: []byte{1, 1}})
+	assert.EqualError(t, err, "Invalid TopK sketch")
+
+	// sizes larger than the maximum capacity are rejected before allocating
+	sketch := make([]byte, 2*binary.MaxVarintLen64+1)
+	n := binary.PutUvarint(sketch, 1<<40)
+	n = n + binary.PutUvarint(sketch[n:], 1<<40)
+	_, err = DecodeTopK(sketch[:n+1])
+	assert.EqualError(t, err, "Invalid TopK sketch")
+}
@@ -0,0 +1,168 @@
+package aggregators
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ValueType is the type of a typed value
+type ValueType int64
+
+const (
+	InvalidValueType ValueType = iota
+	StringValueType
+	IntValueType
+	FloatValueType
+	BoolValueType
+)
+
+// Value is a typed value, used by aggregators whose result is not
+// a number. It holds a string, an int64, a float64 or a bool
+type Value struct {
+	Type   ValueType `json:"type"`
+	String string    `json:"string,omitempty"`
+	Int    int64     `json:"int,string,omitempty"`
+	Float  float64   `json:"float,omitempty"`
+	Bool   bool      `json:"bool,omitempty"`
+}
+
+// ValueAggregator is implemented by aggregators that
+// keep a typed value instead of a number
+type ValueAggregator interface {
+	Aggregator
+	ToValue() Value
+}
+
+// StringValue returns a string value
+func StringValue(value string) Value {
+	return Value{Type: StringValueType, String: value}
+}
+
+// IntValue returns an int64 value
+func IntValue(value int64) Value {
+	return Value{Type: IntValueType, Int: value}
+}
+
+// FloatValue returns a float64 value
+func FloatValue(value float64) Value {
+	return Value{Type: FloatValueType, Float: value}
+}
+
+// BoolValue returns a bool value
+func BoolValue(value bool) Value {
+	return Value{Type: BoolValueType, Bool: value}
+}
+
+// NewValue returns the typed value of a string, an integer, a float or a bool
+func NewValue(value interface{}) (Value, error) {
+	switch v := value.(type) {
+	case Value:
+		return v, nil
+	case string:
+		return StringValue(v), nil
+	case int:
+		return IntValue(int64(v)), nil
+	case int32:
+		return IntValue(int64(v)), nil
+	case int64:
+		return IntValue(v), nil
+	case float32:
+		return NewValue(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Value{}, fmt.Errorf("Invalid value %f", v)
+		}
+		return FloatValue(v), nil
+	case bool:
+		return BoolValue(v), nil
+	default:
+		return Value{}, fmt.Errorf("Unsupported value type %T", value)
+	}
+}
+
+// Interface returns the value as a native go type
+func (v Value) Interface() interface{} {
+	switch v.Type {
+	case StringValueType:
+		return v.String
+	case IntValueType:
+		return v.Int
+	case FloatValueType:
+		return v.Float
+	case BoolValueType:
+		return v.Bool
+	default:
+		return nil
+	}
+}
+
+// ToNum converts the value to a float. Bools are 1 or 0
+// and strings that are not numbers are 0
+func (v Value) ToNum() float64 {
+	switch v.Type {
+	case IntValueType:
+		return float64(v.Int)
+	case FloatValueType:
+		return v.Float
+	case BoolValueType:
+		if v.Bool {
+			return 1
+		}
+		return 0
+	case StringValueType:
+		num, err := strconv.ParseFloat(v.String, 64)
+		if err != nil {
+			return 0
+		}
+		return num
+	default:
+		return 0
+	}
+}
+
+// CompareValues returns -1, 0 or 1 if a is smaller, equal or greater than b.
+// Values of different types are ordered by their type so that the order is
+// the same in every mapper and reducer
+func CompareValues(a Value, b Value) int {
+	if a.Type != b.Type {
+		if a.Type < b.Type {
+			return -1
+		}
+		return 1
+	}
+
+	switch a.Type {
+	case StringValueType:
+		return strings.Compare(a.String, b.String)
+	case IntValueType:
+		switch {
+		case a.Int < b.Int:
+			return -1
+		case a.Int > b.Int:
+			return 1
+		default:
+			return 0
+		}
+	case FloatValueType:
+		switch {
+		case a.Float < b.Float:
+			return -1
+		case a.Float > b.Float:
+			return 1
+		default:
+			return 0
+		}
+	case BoolValueType:
+		if a.Bool == b.Bool {
+			return 0
+		}
+		if !a.Bool {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,222 @@
+package aggregators
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// -------------------
+// VARIANCE AND STDDEV AGGREGATORS
+// -------------------
+
+// Moments is the partial state of the Variance and StdDev aggregators. It keeps
+// the count, the mean and the sum of squared differences from the mean (M2) which
+// are updated with Welford's algorithm so that the variance is numerically stable
+type Moments struct {
+	Count int
+	Mean  float64
+	M2    float64
+}
+
+// Add updates the moments with a new value
+func (m *Moments) Add(value float64) {
+	m.Count++
+	delta := value - m.Mean
+	m.Mean = m.Mean + delta/float64(m.Count)
+	m.M2 = m.M2 + delta*(value-m.Mean)
+}
+
+// Merge updates the moments with the moments of other values
+func (m *Moments) Merge(count int, mean float64, m2 float64) {
+	if count == 0 {
+		return
+	}
+	if m.Count == 0 {
+		m.Count, m.Mean, m.M2 = count, mean, m2
+		return
+	}
+
+	total := m.Count + count
+	delta := mean - m.Mean
+	m.Mean = m.Mean + delta*float64(count)/float64(total)
+	m.M2 = m.M2 + m2 + delta*delta*float64(m.Count)*float64(count)/float64(total)
+	m.Count = total
+}
+
+// GetCount returns the number of values
+func (m *Moments) GetCount() int {
+	return m.Count
+}
+
+// GetMean returns the mean of the values
+func (m *Moments) GetMean() float64 {
+	return m.Mean
+}
+
+// GetM2 returns the sum of squared differences from the mean
+func (m *Moments) GetM2() float64 {
+	return m.M2
+}
+
+// SampleVariance returns the sample variance of the values,
+// which is 0 when there are less than two values
+func (m *Moments) SampleVariance() float64 {
+	if m.Count < 2 {
+		return 0
+	}
+
+	return m.M2 / float64(m.Count-1)
+}
+
+// Variance aggregates values emitted by computing their sample variance
+type Variance struct {
+	Variance float64 `json:",string,omitempty"`
+	Moments  `json:"-"`
+}
+
+// InitVariance initializes a Variance value with the given moments
+func InitVariance(count int, mean float64, m2 float64) *Variance {
+	v := &Variance{
+		Moments: Moments{Count: count, Mean: mean, M2: m2},
+	}
+	v.PerformVariance()
+
+	return v
+}
+
+// PerformVariance computes the variance from the moments
+func (v *Variance) PerformVariance() {
+	v.Variance = v.SampleVariance()
+}
+
+// ToNum converts the Variance value to a float
+func (v *Variance) ToNum() float64 {
+	return v.Variance
+}
+
+func (v *Variance) Type() AggregatorType {
+	return VarianceAggregatorType
+}
+
+// Reduce merges the moments sent in the message
+func (v *Variance) Reduce(message *ReduceMessage) error {
+	v.Merge(message.Count, message.Mean, message.M2)
+	v.PerformVariance()
+
+	return nil
+}
+
+// UpdateOutput merges the previous Variance value with the new intermediate value
+func (v *Variance) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*Variance)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	// update output map values
+	v.Merge(intermediateValueCast.Count, intermediateValueCast.Mean, intermediateValueCast.M2)
+	v.PerformVariance()
+
+	return nil
+}
+
+// StdDev aggregates values emitted by computing their sample standard deviation
+type StdDev struct {
+	StdDev  float64 `json:",string,omitempty"`
+	Moments `json:"-"`
+}
+
+// InitStdDev initializes a StdDev value with the given moments
+func InitStdDev(count int, mean float64, m2 float64) *StdDev {
+	s := &StdDev{
+		Moments: Moments{Count: count, Mean: mean, M2: m2},
+	}
+	s.PerformStdDev()
+
+	return s
+}
+
+// PerformStdDev computes the standard deviation from the moments
+func (s *StdDev) PerformStdDev() {
+	s.StdDev = math.Sqrt(s.SampleVariance())
+}
+
+// ToNum converts the StdDev value to a float
+func (s *StdDev) ToNum() float64 {
+	return s.StdDev
+}
+
+func (s *StdDev) Type() AggregatorType {
+	return StdDevAggregatorType
+}
+
+// Reduce merges the moments sent in the message
+func (s *StdDev) Reduce(message *ReduceMessage) error {
+	s.Merge(message.Count, message.Mean, message.M2)
+	s.PerformStdDev()
+
+	return nil
+}
+
+// UpdateOutput merges the previous StdDev value with the new intermediate value
+func (s *StdDev) UpdateOutput(intermediateValue interface{}, wg *sync.WaitGroup) error {
+	// cast intermediate map
+	intermediateValueCast, ok := intermediateValue.(*StdDev)
+	if !ok {
+		return errors.New("Error updating output")
+	}
+
+	// update output map values
+	s.Merge(intermediateValueCast.Count, intermediateValueCast.Mean, intermediateValueCast.M2)
+	s.PerformStdDev()
+
+	return nil
+}
+
+// AddVariance is a helper function the user can use to add a
+// value to the Variance of a key in the aggregator map
+func (ma MapAggregator) AddVariance(key string, value float64) error {
+	currentVariance, ok := ma[key]
+	if !ok {
+		v := InitVariance(0, 0, 0)
+		v.Add(value)
+		v.PerformVariance()
+		ma[key] = v
+	} else {
+		// cast intermediate map
+		castVariance, ok := currentVariance.(*Variance)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castVariance.Add(value)
+		castVariance.PerformVariance()
+	}
+
+	return nil
+}
+
+// AddStdDev is a helper function the user can use to add a
+// value to the StdDev of a key in the aggregator map
+func (ma MapAggregator) AddStdDev(key string, value float64) error {
+	currentStdDev, ok := ma[key]
+	if !ok {
+		s := InitStdDev(0, 0, 0)
+		s.Add(value)
+		s.PerformStdDev()
+		ma[key] = s
+	} else {
+		// cast intermediate map
+		castStdDev, ok := currentStdDev.(*StdDev)
+		if !ok {
+			return errors.New("Mixed aggregators used")
+		}
+
+		castStdDev.Add(value)
+		castStdDev.PerformStdDev()
+	}
+
+	return nil
+}
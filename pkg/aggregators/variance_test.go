@@ -0,0 +1,77 @@
+package aggregators
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function merges the moments of two mappers. The values have a large
+// offset that makes the sum of squares lose precision
+func Test_VarianceAggregator_HappyPath(t *testing.T) {
+	offset := 1e9
+	mapper1 := NewMap()
+	mapper2 := NewMap()
+	for _, value := range []float64{4, 7, 13} {
+		require.Nil(t, mapper1.AddVariance("variance", offset+value))
+		require.Nil(t, mapper1.AddStdDev("stddev", offset+value))
+	}
+	require.Nil(t, mapper2.AddVariance("variance", offset+16))
+	require.Nil(t, mapper2.AddStdDev("stddev", offset+16))
+
+	// send the moments to the reducer
+	reducer := NewMap()
+	for _, mapper := range []MapAggregator{mapper1, mapper2} {
+		variance := mapper["variance"].(*Variance)
+		stdDev := mapper["stddev"].(*StdDev)
+		for _, message := range []ReduceMessage{
+			{Key: "variance", Type: int64(VarianceAggregatorType), Count: variance.GetCount(), Mean: variance.GetMean(), M2: variance.GetM2()},
+			{Key: "stddev", Type: int64(StdDevAggregatorType), Count: stdDev.GetCount(), Mean: stdDev.GetMean(), M2: stdDev.GetM2()},
+		} {
+			p, err := json.Marshal(message)
+			require.Nil(t, err)
+
+			var reduceMessage *ReduceMessage
+			require.Nil(t, json.Unmarshal(p, &reduceMessage))
+			require.Nil(t, reducer.Reduce(reduceMessage))
+		}
+	}
+
+	assert.Equal(t, VarianceAggregatorType, reducer["variance"].Type())
+	assert.Equal(t, StdDevAggregatorType, reducer["stddev"].Type())
+	assert.InDelta(t, 30, reducer["variance"].ToNum(), 1e-6)
+	assert.InDelta(t, math.Sqrt(30), reducer["stddev"].ToNum(), 1e-6)
+	assert.Equal(t, offset+10, reducer["variance"].(*Variance).GetMean())
+
+	// merging is the same in the reducer output
+	output := NewMap()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	require.Nil(t, output.UpdateOutput(mapper1, &wg))
+	require.Nil(t, output.UpdateOutput(mapper2, &wg))
+	wg.Wait()
+	assert.InDelta(t, 30, output["variance"].ToNum(), 1e-6)
+	assert.InDelta(t, math.Sqrt(30), output["stddev"].ToNum(), 1e-6)
+
+	// the output only has the result
+	p, err := json.Marshal(InitVariance(4, offset+10, 90))
+	require.Nil(t, err)
+	assert.Equal(t, `{"Variance":"30"}`, string(p))
+}
+
+func Test_VarianceAggregator_UnhappyPath(t *testing.T) {
+	aggregatorMap := NewMap()
+
+	// a single value has no variance
+	require.Nil(t, aggregatorMap.AddVariance("a key", 5))
+	assert.Equal(t, float64(0), aggregatorMap["a key"].ToNum())
+
+	err := aggregatorMap.AddStdDev("a key", 1)
+	assert.EqualError(t, err, "Mixed aggregators used")
+	err = aggregatorMap.AddAvg("a key", 1)
+	assert.EqualError(t, err, "Mixed aggregators used")
+}
@@ -1,12 +1,22 @@
 package lambdas
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/sirupsen/logrus"
+
 	"github.com/josenarvaezp/displ/internal/config"
 	"github.com/josenarvaezp/displ/pkg/aggregators"
 )
@@ -23,10 +33,11 @@ func NewMapReducer(
 
 	// init mapper
 	reducer := &Reducer{
-		Region: region,
-		Local:  local,
-		Output: make(aggregators.MapAggregator),
-		Dedupe: InitDedupe(),
+		Region:             region,
+		Local:              local,
+		ContinuationMargin: DefaultReducerContinuationMargin,
+		Output:             make(aggregators.MapAggregator),
+		Dedupe:             InitDedupe(),
 	}
 
 	// create config
@@ -59,5 +70,271 @@ func NewMapReducer(
 	// create sqs client
 	reducer.QueuesAPI = sqs.NewFromConfig(*cfg)
 
+	// create lambda client used to continue in a new invocation
+	reducer.FaasAPI = lambda.NewFromConfig(*cfg)
+
 	return reducer, err
 }
+
+// HandleMapAggregator runs the reducer for the given request. It reads the batches sent
+// by the mappers to its queue partition, reduces them and writes the reducer output.
+// The filter and sort functions are optional and are skipped if nil
+func (r *Reducer) HandleMapAggregator(
+	ctx context.Context,
+	request ReducerInput,
+	filter func(aggregators.MapAggregator) aggregators.MapAggregator,
+	sortOutput func(aggregators.MapAggregator) sort.Interface,
+) error {
+	// update reducer
+	if err := r.UpdateReducerWithRequest(ctx, request); err != nil {
+		return err
+	}
+
+	// get reduce queue information
+	queueName := fmt.Sprintf("%s-%d", r.JobID.String(), request.QueuePartition)
+	queueURL := GetQueueURL(queueName, r.Region, r.AccountID, r.Local)
+
+	// set reducer logger
+	reducerLogger := logrus.WithFields(logrus.Fields{
+		"Job ID":          r.JobID.String(),
+		"Reducer ID":      r.ReducerID.String(),
+		"Queue Partition": r.QueuePartition,
+	})
+
+	// set wait group
+	var wg sync.WaitGroup
+
+	// get checkpoint data
+	checkpointData, err := r.GetCheckpointData(ctx, &wg)
+	if err != nil {
+		reducerLogger.WithError(err).Error("Error reading checkpoint")
+		return err
+	}
+
+	// batch metadata - number of batches the reducer needs to process
+	// it is in the request if a previous invocation has already read it
+	totalBatchesToProcess := &request.NumBatches
+	if request.NumBatches == 0 {
+		totalBatchesToProcess, err = r.GetNumberOfBatchesToProcess(ctx)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error getting queue metadata")
+			return err
+		}
+	}
+	// batches processed before the last checkpoint
+	totalProcessedBatches := r.Dedupe.CompleteBatches()
+
+	// checkpoint info
+	processedMessagesWithoutCheckpoint := 0
+	checkpointData.LastCheckpoint++
+
+	// holds the intermediate results
+	intermediateReducedMap := make(aggregators.MapAggregator)
+
+	// processedMessagesDeleteInfo holds the data to delete messages from queue
+	processedMessagesDeleteInfo := make([]sqsTypes.DeleteMessageBatchRequestEntry, 0, MaxMessagesWithoutCheckpoint)
+
+	// use same parameters for all get messages requests
+	recieveMessageParams := &sqs.ReceiveMessageInput{
+		QueueUrl:            &queueURL,
+		MaxNumberOfMessages: MaxItemsPerBatch,
+		MessageAttributeNames: []string{
+			MapIDAttribute,
+			BatchIDAttribute,
+			MessageIDAttribute,
+		},
+		WaitTimeSeconds: int32(5),
+	}
+
+	// recieve messages until we are done processing all queue
+	for totalProcessedBatches != *totalBatchesToProcess {
+		// hand over to a new invocation before the lambda times out
+		if r.IsDeadlineNear(ctx) {
+			// wait in case reducers is saving checkpoint in the background
+			wg.Wait()
+
+			// save the messages processed since the last checkpoint
+			err := r.SaveCheckpoint(
+				ctx,
+				queueURL,
+				checkpointData.LastCheckpoint,
+				intermediateReducedMap,
+				r.Dedupe.WriteMap,
+				processedMessagesDeleteInfo,
+			)
+			if err != nil {
+				reducerLogger.WithError(err).Error("Error saving checkpoint")
+				return err
+			}
+
+			if err := r.Continue(ctx, request, *totalBatchesToProcess); err != nil {
+				reducerLogger.WithError(err).Error("Error continuing reducer")
+				return err
+			}
+
+			reducerLogger.Info("Reducer continues in a new invocation")
+			return nil
+		}
+
+		if processedMessagesWithoutCheckpoint == MaxMessagesBeforeCheckpointComplete && checkpointData.LastCheckpoint != 1 {
+			// check that the last checkpoint has completed before processing any more messages
+			// we give a buffer of 15,000 new messages for saving the checkpoint which happens
+			// in the background. If this point is reached it means we have processed 115,000 messages
+			// without deleting from the queue which is close to the aws limit for queues
+			wg.Wait()
+		}
+
+		if processedMessagesWithoutCheckpoint == MaxMessagesWithoutCheckpoint {
+			// We need to delete the messages read from the sqs queue and we create a checkpoint
+			// in S3 as the fault tolerant mechanism. Saving the checkpoint can be done concurrently
+			// in the background while we keep processing messages
+
+			// merge the dedupe map so that the read dedupe map is up to date
+			r.Dedupe.Merge()
+
+			// save intermediate dedupe
+			wg.Add(1)
+			go r.SaveIntermediateDedupe(ctx, checkpointData.LastCheckpoint, r.Dedupe.WriteMap, &wg)
+
+			// save intermediate map
+			wg.Add(1)
+			go r.SaveIntermediateOutput(ctx, intermediateReducedMap, checkpointData.LastCheckpoint, &wg)
+
+			// update output map with reduced intermediate results
+			wg.Add(1)
+			go r.Output.UpdateOutput(intermediateReducedMap, &wg)
+
+			// delete all messages from queue
+			wg.Add(1)
+			go r.DeleteIntermediateMessagesFromQueue(ctx, queueURL, processedMessagesDeleteInfo, &wg)
+
+			// update checkpoint info
+			checkpointData.LastCheckpoint++
+			processedMessagesWithoutCheckpoint = 0
+			processedMessagesDeleteInfo = make([]sqsTypes.DeleteMessageBatchRequestEntry, 0, MaxMessagesWithoutCheckpoint)
+			intermediateReducedMap = make(aggregators.MapAggregator)
+			r.Dedupe.WriteMap = InitDedupeMap()
+		}
+
+		// call sqs receive messages
+		output, err := r.QueuesAPI.ReceiveMessage(ctx, recieveMessageParams)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error reading from queue")
+			return err
+		}
+
+		// process messages
+		for _, message := range output.Messages {
+			processedMessagesWithoutCheckpoint++
+
+			// add delete info
+			processedMessagesDeleteInfo = append(processedMessagesDeleteInfo, sqsTypes.DeleteMessageBatchRequestEntry{
+				Id:            message.MessageId,
+				ReceiptHandle: message.ReceiptHandle,
+			})
+
+			// get message attributes
+			currentMapID := message.MessageAttributes[MapIDAttribute].StringValue
+			currentBatchID, err := strconv.Atoi(*message.MessageAttributes[BatchIDAttribute].StringValue)
+			if err != nil {
+				reducerLogger.WithError(err).Error("Error getting message batch ID")
+				return err
+			}
+			currentMessageID, err := strconv.Atoi(*message.MessageAttributes[MessageIDAttribute].StringValue)
+			if err != nil {
+				reducerLogger.WithError(err).Error("Error getting message ID")
+				return err
+			}
+
+			// check if message has already been processed
+			if exists := r.Dedupe.BatchExists(*currentMapID, currentBatchID); exists {
+				if r.Dedupe.IsBatchComplete(*currentMapID, currentBatchID) {
+					// ignore as it is a duplicated message
+					continue
+				}
+
+				if r.Dedupe.IsMessageProcessed(*currentMapID, currentBatchID, currentMessageID) {
+					// ignore as it is a duplicated message
+					continue
+				}
+
+				// message has not been processed
+				// add processed message to dedupe map
+				r.Dedupe.UpdateMessageProcessed(*currentMapID, currentBatchID, currentMessageID)
+
+				// check if we are done processing batch from map
+				if r.Dedupe.IsBatchComplete(*currentMapID, currentBatchID) {
+					totalProcessedBatches++
+					// delete processed map from dedupe
+					r.Dedupe.DeletedProcessedMessages(*currentMapID, currentBatchID)
+				}
+			} else {
+				// no messages for batch have been processed - init dedupe data for batch
+				r.Dedupe.InitDedupeBatch(*currentMapID, currentBatchID, currentMessageID)
+			}
+
+			// process message
+			// unmarshall message body
+			var reduceMessage *aggregators.ReduceMessage
+			body := []byte(*message.Body)
+			err = json.Unmarshal(body, &reduceMessage)
+			if err != nil {
+				return err
+			}
+
+			if err := intermediateReducedMap.Reduce(reduceMessage); err != nil {
+				reducerLogger.WithError(err).Error("Error processing message")
+				return err
+			}
+		}
+	}
+
+	// wait in case reducers is saving checkpoint in the background
+	wg.Wait()
+
+	// update output map with reduced intermediate results
+	wg.Add(1)
+	go r.Output.UpdateOutput(intermediateReducedMap, &wg)
+	wg.Wait()
+
+	if filter != nil {
+		// filter results
+		r.Output = RunFilter(r.Output, filter)
+	}
+
+	// generate name of the part file
+	key := GetPartName(r.QueuePartition)
+
+	if sortOutput != nil {
+		// sort output
+		sortedOutput := RunSort(r.Output, sortOutput)
+
+		// write sorted reducer output
+		err = r.WriteSortedReducerOutput(ctx, sortedOutput, key)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error writing reducer output")
+			return err
+		}
+	} else {
+		// write unsorted reducer output
+		err = r.WriteReducerOutput(ctx, r.Output, key)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error writing reducer output")
+			return err
+		}
+	}
+
+	// delete all messages from queue
+	wg.Add(1)
+	go r.DeleteIntermediateMessagesFromQueue(ctx, queueURL, processedMessagesDeleteInfo, &wg)
+	wg.Wait()
+
+	// indicate reducer has finished
+	err = r.SendFinishedEvent(ctx)
+	if err != nil {
+		reducerLogger.WithError(err).Error("Error sending done message")
+		return err
+	}
+
+	return nil
+}
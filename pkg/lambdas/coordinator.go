@@ -19,9 +19,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
 	"github.com/josenarvaezp/displ/internal/config"
 	"github.com/josenarvaezp/displ/internal/faas"
 	"github.com/josenarvaezp/displ/internal/logs"
@@ -39,6 +42,8 @@ type CoordinatorInput struct {
 	NumMappers   int       `json:"numMappers"`
 	NumQueues    int       `json:"numQueues"`
 	FunctionName string    `json:"functionName"`
+	OutputBucket string    `json:"outputBucket,omitempty"`
+	OutputPrefix string    `json:"outputPrefix,omitempty"`
 }
 
 // CoordinatorAPI is an interface deining the functions available to the coordinator
@@ -58,11 +63,22 @@ type Coordinator struct {
 	LogsAPI        logs.LogsAPI
 	ObjectStoreAPI objectstore.ObjectStoreAPI
 	// metadata
-	Region     string
-	AccountID  string
-	NumMappers int64
-	NumQueues  int64
-	local      bool
+	Region       string
+	AccountID    string
+	NumMappers   int64
+	NumQueues    int64
+	OutputBucket string
+	OutputPrefix string
+	FunctionArn  string
+	local        bool
+	// time before the lambda deadline at which the coordinator continues
+	// in a new invocation, zero disables continuations
+	ContinuationMargin time.Duration
+	// time between checks of the queues and objects the coordinator waits for,
+	// zero uses DefaultPollInterval
+	PollInterval time.Duration
+	// progress of the job
+	State *CoordinatorState
 }
 
 // NewCoordinator initializes a new coordinator with its required clients
@@ -77,8 +93,10 @@ func NewCoordinator(
 
 	// init coordinator
 	coordinator := &Coordinator{
-		Region: region,
-		local:  local,
+		Region:             region,
+		local:              local,
+		ContinuationMargin: DefaultContinuationMargin,
+		PollInterval:       DefaultPollInterval,
 	}
 
 	// create config
@@ -127,9 +145,11 @@ func (c *Coordinator) UpdateCoordinatorWithRequest(ctx context.Context, request
 		return errors.New("Error getting lambda context")
 	}
 	c.AccountID = strings.Split(lc.InvokedFunctionArn, ":")[4]
+	c.FunctionArn = lc.InvokedFunctionArn
 	c.JobID = request.JobID
 	c.NumMappers = int64(request.NumMappers)
 	c.NumQueues = int64(request.NumQueues)
+	c.OutputBucket, c.OutputPrefix = GetOutputLocation(request.JobID, request.OutputBucket, request.OutputPrefix)
 
 	return nil
 }
@@ -144,12 +164,26 @@ func (c *Coordinator) AreMappersDone(ctx context.Context, nextLogToken *string)
 		MaxNumberOfMessages: MaxItemsPerBatch,
 	}
 
-	// keeps a map of done mappers, this is used as the dedupe mechanism
-	doneMappers := make(map[string]bool)
-	doneMappersCount := 0
+	// keeps a map of done mappers, this is used as the dedupe mechanism.
+	// It is part of the coordinator state so that it is kept across invocations
+	if c.State == nil {
+		c.State = NewCoordinatorState()
+	}
+	doneMappers := c.State.DoneMappers
+	doneMappersCount := len(doneMappers)
 
 	// loop until all mappers are done
 	for doneMappersCount < int(c.NumMappers) {
+		// stop waiting if a mapper has failed
+		if err := c.CheckJobFailure(ctx, nextLogToken); err != nil {
+			return nil, err
+		}
+
+		// continue in a new invocation before the lambda times out
+		if c.IsDeadlineNear(ctx) {
+			return nextLogToken, ErrDeadlineNear
+		}
+
 		// mappers are not done yet
 		output, err := c.QueuesAPI.ReceiveMessage(ctx, params)
 		if err != nil {
@@ -170,13 +204,23 @@ func (c *Coordinator) AreMappersDone(ctx context.Context, nextLogToken *string)
 			break
 		}
 
-		// sleep for 1 seconds before trying to get more results
-		time.Sleep(1 * time.Second)
+		// sleep before trying to get more results
+		c.sleep()
 	}
 
 	messagesReceived := 0
 	// loop until all mappers are done
 	for doneMappersCount < int(c.NumMappers) {
+		// stop waiting if a mapper has failed
+		if err := c.CheckJobFailure(ctx, nextLogToken); err != nil {
+			return nil, err
+		}
+
+		// continue in a new invocation before the lambda times out
+		if c.IsDeadlineNear(ctx) {
+			return nextLogToken, ErrDeadlineNear
+		}
+
 		// mappers are not done yet
 		output, err := c.QueuesAPI.ReceiveMessage(ctx, params)
 		if err != nil {
@@ -191,6 +235,11 @@ func (c *Coordinator) AreMappersDone(ctx context.Context, nextLogToken *string)
 			}
 		}
 
+		if len(output.Messages) == 0 {
+			// sleep before trying to get more results
+			c.sleep()
+		}
+
 		// only log every 100 messages
 		if messagesReceived%100 == 0 {
 			if mappersCompleted, err := c.GetNumMessagesInQueue(ctx, queueURL); err == nil {
@@ -235,6 +284,8 @@ func (c *Coordinator) InvokeReducers(ctx context.Context, reducerName string) er
 			ReducerID:      uuid.New(),
 			QueuePartition: i,
 			NumMappers:     int(c.NumMappers),
+			OutputBucket:   c.OutputBucket,
+			OutputPrefix:   c.OutputPrefix,
 		}
 		requestPayload, err := json.Marshal(reducerInput)
 		if err != nil {
@@ -284,10 +335,12 @@ func (c *Coordinator) InvokeReducer(ctx context.Context, reducerName string) err
 
 	// encode reducer input to json
 	reducerInput := ReducerInput{
-		JobID:       c.JobID,
-		ReducerID:   uuid.New(),
-		NumReducers: int(c.NumQueues),
-		NumMappers:  int(c.NumMappers),
+		JobID:        c.JobID,
+		ReducerID:    uuid.New(),
+		NumReducers:  int(c.NumQueues),
+		NumMappers:   int(c.NumMappers),
+		OutputBucket: c.OutputBucket,
+		OutputPrefix: c.OutputPrefix,
 	}
 	requestPayload, err := json.Marshal(reducerInput)
 	if err != nil {
@@ -326,12 +379,26 @@ func (c *Coordinator) AreReducersDone(ctx context.Context, nextLogToken *string)
 		MaxNumberOfMessages: MaxItemsPerBatch,
 	}
 
-	// keeps a map of done reducers, this is used as the dedupe mechanism
-	doneReducers := make(map[string]bool)
-	doneReducersCount := 0
+	// keeps a map of done reducers, this is used as the dedupe mechanism.
+	// It is part of the coordinator state so that it is kept across invocations
+	if c.State == nil {
+		c.State = NewCoordinatorState()
+	}
+	doneReducers := c.State.DoneReducers
+	doneReducersCount := len(doneReducers)
 
 	// loop until all reducers are done
 	for doneReducersCount < int(c.NumQueues) {
+		// stop waiting if a reducer has failed
+		if err := c.CheckJobFailure(ctx, nextLogToken); err != nil {
+			return nil, err
+		}
+
+		// continue in a new invocation before the lambda times out
+		if c.IsDeadlineNear(ctx) {
+			return nextLogToken, ErrDeadlineNear
+		}
+
 		// reducers are not done yet
 		output, err := c.QueuesAPI.ReceiveMessage(ctx, params)
 		if err != nil {
@@ -352,13 +419,23 @@ func (c *Coordinator) AreReducersDone(ctx context.Context, nextLogToken *string)
 			break
 		}
 
-		// sleep for 5 seconds before trying to get more results
-		time.Sleep(1 * time.Second)
+		// sleep before trying to get more results
+		c.sleep()
 	}
 
 	messagesReceived := 0
 	// loop until all reducers are done
 	for doneReducersCount < int(c.NumQueues) {
+		// stop waiting if a reducer has failed
+		if err := c.CheckJobFailure(ctx, nextLogToken); err != nil {
+			return nil, err
+		}
+
+		// continue in a new invocation before the lambda times out
+		if c.IsDeadlineNear(ctx) {
+			return nextLogToken, ErrDeadlineNear
+		}
+
 		// reducers are not done yet
 		output, err := c.QueuesAPI.ReceiveMessage(ctx, params)
 		if err != nil {
@@ -373,6 +450,11 @@ func (c *Coordinator) AreReducersDone(ctx context.Context, nextLogToken *string)
 			}
 		}
 
+		if len(output.Messages) == 0 {
+			// sleep before trying to get more results
+			c.sleep()
+		}
+
 		// only log every 100 messages
 		if messagesReceived%100 == 0 {
 			if reducersCompleted, err := c.GetNumMessagesInQueue(ctx, queueURL); err == nil {
@@ -426,6 +508,135 @@ func (c *Coordinator) GetDoneObject(ctx context.Context, filename string) bool {
 	return true
 }
 
+// GetJobFailure reads the dead-letter queues of the job. Events of functions that failed
+// after all their retries are sent to the lambda dead-letter queue and messages that the
+// reducers couldn't process are sent to the messages dead-letter queue. It returns the
+// failure of the first message found or nil if both queues are empty
+func (c *Coordinator) GetJobFailure(ctx context.Context) (*JobFailure, error) {
+	dlqs := []struct {
+		name  string
+		parse func(string, sqsTypes.Message) *JobFailure
+	}{
+		{LambdaDLQName, ParseLambdaDLQMessage},
+		{MessagesDLQName, ParseMessagesDLQMessage},
+	}
+
+	for _, dlq := range dlqs {
+		queueName := fmt.Sprintf("%s-%s", c.JobID.String(), dlq.name)
+		queueURL := GetQueueURL(queueName, c.Region, c.AccountID, c.local)
+
+		// messages are not deleted so that they can be inspected
+		output, err := c.QueuesAPI.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              &queueURL,
+			MaxNumberOfMessages:   1,
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			// jobs that run in-process don't have a lambda dead-letter queue
+			var notFound *sqsTypes.QueueDoesNotExist
+			if errors.As(err, &notFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		if len(output.Messages) > 0 {
+			return dlq.parse(queueName, output.Messages[0]), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// CheckJobFailure checks the dead-letter queues of the job. If a function or message
+// has failed the failure is written to the job bucket and logged, and a JobFailedError
+// is returned so that the coordinator stops waiting
+func (c *Coordinator) CheckJobFailure(ctx context.Context, nextLogToken *string) error {
+	failure, err := c.GetJobFailure(ctx)
+	if err != nil || failure == nil {
+		return err
+	}
+
+	// encode failure to JSON
+	p, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.JobID.String()),
+		Key:    aws.String(FailedObject),
+		Body:   bytes.NewReader(p),
+	}
+	if _, err := c.UploaderAPI.Upload(ctx, input); err != nil {
+		return err
+	}
+
+	failedErr := &JobFailedError{Failure: failure}
+	c.LogEvent(ctx, failedErr.Error(), nextLogToken)
+
+	return failedErr
+}
+
+// WriteSuccessManifest writes the manifest listing the part files of the job next to
+// them in the output location. It is written once all the part files are in place
+func (c *Coordinator) WriteSuccessManifest(ctx context.Context, numParts int) error {
+	manifest := SuccessManifest{
+		JobID:  c.JobID,
+		Bucket: c.OutputBucket,
+		Parts:  make([]string, 0, numParts),
+	}
+	for i := 0; i < numParts; i++ {
+		manifest.Parts = append(manifest.Parts, GetOutputKey(c.OutputPrefix, GetPartName(i)))
+	}
+
+	// encode manifest to JSON
+	p, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	jsonContentType := "application/json"
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(c.OutputBucket),
+		Key:           aws.String(GetOutputKey(c.OutputPrefix, SuccessManifestName)),
+		Body:          bytes.NewReader(p),
+		ContentType:   &jsonContentType,
+		ContentLength: int64(len(p)),
+	}
+
+	_, err = c.UploaderAPI.Upload(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetOutputURI returns the S3 URI of the output location of the job
+func (c *Coordinator) GetOutputURI() string {
+	return fmt.Sprintf("s3://%s/%s", c.OutputBucket, c.OutputPrefix)
+}
+
+// DeleteSuccessManifest deletes the manifest left in the output location by a previous
+// job so that consumers don't read the part files while they are being overwritten
+func (c *Coordinator) DeleteSuccessManifest(ctx context.Context) error {
+	_, err := c.ObjectStoreAPI.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(c.OutputBucket),
+		Delete: &s3Types.Delete{
+			Objects: []s3Types.ObjectIdentifier{
+				{Key: aws.String(GetOutputKey(c.OutputPrefix, SuccessManifestName))},
+			},
+			Quiet: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // GetNumMessagesInQueue gets the approximate number of messages in a queue
 func (c *Coordinator) GetNumMessagesInQueue(ctx context.Context, queueURL string) (int, error) {
 	res, _ := c.QueuesAPI.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
@@ -590,3 +801,270 @@ func (c *Coordinator) GetMappings(ctx context.Context) ([]*Mapping, error) {
 
 	return mappings, nil
 }
+
+// HandleCoordinator runs the coordinator for the given request. It invokes the
+// mappers, waits for them to finish, invokes one reducer per queue and waits until
+// all reducers are done before marking the job as completed. If the lambda is about
+// to time out the coordinator saves its progress and continues in a new invocation
+func (c *Coordinator) HandleCoordinator(ctx context.Context, request CoordinatorInput, reducerName string) error {
+	// update coordinator
+	c.UpdateCoordinatorWithRequest(ctx, request)
+
+	// set coordinator logger
+	coordinatorLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": c.JobID.String(),
+	})
+
+	// a failed job is not resumed
+	if c.GetDoneObject(ctx, FailedObject) {
+		coordinatorLogger.Error("Job has failed")
+		return nil
+	}
+
+	// get the progress of previous invocations
+	if err := c.ReadState(ctx); err != nil {
+		coordinatorLogger.WithError(err).Error("Error reading coordinator state")
+		return err
+	}
+	nextLogToken := c.State.NextLogToken
+
+	if c.State.Phase == "" {
+		// log init
+		nextLogToken, _ = c.LogEvents(
+			ctx,
+			[]string{
+				"Coordinator starting...",
+				fmt.Sprintf("Waiting for %d mappers...", request.NumMappers),
+			},
+			nil, // empty token as it is the first log
+		)
+		c.State.Phase = CoordinatorPhaseMappers
+	}
+
+	if c.State.Phase == CoordinatorPhaseMappers {
+		// start mappers
+		err := c.StartMappers(ctx, request.NumQueues, request.FunctionName)
+		if err != nil {
+			coordinatorLogger.WithError(err).Error("Error starting the mappers")
+			return err
+		}
+
+		// waits until mappers are done
+		nextLogToken, err = c.AreMappersDone(ctx, nextLogToken)
+		if err != nil {
+			return c.handleWaitError(ctx, request, nextLogToken, err, coordinatorLogger, "Error reading mappers done queue")
+		}
+
+		// log mappers done
+		nextLogToken, _ = c.LogEvents(
+			ctx,
+			[]string{
+				"Mappers execution completed...",
+				fmt.Sprintf("Waiting for %d reducers...", request.NumQueues),
+			},
+			nextLogToken,
+		)
+		c.State.Phase = CoordinatorPhaseReducers
+	}
+
+	// remove the manifest of a previous job before the part files are overwritten
+	if err := c.DeleteSuccessManifest(ctx); err != nil {
+		coordinatorLogger.WithError(err).Error("Error deleting success manifest")
+		return err
+	}
+
+	// invoke reducers
+	if err := c.InvokeReducers(ctx, reducerName); err != nil {
+		coordinatorLogger.WithError(err).Error("Error invoking reducers")
+		return nil
+	}
+
+	// wait until reducers are done
+	nextLogToken, err := c.AreReducersDone(ctx, nextLogToken)
+	if err != nil {
+		return c.handleWaitError(ctx, request, nextLogToken, err, coordinatorLogger, "Error reading reducers done queue")
+	}
+
+	// list the part files written by the reducers
+	if err := c.WriteSuccessManifest(ctx, int(c.NumQueues)); err != nil {
+		coordinatorLogger.WithError(err).Error("Error writing success manifest")
+		return err
+	}
+
+	// log reducers done
+	nextLogToken, _ = c.LogEvents(
+		ctx,
+		[]string{
+			"Reducers execution completed...",
+			fmt.Sprintf(
+				"Job completed successfully, output is available at %s...",
+				c.GetOutputURI(),
+			),
+		},
+		nextLogToken,
+	)
+
+	// indicate reducers are done
+	if err := c.WriteDoneObject(ctx, "done"); err != nil {
+		coordinatorLogger.WithError(err).Error("Error writing done signal")
+		return err
+	}
+
+	return nil
+}
+
+// HandleRandomCoordinator runs the coordinator for jobs that use randomized partitions.
+// Once all reducers are done it invokes the final reducer and waits until
+// the final output has been written. If the lambda is about to time out the
+// coordinator saves its progress and continues in a new invocation
+func (c *Coordinator) HandleRandomCoordinator(
+	ctx context.Context,
+	request CoordinatorInput,
+	reducerName string,
+	finalReducerName string,
+) error {
+	// update coordinator
+	c.UpdateCoordinatorWithRequest(ctx, request)
+
+	// set coordinator logger
+	coordinatorLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": c.JobID.String(),
+	})
+
+	// a failed job is not resumed
+	if c.GetDoneObject(ctx, FailedObject) {
+		coordinatorLogger.Error("Job has failed")
+		return nil
+	}
+
+	// get the progress of previous invocations
+	if err := c.ReadState(ctx); err != nil {
+		coordinatorLogger.WithError(err).Error("Error reading coordinator state")
+		return err
+	}
+	nextLogToken := c.State.NextLogToken
+
+	if c.State.Phase == "" {
+		// log init
+		nextLogToken, _ = c.LogEvents(
+			ctx,
+			[]string{
+				"Coordinator starting...",
+				fmt.Sprintf("Waiting for %d mappers...", request.NumMappers),
+			},
+			nil, // empty token as it is the first log
+		)
+		c.State.Phase = CoordinatorPhaseMappers
+	}
+
+	if c.State.Phase == CoordinatorPhaseMappers {
+		// start mappers
+		err := c.StartMappers(ctx, request.NumQueues, request.FunctionName)
+		if err != nil {
+			coordinatorLogger.WithError(err).Error("Error starting the mappers")
+			return err
+		}
+
+		// waits until mappers are done
+		nextLogToken, err = c.AreMappersDone(ctx, nextLogToken)
+		if err != nil {
+			return c.handleWaitError(ctx, request, nextLogToken, err, coordinatorLogger, "Error reading mappers done queue")
+		}
+
+		// log mappers done
+		nextLogToken, _ = c.LogEvents(
+			ctx,
+			[]string{
+				"Mappers execution completed...",
+				fmt.Sprintf("Waiting for %d reducers...", request.NumQueues),
+			},
+			nextLogToken,
+		)
+		c.State.Phase = CoordinatorPhaseReducers
+	}
+
+	if c.State.Phase == CoordinatorPhaseReducers {
+		// remove the manifest of a previous job before the part files are overwritten
+		if err := c.DeleteSuccessManifest(ctx); err != nil {
+			coordinatorLogger.WithError(err).Error("Error deleting success manifest")
+			return err
+		}
+
+		// invoke reducers
+		if err := c.InvokeReducers(ctx, reducerName); err != nil {
+			coordinatorLogger.WithError(err).Error("Error invoking reducers")
+			return nil
+		}
+
+		// wait until reducers are done
+		var err error
+		nextLogToken, err = c.AreReducersDone(ctx, nextLogToken)
+		if err != nil {
+			return c.handleWaitError(ctx, request, nextLogToken, err, coordinatorLogger, "Error reading reducers done queue")
+		}
+
+		// log reducers done
+		nextLogToken, _ = c.LogEvents(
+			ctx,
+			[]string{
+				"Reducers execution completed...",
+				"Waiting for final reducer...",
+			},
+			nextLogToken,
+		)
+		c.State.Phase = CoordinatorPhaseFinalReducer
+	}
+
+	// invoke final reducer
+	if err := c.InvokeReducer(ctx, finalReducerName); err != nil {
+		coordinatorLogger.WithError(err).Error("Error invoking final reducer")
+		return nil
+	}
+
+	// wait until the final reducer writes the job output
+	for !c.GetDoneObject(ctx, FinalReducerDoneObject) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// stop waiting if the final reducer has failed
+		if err := c.CheckJobFailure(ctx, nextLogToken); err != nil {
+			return c.handleWaitError(ctx, request, nextLogToken, err, coordinatorLogger, "Error checking job failure")
+		}
+
+		// continue in a new invocation before the lambda times out
+		if c.IsDeadlineNear(ctx) {
+			return c.handleWaitError(ctx, request, nextLogToken, ErrDeadlineNear, coordinatorLogger, "")
+		}
+
+		// sleep before trying to get the object
+		c.sleep()
+	}
+
+	// list the part file written by the final reducer
+	if err := c.WriteSuccessManifest(ctx, 1); err != nil {
+		coordinatorLogger.WithError(err).Error("Error writing success manifest")
+		return err
+	}
+
+	// log job done
+	nextLogToken, _ = c.LogEvents(
+		ctx,
+		[]string{
+			"Final reducer execution completed...",
+			fmt.Sprintf(
+				"Job completed successfully, output is available at %s...",
+				c.GetOutputURI(),
+			),
+		},
+		nextLogToken,
+	)
+
+	// indicate job is done
+	if err := c.WriteDoneObject(ctx, "done"); err != nil {
+		coordinatorLogger.WithError(err).Error("Error writing done signal")
+		return err
+	}
+
+	return nil
+}
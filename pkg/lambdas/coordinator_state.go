@@ -0,0 +1,191 @@
+package lambdas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CoordinatorStateObject is written to the job bucket with the progress of the
+	// coordinator before it continues in a new invocation
+	CoordinatorStateObject = "coordinator-state"
+
+	// DefaultContinuationMargin is the time left before the lambda deadline at
+	// which the coordinator stops waiting and invokes itself again
+	DefaultContinuationMargin = 1 * time.Minute
+
+	// DefaultPollInterval is the time the coordinator sleeps
+	// before it checks again the functions it waits for
+	DefaultPollInterval = 1 * time.Second
+)
+
+// ErrDeadlineNear is returned by the coordinator wait functions when the lambda
+// is about to time out and the coordinator needs to continue in a new invocation
+var ErrDeadlineNear = errors.New("Coordinator deadline is near")
+
+// CoordinatorPhase is the step of the job the coordinator is waiting for
+type CoordinatorPhase string
+
+const (
+	CoordinatorPhaseMappers      CoordinatorPhase = "mappers"
+	CoordinatorPhaseReducers     CoordinatorPhase = "reducers"
+	CoordinatorPhaseFinalReducer CoordinatorPhase = "final-reducer"
+)
+
+// CoordinatorState is the progress of the coordinator. It is saved to the job bucket
+// so that a new invocation of the coordinator resumes where the last one stopped
+type CoordinatorState struct {
+	Phase        CoordinatorPhase `json:"phase,omitempty"`
+	DoneMappers  map[string]bool  `json:"doneMappers"`
+	DoneReducers map[string]bool  `json:"doneReducers"`
+	NextLogToken *string          `json:"nextLogToken,omitempty"`
+	Invocations  int              `json:"invocations"`
+}
+
+// NewCoordinatorState returns the state of a coordinator that hasn't started the job
+func NewCoordinatorState() *CoordinatorState {
+	return &CoordinatorState{
+		DoneMappers:  make(map[string]bool),
+		DoneReducers: make(map[string]bool),
+	}
+}
+
+// ReadState reads the state saved by a previous invocation of the coordinator.
+// The coordinator starts with an empty state if there is no saved state
+func (c *Coordinator) ReadState(ctx context.Context) error {
+	bucket := c.JobID.String()
+
+	output, err := c.ObjectStoreAPI.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    aws.String(CoordinatorStateObject),
+	})
+	if err != nil {
+		var noSuchKey *s3Types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			c.State = NewCoordinatorState()
+			return nil
+		}
+		return err
+	}
+	defer output.Body.Close()
+
+	state := NewCoordinatorState()
+	if err := json.NewDecoder(output.Body).Decode(state); err != nil {
+		return err
+	}
+	c.State = state
+
+	return nil
+}
+
+// WriteState saves the state of the coordinator to the job bucket
+func (c *Coordinator) WriteState(ctx context.Context) error {
+	// encode state to JSON
+	p, err := json.Marshal(c.State)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.JobID.String()),
+		Key:    aws.String(CoordinatorStateObject),
+		Body:   bytes.NewReader(p),
+	}
+	if _, err := c.UploaderAPI.Upload(ctx, input); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsDeadlineNear returns true if the lambda running the coordinator times out in less
+// than the continuation margin. Coordinators without a margin, like the in-process one
+// where the deadline applies to the whole job, never continue in a new invocation
+func (c *Coordinator) IsDeadlineNear(ctx context.Context) bool {
+	return isDeadlineNear(ctx, c.ContinuationMargin)
+}
+
+// sleep waits for the poll interval of the coordinator
+func (c *Coordinator) sleep() {
+	pollInterval := c.PollInterval
+	if pollInterval == 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	time.Sleep(pollInterval)
+}
+
+// Continue saves the state of the coordinator and invokes the coordinator again
+// with the same request so that the job continues in a new invocation
+func (c *Coordinator) Continue(ctx context.Context, request CoordinatorInput, nextLogToken *string) error {
+	// save progress
+	c.State.NextLogToken = nextLogToken
+	c.State.Invocations++
+	if err := c.WriteState(ctx); err != nil {
+		return err
+	}
+
+	requestPayload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.FaasAPI.Invoke(
+		ctx,
+		&lambda.InvokeInput{
+			FunctionName:   aws.String(c.FunctionArn),
+			Payload:        requestPayload,
+			InvocationType: types.InvocationTypeEvent,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// error is ignored from asynch invokation and result only holds the status code
+	if result.StatusCode != 202 { //SUCCESS_CODE
+		return errors.New("Error invoking coordinator")
+	}
+
+	return nil
+}
+
+// handleWaitError handles the error returned while the coordinator waits for the functions
+// of the job. The coordinator continues in a new invocation if its deadline is near and
+// it stops without error if the job has failed since retrying it doesn't help
+func (c *Coordinator) handleWaitError(
+	ctx context.Context,
+	request CoordinatorInput,
+	nextLogToken *string,
+	err error,
+	coordinatorLogger *logrus.Entry,
+	message string,
+) error {
+	if errors.Is(err, ErrDeadlineNear) {
+		coordinatorLogger.Info("Continuing coordinator in a new invocation")
+		if err := c.Continue(ctx, request, nextLogToken); err != nil {
+			coordinatorLogger.WithError(err).Error("Error continuing coordinator")
+			return err
+		}
+		return nil
+	}
+
+	var failedErr *JobFailedError
+	if errors.As(err, &failedErr) {
+		coordinatorLogger.WithError(err).Error("Job failed")
+		return nil
+	}
+
+	coordinatorLogger.WithError(err).Error(message)
+	return err
+}
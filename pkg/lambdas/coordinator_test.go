@@ -4,17 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/logs"
+	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/queues"
 	"github.com/josenarvaezp/displ/mocks"
 	"github.com/josenarvaezp/displ/pkg/lambdas"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -116,3 +125,209 @@ func Test_InvokeReducers_HappyPath(t *testing.T) {
 	err = coordinator.InvokeReducers(ctx, reducerName)
 	assert.Nil(t, err)
 }
+
+func Test_AreMappersDone_Failure(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(jobID.String())})
+	require.Nil(t, err)
+
+	memoryQueues := queues.NewMemoryQueues()
+	queueURLs := map[string]*string{}
+	for _, name := range []string{"mappers-done", "lambda-dlq", "messages-dlq"} {
+		output, err := memoryQueues.CreateQueue(ctx, &sqs.CreateQueueInput{
+			QueueName: aws.String(fmt.Sprintf("%s-%s", jobID.String(), name)),
+		})
+		require.Nil(t, err)
+		queueURLs[name] = output.QueueUrl
+	}
+
+	coordinator := &lambdas.Coordinator{
+		JobID:          jobID,
+		Region:         "eu-west-2",
+		AccountID:      "000000000000",
+		NumMappers:     2,
+		QueuesAPI:      memoryQueues,
+		UploaderAPI:    store,
+		ObjectStoreAPI: store,
+		LogsAPI:        logs.NewLocalLogs(),
+	}
+
+	// one mapper is done and the other one failed
+	_, err = memoryQueues.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    queueURLs["mappers-done"],
+		MessageBody: aws.String(uuid.New().String()),
+	})
+	require.Nil(t, err)
+
+	mapping := lambdas.NewMapping()
+	payload, err := json.Marshal(lambdas.MapperInput{JobID: jobID, Mapping: *mapping, NumQueues: 2})
+	require.Nil(t, err)
+	_, err = memoryQueues.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    queueURLs["lambda-dlq"],
+		MessageBody: aws.String(string(payload)),
+		MessageAttributes: map[string]sqsTypes.MessageAttributeValue{
+			"RequestID":    {DataType: aws.String("String"), StringValue: aws.String("request-1")},
+			"ErrorCode":    {DataType: aws.String("String"), StringValue: aws.String("200")},
+			"ErrorMessage": {DataType: aws.String("String"), StringValue: aws.String("runtime error: index out of range")},
+		},
+	})
+	require.Nil(t, err)
+
+	_, err = coordinator.AreMappersDone(ctx, nil)
+	var failedErr *lambdas.JobFailedError
+	require.True(t, errors.As(err, &failedErr))
+
+	expectedFailure := &lambdas.JobFailure{
+		Queue:     fmt.Sprintf("%s-lambda-dlq", jobID.String()),
+		Function:  "Mapper",
+		MappingID: mapping.MapID.String(),
+		RequestID: "request-1",
+		Error:     "runtime error: index out of range",
+	}
+	assert.Equal(t, expectedFailure, failedErr.Failure)
+
+	// the failure is written to the job bucket
+	output, err := store.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(jobID.String()),
+		Key:    aws.String(lambdas.FailedObject),
+	})
+	require.Nil(t, err)
+	var failure lambdas.JobFailure
+	require.Nil(t, json.NewDecoder(output.Body).Decode(&failure))
+	assert.Equal(t, expectedFailure, &failure)
+}
+
+func Test_GetJobFailure_MessagesDLQ(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+
+	// jobs without a lambda dead-letter queue only check the messages one
+	memoryQueues := queues.NewMemoryQueues()
+	output, err := memoryQueues.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("%s-messages-dlq", jobID.String())),
+	})
+	require.Nil(t, err)
+
+	coordinator := &lambdas.Coordinator{
+		JobID:     jobID,
+		Region:    "eu-west-2",
+		AccountID: "000000000000",
+		QueuesAPI: memoryQueues,
+	}
+
+	failure, err := coordinator.GetJobFailure(ctx)
+	require.Nil(t, err)
+	assert.Nil(t, failure)
+
+	mapID := uuid.New().String()
+	_, err = memoryQueues.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    output.QueueUrl,
+		MessageBody: aws.String(`{"Sum":"1"}`),
+		MessageAttributes: map[string]sqsTypes.MessageAttributeValue{
+			lambdas.MapIDAttribute:   {DataType: aws.String("String"), StringValue: aws.String(mapID)},
+			lambdas.BatchIDAttribute: {DataType: aws.String("Number"), StringValue: aws.String("3")},
+		},
+	})
+	require.Nil(t, err)
+
+	failure, err = coordinator.GetJobFailure(ctx)
+	require.Nil(t, err)
+	require.NotNil(t, failure)
+	assert.Equal(t, mapID, failure.MappingID)
+	assert.Equal(t, "Reducer", failure.Function)
+	assert.Equal(t, "message of batch 3 could not be processed by the reducer", failure.Error)
+}
+
+func Test_HandleCoordinator_Continuation(t *testing.T) {
+	jobID := uuid.New()
+	functionARN := "arn:aws:lambda:eu-west-2:000000000000:function:coordinator"
+	lambdaCtx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		InvokedFunctionArn: functionARN,
+	})
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(lambdaCtx, &s3.CreateBucketInput{Bucket: aws.String(jobID.String())})
+	require.Nil(t, err)
+
+	memoryQueues := queues.NewMemoryQueues()
+	queueURLs := map[string]*string{}
+	for _, name := range []string{"mappers-done", "reducers-done", "lambda-dlq", "messages-dlq"} {
+		output, err := memoryQueues.CreateQueue(lambdaCtx, &sqs.CreateQueueInput{
+			QueueName: aws.String(fmt.Sprintf("%s-%s", jobID.String(), name)),
+		})
+		require.Nil(t, err)
+		queueURLs[name] = output.QueueUrl
+	}
+
+	request := lambdas.CoordinatorInput{
+		JobID:      jobID,
+		NumMappers: 2,
+		NumQueues:  1,
+	}
+	requestPayload, err := json.Marshal(request)
+	require.Nil(t, err)
+
+	// the coordinator invokes itself with the same request
+	lambdaMock := new(mocks.FaasAPI)
+	lambdaMock.On("Invoke", mock.Anything, &lambda.InvokeInput{
+		FunctionName:   aws.String(functionARN),
+		Payload:        requestPayload,
+		InvocationType: types.InvocationTypeEvent,
+	}).Return(&lambda.InvokeOutput{StatusCode: int32(202)}, nil).Once()
+
+	newCoordinator := func() *lambdas.Coordinator {
+		return &lambdas.Coordinator{
+			Region:             "eu-west-2",
+			QueuesAPI:          memoryQueues,
+			FaasAPI:            lambdaMock,
+			UploaderAPI:        store,
+			ObjectStoreAPI:     store,
+			LogsAPI:            logs.NewLocalLogs(),
+			ContinuationMargin: time.Hour,
+		}
+	}
+
+	// a previous invocation started the mappers and saw the first mapper finish
+	coordinator := newCoordinator()
+	coordinator.JobID = jobID
+	coordinator.State = lambdas.NewCoordinatorState()
+	coordinator.State.Phase = lambdas.CoordinatorPhaseMappers
+	coordinator.State.DoneMappers["mapper-1"] = true
+	require.Nil(t, coordinator.WriteState(lambdaCtx))
+	require.Nil(t, coordinator.WriteDoneObject(lambdaCtx, "mappers-invoked"))
+
+	// the lambda times out within the continuation margin
+	deadlineCtx, cancel := context.WithTimeout(lambdaCtx, time.Minute)
+	defer cancel()
+	err = coordinator.HandleCoordinator(deadlineCtx, request, "reducer")
+	require.Nil(t, err)
+	lambdaMock.AssertExpectations(t)
+
+	// the progress is saved for the next invocation
+	require.Nil(t, coordinator.ReadState(lambdaCtx))
+	assert.Equal(t, lambdas.CoordinatorPhaseMappers, coordinator.State.Phase)
+	assert.Equal(t, map[string]bool{"mapper-1": true}, coordinator.State.DoneMappers)
+	assert.Equal(t, 1, coordinator.State.Invocations)
+	assert.False(t, coordinator.GetDoneObject(lambdaCtx, "done"))
+
+	// the second mapper and the reducer finish
+	_, err = memoryQueues.SendMessage(lambdaCtx, &sqs.SendMessageInput{
+		QueueUrl:    queueURLs["mappers-done"],
+		MessageBody: aws.String("mapper-2"),
+	})
+	require.Nil(t, err)
+	require.Nil(t, coordinator.WriteDoneObject(lambdaCtx, "reducers-invoked"))
+	_, err = memoryQueues.SendMessage(lambdaCtx, &sqs.SendMessageInput{
+		QueueUrl:    queueURLs["reducers-done"],
+		MessageBody: aws.String("reducer-1"),
+	})
+	require.Nil(t, err)
+
+	// the next invocation resumes waiting for the mappers and completes the job
+	err = newCoordinator().HandleCoordinator(lambdaCtx, request, "reducer")
+	require.Nil(t, err)
+	assert.True(t, coordinator.GetDoneObject(lambdaCtx, "done"))
+}
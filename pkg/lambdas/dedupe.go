@@ -46,11 +46,14 @@ func (d *Dedupe) BatchExists(mapID string, batchID int) bool {
 // IsBatchComplete checks if the reducer has processed
 // the maximum amount of message a batch can have
 func (d *Dedupe) IsBatchComplete(mapID string, batchID int) bool {
-	writeMapProcessedCount := d.WriteMap[mapID][batchID].ProcessedCount
+	writeMapProcessedCount := 0
+	if writeBatch, ok := d.WriteMap[mapID][batchID]; ok {
+		writeMapProcessedCount = writeBatch.ProcessedCount
+	}
 
 	readMapProcessedCount := 0
-	if _, ok := d.ReadMap[mapID]; ok {
-		readMapProcessedCount = d.ReadMap[mapID][batchID].ProcessedCount
+	if readBatch, ok := d.ReadMap[mapID][batchID]; ok {
+		readMapProcessedCount = readBatch.ProcessedCount
 	}
 
 	if writeMapProcessedCount+readMapProcessedCount == MaxItemsPerBatch {
@@ -62,11 +65,15 @@ func (d *Dedupe) IsBatchComplete(mapID string, batchID int) bool {
 
 // IsMessageProcessed returns true if the message has been processed
 func (d *Dedupe) IsMessageProcessed(mapID string, batchID int, mesageID int) bool {
-	if _, ok := d.ReadMap[mapID]; ok {
-		return d.WriteMap[mapID][batchID].Processed[mesageID] || d.ReadMap[mapID][batchID].Processed[mesageID]
+	if writeBatch, ok := d.WriteMap[mapID][batchID]; ok && writeBatch.Processed[mesageID] {
+		return true
+	}
+
+	if readBatch, ok := d.ReadMap[mapID][batchID]; ok && readBatch.Processed[mesageID] {
+		return true
 	}
 
-	return d.WriteMap[mapID][batchID].Processed[mesageID]
+	return false
 }
 
 // GetProcessedMessages gets the dedupe data for the specific map and batch.
@@ -92,6 +99,16 @@ func (d *Dedupe) GetProcessedMessages(mapID string, batchID int) (DedupeProcesse
 // UpdateMessageProcessed updates the dedupe map to register the
 // given message as registered
 func (d *Dedupe) UpdateMessageProcessed(mapID string, batchID int, mesageID int) {
+	// the batch may only be in the read map if it was started before the last checkpoint
+	if _, ok := d.WriteMap[mapID]; !ok {
+		d.WriteMap[mapID] = make(map[int]*DedupeProcessedMessages)
+	}
+	if _, ok := d.WriteMap[mapID][batchID]; !ok {
+		d.WriteMap[mapID][batchID] = &DedupeProcessedMessages{
+			Processed: make(map[int]bool),
+		}
+	}
+
 	d.WriteMap[mapID][batchID].Processed[mesageID] = true
 	d.WriteMap[mapID][batchID].ProcessedCount++
 }
@@ -100,13 +117,62 @@ func (d *Dedupe) UpdateMessageProcessed(mapID string, batchID int, mesageID int)
 // this should be called if IsBatchComplete() returns true
 // and it is used to save memory space
 func (d *Dedupe) DeletedProcessedMessages(mapID string, batchID int) {
-	d.WriteMap[mapID][batchID].Processed = nil
+	if writeBatch, ok := d.WriteMap[mapID][batchID]; ok {
+		writeBatch.Processed = nil
+	}
+	if readBatch, ok := d.ReadMap[mapID][batchID]; ok {
+		readBatch.Processed = nil
+	}
+}
+
+// AddCheckpoint adds the dedupe data saved in a checkpoint to the read map. A batch
+// can be in more than one checkpoint if its messages were processed before and after
+// a checkpoint so its processed messages are added to the ones already read
+func (d *Dedupe) AddCheckpoint(checkpoint DedupeMap) {
+	for mapperID, batchMap := range checkpoint {
+		if _, ok := d.ReadMap[mapperID]; !ok {
+			d.ReadMap[mapperID] = make(map[int]*DedupeProcessedMessages)
+		}
+
+		for batchID, dedupeMessages := range batchMap {
+			readBatch, ok := d.ReadMap[mapperID][batchID]
+			if !ok {
+				d.ReadMap[mapperID][batchID] = dedupeMessages
+				continue
+			}
+
+			readBatch.ProcessedCount = readBatch.ProcessedCount + dedupeMessages.ProcessedCount
+			if readBatch.ProcessedCount == MaxItemsPerBatch {
+				readBatch.Processed = nil
+			} else {
+				readBatch.Processed = mergeBoolMaps(dedupeMessages.Processed, readBatch.Processed)
+			}
+		}
+	}
+}
+
+// CompleteBatches returns the number of batches in the read map that have been processed
+func (d *Dedupe) CompleteBatches() int {
+	completeBatches := 0
+	for _, batchMap := range d.ReadMap {
+		for _, dedupeMessages := range batchMap {
+			if dedupeMessages.ProcessedCount == MaxItemsPerBatch {
+				completeBatches++
+			}
+		}
+	}
+
+	return completeBatches
 }
 
 // Merge is used to merge the read and write maps into the read map
 func (d *Dedupe) Merge() {
 	// update read map with write map values
 	for mapperID, batchMap := range d.WriteMap {
+		if _, ok := d.ReadMap[mapperID]; !ok {
+			d.ReadMap[mapperID] = make(map[int]*DedupeProcessedMessages)
+		}
+
 		for batchID, dedupeMessages := range batchMap {
 			d.ReadMap[mapperID][batchID] = dedupeMessages
 		}
@@ -132,6 +198,10 @@ type DedupeProcessedMessages struct {
 
 // mergeBoolMaps is a helper function to merge the input map into the output map
 func mergeBoolMaps(input, output map[int]bool) map[int]bool {
+	if output == nil {
+		output = make(map[int]bool)
+	}
+
 	for k, v := range input {
 		output[k] = v
 	}
@@ -170,6 +240,13 @@ func (d *DedupeSimple) Merge() {
 	}
 }
 
+// AddCheckpoint adds the dedupe data saved in a checkpoint to the read map
+func (d *DedupeSimple) AddCheckpoint(checkpoint DedupeSimpleMap) {
+	for messageID, processed := range checkpoint {
+		d.ReadMap[messageID] = processed
+	}
+}
+
 // UpdateMessageProcessed updates the dedupe map to register the
 // given message as registered
 func (d *DedupeSimple) UpdateMessageProcessed(mesageID string) {
@@ -0,0 +1,196 @@
+package lambdas
+
+import (
+	"encoding/json"
+	"fmt"
+
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+
+	"github.com/josenarvaezp/displ/internal/objectstore"
+)
+
+const (
+	// FailedObject is written to the job bucket with the failure that stopped the job
+	FailedObject = "failed"
+	// SkippedSplitsPrefix is the prefix of the objects written to the job bucket
+	// with the errors of the splits skipped by the mappers
+	SkippedSplitsPrefix = "skipped-splits/"
+
+	// names of the dead-letter queues of the job
+	LambdaDLQName   = "lambda-dlq"
+	MessagesDLQName = "messages-dlq"
+
+	// attributes lambda adds to the events sent to the dead-letter queue
+	requestIDAttribute    = "RequestID"
+	errorCodeAttribute    = "ErrorCode"
+	errorMessageAttribute = "ErrorMessage"
+)
+
+// JobFailure describes a function or message that failed and stopped the job
+type JobFailure struct {
+	Queue     string `json:"queue"`
+	Function  string `json:"function,omitempty"`
+	MappingID string `json:"mappingID,omitempty"`
+	ReducerID string `json:"reducerID,omitempty"`
+	RequestID string `json:"requestID,omitempty"`
+	Error     string `json:"error"`
+}
+
+// Message returns a description of the failure
+func (f *JobFailure) Message() string {
+	switch {
+	case f.MappingID != "" && f.Function != "":
+		return fmt.Sprintf("%s for mapping %s failed: %s", f.Function, f.MappingID, f.Error)
+	case f.MappingID != "":
+		return fmt.Sprintf("Mapping %s failed: %s", f.MappingID, f.Error)
+	case f.ReducerID != "":
+		return fmt.Sprintf("%s %s failed: %s", f.Function, f.ReducerID, f.Error)
+	case f.Function != "":
+		return fmt.Sprintf("%s failed: %s", f.Function, f.Error)
+	default:
+		return f.Error
+	}
+}
+
+// MapperFailurePolicy decides what happens to the job when
+// the map function fails on a split of its input
+type MapperFailurePolicy string
+
+const (
+	// FailJob stops the job when the map function fails on a split
+	FailJob MapperFailurePolicy = "fail"
+	// SkipFailedSplits skips the splits where the map function fails, the rest of
+	// the job runs and the errors are written to the job bucket to be reported
+	SkipFailedSplits MapperFailurePolicy = "skip"
+)
+
+// Validate checks that the policy is known, the default policy is FailJob
+func (p MapperFailurePolicy) Validate() error {
+	switch p {
+	case "", FailJob, SkipFailedSplits:
+		return nil
+	default:
+		return fmt.Errorf("Invalid mapper failure policy %q, it must be fail or skip", string(p))
+	}
+}
+
+// MapperError is the error returned by the map function on a split, which
+// is the range of an object in a mapping. The errors of skipped splits are
+// written to the job bucket
+type MapperError struct {
+	MappingID   string `json:"mappingID"`
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	InitialByte int64  `json:"initialByte,string"`
+	FinalByte   int64  `json:"finalByte,string"`
+	// Message is the error of the map function
+	Message string `json:"error"`
+	Err     error  `json:"-"`
+}
+
+// NewMapperError creates the error of the map function on the range of the object
+func NewMapperError(mappingID uuid.UUID, object objectstore.ObjectRange, err error) *MapperError {
+	return &MapperError{
+		MappingID:   mappingID.String(),
+		Bucket:      object.Bucket,
+		Key:         object.Key,
+		InitialByte: object.InitialByte,
+		FinalByte:   object.FinalByte,
+		Message:     err.Error(),
+		Err:         err,
+	}
+}
+
+func (e *MapperError) Error() string {
+	return fmt.Sprintf(
+		"Map function failed on bytes %d-%d of s3://%s/%s in mapping %s: %s",
+		e.InitialByte,
+		e.FinalByte,
+		e.Bucket,
+		e.Key,
+		e.MappingID,
+		e.Message,
+	)
+}
+
+func (e *MapperError) Unwrap() error {
+	return e.Err
+}
+
+// JobFailedError is returned when the job has been stopped by a failure
+type JobFailedError struct {
+	Failure *JobFailure
+}
+
+func (e *JobFailedError) Error() string {
+	return fmt.Sprintf("Job failed: %s", e.Failure.Message())
+}
+
+// ParseLambdaDLQMessage gets the failure from an event that lambda sent to the dead-letter
+// queue after all the retries of an asynchronous invocation failed. The body of the
+// message is the payload of the invocation and the error is in the message attributes
+func ParseLambdaDLQMessage(queueName string, message sqsTypes.Message) *JobFailure {
+	failure := &JobFailure{
+		Queue:     queueName,
+		RequestID: stringAttribute(message, requestIDAttribute),
+		Error:     stringAttribute(message, errorMessageAttribute),
+	}
+	if failure.Error == "" {
+		failure.Error = stringAttribute(message, errorCodeAttribute)
+	}
+	if failure.Error == "" {
+		failure.Error = "Function invocation failed"
+	}
+
+	// the payload tells which function failed
+	var payload struct {
+		Mapping        *Mapping  `json:"mapping"`
+		ReducerID      uuid.UUID `json:"reducerID"`
+		QueuePartition int       `json:"queuePartition"`
+		NumQueues      int       `json:"numQueues"`
+	}
+	if message.Body == nil || json.Unmarshal([]byte(*message.Body), &payload) != nil {
+		return failure
+	}
+
+	switch {
+	case payload.Mapping != nil:
+		failure.Function = "Mapper"
+		failure.MappingID = payload.Mapping.MapID.String()
+	case payload.ReducerID != uuid.Nil:
+		failure.Function = fmt.Sprintf("Reducer for partition %d", payload.QueuePartition)
+		failure.ReducerID = payload.ReducerID.String()
+	case payload.NumQueues != 0:
+		failure.Function = "Coordinator"
+	}
+
+	return failure
+}
+
+// ParseMessagesDLQMessage gets the failure from a message that a reducer received
+// too many times without processing it and was moved to the dead-letter queue
+func ParseMessagesDLQMessage(queueName string, message sqsTypes.Message) *JobFailure {
+	failure := &JobFailure{
+		Queue:     queueName,
+		Function:  "Reducer",
+		MappingID: stringAttribute(message, MapIDAttribute),
+		Error:     "message could not be processed by the reducer",
+	}
+
+	if batchID := stringAttribute(message, BatchIDAttribute); batchID != "" {
+		failure.Error = fmt.Sprintf("message of batch %s could not be processed by the reducer", batchID)
+	}
+
+	return failure
+}
+
+// stringAttribute returns the value of a message attribute or an empty string
+func stringAttribute(message sqsTypes.Message, name string) string {
+	attribute, ok := message.MessageAttributes[name]
+	if !ok || attribute.StringValue == nil {
+		return ""
+	}
+
+	return *attribute.StringValue
+}
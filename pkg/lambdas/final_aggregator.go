@@ -0,0 +1,247 @@
+package lambdas
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/sirupsen/logrus"
+
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+)
+
+// HandleFinalMapAggregator runs the final reducer for jobs that use randomized partitions.
+// It reduces the values sent by every reducer and writes the job output.
+// The filter and sort functions are optional and are skipped if nil
+func (r *Reducer) HandleFinalMapAggregator(
+	ctx context.Context,
+	request ReducerInput,
+	filter func(aggregators.MapAggregator) aggregators.MapAggregator,
+	sortOutput func(aggregators.MapAggregator) sort.Interface,
+) error {
+	// update reducer
+	if err := r.UpdateReducerWithRequest(ctx, request); err != nil {
+		return err
+	}
+
+	// get reduce queue information
+	queueName := fmt.Sprintf("%s-%s", r.JobID.String(), "final-aggregator")
+	queueURL := GetQueueURL(queueName, r.Region, r.AccountID, r.Local)
+
+	// set reducer logger
+	reducerLogger := logrus.WithFields(logrus.Fields{
+		"Job ID":          r.JobID.String(),
+		"Reducer ID":      r.ReducerID.String(),
+		"Queue Partition": r.QueuePartition,
+	})
+
+	// set wait group
+	var wg sync.WaitGroup
+
+	// get checkpoint data
+	checkpointData, err := r.GetCheckpointData(ctx, &wg)
+	if err != nil {
+		reducerLogger.WithError(err).Error("Error reading checkpoint")
+		return err
+	}
+	// checkpoint info
+	processedMessagesWithoutCheckpoint := 0
+	checkpointData.LastCheckpoint++
+
+	// number of messages the reducer needs to process - once per reducer
+	// it is in the request if a previous invocation has already read it
+	totalMessagesToProcess := &request.NumBatches
+	if request.NumBatches == 0 {
+		totalMessagesToProcess, err = r.GetNumberOfMessagesToProcessFinalAggregator(ctx, request.NumReducers)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error getting queue metadata")
+			return err
+		}
+	}
+	// messages processed before the last checkpoint
+	totalProcessedMessages := len(r.DedupeSimple.ReadMap)
+
+	// processedMessagesDeleteInfo holds the data to delete messages from queue
+	processedMessagesDeleteInfo := make([]sqsTypes.DeleteMessageBatchRequestEntry, 0, MaxMessagesWithoutCheckpoint)
+
+	// holds the intermediate results
+	intermediateOutput := make(aggregators.MapAggregator)
+
+	// use same parameters for all get messages requests
+	recieveMessageParams := &sqs.ReceiveMessageInput{
+		QueueUrl:            &queueURL,
+		MaxNumberOfMessages: MaxItemsPerBatch,
+		MessageAttributeNames: []string{
+			MessageIDAttribute,
+		},
+		WaitTimeSeconds: int32(5),
+	}
+
+	// recieve messages until we are done processing all queue
+	for totalProcessedMessages != *totalMessagesToProcess {
+		// hand over to a new invocation before the lambda times out
+		if r.IsDeadlineNear(ctx) {
+			// wait in case reducers is saving checkpoint in the background
+			wg.Wait()
+
+			// save the messages processed since the last checkpoint
+			err := r.SaveCheckpoint(
+				ctx,
+				queueURL,
+				checkpointData.LastCheckpoint,
+				intermediateOutput,
+				r.DedupeSimple.WriteMap,
+				processedMessagesDeleteInfo,
+			)
+			if err != nil {
+				reducerLogger.WithError(err).Error("Error saving checkpoint")
+				return err
+			}
+
+			if err := r.Continue(ctx, request, *totalMessagesToProcess); err != nil {
+				reducerLogger.WithError(err).Error("Error continuing reducer")
+				return err
+			}
+
+			reducerLogger.Info("Reducer continues in a new invocation")
+			return nil
+		}
+
+		if processedMessagesWithoutCheckpoint == MaxMessagesBeforeCheckpointComplete && checkpointData.LastCheckpoint != 1 {
+			// check that the last checkpoint has completed before processing any more messages
+			// we give a buffer of 15,000 new messages for saving the checkpoint which happens
+			// in the background. If this point is reached it means we have processed 115,000 messages
+			// without deleting from the queue which is close to the aws limit for queues
+			wg.Wait()
+		}
+
+		if processedMessagesWithoutCheckpoint == MaxMessagesWithoutCheckpoint {
+			// We need to delete the messages read from the sqs queue and we create a checkpoint
+			// in S3 as the fault tolerant mechanism. Saving the checkpoint can be done concurrently
+			// in the background while we keep processing messages
+
+			// merge the dedupe map so that the read dedupe map is up to date
+			r.DedupeSimple.Merge()
+
+			// save intermediate dedupe
+			wg.Add(1)
+			go r.SaveIntermediateDedupe(ctx, checkpointData.LastCheckpoint, r.DedupeSimple.ReadMap, &wg)
+
+			// save intermediate map
+			wg.Add(1)
+			go r.SaveIntermediateOutput(ctx, intermediateOutput, checkpointData.LastCheckpoint, &wg)
+
+			// update output map with reduced intermediate results
+			wg.Add(1)
+			go r.Output.UpdateOutput(intermediateOutput, &wg)
+
+			// delete all messages from queue
+			wg.Add(1)
+			go r.DeleteIntermediateMessagesFromQueue(ctx, queueURL, processedMessagesDeleteInfo, &wg)
+
+			// update checkpoint info
+			checkpointData.LastCheckpoint++
+			processedMessagesWithoutCheckpoint = 0
+			processedMessagesDeleteInfo = make([]sqsTypes.DeleteMessageBatchRequestEntry, 0, MaxMessagesWithoutCheckpoint)
+			intermediateOutput = make(aggregators.MapAggregator)
+			r.DedupeSimple.WriteMap = InitDedupeSimpleMap()
+		}
+
+		// call sqs receive messages
+		output, err := r.QueuesAPI.ReceiveMessage(ctx, recieveMessageParams)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error reading from queue")
+			return err
+		}
+
+		// process messages
+		for _, message := range output.Messages {
+			processedMessagesWithoutCheckpoint++
+
+			// add delete info
+			processedMessagesDeleteInfo = append(processedMessagesDeleteInfo, sqsTypes.DeleteMessageBatchRequestEntry{
+				Id:            message.MessageId,
+				ReceiptHandle: message.ReceiptHandle,
+			})
+
+			// get message attributes
+			currentMessageID := *message.MessageAttributes[MessageIDAttribute].StringValue
+
+			// check if message has already been processed
+			if !r.DedupeSimple.IsMessageProcessed(currentMessageID) {
+
+				// process message
+				// unmarshall message body
+				var reduceMessage *aggregators.ReduceMessage
+				body := []byte(*message.Body)
+				err = json.Unmarshal(body, &reduceMessage)
+				if err != nil {
+					return err
+				}
+
+				// process message
+				if err := intermediateOutput.Reduce(reduceMessage); err != nil {
+					reducerLogger.WithError(err).Error("Error processing message")
+					return err
+				}
+
+				// update dedupe and messages processed count
+				r.DedupeSimple.UpdateMessageProcessed(currentMessageID)
+				totalProcessedMessages++
+			}
+		}
+	}
+
+	// wait in case reducers is saving checkpoint in the background
+	wg.Wait()
+
+	// update output map with reduced intermediate results
+	wg.Add(1)
+	go r.Output.UpdateOutput(intermediateOutput, &wg)
+	wg.Wait()
+
+	if filter != nil {
+		// filter results
+		r.Output = RunFilter(r.Output, filter)
+	}
+
+	// generate name of the part file
+	key := GetPartName(r.QueuePartition)
+
+	if sortOutput != nil {
+		// sort output
+		sortedOutput := RunSort(r.Output, sortOutput)
+
+		// write sorted reducer output
+		err = r.WriteSortedReducerOutput(ctx, sortedOutput, key)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error writing reducer output")
+			return err
+		}
+	} else {
+		// write unsorted reducer output
+		err = r.WriteReducerOutput(ctx, r.Output, key)
+		if err != nil {
+			reducerLogger.WithError(err).Error("Error writing reducer output")
+			return err
+		}
+	}
+
+	// indicate the job output has been written
+	err = r.writeObject(ctx, r.JobID.String(), FinalReducerDoneObject, []byte{})
+	if err != nil {
+		reducerLogger.WithError(err).Error("Error writing done signal")
+		return err
+	}
+
+	// delete all messages from queue
+	wg.Add(1)
+	go r.DeleteIntermediateMessagesFromQueue(ctx, queueURL, processedMessagesDeleteInfo, &wg)
+	wg.Wait()
+
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/big"
 	"math/rand"
@@ -22,6 +24,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/josenarvaezp/displ/internal/compression"
 	"github.com/josenarvaezp/displ/internal/config"
 	"github.com/josenarvaezp/displ/internal/objectstore"
 	"github.com/josenarvaezp/displ/internal/queues"
@@ -52,9 +57,43 @@ type MapperInput struct {
 	NumQueues int64     `json:"queues,string"`
 }
 
+// InputObject describes the range of an object that a streaming mapper reads
+type InputObject struct {
+	Bucket      string
+	Key         string
+	InitialByte int64
+	FinalByte   int64
+	// RowGroups are the row groups of a Parquet object in the range
+	// and Footer is the footer of the object that describes them
+	RowGroups *objectstore.RowGroupRange
+	Footer    []byte
+	// Compression is set for compressed objects, the reader
+	// has the decompressed lines of the range
+	Compression *objectstore.CompressedRange
+}
+
+// MapFunction is the signature of mappers that read their input from a file in
+// the local filesystem, the whole range of the object is downloaded before the
+// function runs
+type MapFunction func(filename string) aggregators.MapAggregator
+
+// StreamMapFunction is the signature of mappers that read their input as a stream.
+// The reader streams the range of the object from the object store so the range
+// doesn't need to fit in the local filesystem
+type StreamMapFunction func(reader io.Reader, object InputObject) aggregators.MapAggregator
+
+// MapFunctionWithError is the signature of mappers that read their input from a
+// file and can fail. The error fails the job or skips the split, see MapperFailurePolicy
+type MapFunctionWithError func(filename string) (aggregators.MapAggregator, error)
+
+// StreamMapFunctionWithError is the signature of mappers that read their input as
+// a stream and can fail
+type StreamMapFunctionWithError func(reader io.Reader, object InputObject) (aggregators.MapAggregator, error)
+
 // MapperAPI is an interface deining the functions available to the mapper
 type MapperAPI interface {
 	DownloadFile(object objectstore.ObjectRange) (*string, error)
+	OpenObject(ctx context.Context, object objectstore.ObjectRange) (io.ReadCloser, error)
 	EmitMap(ctx context.Context, outputMap map[string]int, batchMetadata map[int]int64) error
 	WriteBatchMetadata(ctx context.Context, bucket, key string, batchMetadata map[int]int64) error
 	SendFinishedEvent(ctx context.Context) error
@@ -65,14 +104,18 @@ type Mapper struct {
 	JobID uuid.UUID
 	MapID uuid.UUID
 	// clients
-	DownloaderAPI objectstore.ManagerDownloaderAPI
-	UploaderAPI   objectstore.ManagerUploaderAPI
-	QueuesAPI     queues.QueuesAPI
+	ObjectStoreAPI objectstore.ObjectStoreAPI
+	DownloaderAPI  objectstore.ManagerDownloaderAPI
+	UploaderAPI    objectstore.ManagerUploaderAPI
+	QueuesAPI      queues.QueuesAPI
 	// metadata
 	Region    string
 	AccountID string
 	NumQueues int64
-	local     bool
+	// FailurePolicy decides if a split where the map function
+	// fails stops the job or is skipped
+	FailurePolicy MapperFailurePolicy
+	local         bool
 }
 
 // NewMapper initializes a new mapper with its required clients
@@ -114,6 +157,7 @@ func NewMapper(
 	})
 
 	// Create a S3 downloader and uploader
+	mapper.ObjectStoreAPI = s3Client
 	mapper.DownloaderAPI = manager.NewDownloader(s3Client)
 	mapper.UploaderAPI = manager.NewUploader(s3Client)
 
@@ -144,13 +188,36 @@ func (m *Mapper) UpdateMapperWithRequest(ctx context.Context, request MapperInpu
 	return nil
 }
 
-// DownloadFile downloads a file from the object store into the local filesystem
+// DownloadFile downloads a file from the object store into the local filesystem.
+// Compressed objects are decompressed into the file
 func (m *Mapper) DownloadFile(object objectstore.ObjectRange) (*string, error) {
-	// create temporary file to store object
-	file, err := os.Create(filepath.Join("/tmp", object.Key))
+	// create temporary file to store object, the map id is used as
+	// prefix so that mappers sharing the same filesystem don't collide
+	name := filepath.Base(object.Key)
+	if object.Compression != nil && compression.FromKey(name) != compression.None {
+		// the file has the decompressed data
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	file, err := os.Create(filepath.Join("/tmp", fmt.Sprintf("%s-%s", m.MapID.String(), name)))
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+
+	if object.Compression != nil {
+		body, err := m.OpenObject(context.Background(), object)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		if _, err := io.Copy(file, body); err != nil {
+			return nil, err
+		}
+
+		filename := file.Name()
+		return &filename, nil
+	}
 
 	// download object accordint to range
 	objectRange := fmt.Sprintf("bytes=%d-%d", object.InitialByte, object.FinalByte)
@@ -168,6 +235,37 @@ func (m *Mapper) DownloadFile(object objectstore.ObjectRange) (*string, error) {
 	return &filename, nil
 }
 
+// OpenObject returns a reader that streams the range of the object from the object store.
+// Compressed objects are decompressed while they are read, where a range that doesn't start
+// the object starts at its first complete line and its last line is read until its end
+func (m *Mapper) OpenObject(ctx context.Context, object objectstore.ObjectRange) (io.ReadCloser, error) {
+	open := func(initialByte, finalByte int64) (io.ReadCloser, error) {
+		objectRange := fmt.Sprintf("bytes=%d-%d", initialByte, finalByte)
+		output, err := m.ObjectStoreAPI.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(object.Bucket),
+			Key:    aws.String(object.Key),
+			Range:  aws.String(objectRange),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return output.Body, nil
+	}
+
+	if object.Compression != nil {
+		return compression.OpenRange(
+			object.Compression.Codec,
+			object.InitialByte,
+			object.FinalByte,
+			object.Compression.Size,
+			open,
+		)
+	}
+
+	return open(object.InitialByte, object.FinalByte)
+}
+
 // EmitMapSum sends the output map in batches to the queues
 func (m *Mapper) EmitMap(
 	ctx context.Context,
@@ -176,110 +274,115 @@ func (m *Mapper) EmitMap(
 ) error {
 	MetricsSQSTotalMessages := 0
 	// keep dictionary of batches to allow sending keys in batches
-	batches := make(map[int][]aggregators.ReduceMessage)
+	batches := make(map[int][]string)
+	batchSizes := make(map[int]int)
 
 	// iterate through the output map and send values in batches
 	for key, value := range outputMap {
 		// get partition queue from key
 		partitionQueue := m.getQueuePartition(key)
 
-		aggregatorType := GetAggregatorType(value)
-
-		// add value to batch
-		mapMessage := aggregators.ReduceMessage{
-			Key:  key,
-			Type: int64(aggregatorType),
+		// encode value, values larger than a message are split in several messages
+		messages, err := EncodeReduceMessages(key, value, MaxMessageSize)
+		if err != nil {
+			return err
 		}
 
-		if aggregatorType == AvgAggregator {
-			castAvg := value.(*aggregators.Avg)
-			mapMessage.Value = castAvg.GetSum()
-			mapMessage.Count = castAvg.GetCount()
-		} else {
-			mapMessage.Value = value.ToNum()
-		}
+		for _, message := range messages {
+			// flush batch if the message doesn't fit in it
+			if batchSizes[partitionQueue]+len(message) > MaxMessageSize {
+				if err := m.flushBatch(ctx, partitionQueue, batches[partitionQueue], batchMetadata); err != nil {
+					return err
+				}
 
-		batches[partitionQueue] = append(
-			batches[partitionQueue],
-			mapMessage,
-		)
+				MetricsSQSTotalMessages = MetricsSQSTotalMessages + MaxItemsPerBatch
 
-		// flush batch if it has maximum items
-		if len(batches[partitionQueue]) == MaxItemsPerBatch {
-			// send batch to queue
-			if err := m.sendBatch(
-				ctx,
-				partitionQueue,
-				int(batchMetadata[partitionQueue]+int64(1)),
-				batches[partitionQueue],
-			); err != nil {
-				return err
+				// delete batch from map
+				delete(batches, partitionQueue)
+				delete(batchSizes, partitionQueue)
 			}
 
-			MetricsSQSTotalMessages = MetricsSQSTotalMessages + 10
+			// add value to batch
+			batches[partitionQueue] = append(batches[partitionQueue], message)
+			batchSizes[partitionQueue] = batchSizes[partitionQueue] + len(message)
+
+			// flush batch if it has maximum items
+			if len(batches[partitionQueue]) == MaxItemsPerBatch {
+				if err := m.flushBatch(ctx, partitionQueue, batches[partitionQueue], batchMetadata); err != nil {
+					return err
+				}
 
-			// update batch metadata
-			batchMetadata[partitionQueue] = batchMetadata[partitionQueue] + int64(1)
+				MetricsSQSTotalMessages = MetricsSQSTotalMessages + MaxItemsPerBatch
 
-			// delete batch from map
-			delete(batches, partitionQueue)
+				// delete batch from map
+				delete(batches, partitionQueue)
+				delete(batchSizes, partitionQueue)
+			}
 		}
 	}
 
 	// flush all remaining batches that don't have 10 values
 	for key, valuesInBatch := range batches {
-		// add values until we complete the batch
-		// Note that while this is a little more inefficient for the mapper
-		// since we could send batches with less values, the reducers logic will
-		// be much simpler given that a reducer will only need to know the number of
-		// batches that the mapper sent rather that the number of batches and for
-		// each batch how many items
-		valuesToAppend := make([]aggregators.ReduceMessage, MaxItemsPerBatch-len(valuesInBatch))
-		for i := 0; i < len(valuesToAppend); i++ {
-			valuesToAppend[i] = aggregators.ReduceMessage{
-				EmptyVal: true,
-			}
+		if err := m.flushBatch(ctx, key, valuesInBatch, batchMetadata); err != nil {
+			return err
 		}
 
-		// append values to values in batch
-		valuesInBatch = append(valuesInBatch, valuesToAppend...)
+		MetricsSQSTotalMessages = MetricsSQSTotalMessages + MaxItemsPerBatch
+	}
+
+	log.Default().Println("Messages sent: ", MetricsSQSTotalMessages)
+
+	return nil
+}
 
-		// send batch to queue
-		if err := m.sendBatch(
-			ctx,
-			key,
-			int(batchMetadata[key]+int64(1)),
-			valuesInBatch,
-		); err != nil {
+// flushBatch sends a batch of encoded messages to the partition queue and updates the batch metadata
+func (m *Mapper) flushBatch(ctx context.Context, partitionQueue int, valuesInBatch []string, batchMetadata map[int]int64) error {
+	// add values until we complete the batch
+	// Note that while this is a little more inefficient for the mapper
+	// since we could send batches with less values, the reducers logic will
+	// be much simpler given that a reducer will only need to know the number of
+	// batches that the mapper sent rather that the number of batches and for
+	// each batch how many items
+	if len(valuesInBatch) < MaxItemsPerBatch {
+		p, err := json.Marshal(aggregators.ReduceMessage{
+			EmptyVal: true,
+		})
+		if err != nil {
 			return err
 		}
 
-		MetricsSQSTotalMessages = MetricsSQSTotalMessages + 10
+		for len(valuesInBatch) < MaxItemsPerBatch {
+			valuesInBatch = append(valuesInBatch, string(p))
+		}
+	}
 
-		// update batch metadata
-		batchMetadata[key] = batchMetadata[key] + int64(1)
+	// send batch to queue
+	if err := m.sendBatch(
+		ctx,
+		partitionQueue,
+		int(batchMetadata[partitionQueue]+int64(1)),
+		valuesInBatch,
+	); err != nil {
+		return err
 	}
 
-	log.Default().Println("Messages sent: ", MetricsSQSTotalMessages)
+	// update batch metadata
+	batchMetadata[partitionQueue] = batchMetadata[partitionQueue] + int64(1)
 
 	return nil
 }
 
 // sendBatch sends the specified batch to the specified queue
-func (m *Mapper) sendBatch(ctx context.Context, partitionQueue int, batchID int, batch []aggregators.ReduceMessage) error {
+func (m *Mapper) sendBatch(ctx context.Context, partitionQueue int, batchID int, batch []string) error {
 	// convert batch to message entries
 	messsageEntries := make([]types.SendMessageBatchRequestEntry, len(batch))
-	for i, message := range batch {
+	for i := range batch {
 		messageID := strconv.Itoa(i) // unique message id within batch
 		batchID := strconv.Itoa(batchID)
 		mapID := m.MapID.String()
 
-		// encode map input into JSON
-		p, err := json.Marshal(message)
-		if err != nil {
-			return err
-		}
-		messageJSONString := string(p)
+		// messages are encoded to JSON before they are added to the batch
+		messageJSONString := batch[i]
 
 		messsageEntries[i] = types.SendMessageBatchRequestEntry{
 			Id:          &messageID,
@@ -321,7 +424,9 @@ func (m *Mapper) sendBatch(ctx context.Context, partitionQueue int, batchID int,
 }
 
 // getQueuePartition is a helpder function for the mapper that
-// gets the queue partition of a key given its md5 hash
+// gets the queue partition of a key given its md5 hash. Group keys
+// are hashed by their encoding, so the same tuple of columns always
+// goes to the same partition
 func (m *Mapper) getQueuePartition(key string) int {
 	bi := big.NewInt(0)
 	h := md5.New()
@@ -358,55 +463,41 @@ func (m *Mapper) EmitRandom(ctx context.Context, outputMap aggregators.MapAggreg
 	MetricsSQSTotalMessages := 0
 
 	for key, value := range outputMap {
-		messageID := uuid.New().String()
-
 		// get partition queue from key
 		partitionQueue := m.GetRandomQueuePartition(randomWithSeed)
 
-		aggregatorType := GetAggregatorType(value)
-
-		// add value to batch
-		mapMessage := aggregators.ReduceMessage{
-			Key:  key,
-			Type: int64(aggregatorType),
-		}
-
-		if aggregatorType == AvgAggregator {
-			castAvg := value.(*aggregators.Avg)
-			mapMessage.Value = castAvg.GetSum()
-			mapMessage.Count = castAvg.GetCount()
-		} else {
-			mapMessage.Value = value.ToNum()
-		}
-
-		// encode map input into JSON
-		p, err := json.Marshal(mapMessage)
+		// encode value, values larger than a message are split in several messages
+		messages, err := EncodeReduceMessages(key, value, MaxMessageSize)
 		if err != nil {
 			return err
 		}
-		messageJSONString := string(p)
 
-		queueName := fmt.Sprintf("%s-%d", m.JobID.String(), partitionQueue)
-		queueURL := GetQueueURL(queueName, m.Region, m.AccountID, m.local)
-		params := &sqs.SendMessageInput{
-			MessageBody: &messageJSONString,
-			MessageAttributes: map[string]types.MessageAttributeValue{
-				MessageIDAttribute: {
-					DataType:    &stringDataType,
-					StringValue: &messageID,
+		for i := range messages {
+			messageID := uuid.New().String()
+			messageJSONString := messages[i]
+
+			queueName := fmt.Sprintf("%s-%d", m.JobID.String(), partitionQueue)
+			queueURL := GetQueueURL(queueName, m.Region, m.AccountID, m.local)
+			params := &sqs.SendMessageInput{
+				MessageBody: &messageJSONString,
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					MessageIDAttribute: {
+						DataType:    &stringDataType,
+						StringValue: &messageID,
+					},
 				},
-			},
-			QueueUrl: &queueURL,
-		}
-		_, err = m.QueuesAPI.SendMessage(ctx, params)
-		if err != nil {
-			return err
-		}
+				QueueUrl: &queueURL,
+			}
+			_, err = m.QueuesAPI.SendMessage(ctx, params)
+			if err != nil {
+				return err
+			}
 
-		MetricsSQSTotalMessages = MetricsSQSTotalMessages + 1
+			MetricsSQSTotalMessages = MetricsSQSTotalMessages + 1
 
-		// update message metadata
-		messageMetadata[partitionQueue] = messageMetadata[partitionQueue] + int64(1)
+			// update message metadata
+			messageMetadata[partitionQueue] = messageMetadata[partitionQueue] + int64(1)
+		}
 	}
 
 	log.Default().Println("Messages sent: ", MetricsSQSTotalMessages)
@@ -532,6 +623,305 @@ func (m *Mapper) SendBatchMetadata(ctx context.Context, batchMetadata map[int]in
 	return nil
 }
 
-func RunMapAggregator(filename string, userMap func(filename string) aggregators.MapAggregator) aggregators.MapAggregator {
+// RunMapAggregator runs the user's map function on the file
+func RunMapAggregator(
+	filename string,
+	userMap func(filename string) (aggregators.MapAggregator, error),
+) (aggregators.MapAggregator, error) {
 	return userMap(filename)
 }
+
+// RunMap runs the user's map function on the range of the object. Map functions that take
+// a filename get the range downloaded to the local filesystem and stream map functions
+// get a reader that streams the range from the object store. The errors returned by the
+// map function are MapperErrors
+func (m *Mapper) RunMap(
+	ctx context.Context,
+	object objectstore.ObjectRange,
+	userMap interface{},
+) (aggregators.MapAggregator, error) {
+	switch mapFunction := userMap.(type) {
+	case func(string) aggregators.MapAggregator:
+		return m.runFileMap(object, withoutError(mapFunction))
+	case MapFunction:
+		return m.runFileMap(object, withoutError(mapFunction))
+	case func(string) (aggregators.MapAggregator, error):
+		return m.runFileMap(object, mapFunction)
+	case MapFunctionWithError:
+		return m.runFileMap(object, mapFunction)
+	case func(io.Reader, InputObject) aggregators.MapAggregator:
+		return m.runStreamMap(ctx, object, streamWithoutError(mapFunction))
+	case StreamMapFunction:
+		return m.runStreamMap(ctx, object, streamWithoutError(mapFunction))
+	case func(io.Reader, InputObject) (aggregators.MapAggregator, error):
+		// stream map functions that can fail, like record mappers
+		return m.runStreamMap(ctx, object, mapFunction)
+	case StreamMapFunctionWithError:
+		return m.runStreamMap(ctx, object, mapFunction)
+	default:
+		return nil, fmt.Errorf("Invalid map function of type %T", userMap)
+	}
+}
+
+// withoutError returns a map function that can fail from one that can't
+func withoutError(userMap func(filename string) aggregators.MapAggregator) func(string) (aggregators.MapAggregator, error) {
+	return func(filename string) (aggregators.MapAggregator, error) {
+		return userMap(filename), nil
+	}
+}
+
+// streamWithoutError returns a stream map function that can fail from one that can't
+func streamWithoutError(userMap func(io.Reader, InputObject) aggregators.MapAggregator) func(io.Reader, InputObject) (aggregators.MapAggregator, error) {
+	return func(reader io.Reader, object InputObject) (aggregators.MapAggregator, error) {
+		return userMap(reader, object), nil
+	}
+}
+
+// runFileMap downloads the range of the object and runs the map function on the file
+func (m *Mapper) runFileMap(
+	object objectstore.ObjectRange,
+	userMap func(filename string) (aggregators.MapAggregator, error),
+) (aggregators.MapAggregator, error) {
+	// the row groups of Parquet objects can't be read without the footer
+	if object.RowGroups != nil {
+		return nil, fmt.Errorf("The row groups of s3://%s/%s can only be read by stream map functions", object.Bucket, object.Key)
+	}
+
+	// download file
+	filename, err := m.DownloadFile(object)
+	if err != nil {
+		return nil, err
+	}
+
+	// user function starts here
+	mapOutput, mapErr := RunMapAggregator(*filename, userMap)
+
+	// clean up file in /tmp, the result of the map function is kept if it fails
+	if err := os.Remove(*filename); err != nil {
+		logrus.WithError(err).WithField("File", *filename).Warn("Error removing downloaded file")
+	}
+
+	if mapErr != nil {
+		return nil, NewMapperError(m.MapID, object, mapErr)
+	}
+
+	return mapOutput, nil
+}
+
+// runStreamMap runs the map function on a reader that streams the range of the object
+func (m *Mapper) runStreamMap(
+	ctx context.Context,
+	object objectstore.ObjectRange,
+	userMap func(reader io.Reader, object InputObject) (aggregators.MapAggregator, error),
+) (aggregators.MapAggregator, error) {
+	input, err := m.newInputObject(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := m.OpenObject(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	// user function starts here
+	mapOutput, err := userMap(body, input)
+	if err != nil {
+		return nil, NewMapperError(m.MapID, object, err)
+	}
+
+	return mapOutput, nil
+}
+
+// newInputObject returns the input object of a range. The footer of
+// Parquet objects is read so that the row groups can be decoded
+func (m *Mapper) newInputObject(ctx context.Context, object objectstore.ObjectRange) (InputObject, error) {
+	input := InputObject{
+		Bucket:      object.Bucket,
+		Key:         object.Key,
+		InitialByte: object.InitialByte,
+		FinalByte:   object.FinalByte,
+		RowGroups:   object.RowGroups,
+		Compression: object.Compression,
+	}
+
+	if object.RowGroups != nil {
+		body, err := m.OpenObject(ctx, objectstore.ObjectRange{
+			Bucket:      object.Bucket,
+			Key:         object.Key,
+			InitialByte: object.RowGroups.FooterOffset,
+			FinalByte:   object.RowGroups.FooterOffset + object.RowGroups.FooterLength - 1,
+		})
+		if err != nil {
+			return InputObject{}, err
+		}
+		defer body.Close()
+
+		input.Footer, err = ioutil.ReadAll(body)
+		if err != nil {
+			return InputObject{}, err
+		}
+	}
+
+	return input, nil
+}
+
+// WriteSkippedSplit writes the error of a split skipped by the mapper to the job
+// bucket, index is the position of the split in the mapping
+func (m *Mapper) WriteSkippedSplit(ctx context.Context, index int, mapperErr *MapperError) error {
+	p, err := json.Marshal(mapperErr)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(m.JobID.String()),
+		Key:    aws.String(fmt.Sprintf("%s%s-%d", SkippedSplitsPrefix, m.MapID.String(), index)),
+		Body:   strings.NewReader(string(p)),
+	}
+	_, err = m.UploaderAPI.Upload(ctx, input)
+
+	return err
+}
+
+// HandleMap runs the mapper for the given request. Each object in the mapping is
+// read, processed with the user's map function and its output is sent in batches
+// to the reducers' queues. The map function can be a MapFunction or a StreamMapFunction,
+// or their versions that can fail, in which case the failure policy of the mapper
+// decides if the error is returned or the split is skipped
+func (m *Mapper) HandleMap(
+	ctx context.Context,
+	request MapperInput,
+	userMap interface{},
+) error {
+	// update mapper
+	if err := m.UpdateMapperWithRequest(ctx, request); err != nil {
+		return err
+	}
+
+	// set mapper logger
+	mapperLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": m.JobID.String(),
+		"Map ID": m.MapID.String(),
+	})
+
+	// keep a dictionary with the number of batches per queue
+	batchMetadata := make(map[int]int64)
+
+	for i, object := range request.Mapping.Objects {
+		objectLogger := mapperLogger.WithFields(logrus.Fields{
+			"Bucket": object.Bucket,
+			"Object": object.Key,
+		})
+
+		// read the object and run the user function
+		mapOutput, err := m.RunMap(ctx, object, userMap)
+		var mapperErr *MapperError
+		if errors.As(err, &mapperErr) && m.FailurePolicy == SkipFailedSplits {
+			// the split is reported and the rest of the mapping is processed
+			objectLogger.WithError(err).Warn("Skipping split where the map function failed")
+			if err := m.WriteSkippedSplit(ctx, i, mapperErr); err != nil {
+				objectLogger.WithError(err).Error("Error writing skipped split")
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			objectLogger.WithError(err).Error("Error running map function")
+			return err
+		}
+
+		// send output to reducers via queues
+		err = m.EmitMap(ctx, mapOutput, batchMetadata)
+		if err != nil {
+			objectLogger.WithError(err).Error("Error sending map output to reducers")
+			return err
+		}
+	}
+
+	// send batch metadata to sqs
+	if err := m.SendBatchMetadata(ctx, batchMetadata); err != nil {
+		mapperLogger.WithError(err).Error("Error sending metadata to streams")
+		return err
+	}
+
+	// send event to queue indicating this mapper has completed
+	if err := m.SendFinishedEvent(ctx); err != nil {
+		mapperLogger.WithError(err).Error("Error sending done event to stream")
+		return err
+	}
+
+	return nil
+}
+
+// HandleRandomMap runs the mapper for the given request when the job uses
+// randomized partitions. Each value produced by the user's map function is
+// sent to a random reducer queue
+func (m *Mapper) HandleRandomMap(
+	ctx context.Context,
+	request MapperInput,
+	userMap interface{},
+) error {
+	// update mapper
+	if err := m.UpdateMapperWithRequest(ctx, request); err != nil {
+		return err
+	}
+
+	// set mapper logger
+	mapperLogger := logrus.WithFields(logrus.Fields{
+		"Job ID": m.JobID.String(),
+		"Map ID": m.MapID.String(),
+	})
+
+	// keep a dictionary with the number of messages per queue
+	messageMetadata := make(map[int]int64)
+
+	// create random number generator with seed
+	randGen := m.InitRandomSeed()
+
+	for i, object := range request.Mapping.Objects {
+		objectLogger := mapperLogger.WithFields(logrus.Fields{
+			"Bucket": object.Bucket,
+			"Object": object.Key,
+		})
+
+		// read the object and run the user function
+		mapOutput, err := m.RunMap(ctx, object, userMap)
+		var mapperErr *MapperError
+		if errors.As(err, &mapperErr) && m.FailurePolicy == SkipFailedSplits {
+			// the split is reported and the rest of the mapping is processed
+			objectLogger.WithError(err).Warn("Skipping split where the map function failed")
+			if err := m.WriteSkippedSplit(ctx, i, mapperErr); err != nil {
+				objectLogger.WithError(err).Error("Error writing skipped split")
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			objectLogger.WithError(err).Error("Error running map function")
+			return err
+		}
+
+		// send output to reducers via queues
+		err = m.EmitRandom(ctx, mapOutput, messageMetadata, randGen)
+		if err != nil {
+			objectLogger.WithError(err).Error("Error sending map output to reducers")
+			return err
+		}
+	}
+
+	// send message metadata to sqs
+	if err := m.SendBatchMetadata(ctx, messageMetadata); err != nil {
+		mapperLogger.WithError(err).Error("Error sending metadata to streams")
+		return err
+	}
+
+	// send event to queue indicating this mapper has completed
+	if err := m.SendFinishedEvent(ctx); err != nil {
+		mapperLogger.WithError(err).Error("Error sending done event to stream")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package lambdas_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/queues"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// this function checks that values larger than a message are split across
+// messages and batches and that the reducer merges them back
+func Test_EmitMap_SplitsLargeValues(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+
+	memoryQueues := queues.NewMemoryQueues()
+	queueOutput, err := memoryQueues.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("%s-0", jobID.String())),
+	})
+	require.Nil(t, err)
+
+	// an inverted index with a word that is in many documents
+	outputMap := aggregators.NewMap()
+	for i := 0; i < 3000; i++ {
+		document := fmt.Sprintf("document-%04d-%s", i, strings.Repeat("x", 200))
+		require.Nil(t, outputMap.AddCollectList("word", document))
+		require.Nil(t, outputMap.AddCollectSet("distinct word", document))
+	}
+	require.Nil(t, outputMap.AddSum("count", 3000))
+
+	mapper := &lambdas.Mapper{
+		JobID:     jobID,
+		MapID:     uuid.New(),
+		QueuesAPI: memoryQueues,
+		NumQueues: 1,
+	}
+	batchMetadata := make(map[int]int64)
+	require.Nil(t, mapper.EmitMap(ctx, outputMap, batchMetadata))
+	assert.Greater(t, batchMetadata[0], int64(2))
+
+	// every batch is complete and the reducer gets the values back
+	reducerOutput := aggregators.NewMap()
+	numMessages := 0
+	for {
+		output, err := memoryQueues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            queueOutput.QueueUrl,
+			MaxNumberOfMessages: 10,
+		})
+		require.Nil(t, err)
+		if len(output.Messages) == 0 {
+			break
+		}
+
+		for _, message := range output.Messages {
+			assert.LessOrEqual(t, len(*message.Body), lambdas.MaxMessageSize)
+
+			var reduceMessage *aggregators.ReduceMessage
+			require.Nil(t, json.Unmarshal([]byte(*message.Body), &reduceMessage))
+			require.Nil(t, reducerOutput.Reduce(reduceMessage))
+			numMessages++
+		}
+	}
+	assert.Equal(t, int(batchMetadata[0])*lambdas.MaxItemsPerBatch, numMessages)
+
+	assert.Len(t, reducerOutput["word"].(*aggregators.CollectList).GetValues(), 3000)
+	assert.Equal(t, outputMap["distinct word"].(*aggregators.CollectSet).GetValues(), reducerOutput["distinct word"].(*aggregators.CollectSet).GetValues())
+	assert.Equal(t, float64(3000), reducerOutput["count"].ToNum())
+}
+
+func Test_EncodeReduceMessages_TooLarge(t *testing.T) {
+	outputMap := aggregators.NewMap()
+	require.Nil(t, outputMap.AddCollectList("word", strings.Repeat("x", 1000)))
+
+	_, err := lambdas.EncodeReduceMessages("word", outputMap["word"], 100)
+	assert.EqualError(t, err, "Message for key word is larger than 100 bytes")
+}
+
+// this function checks that the largest TopK sketch, which can't be split
+// across messages, fits in a single message with a long key
+func Test_EncodeReduceMessages_LargestTopK(t *testing.T) {
+	topK := aggregators.InitTopKWithCapacity(aggregators.MaxTopKCapacity, aggregators.MaxTopKCapacity)
+	for i := 0; i < aggregators.MaxTopKCapacity; i++ {
+		topK.Add(fmt.Sprintf("%0*d", aggregators.MaxTopKItemSize, i), 1)
+	}
+	for _, counter := range topK.Counters {
+		counter.Count = math.MaxUint64
+		counter.Error = math.MaxUint64
+	}
+
+	messages, err := lambdas.EncodeReduceMessages(strings.Repeat("k", 1024), topK, lambdas.MaxMessageSize)
+	require.Nil(t, err)
+	assert.Len(t, messages, 1)
+}
+
+// this function checks that stream mappers read only the range of the object,
+// including objects whose key has slashes, and that map functions get the same range
+func Test_RunMap_StreamsRange(t *testing.T) {
+	ctx := context.Background()
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("input")})
+	require.Nil(t, err)
+	_, err = store.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("input"),
+		Key:    aws.String("logs/2022/01/app.log"),
+		Body:   strings.NewReader("first line\nsecond line\n"),
+	})
+	require.Nil(t, err)
+
+	mapper := &lambdas.Mapper{
+		MapID:          uuid.New(),
+		ObjectStoreAPI: store,
+		DownloaderAPI:  store,
+	}
+	object := objectstore.ObjectRange{Bucket: "input", Key: "logs/2022/01/app.log", InitialByte: 11, FinalByte: 21}
+
+	var streamedObject lambdas.InputObject
+	output, err := mapper.RunMap(ctx, object, func(reader io.Reader, inputObject lambdas.InputObject) aggregators.MapAggregator {
+		streamedObject = inputObject
+		data, err := ioutil.ReadAll(reader)
+		require.Nil(t, err)
+
+		output := aggregators.NewMap()
+		require.Nil(t, output.AddAny("range", string(data)))
+		return output
+	})
+	require.Nil(t, err)
+	assert.Equal(t, aggregators.StringValue("second line"), output["range"].(aggregators.ValueAggregator).ToValue())
+	assert.Equal(t, lambdas.InputObject{Bucket: "input", Key: "logs/2022/01/app.log", InitialByte: 11, FinalByte: 21}, streamedObject)
+
+	output, err = mapper.RunMap(ctx, object, func(filename string) aggregators.MapAggregator {
+		data, err := ioutil.ReadFile(filename)
+		require.Nil(t, err)
+
+		output := aggregators.NewMap()
+		require.Nil(t, output.AddAny("range", string(data)))
+		return output
+	})
+	require.Nil(t, err)
+	assert.Equal(t, aggregators.StringValue("second line"), output["range"].(aggregators.ValueAggregator).ToValue())
+
+	_, err = mapper.RunMap(ctx, object, func(line []byte) aggregators.MapAggregator { return nil })
+	assert.EqualError(t, err, "Invalid map function of type func([]uint8) aggregators.MapAggregator")
+}
+
+// this function checks that the errors of the map function
+// record the mapping and the range of the object
+func Test_RunMap_MapperError(t *testing.T) {
+	ctx := context.Background()
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("input")})
+	require.Nil(t, err)
+	_, err = store.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("input"),
+		Key:    aws.String("app.log"),
+		Body:   strings.NewReader("first line\nsecond line\n"),
+	})
+	require.Nil(t, err)
+
+	mapper := &lambdas.Mapper{
+		MapID:          uuid.New(),
+		ObjectStoreAPI: store,
+		DownloaderAPI:  store,
+	}
+	object := objectstore.ObjectRange{Bucket: "input", Key: "app.log", InitialByte: 11, FinalByte: 21}
+	mapErr := errors.New("Invalid line")
+
+	mapFunctions := []interface{}{
+		func(filename string) (aggregators.MapAggregator, error) { return nil, mapErr },
+		// the error of the map function is kept when the file can't be removed
+		func(filename string) (aggregators.MapAggregator, error) {
+			require.Nil(t, os.Remove(filename))
+			return nil, mapErr
+		},
+		func(reader io.Reader, inputObject lambdas.InputObject) (aggregators.MapAggregator, error) {
+			return nil, mapErr
+		},
+	}
+	for _, mapFunction := range mapFunctions {
+		_, err = mapper.RunMap(ctx, object, mapFunction)
+		require.NotNil(t, err)
+		assert.True(t, errors.Is(err, mapErr))
+		assert.EqualError(t, err, fmt.Sprintf("Map function failed on bytes 11-21 of s3://input/app.log in mapping %s: Invalid line", mapper.MapID))
+
+		var mapperErr *lambdas.MapperError
+		require.True(t, errors.As(err, &mapperErr))
+		assert.Equal(t, mapper.MapID.String(), mapperErr.MappingID)
+		assert.Equal(t, "app.log", mapperErr.Key)
+	}
+
+	assert.Nil(t, lambdas.MapperFailurePolicy("").Validate())
+	assert.Nil(t, lambdas.SkipFailedSplits.Validate())
+	assert.EqualError(t, lambdas.MapperFailurePolicy("retry").Validate(), `Invalid mapper failure policy "retry", it must be fail or skip`)
+}
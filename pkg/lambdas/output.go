@@ -0,0 +1,56 @@
+package lambdas
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultOutputPrefix is the prefix of the output objects when the job
+	// has no output bucket and they are written to the job bucket
+	DefaultOutputPrefix = "output"
+	// SuccessManifestName is the name of the object written next to the part
+	// files once every reducer has written its output
+	SuccessManifestName = "_SUCCESS"
+	// FinalReducerDoneObject is written to the job bucket by the final reducer
+	// of jobs with randomized partitions once it has written the job output
+	FinalReducerDoneObject = "final-reducer-done"
+)
+
+// SuccessManifest lists the part files produced by a job. It is written
+// once all the part files are in place so consumers can use it to know
+// that the output is complete and which objects belong to the job
+type SuccessManifest struct {
+	JobID  uuid.UUID `json:"jobID"`
+	Bucket string    `json:"bucket"`
+	Parts  []string  `json:"parts"`
+}
+
+// GetOutputLocation returns the bucket and key prefix the job output is written to.
+// Jobs without an output bucket write to the job bucket under DefaultOutputPrefix
+func GetOutputLocation(jobID uuid.UUID, outputBucket string, outputPrefix string) (string, string) {
+	if outputBucket == "" {
+		outputBucket = jobID.String()
+		if outputPrefix == "" {
+			outputPrefix = DefaultOutputPrefix
+		}
+	}
+
+	return outputBucket, strings.Trim(outputPrefix, "/")
+}
+
+// GetOutputKey returns the key of the object with the given name under the prefix
+func GetOutputKey(outputPrefix string, name string) string {
+	if outputPrefix == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s/%s", outputPrefix, name)
+}
+
+// GetPartName returns the name of the part file written by the reducer of a partition
+func GetPartName(partition int) string {
+	return fmt.Sprintf("part-%05d", partition)
+}
@@ -0,0 +1,34 @@
+package lambdas_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetOutputLocation(t *testing.T) {
+	jobID := uuid.New()
+
+	tests := []struct {
+		name           string
+		outputBucket   string
+		outputPrefix   string
+		expectedBucket string
+		expectedKey    string
+	}{
+		{"default", "", "", jobID.String(), "output/part-00003"},
+		{"job bucket with prefix", "", "results", jobID.String(), "results/part-00003"},
+		{"output bucket", "my-output", "", "my-output", "part-00003"},
+		{"output bucket with prefix", "my-output", "/daily/results/", "my-output", "daily/results/part-00003"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket, prefix := lambdas.GetOutputLocation(jobID, test.outputBucket, test.outputPrefix)
+			assert.Equal(t, test.expectedBucket, bucket)
+			assert.Equal(t, test.expectedKey, lambdas.GetOutputKey(prefix, lambdas.GetPartName(3)))
+		})
+	}
+}
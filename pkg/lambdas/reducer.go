@@ -10,31 +10,24 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/faas"
 	"github.com/josenarvaezp/displ/internal/objectstore"
 	"github.com/josenarvaezp/displ/internal/queues"
 	"github.com/josenarvaezp/displ/pkg/aggregators"
 )
 
-type AggregatorType int64
-
-const (
-	// Aggregator types
-	InvalidAggregator AggregatorType = iota
-	MapAggregator
-	SumAggregator
-	MaxAggregator
-	MinAggregator
-	AvgAggregator
-)
-
 const (
 	// ECR repo aggregator names
 	ECRMapAggregator       string = "map_aggregator"
@@ -55,6 +48,11 @@ const (
 	// constants used for doing the checkpoint mechanism
 	MaxMessagesWithoutCheckpoint        = 100000
 	MaxMessagesBeforeCheckpointComplete = 15000
+
+	// DefaultReducerContinuationMargin is the time left before the lambda deadline at which
+	// the reducer saves a checkpoint and invokes itself again. It leaves time to delete up
+	// to MaxMessagesWithoutCheckpoint messages from the queue
+	DefaultReducerContinuationMargin = 3 * time.Minute
 )
 
 // ReducerInput is the input the reducer lambda receives
@@ -64,6 +62,11 @@ type ReducerInput struct {
 	QueuePartition int       `json:"queuePartition"`
 	NumMappers     int       `json:"numMappers"`
 	NumReducers    int       `json:"numReducers"`
+	OutputBucket   string    `json:"outputBucket,omitempty"`
+	OutputPrefix   string    `json:"outputPrefix,omitempty"`
+	// NumBatches is the number of batches the reducer needs to process. It is
+	// set when a reducer continues in a new invocation so it is not read again
+	NumBatches int `json:"numBatches,omitempty"`
 }
 
 // Reducer is an interface that implements ReducerAPI
@@ -75,77 +78,88 @@ type Reducer struct {
 	DownloaderAPI  objectstore.ManagerDownloaderAPI
 	UploaderAPI    objectstore.ManagerUploaderAPI
 	QueuesAPI      queues.QueuesAPI
+	FaasAPI        faas.FaasAPI
 	// metadata
 	Region         string
 	AccountID      string
 	NumMappers     int
 	QueuePartition int
+	OutputBucket   string
+	OutputPrefix   string
 	Local          bool
+	FunctionArn    string
 	Output         aggregators.MapAggregator
 	Dedupe         *Dedupe
 	DedupeSimple   *DedupeSimple
 	mu             sync.Mutex
+	// time before the lambda deadline at which the reducer saves a checkpoint
+	// and continues in a new invocation, zero disables continuations
+	ContinuationMargin time.Duration
 }
 
 // UpdateReducerWithRequest updates the reducer struct with the information
 // gathered from the context and request
 func (r *Reducer) UpdateReducerWithRequest(ctx context.Context, request ReducerInput) error {
 	// get data from context
+	lc, ok := lambdacontext.FromContext(ctx)
 	if r.Local {
 		r.AccountID = "000000000000"
 	} else {
-		lc, ok := lambdacontext.FromContext(ctx)
 		if !ok {
 			return errors.New("Error getting lambda context")
 		}
 		r.AccountID = strings.Split(lc.InvokedFunctionArn, ":")[4]
 	}
+	if ok {
+		r.FunctionArn = lc.InvokedFunctionArn
+	}
 
 	r.ReducerID = request.ReducerID
 	r.JobID = request.JobID
 	r.NumMappers = request.NumMappers
 	r.QueuePartition = request.QueuePartition
+	r.OutputBucket, r.OutputPrefix = GetOutputLocation(request.JobID, request.OutputBucket, request.OutputPrefix)
+
+	// a warm lambda reuses the reducer so the data of a previous invocation is cleared
+	r.Output = make(aggregators.MapAggregator)
+	if r.Dedupe != nil {
+		r.Dedupe = InitDedupe()
+	}
+	if r.DedupeSimple != nil {
+		r.DedupeSimple = InitDedupeSimple()
+	}
 
 	return nil
 }
 
-// WriteSortedReducerOutput writes the output of the reducer to objectstore
-func (r *Reducer) WriteSortedReducerOutput(ctx context.Context, output sort.Interface, key string) error {
+// WriteSortedReducerOutput writes the output of the reducer to the output
+// location of the job as the object with the given name
+func (r *Reducer) WriteSortedReducerOutput(ctx context.Context, output sort.Interface, name string) error {
 	// encode map to JSON
 	p, err := json.Marshal(output)
 	if err != nil {
 		return err
 	}
 
-	// use uploader manager to write file to S3
-	jsonContentType := "application/json"
-	bucket := r.JobID.String()
-	input := &s3.PutObjectInput{
-		Bucket:        &bucket,
-		Key:           &key,
-		Body:          bytes.NewReader(p),
-		ContentType:   &jsonContentType,
-		ContentLength: int64(len(p)),
-	}
-	_, err = r.UploaderAPI.Upload(ctx, input)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return r.writeObject(ctx, r.OutputBucket, GetOutputKey(r.OutputPrefix, name), p)
 }
 
-// WriteReducerOutput writes the output of the reducer to objectstore
-func (r *Reducer) WriteReducerOutput(ctx context.Context, output aggregators.Aggregator, key string) error {
+// WriteReducerOutput writes the output of the reducer to the output
+// location of the job as the object with the given name
+func (r *Reducer) WriteReducerOutput(ctx context.Context, output aggregators.Aggregator, name string) error {
 	// encode map to JSON
 	p, err := json.Marshal(output)
 	if err != nil {
 		return err
 	}
 
+	return r.writeObject(ctx, r.OutputBucket, GetOutputKey(r.OutputPrefix, name), p)
+}
+
+// writeObject writes a JSON object to the given bucket
+func (r *Reducer) writeObject(ctx context.Context, bucket string, key string, p []byte) error {
 	// use uploader manager to write file to S3
 	jsonContentType := "application/json"
-	bucket := r.JobID.String()
 	input := &s3.PutObjectInput{
 		Bucket:        &bucket,
 		Key:           &key,
@@ -153,7 +167,7 @@ func (r *Reducer) WriteReducerOutput(ctx context.Context, output aggregators.Agg
 		ContentType:   &jsonContentType,
 		ContentLength: int64(len(p)),
 	}
-	_, err = r.UploaderAPI.Upload(ctx, input)
+	_, err := r.UploaderAPI.Upload(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -267,50 +281,36 @@ func (r *Reducer) EmitValuesToFinalReducer(ctx context.Context) (int, error) {
 	messageMetadata := 0
 
 	for key, value := range r.Output {
-		messageID := uuid.New().String()
-
-		aggregatorType := GetAggregatorType(value)
-
-		// add value to batch
-		mapMessage := aggregators.ReduceMessage{
-			Key:  key,
-			Type: int64(aggregatorType),
-		}
-
-		if aggregatorType == AvgAggregator {
-			castAvg := value.(*aggregators.Avg)
-			mapMessage.Value = castAvg.GetSum()
-			mapMessage.Count = castAvg.GetCount()
-		} else {
-			mapMessage.Value = value.ToNum()
-		}
-
-		// encode map input into JSON
-		p, err := json.Marshal(mapMessage)
+		// encode value, values larger than a message are split in several messages
+		messages, err := EncodeReduceMessages(key, value, MaxMessageSize)
 		if err != nil {
 			return 0, err
 		}
-		messageJSONString := string(p)
-
-		queueName := fmt.Sprintf("%s-%s", r.JobID.String(), "final-aggregator")
-		queueURL := GetQueueURL(queueName, r.Region, r.AccountID, r.Local)
-		params := &sqs.SendMessageInput{
-			MessageBody: &messageJSONString,
-			MessageAttributes: map[string]types.MessageAttributeValue{
-				MessageIDAttribute: {
-					DataType:    &stringDataType,
-					StringValue: &messageID,
+
+		for i := range messages {
+			messageID := uuid.New().String()
+			messageJSONString := messages[i]
+
+			queueName := fmt.Sprintf("%s-%s", r.JobID.String(), "final-aggregator")
+			queueURL := GetQueueURL(queueName, r.Region, r.AccountID, r.Local)
+			params := &sqs.SendMessageInput{
+				MessageBody: &messageJSONString,
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					MessageIDAttribute: {
+						DataType:    &stringDataType,
+						StringValue: &messageID,
+					},
 				},
-			},
-			QueueUrl: &queueURL,
-		}
-		_, err = r.QueuesAPI.SendMessage(ctx, params)
-		if err != nil {
-			return 0, err
-		}
+				QueueUrl: &queueURL,
+			}
+			_, err = r.QueuesAPI.SendMessage(ctx, params)
+			if err != nil {
+				return 0, err
+			}
 
-		// update message metadata
-		messageMetadata = messageMetadata + 1
+			// update message metadata
+			messageMetadata = messageMetadata + 1
+		}
 	}
 
 	log.Default().Println("Messages sent: ", messageMetadata)
@@ -352,18 +352,32 @@ func (r *Reducer) SendMetadata(ctx context.Context, messagesSent int) error {
 	return nil
 }
 
-// SaveIntermediateOutput saves the intermediate output into an S3 object
+// SaveIntermediateOutput saves the intermediate output into an S3 object. The output
+// is saved as reduce messages so that it can be reduced again when it is read
 func (r *Reducer) SaveIntermediateOutput(
 	ctx context.Context,
-	intermediateMap aggregators.Aggregator,
+	intermediateMap aggregators.MapAggregator,
 	currentCheckpoint int,
 	wg *sync.WaitGroup,
 ) error {
 	defer wg.Done()
 
 	// save intermediate output map
+	messages := make([]aggregators.ReduceMessage, 0, len(intermediateMap))
+	for key, value := range intermediateMap {
+		message, err := NewReduceMessage(key, value)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+	}
+	p, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
 	key := fmt.Sprintf("checkpoints/%s/%d-intermediate", r.ReducerID.String(), currentCheckpoint)
-	if err := r.WriteReducerOutput(ctx, intermediateMap, key); err != nil {
+	if err := r.writeObject(ctx, r.JobID.String(), key, p); err != nil {
 		return err
 	}
 
@@ -412,29 +426,21 @@ func (r *Reducer) DeleteIntermediateMessagesFromQueue(
 		QueueUrl: &queueURL,
 	}
 
-	firstMessageToDelete := 0
-	lastMessageToDelete := 10
-
 	// we can only delete 10 per call so we need to loop through all delete requests
-	for lastMessageToDelete <= len(deleteEntries) {
-		messagesToDelete := deleteEntries[firstMessageToDelete:lastMessageToDelete]
-		params.Entries = messagesToDelete
+	for firstMessageToDelete := 0; firstMessageToDelete < len(deleteEntries); firstMessageToDelete += MaxItemsPerBatch {
+		lastMessageToDelete := firstMessageToDelete + MaxItemsPerBatch
+		if lastMessageToDelete > len(deleteEntries) {
+			// we don't have 10 values to delete
+			lastMessageToDelete = len(deleteEntries)
+		}
+
+		params.Entries = deleteEntries[firstMessageToDelete:lastMessageToDelete]
 		_, err := r.QueuesAPI.DeleteMessageBatch(ctx, params)
 		if err != nil {
 			return err
 		}
 
 		// TODO check failed results and add error message - we probs don't want to stop execution
-
-		// update messages to delete indexes
-		firstMessageToDelete = lastMessageToDelete
-
-		if len(deleteEntries) < lastMessageToDelete+10 {
-			// we don't have 10 values to delete
-			lastMessageToDelete = lastMessageToDelete + (len(deleteEntries) - lastMessageToDelete)
-		} else {
-			lastMessageToDelete = lastMessageToDelete + 10
-		}
 	}
 
 	return nil
@@ -506,10 +512,20 @@ func (r *Reducer) GetCheckpointData(ctx context.Context, wg *sync.WaitGroup) (*C
 
 	// get output map and dedupe info from checkpoints
 	if len(checkpointData.IntermediateOutputData) != 0 {
-		r.GetOutputMap(ctx, checkpointData.IntermediateOutputData, wg)
-		r.GetDedupe(ctx, checkpointData.DedupeData, wg)
+		errs := make(chan error, len(checkpointData.IntermediateOutputData)+len(checkpointData.DedupeData))
+		r.GetOutputMap(ctx, checkpointData.IntermediateOutputData, wg, errs)
+		r.GetDedupe(ctx, checkpointData.DedupeData, wg, errs)
 
 		wg.Wait()
+		close(errs)
+
+		// the messages of the checkpoints are no longer in the queue so
+		// the reducer can't continue without any of them
+		for err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return checkpointData, nil
@@ -517,15 +533,21 @@ func (r *Reducer) GetCheckpointData(ctx context.Context, wg *sync.WaitGroup) (*C
 
 // GetOutputMap updates the output map with the data from the intermediate checkpoints.
 // For each intermediate checkpoint it merges the data with the output map concurrently
-func (r *Reducer) GetOutputMap(ctx context.Context, intermediateData []objectstore.Object, wg *sync.WaitGroup) error {
-
+// and sends the result to errs
+func (r *Reducer) GetOutputMap(
+	ctx context.Context,
+	intermediateData []objectstore.Object,
+	wg *sync.WaitGroup,
+	errs chan<- error,
+) {
 	// loop through intermediate results
 	for _, intermediateOutputObject := range intermediateData {
 		wg.Add(1)
-		go r.updateOutputWithIntermediateObject(ctx, intermediateOutputObject, wg)
+		go func(object objectstore.Object) {
+			defer wg.Done()
+			errs <- r.updateOutputWithIntermediateObject(ctx, object)
+		}(intermediateOutputObject)
 	}
-
-	return nil
 }
 
 // updateOutputWithIntermediateObject is a helper function to merge the outputData concurrently.
@@ -534,7 +556,6 @@ func (r *Reducer) GetOutputMap(ctx context.Context, intermediateData []objectsto
 func (r *Reducer) updateOutputWithIntermediateObject(
 	ctx context.Context,
 	intermediateOutputObject objectstore.Object,
-	wg *sync.WaitGroup,
 ) error {
 	params := &s3.GetObjectInput{
 		Bucket: &intermediateOutputObject.Bucket,
@@ -547,7 +568,7 @@ func (r *Reducer) updateOutputWithIntermediateObject(
 	}
 
 	// unmarshal result
-	var res map[string]int
+	var res []*aggregators.ReduceMessage
 	err = json.Unmarshal(buf.Bytes(), &res)
 	if err != nil {
 		return err
@@ -558,29 +579,38 @@ func (r *Reducer) updateOutputWithIntermediateObject(
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.Output.UpdateOutput(res, wg)
+	for _, message := range res {
+		if err := r.Output.Reduce(message); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 // GetDedupe updates the dedupe map with the data from the checkpoints.
-// For each intermediate checkpoint it merges the data with the dedupe map concurrently
-func (r *Reducer) GetDedupe(ctx context.Context, dedupeData []objectstore.Object, wg *sync.WaitGroup) error {
+// For each intermediate checkpoint it merges the data with the dedupe map
+// concurrently and sends the result to errs
+func (r *Reducer) GetDedupe(
+	ctx context.Context,
+	dedupeData []objectstore.Object,
+	wg *sync.WaitGroup,
+	errs chan<- error,
+) {
 	// loop through dedupe results
 	for _, dedupeObject := range dedupeData {
 		wg.Add(1)
-		go r.updateDedupeReaderWithDedupeObject(ctx, dedupeObject, wg)
+		go func(object objectstore.Object) {
+			defer wg.Done()
+			errs <- r.updateDedupeReaderWithDedupeObject(ctx, object)
+		}(dedupeObject)
 	}
-
-	return nil
 }
 
 // updateDedupeReaderWithDedupeObject is a helper function to merge the dedupe map concurrently.
 // It downloads a dedupe object and merges the data to the dedupe map with a mutex
 // so that the data is updated consistently accross all go routines
-func (r *Reducer) updateDedupeReaderWithDedupeObject(ctx context.Context, dedupeObject objectstore.Object, wg *sync.WaitGroup) error {
-	defer wg.Done()
-
+func (r *Reducer) updateDedupeReaderWithDedupeObject(ctx context.Context, dedupeObject objectstore.Object) error {
 	params := &s3.GetObjectInput{
 		Bucket: &dedupeObject.Bucket,
 		Key:    &dedupeObject.Key,
@@ -591,28 +621,102 @@ func (r *Reducer) updateDedupeReaderWithDedupeObject(ctx context.Context, dedupe
 		return err
 	}
 
+	// use mutex to get consistent result
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// reducers that receive single values only use the simple dedupe
+	if r.Dedupe == nil {
+		var res DedupeSimpleMap
+		if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+			return err
+		}
+		r.DedupeSimple.AddCheckpoint(res)
+
+		return nil
+	}
+
 	// unmarshal result
 	var res DedupeMap
-	err = json.Unmarshal(buf.Bytes(), &res)
-	if err != nil {
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
 		return err
 	}
 
-	// update output map values
-	// use mutex to get consistent result
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	// update map
-	for mapperID, batchMap := range res {
-		for batchID, dedupeMessages := range batchMap {
-			if _, ok := r.Dedupe.ReadMap[mapperID]; !ok {
-				r.Dedupe.ReadMap[mapperID] = make(map[int]*DedupeProcessedMessages)
-			}
-			r.Dedupe.ReadMap[mapperID][batchID] = dedupeMessages
+	r.Dedupe.AddCheckpoint(res)
+
+	return nil
+}
+
+// SaveCheckpoint saves the intermediate output and dedupe data processed since the last
+// checkpoint and deletes the processed messages from the queue. Unlike the checkpoints
+// saved while the reducer processes messages it waits for the checkpoint to be saved,
+// it is used before the reducer continues in a new invocation
+func (r *Reducer) SaveCheckpoint(
+	ctx context.Context,
+	queueURL string,
+	currentCheckpoint int,
+	intermediateMap aggregators.MapAggregator,
+	dedupeMap interface{},
+	deleteEntries []sqsTypes.DeleteMessageBatchRequestEntry,
+) error {
+	var wg sync.WaitGroup
+	saveErrs := make(chan error, 2)
+
+	// save dedupe and output at the same time
+	wg.Add(2)
+	go func() {
+		saveErrs <- r.SaveIntermediateDedupe(ctx, currentCheckpoint, dedupeMap, &wg)
+	}()
+	go func() {
+		saveErrs <- r.SaveIntermediateOutput(ctx, intermediateMap, currentCheckpoint, &wg)
+	}()
+	wg.Wait()
+
+	for i := 0; i < 2; i++ {
+		if err := <-saveErrs; err != nil {
+			return err
 		}
 	}
 
+	// messages are only deleted once they are saved in the checkpoint
+	wg.Add(1)
+	return r.DeleteIntermediateMessagesFromQueue(ctx, queueURL, deleteEntries, &wg)
+}
+
+// IsDeadlineNear returns true if the lambda running the reducer times out in less
+// than the continuation margin. Reducers without a margin never continue
+func (r *Reducer) IsDeadlineNear(ctx context.Context) bool {
+	return isDeadlineNear(ctx, r.ContinuationMargin)
+}
+
+// Continue invokes the reducer again with the same request so that it resumes from
+// its checkpoints in a new invocation. The number of batches to process is added
+// to the request so that the new invocation doesn't need to read the metadata queue
+func (r *Reducer) Continue(ctx context.Context, request ReducerInput, numBatches int) error {
+	request.NumBatches = numBatches
+	requestPayload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.FaasAPI.Invoke(
+		ctx,
+		&lambda.InvokeInput{
+			FunctionName:   aws.String(r.FunctionArn),
+			Payload:        requestPayload,
+			InvocationType: lambdaTypes.InvocationTypeEvent,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// error is ignored from asynch invokation and result only holds the status code
+	if result.StatusCode != 202 { //SUCCESS_CODE
+		return errors.New("Error invoking reducer")
+	}
+
 	return nil
 }
 
@@ -4,14 +4,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/queues"
 	"github.com/josenarvaezp/displ/mocks"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
 	"github.com/josenarvaezp/displ/pkg/lambdas"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -81,3 +94,410 @@ func Test_GetNumberOfBatchesToProcess_HappyPath(t *testing.T) {
 	// each indicating they sent 5 messages
 	assert.Equal(t, 10, *numBatches)
 }
+
+func Test_GetNumberOfBatchesToProcess_Redelivery(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+	queueName := fmt.Sprintf("%s-1-meta", jobID.String())
+
+	// each read of the clock moves it one minute so that received
+	// messages become visible again before the next receive
+	var clockMu sync.Mutex
+	now := time.Unix(1640995200, 0)
+	clock := func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	sqsMemory := queues.NewMemoryQueuesWithClock(clock)
+	output, err := sqsMemory.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: &queueName})
+	require.Nil(t, err)
+
+	// the second mapper metadata is delayed so that the first one is redelivered
+	_, err = sqsMemory.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    output.QueueUrl,
+		MessageBody: getExpectedMessage(t),
+	})
+	require.Nil(t, err)
+	_, err = sqsMemory.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     output.QueueUrl,
+		MessageBody:  getExpectedMessage(t),
+		DelaySeconds: 120,
+	})
+	require.Nil(t, err)
+
+	reducer := lambdas.Reducer{
+		JobID:          jobID,
+		Region:         "local",
+		AccountID:      "000000000000",
+		Local:          true,
+		NumMappers:     2,
+		QueuePartition: 1,
+		QueuesAPI:      sqsMemory,
+	}
+
+	numBatches, err := reducer.GetNumberOfBatchesToProcess(ctx)
+	require.Nil(t, err)
+	// the redelivered metadata is only counted once
+	assert.Equal(t, 10, *numBatches)
+}
+
+// slowQueues delays the first receive from the reducer queue so that the
+// deadline of the reducer is near once it has processed the received messages
+type slowQueues struct {
+	queues.QueuesAPI
+	delay time.Duration
+	once  sync.Once
+}
+
+func (q *slowQueues) ReceiveMessage(
+	ctx context.Context,
+	params *sqs.ReceiveMessageInput,
+	optFns ...func(*sqs.Options),
+) (*sqs.ReceiveMessageOutput, error) {
+	output, err := q.QueuesAPI.ReceiveMessage(ctx, params, optFns...)
+	if !strings.HasSuffix(*params.QueueUrl, "-meta") {
+		q.once.Do(func() { time.Sleep(q.delay) })
+	}
+
+	return output, err
+}
+
+func Test_HandleMapAggregator_Continuation(t *testing.T) {
+	jobID := uuid.New()
+	mapID := uuid.New().String()
+	functionARN := "arn:aws:lambda:eu-west-2:000000000000:function:reducer"
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		InvokedFunctionArn: functionARN,
+	})
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(jobID.String())})
+	require.Nil(t, err)
+
+	memoryQueues := queues.NewMemoryQueues()
+	queueURLs := map[string]*string{}
+	for _, name := range []string{"0", "0-meta", "reducers-done"} {
+		output, err := memoryQueues.CreateQueue(ctx, &sqs.CreateQueueInput{
+			QueueName: aws.String(fmt.Sprintf("%s-%s", jobID.String(), name)),
+		})
+		require.Nil(t, err)
+		queueURLs[name] = output.QueueUrl
+	}
+
+	// a mapper sends two batches, the first receive gets half of each batch
+	body, err := json.Marshal(aggregators.ReduceMessage{Key: "a", Value: 1, Type: int64(aggregators.SumAggregatorType)})
+	require.Nil(t, err)
+	for _, messages := range [][2]int{{0, 5}, {5, 10}} {
+		for batchID := 0; batchID < 2; batchID++ {
+			for messageID := messages[0]; messageID < messages[1]; messageID++ {
+				_, err := memoryQueues.SendMessage(ctx, &sqs.SendMessageInput{
+					QueueUrl:    queueURLs["0"],
+					MessageBody: aws.String(string(body)),
+					MessageAttributes: map[string]types.MessageAttributeValue{
+						lambdas.MapIDAttribute:     {DataType: aws.String("String"), StringValue: aws.String(mapID)},
+						lambdas.BatchIDAttribute:   {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(batchID))},
+						lambdas.MessageIDAttribute: {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(messageID))},
+					},
+				})
+				require.Nil(t, err)
+			}
+		}
+	}
+	meta, err := json.Marshal(lambdas.QueueMetadata{MapID: mapID, NumBatches: 2})
+	require.Nil(t, err)
+	_, err = memoryQueues.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    queueURLs["0-meta"],
+		MessageBody: aws.String(string(meta)),
+	})
+	require.Nil(t, err)
+
+	// the reducer invokes itself with the same reducer and the number of batches
+	var continuation lambdas.ReducerInput
+	lambdaMock := new(mocks.FaasAPI)
+	lambdaMock.On("Invoke", mock.Anything, mock.MatchedBy(func(input *lambda.InvokeInput) bool {
+		return *input.FunctionName == functionARN && json.Unmarshal(input.Payload, &continuation) == nil
+	})).Return(&lambda.InvokeOutput{StatusCode: int32(202)}, nil).Once()
+
+	newReducer := func(queuesAPI queues.QueuesAPI, margin time.Duration) *lambdas.Reducer {
+		return &lambdas.Reducer{
+			Local:              true,
+			ObjectStoreAPI:     store,
+			DownloaderAPI:      store,
+			UploaderAPI:        store,
+			QueuesAPI:          queuesAPI,
+			FaasAPI:            lambdaMock,
+			Output:             make(aggregators.MapAggregator),
+			Dedupe:             lambdas.InitDedupe(),
+			ContinuationMargin: margin,
+		}
+	}
+
+	request := lambdas.ReducerInput{
+		JobID:      jobID,
+		ReducerID:  uuid.New(),
+		NumMappers: 1,
+	}
+
+	// the deadline is near after the first receive
+	deadlineCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	reducer := newReducer(&slowQueues{QueuesAPI: memoryQueues, delay: 1500 * time.Millisecond}, 2*time.Second)
+	err = reducer.HandleMapAggregator(deadlineCtx, request, nil, nil)
+	require.Nil(t, err)
+	lambdaMock.AssertExpectations(t)
+	assert.Equal(t, request.ReducerID, continuation.ReducerID)
+	assert.Equal(t, 2, continuation.NumBatches)
+
+	// the new invocation resumes from the checkpoint
+	err = newReducer(memoryQueues, 0).HandleMapAggregator(ctx, continuation, nil, nil)
+	require.Nil(t, err)
+
+	output, err := store.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(jobID.String()),
+		Key:    aws.String(lambdas.GetOutputKey(lambdas.DefaultOutputPrefix, lambdas.GetPartName(0))),
+	})
+	require.Nil(t, err)
+	var result map[string]map[string]string
+	require.Nil(t, json.NewDecoder(output.Body).Decode(&result))
+	assert.Equal(t, map[string]map[string]string{"a": {"Sum": "20"}}, result)
+}
+
+// this function checks that quantile sketches reach the final reducer in the
+// randomized partition flow
+func Test_EmitValuesToFinalReducer_Quantile(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+
+	memoryQueues := queues.NewMemoryQueues()
+	queueOutput, err := memoryQueues.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("%s-final-aggregator", jobID.String())),
+	})
+	require.Nil(t, err)
+
+	// two reducers get half of the values each
+	finalOutput := make(aggregators.MapAggregator)
+	for reducerID := 0; reducerID < 2; reducerID++ {
+		reducer := &lambdas.Reducer{
+			JobID:     jobID,
+			Local:     true,
+			QueuesAPI: memoryQueues,
+			Output:    make(aggregators.MapAggregator),
+		}
+		for i := 1; i <= 1000; i++ {
+			if i%2 == reducerID {
+				require.Nil(t, reducer.Output.AddQuantile("latency", float64(i), 0.5, 0.95))
+			}
+		}
+
+		messagesSent, err := reducer.EmitValuesToFinalReducer(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, 1, messagesSent)
+	}
+
+	// the final reducer merges the sketches
+	output, err := memoryQueues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            queueOutput.QueueUrl,
+		MaxNumberOfMessages: 10,
+	})
+	require.Nil(t, err)
+	require.Len(t, output.Messages, 2)
+	for _, message := range output.Messages {
+		var reduceMessage *aggregators.ReduceMessage
+		require.Nil(t, json.Unmarshal([]byte(*message.Body), &reduceMessage))
+		require.Nil(t, finalOutput.Reduce(reduceMessage))
+	}
+
+	quantile := finalOutput["latency"].(*aggregators.Quantile)
+	assert.Equal(t, []float64{0.5, 0.95}, quantile.GetQuantiles())
+	assert.Equal(t, uint64(1000), quantile.Count)
+	assert.InEpsilon(t, 500, quantile.GetQuantile(0.5), aggregators.QuantileRelativeAccuracy)
+	assert.InEpsilon(t, 950, quantile.GetQuantile(0.95), aggregators.QuantileRelativeAccuracy)
+}
+
+func Test_NewReduceMessage_TypedValues(t *testing.T) {
+	output := make(aggregators.MapAggregator)
+	require.Nil(t, output.AddFirst("first", "created", 10))
+	require.Nil(t, output.AddLast("last", int64(3), 20))
+	require.Nil(t, output.AddAny("any", true))
+
+	for key, value := range output {
+		message, err := lambdas.NewReduceMessage(key, value)
+		require.Nil(t, err)
+		assert.Equal(t, int64(value.Type()), message.Type)
+		assert.Equal(t, value.(aggregators.ValueAggregator).ToValue(), *message.TypedValue)
+	}
+	assert.Equal(t, aggregators.FirstAggregatorType, lambdas.GetAggregatorType(output["first"]))
+	assert.Equal(t, aggregators.LastAggregatorType, lambdas.GetAggregatorType(output["last"]))
+	message, err := lambdas.NewReduceMessage("last", output["last"])
+	require.Nil(t, err)
+	assert.Equal(t, int64(20), message.Order)
+}
+
+// Range is a user-defined aggregator that keeps the smallest and greatest values
+type Range struct {
+	Min float64
+	Max float64
+}
+
+func (r *Range) Reduce(message *aggregators.ReduceMessage) error {
+	return nil
+}
+
+func (r *Range) UpdateOutput(intermediate interface{}, wg *sync.WaitGroup) error {
+	return nil
+}
+
+func (r *Range) ToNum() float64 {
+	return r.Max - r.Min
+}
+
+func (r *Range) Type() aggregators.AggregatorType {
+	return aggregators.CustomAggregatorType
+}
+
+// this function checks that user-defined aggregators are sent from the mappers
+// to the reducer and saved and read from the checkpoints of the reducer
+func Test_CustomAggregator_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+
+	err := aggregators.RegisterAggregator(&Range{}, aggregators.AggregatorDefinition{
+		Name: "range",
+		Encode: func(value aggregators.Aggregator) ([]byte, error) {
+			return json.Marshal(value)
+		},
+		Decode: func(state []byte) (aggregators.Aggregator, error) {
+			r := &Range{}
+			return r, json.Unmarshal(state, r)
+		},
+		Merge: func(value aggregators.Aggregator, other aggregators.Aggregator) error {
+			r := value.(*Range)
+			otherRange := other.(*Range)
+			r.Min = math.Min(r.Min, otherRange.Min)
+			r.Max = math.Max(r.Max, otherRange.Max)
+			return nil
+		},
+	})
+	require.Nil(t, err)
+
+	memoryQueues := queues.NewMemoryQueues()
+	queueOutput, err := memoryQueues.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String(fmt.Sprintf("%s-0", jobID.String())),
+	})
+	require.Nil(t, err)
+
+	// two mappers emit their ranges
+	for _, mapperRange := range []*Range{{Min: 3, Max: 21}, {Min: -4, Max: 15}} {
+		outputMap := aggregators.NewMap()
+		outputMap["temperature"] = mapperRange
+		assert.Equal(t, aggregators.CustomAggregatorType, lambdas.GetAggregatorType(mapperRange))
+
+		mapper := &lambdas.Mapper{
+			JobID:     jobID,
+			MapID:     uuid.New(),
+			QueuesAPI: memoryQueues,
+			NumQueues: 1,
+		}
+		require.Nil(t, mapper.EmitMap(ctx, outputMap, make(map[int]int64)))
+	}
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err = store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(jobID.String())})
+	require.Nil(t, err)
+	newReducer := func(reducerID uuid.UUID) *lambdas.Reducer {
+		return &lambdas.Reducer{
+			JobID:          jobID,
+			ReducerID:      reducerID,
+			Local:          true,
+			ObjectStoreAPI: store,
+			DownloaderAPI:  store,
+			UploaderAPI:    store,
+			Output:         make(aggregators.MapAggregator),
+		}
+	}
+
+	// the reducer merges the ranges
+	reducer := newReducer(uuid.New())
+	for {
+		output, err := memoryQueues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            queueOutput.QueueUrl,
+			MaxNumberOfMessages: 10,
+		})
+		require.Nil(t, err)
+		if len(output.Messages) == 0 {
+			break
+		}
+
+		for _, message := range output.Messages {
+			var reduceMessage *aggregators.ReduceMessage
+			require.Nil(t, json.Unmarshal([]byte(*message.Body), &reduceMessage))
+			require.Nil(t, reducer.Output.Reduce(reduceMessage))
+		}
+	}
+	assert.Equal(t, &Range{Min: -4, Max: 21}, reducer.Output["temperature"])
+
+	// the range is read back from the checkpoint
+	var wg sync.WaitGroup
+	wg.Add(1)
+	require.Nil(t, reducer.SaveIntermediateOutput(ctx, reducer.Output, 0, &wg))
+
+	restoredReducer := newReducer(reducer.ReducerID)
+	_, err = restoredReducer.GetCheckpointData(ctx, &wg)
+	require.Nil(t, err)
+	assert.Equal(t, &Range{Min: -4, Max: 21}, restoredReducer.Output["temperature"])
+}
+
+// this function checks that the reducer fails if one of its checkpoints can't
+// be read, as the messages of the checkpoint are no longer in the queue
+func Test_GetCheckpointData_CorruptCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	jobID := uuid.New()
+	reducerID := uuid.New()
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(jobID.String())})
+	require.Nil(t, err)
+	newReducer := func() *lambdas.Reducer {
+		return &lambdas.Reducer{
+			JobID:          jobID,
+			ReducerID:      reducerID,
+			Local:          true,
+			ObjectStoreAPI: store,
+			DownloaderAPI:  store,
+			UploaderAPI:    store,
+			Output:         make(aggregators.MapAggregator),
+			Dedupe:         lambdas.InitDedupe(),
+		}
+	}
+
+	// two checkpoints are saved
+	reducer := newReducer()
+	var wg sync.WaitGroup
+	for checkpoint := 0; checkpoint < 2; checkpoint++ {
+		output := aggregators.NewMap()
+		require.Nil(t, output.AddSum("a key", 1))
+
+		wg.Add(2)
+		require.Nil(t, reducer.SaveIntermediateOutput(ctx, output, checkpoint, &wg))
+		require.Nil(t, reducer.SaveIntermediateDedupe(ctx, checkpoint, lambdas.DedupeMap{}, &wg))
+	}
+
+	restoredReducer := newReducer()
+	_, err = restoredReducer.GetCheckpointData(ctx, &wg)
+	require.Nil(t, err)
+	assert.Equal(t, float64(2), restoredReducer.Output["a key"].ToNum())
+
+	// the second intermediate checkpoint is corrupt
+	_, err = store.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(jobID.String()),
+		Key:    aws.String(fmt.Sprintf("checkpoints/%s/1-intermediate", reducerID.String())),
+		Body:   strings.NewReader(`[{"Key":"a key"`),
+	})
+	require.Nil(t, err)
+
+	_, err = newReducer().GetCheckpointData(ctx, &wg)
+	assert.NotNil(t, err)
+}
@@ -1,8 +1,10 @@
 package lambdas
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"reflect"
+	"time"
 
 	"github.com/josenarvaezp/displ/pkg/aggregators"
 )
@@ -11,6 +13,14 @@ const (
 	// items per batch
 	MaxItemsPerBatch = 10
 
+	// MaxBatchSize is the maximum size in bytes of a message and of a batch of messages in SQS
+	MaxBatchSize = 256 * 1024
+	// maxAttributesSize is the space reserved in a batch for the attributes of each message
+	maxAttributesSize = 256
+	// MaxMessageSize is the maximum size of the bodies of the messages in a batch, it leaves
+	// space for the attributes of the messages and the empty messages that complete the batch
+	MaxMessageSize = MaxBatchSize - MaxItemsPerBatch*maxAttributesSize
+
 	// attributes for sending and receiving messages
 	MapIDAttribute     = "map-id"
 	BatchIDAttribute   = "batch-id"
@@ -44,33 +54,148 @@ func GetQueueURL(queueName string, region string, accountID string, local bool)
 	return queueURL
 }
 
-func GetAggregatorType(value aggregators.Aggregator) AggregatorType {
-	aggregatorReflectType := reflect.TypeOf(value)
+// GetAggregatorType returns the type of the aggregator. User-defined aggregators are
+// looked up first so that they are sent with their definition whatever their type is
+func GetAggregatorType(value aggregators.Aggregator) aggregators.AggregatorType {
+	if _, ok := aggregators.LookupAggregator(value); ok {
+		return aggregators.CustomAggregatorType
+	}
+
+	return value.Type()
+}
+
+// NewReduceMessage creates the message that reduces the given value of a key. It is used to
+// send the output of mappers and reducers to the next reducer and to save checkpoints
+func NewReduceMessage(key string, value aggregators.Aggregator) (aggregators.ReduceMessage, error) {
+	aggregatorType := GetAggregatorType(value)
+
+	message := aggregators.ReduceMessage{
+		Key:  key,
+		Type: int64(aggregatorType),
+	}
+
+	switch aggregatorType {
+	case aggregators.AvgAggregatorType:
+		castAvg := value.(*aggregators.Avg)
+		message.Value = castAvg.GetSum()
+		message.Count = castAvg.GetCount()
+	case aggregators.CountAggregatorType:
+		// counts are sent as integers so that they are exact
+		message.Count = int(value.(*aggregators.Count).GetCount())
+	case aggregators.HyperLogLogAggregatorType:
+		message.Sketch = value.(*aggregators.HyperLogLog).GetRegisters()
+	case aggregators.QuantileAggregatorType:
+		message.Sketch = value.(*aggregators.Quantile).Encode()
+	case aggregators.TopKAggregatorType:
+		message.Sketch = value.(*aggregators.TopK).Encode()
+	case aggregators.VarianceAggregatorType:
+		castVariance := value.(*aggregators.Variance)
+		message.Count = castVariance.GetCount()
+		message.Mean = castVariance.GetMean()
+		message.M2 = castVariance.GetM2()
+	case aggregators.StdDevAggregatorType:
+		castStdDev := value.(*aggregators.StdDev)
+		message.Count = castStdDev.GetCount()
+		message.Mean = castStdDev.GetMean()
+		message.M2 = castStdDev.GetM2()
+	case aggregators.FirstAggregatorType:
+		castFirst := value.(*aggregators.First)
+		message.TypedValue = &castFirst.Value
+		message.Order = castFirst.Order
+	case aggregators.LastAggregatorType:
+		castLast := value.(*aggregators.Last)
+		message.TypedValue = &castLast.Value
+		message.Order = castLast.Order
+	case aggregators.AnyAggregatorType:
+		message.TypedValue = &value.(*aggregators.Any).Value
+	case aggregators.CollectSetAggregatorType:
+		castSet := value.(*aggregators.CollectSet)
+		message.Values = castSet.GetValues()
+		message.Limit = castSet.Limit
+	case aggregators.CollectListAggregatorType:
+		castList := value.(*aggregators.CollectList)
+		message.Values = castList.GetValues()
+		message.Limit = castList.Limit
+	case aggregators.CustomAggregatorType:
+		definition, _ := aggregators.LookupAggregator(value)
+		state, err := definition.Encode(value)
+		if err != nil {
+			return message, err
+		}
+		message.Custom = definition.Name
+		message.State = state
+	default:
+		message.Value = value.ToNum()
+	}
+
+	return message, nil
+}
+
+// EncodeReduceMessages encodes the messages that reduce the given value of a key into JSON
+// bodies no larger than maxSize. The values of collect aggregators that don't fit in a
+// message are split across several messages, which reducers merge like the messages of
+// different mappers
+func EncodeReduceMessages(key string, value aggregators.Aggregator, maxSize int) ([]string, error) {
+	message, err := NewReduceMessage(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeReduceMessage(message, maxSize)
+}
+
+func encodeReduceMessage(message aggregators.ReduceMessage, maxSize int) ([]string, error) {
+	p, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
 
-	mapType := reflect.TypeOf(make(aggregators.MapAggregator))
-	if aggregatorReflectType.ConvertibleTo(mapType) {
-		return MapAggregator
+	if len(p) <= maxSize {
+		return []string{string(p)}, nil
 	}
 
-	sumType := reflect.TypeOf(aggregators.InitSum(0))
-	if aggregatorReflectType.ConvertibleTo(sumType) {
-		return SumAggregator
+	if len(message.Values) < 2 {
+		return nil, fmt.Errorf("Message for key %s is larger than %d bytes", message.Key, maxSize)
 	}
 
-	maxType := reflect.TypeOf(aggregators.InitMax(0))
-	if aggregatorReflectType.ConvertibleTo(maxType) {
-		return MaxAggregator
+	// split the values into parts that should fit in a message,
+	// parts that are still too large are split again
+	numParts := len(p)/maxSize + 1
+	if numParts > len(message.Values) {
+		numParts = len(message.Values)
 	}
+	partSize := (len(message.Values) + numParts - 1) / numParts
+
+	bodies := []string{}
+	for start := 0; start < len(message.Values); start = start + partSize {
+		end := start + partSize
+		if end > len(message.Values) {
+			end = len(message.Values)
+		}
+
+		part := message
+		part.Values = message.Values[start:end]
+		partBodies, err := encodeReduceMessage(part, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, partBodies...)
+	}
+
+	return bodies, nil
+}
 
-	minType := reflect.TypeOf(aggregators.InitMin(0))
-	if aggregatorReflectType.ConvertibleTo(minType) {
-		return MinAggregator
+// isDeadlineNear returns true if the context deadline is closer than the margin.
+// It is false for a zero margin or a context without deadline
+func isDeadlineNear(ctx context.Context, margin time.Duration) bool {
+	if margin == 0 {
+		return false
 	}
 
-	avgType := reflect.TypeOf(aggregators.InitAvg(0, 0))
-	if aggregatorReflectType.ConvertibleTo(avgType) {
-		return AvgAggregator
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return false
 	}
 
-	return InvalidAggregator
+	return time.Until(deadline) < margin
 }
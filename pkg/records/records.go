@@ -0,0 +1,576 @@
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josenarvaezp/displ/internal/parquet"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+)
+
+// InputFormat is the format of the records in the input objects
+type InputFormat string
+
+const (
+	// FormatCSV reads comma separated fields which can be quoted with "
+	FormatCSV InputFormat = "csv"
+	// FormatTSV reads tab separated fields
+	FormatTSV InputFormat = "tsv"
+	// FormatPipe reads pipe separated fields
+	FormatPipe InputFormat = "pipe"
+	// FormatTbl reads the pipe separated fields of TPC-H .tbl files,
+	// where each line ends with a separator
+	FormatTbl InputFormat = "tbl"
+	// FormatJSONLines reads a JSON object per line
+	FormatJSONLines InputFormat = "jsonl"
+	// FormatParquet reads the rows of Parquet objects, which are split by row
+	// groups instead of lines. The values of the rows are read as strings
+	FormatParquet InputFormat = "parquet"
+
+	// NoQuote disables quoting in formats that quote fields by default
+	NoQuote = "none"
+
+	// dateLayout is the layout of dates read with Record.Date
+	dateLayout = "2006-01-02"
+)
+
+// RecordFormat defines how the records of the input objects are read. Records
+// are read one per line so quoted fields can't have line breaks, the same as
+// the splits of the input which end at a line break. Parquet records are rows
+type RecordFormat struct {
+	// Format is csv, tsv, pipe, tbl, jsonl or parquet
+	Format InputFormat `yaml:"format,omitempty"`
+	// Separator replaces the separator of delimited formats
+	Separator string `yaml:"separator,omitempty"`
+	// Quote replaces the quote character of delimited formats, only csv
+	// quotes fields by default. Use none to disable quoting
+	Quote string `yaml:"quote,omitempty"`
+	// Columns are the names of the fields. Records of delimited formats must
+	// have one field per column and records of JSON Lines read these fields.
+	// Records of Parquet read these columns or all of them if it's empty
+	Columns []string `yaml:"columns,omitempty"`
+	// SkipHeader skips the first line of each object
+	SkipHeader bool `yaml:"skipHeader,omitempty"`
+}
+
+// Validate checks that the format can be used to read records
+func (f RecordFormat) Validate() error {
+	switch f.Format {
+	case FormatCSV, FormatTSV, FormatPipe, FormatTbl:
+		if f.Separator != "" && len([]rune(f.Separator)) != 1 {
+			return fmt.Errorf("Invalid separator %q, it must be a single character", f.Separator)
+		}
+		if f.Quote != "" && f.Quote != NoQuote && len([]rune(f.Quote)) != 1 {
+			return fmt.Errorf("Invalid quote %q, it must be a single character or none", f.Quote)
+		}
+		if f.separator() == f.quote() {
+			return errors.New("The separator and the quote must be different")
+		}
+	case FormatJSONLines:
+		if len(f.Columns) == 0 {
+			return errors.New("The columns to read are needed for JSON Lines input")
+		}
+	case FormatParquet:
+		// the columns are optional and the other options don't apply
+	default:
+		return fmt.Errorf("Invalid input format %q, it must be csv, tsv, pipe, tbl, jsonl or parquet", f.Format)
+	}
+
+	return nil
+}
+
+// separator returns the separator of a delimited format
+func (f RecordFormat) separator() rune {
+	if f.Separator != "" {
+		return []rune(f.Separator)[0]
+	}
+
+	switch f.Format {
+	case FormatCSV:
+		return ','
+	case FormatTSV:
+		return '\t'
+	default:
+		return '|'
+	}
+}
+
+// quote returns the quote character of a delimited format or 0 if fields are not quoted
+func (f RecordFormat) quote() rune {
+	switch {
+	case f.Quote == NoQuote:
+		return 0
+	case f.Quote != "":
+		return []rune(f.Quote)[0]
+	case f.Format == FormatCSV:
+		return '"'
+	default:
+		return 0
+	}
+}
+
+// Record is a record read from the input. Its fields are read as strings
+// and converted with the typed accessors, which get the index of the field
+type Record struct {
+	fields  []string
+	columns map[string]int
+	// Offset is the byte of the object where the record starts, for
+	// compressed objects it is the byte of the decompressed range
+	Offset int64
+	// Row is the number of the row in a Parquet object, starting at 1
+	Row int64
+}
+
+// NewRecord returns a record with the given fields and column names
+func NewRecord(fields []string, columns []string) Record {
+	return Record{
+		fields:  fields,
+		columns: columnIndexes(columns),
+	}
+}
+
+// Len returns the number of fields of the record
+func (r Record) Len() int {
+	return len(r.fields)
+}
+
+// Fields returns the fields of the record
+func (r Record) Fields() []string {
+	return r.fields
+}
+
+// Index returns the index of the field of the column or -1 if the column doesn't exist
+func (r Record) Index(column string) int {
+	index, ok := r.columns[column]
+	if !ok {
+		return -1
+	}
+
+	return index
+}
+
+// String returns the field as a string
+func (r Record) String(i int) (string, error) {
+	if i < 0 || i >= len(r.fields) {
+		return "", fmt.Errorf("Field %d out of range, the record has %d fields", i, len(r.fields))
+	}
+
+	return r.fields[i], nil
+}
+
+// Int returns the field as an integer
+func (r Record) Int(i int) (int64, error) {
+	field, err := r.String(i)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid integer %q in field %d", field, i)
+	}
+
+	return value, nil
+}
+
+// Float returns the field as a float
+func (r Record) Float(i int) (float64, error) {
+	field, err := r.String(i)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid float %q in field %d", field, i)
+	}
+
+	return value, nil
+}
+
+// Bool returns the field as a bool
+func (r Record) Bool(i int) (bool, error) {
+	field, err := r.String(i)
+	if err != nil {
+		return false, err
+	}
+
+	value, err := strconv.ParseBool(strings.TrimSpace(field))
+	if err != nil {
+		return false, fmt.Errorf("Invalid bool %q in field %d", field, i)
+	}
+
+	return value, nil
+}
+
+// Date returns the field as a date with the YYYY-MM-DD layout
+func (r Record) Date(i int) (time.Time, error) {
+	field, err := r.String(i)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	value, err := time.Parse(dateLayout, strings.TrimSpace(field))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date %q in field %d", field, i)
+	}
+
+	return value, nil
+}
+
+// RecordError is the error of a record that can't be read or processed. Records
+// of Parquet objects are located by their row and other records by their offset
+type RecordError struct {
+	Bucket string
+	Key    string
+	Offset int64
+	Row    int64
+	// Decompressed is set when the offset is in the decompressed range of the object
+	Decompressed bool
+	Err          error
+}
+
+func (e *RecordError) Error() string {
+	if e.Row > 0 {
+		return fmt.Sprintf("Error in row %d of s3://%s/%s: %v", e.Row, e.Bucket, e.Key, e.Err)
+	}
+
+	if e.Decompressed {
+		return fmt.Sprintf("Error in record at byte %d of the decompressed range of s3://%s/%s: %v", e.Offset, e.Bucket, e.Key, e.Err)
+	}
+
+	return fmt.Sprintf("Error in record at byte %d of s3://%s/%s: %v", e.Offset, e.Bucket, e.Key, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// RecordReader reads the records of the range of an object. A range that doesn't
+// start at the beginning of the object starts with the line break that ends the
+// previous range, as generated by the logical split of the driver, which is skipped.
+// Ranges of compressed objects are decompressed and start at a complete line. The range of a Parquet object is its row groups, which are read with the footer
+type RecordReader struct {
+	reader  *bufio.Reader
+	rows    *parquet.RowReader
+	object  lambdas.InputObject
+	format  RecordFormat
+	columns map[string]int
+	offset  int64
+}
+
+// NewRecordReader returns a reader of the records in the range of the object
+func NewRecordReader(reader io.Reader, object lambdas.InputObject, format RecordFormat) (*RecordReader, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	if format.Format == FormatParquet {
+		return newParquetRecordReader(reader, object, format)
+	}
+
+	r := &RecordReader{
+		reader:  bufio.NewReader(reader),
+		object:  object,
+		format:  format,
+		columns: columnIndexes(format.Columns),
+		offset:  object.InitialByte,
+	}
+
+	switch {
+	case object.InitialByte == 0:
+		if format.SkipHeader {
+			if _, err := r.readLine(); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+	case object.Compression != nil:
+		// ranges of compressed objects start at a complete line
+		// and the offsets are in the decompressed range
+		r.offset = 0
+	default:
+		// skip the line break of the previous range
+		next, err := r.reader.Peek(1)
+		if err == nil && next[0] == '\n' {
+			r.reader.Discard(1)
+			r.offset++
+		}
+	}
+
+	return r, nil
+}
+
+// newParquetRecordReader returns a reader of the rows in the row groups of the range
+func newParquetRecordReader(reader io.Reader, object lambdas.InputObject, format RecordFormat) (*RecordReader, error) {
+	if object.RowGroups == nil {
+		return nil, fmt.Errorf("The range of s3://%s/%s doesn't have row groups, the input must be split as Parquet", object.Bucket, object.Key)
+	}
+
+	metadata, err := parquet.ReadMetadata(object.Footer)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := parquet.NewRowReader(
+		reader,
+		metadata,
+		object.InitialByte,
+		object.RowGroups.First,
+		object.RowGroups.Count,
+		format.Columns,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordReader{
+		rows:    rows,
+		object:  object,
+		format:  format,
+		columns: columnIndexes(rows.Columns()),
+	}, nil
+}
+
+// Read returns the next record. It returns io.EOF when there are no more
+// records and a RecordError if the record is malformed
+func (r *RecordReader) Read() (Record, error) {
+	if r.rows != nil {
+		return r.readRow()
+	}
+
+	for {
+		offset := r.offset
+		line, err := r.readLine()
+		if err != nil && (err != io.EOF || line == "") {
+			return Record{}, err
+		}
+
+		// empty lines are skipped
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields, err := r.parse(line)
+		if err != nil {
+			return Record{}, r.recordError(offset, err)
+		}
+
+		return Record{
+			fields:  fields,
+			columns: r.columns,
+			Offset:  offset,
+		}, nil
+	}
+}
+
+// readRow returns the next row of a Parquet object
+func (r *RecordReader) readRow() (Record, error) {
+	fields, err := r.rows.Read()
+	if err == io.EOF {
+		return Record{}, err
+	}
+	if err != nil {
+		// the row group of the next row can't be read
+		return Record{}, &RecordError{
+			Bucket: r.object.Bucket,
+			Key:    r.object.Key,
+			Row:    r.rows.RowNumber() + 1,
+			Err:    err,
+		}
+	}
+
+	return Record{
+		fields:  fields,
+		columns: r.columns,
+		Row:     r.rows.RowNumber(),
+	}, nil
+}
+
+// readLine reads the next line without its line break
+func (r *RecordReader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	r.offset = r.offset + int64(len(line))
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+
+	return line, err
+}
+
+// parse returns the fields of a line
+func (r *RecordReader) parse(line string) ([]string, error) {
+	if r.format.Format == FormatJSONLines {
+		return parseJSONLine(line, r.format.Columns)
+	}
+
+	fields, err := splitLine(line, r.format.separator(), r.format.quote())
+	if err != nil {
+		return nil, err
+	}
+
+	// lines of .tbl files end with a separator
+	if r.format.Format == FormatTbl && len(fields) > 1 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+
+	if len(r.format.Columns) > 0 && len(fields) != len(r.format.Columns) {
+		return nil, fmt.Errorf("Expected %d fields but got %d", len(r.format.Columns), len(fields))
+	}
+
+	return fields, nil
+}
+
+func (r *RecordReader) recordError(offset int64, err error) *RecordError {
+	return &RecordError{
+		Bucket: r.object.Bucket,
+		Key:    r.object.Key,
+		Offset: offset,
+		// the mapper reads compressed objects decompressed
+		Decompressed: r.object.Compression != nil,
+		Err:          err,
+	}
+}
+
+// splitLine splits a line by the separator. If quote is not 0 fields can be
+// quoted, and quotes in a quoted field are escaped by doubling them
+func splitLine(line string, separator rune, quote rune) ([]string, error) {
+	if quote == 0 || !strings.ContainsRune(line, quote) {
+		return strings.Split(line, string(separator)), nil
+	}
+
+	fields := []string{}
+	runes := []rune(line)
+	var field strings.Builder
+	for i := 0; i <= len(runes); {
+		// unquoted field
+		if i == len(runes) || runes[i] != quote {
+			for i < len(runes) && runes[i] != separator {
+				if runes[i] == quote {
+					return nil, fmt.Errorf("Unexpected quote in field %d", len(fields))
+				}
+				field.WriteRune(runes[i])
+				i++
+			}
+		} else {
+			// quoted field
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == quote {
+					if i+1 < len(runes) && runes[i+1] == quote {
+						field.WriteRune(quote)
+						i = i + 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				field.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("Missing closing quote in field %d", len(fields))
+			}
+			if i < len(runes) && runes[i] != separator {
+				return nil, fmt.Errorf("Unexpected character after quoted field %d", len(fields))
+			}
+		}
+
+		fields = append(fields, field.String())
+		field.Reset()
+
+		// skip the separator
+		i++
+	}
+
+	return fields, nil
+}
+
+// parseJSONLine returns the fields of the columns of a JSON object. Strings are
+// unquoted, missing fields and nulls are empty and any other value is kept as JSON
+func parseJSONLine(line string, columns []string) ([]string, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &object); err != nil {
+		return nil, fmt.Errorf("Invalid JSON object: %v", err)
+	}
+
+	fields := make([]string, len(columns))
+	for i, column := range columns {
+		value, ok := object[column]
+		if !ok || bytes.Equal(value, []byte("null")) {
+			continue
+		}
+
+		if len(value) > 0 && value[0] == '"' {
+			if err := json.Unmarshal(value, &fields[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fields[i] = string(value)
+	}
+
+	return fields, nil
+}
+
+// columnIndexes returns the index of each column
+func columnIndexes(columns []string) map[string]int {
+	indexes := make(map[string]int, len(columns))
+	for i, column := range columns {
+		indexes[column] = i
+	}
+
+	return indexes
+}
+
+// IsRecordMapper returns true if the mapper processes records,
+// func(records.Record, aggregators.MapAggregator) error
+func IsRecordMapper(mapper interface{}) bool {
+	_, ok := mapper.(func(Record, aggregators.MapAggregator) error)
+	return ok
+}
+
+// NewRecordMapper returns a map function that reads the records of its input with the
+// format and calls the record mapper for each of them. The record mapper adds the values
+// of the record to the output map. Malformed records and the errors of the record mapper
+// stop the map function and are returned as a RecordError
+func NewRecordMapper(
+	format RecordFormat,
+	mapper func(record Record, output aggregators.MapAggregator) error,
+) func(io.Reader, lambdas.InputObject) (aggregators.MapAggregator, error) {
+	return func(reader io.Reader, object lambdas.InputObject) (aggregators.MapAggregator, error) {
+		recordReader, err := NewRecordReader(reader, object, format)
+		if err != nil {
+			return nil, err
+		}
+
+		output := aggregators.NewMap()
+		for {
+			record, err := recordReader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if err := mapper(record, output); err != nil {
+				recordErr := recordReader.recordError(record.Offset, err)
+				recordErr.Row = record.Row
+				return nil, recordErr
+			}
+		}
+
+		return output, nil
+	}
+}
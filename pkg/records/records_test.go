@@ -0,0 +1,349 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/compression"
+	"github.com/josenarvaezp/displ/internal/objectstore"
+	"github.com/josenarvaezp/displ/internal/parquet"
+	"github.com/josenarvaezp/displ/internal/parquet/parquettest"
+	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readRecords reads every record of the data with the format
+func readRecords(t *testing.T, data string, object lambdas.InputObject, format RecordFormat) [][]string {
+	reader, err := NewRecordReader(strings.NewReader(data), object, format)
+	require.Nil(t, err)
+
+	records := [][]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		records = append(records, record.Fields())
+	}
+
+	return records
+}
+
+func Test_RecordReader_Formats(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		format   RecordFormat
+		expected [][]string
+	}{
+		{
+			name:     "tbl",
+			data:     "1|R|F|1998-09-02|\n2|A|F|1995-01-10|\n",
+			format:   RecordFormat{Format: FormatTbl, Columns: []string{"key", "flag", "status", "date"}},
+			expected: [][]string{{"1", "R", "F", "1998-09-02"}, {"2", "A", "F", "1995-01-10"}},
+		},
+		{
+			name:     "pipe",
+			data:     "a|b|\r\n\nc|d|e",
+			format:   RecordFormat{Format: FormatPipe},
+			expected: [][]string{{"a", "b", ""}, {"c", "d", "e"}},
+		},
+		{
+			name:     "csv",
+			data:     "name,comment\n\"Smith, J\",\"said \"\"hi\"\"\"\nDoe,\n",
+			format:   RecordFormat{Format: FormatCSV, SkipHeader: true},
+			expected: [][]string{{"Smith, J", `said "hi"`}, {"Doe", ""}},
+		},
+		{
+			name:     "csv with separator and no quotes",
+			data:     "a;\"b\"\n",
+			format:   RecordFormat{Format: FormatCSV, Separator: ";", Quote: NoQuote},
+			expected: [][]string{{"a", `"b"`}},
+		},
+		{
+			name:     "tsv",
+			data:     "a b\tc\n",
+			format:   RecordFormat{Format: FormatTSV},
+			expected: [][]string{{"a b", "c"}},
+		},
+		{
+			name:     "jsonl",
+			data:     "{\"id\":1,\"name\":\"a\\tb\",\"tags\":[\"x\"]}\n{\"id\":2,\"name\":null}\n",
+			format:   RecordFormat{Format: FormatJSONLines, Columns: []string{"id", "name", "tags"}},
+			expected: [][]string{{"1", "a\tb", `["x"]`}, {"2", "", ""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object := lambdas.InputObject{Bucket: "input", Key: "data", FinalByte: int64(len(test.data))}
+			assert.Equal(t, test.expected, readRecords(t, test.data, object, test.format))
+		})
+	}
+}
+
+// this function splits an object like the logical split of the driver, where
+// a range ends with a line break and the next range starts with it, and checks
+// that every record is read once
+func Test_RecordReader_Ranges(t *testing.T) {
+	data := "id\n1|a\n2|b\n3|c\n"
+	format := RecordFormat{Format: FormatPipe, SkipHeader: true}
+
+	for split := 1; split < len(data)-1; split++ {
+		if data[split] != '\n' {
+			continue
+		}
+
+		ranges := []lambdas.InputObject{
+			{Bucket: "input", Key: "data", InitialByte: 0, FinalByte: int64(split)},
+			{Bucket: "input", Key: "data", InitialByte: int64(split), FinalByte: int64(len(data))},
+		}
+
+		records := [][]string{}
+		for _, object := range ranges {
+			end := object.FinalByte + 1
+			if end > int64(len(data)) {
+				end = int64(len(data))
+			}
+			records = append(records, readRecords(t, data[object.InitialByte:end], object, format)...)
+		}
+		assert.Equal(t, [][]string{{"1", "a"}, {"2", "b"}, {"3", "c"}}, records, "split at %d", split)
+	}
+}
+
+func Test_Record_Accessors(t *testing.T) {
+	record := NewRecord([]string{"42", " 1.5 ", "true", "1998-09-02", "R"}, []string{"id", "price", "flag", "date", "status"})
+
+	assert.Equal(t, 5, record.Len())
+	assert.Equal(t, 3, record.Index("date"))
+	assert.Equal(t, -1, record.Index("unknown"))
+
+	id, err := record.Int(0)
+	require.Nil(t, err)
+	assert.Equal(t, int64(42), id)
+
+	price, err := record.Float(record.Index("price"))
+	require.Nil(t, err)
+	assert.Equal(t, 1.5, price)
+
+	flag, err := record.Bool(2)
+	require.Nil(t, err)
+	assert.True(t, flag)
+
+	date, err := record.Date(3)
+	require.Nil(t, err)
+	assert.Equal(t, time.Date(1998, 9, 2, 0, 0, 0, 0, time.UTC), date)
+
+	_, err = record.Int(4)
+	assert.EqualError(t, err, `Invalid integer "R" in field 4`)
+	_, err = record.String(record.Index("unknown"))
+	assert.EqualError(t, err, "Field -1 out of range, the record has 5 fields")
+}
+
+func Test_RecordReader_Malformed(t *testing.T) {
+	object := lambdas.InputObject{Bucket: "input", Key: "lineitem.tbl", InitialByte: 100}
+	tests := []struct {
+		data     string
+		format   RecordFormat
+		expected string
+	}{
+		{"\n1|a|\n2|\n", RecordFormat{Format: FormatTbl, Columns: []string{"id", "name"}}, "Error in record at byte 106 of s3://input/lineitem.tbl: Expected 2 fields but got 1"},
+		{"\na,\"b\n", RecordFormat{Format: FormatCSV}, "Error in record at byte 101 of s3://input/lineitem.tbl: Missing closing quote in field 1"},
+		{"\na,b\"c\n", RecordFormat{Format: FormatCSV}, "Error in record at byte 101 of s3://input/lineitem.tbl: Unexpected quote in field 1"},
+		{"\n{\"id\":\n", RecordFormat{Format: FormatJSONLines, Columns: []string{"id"}}, "Error in record at byte 101 of s3://input/lineitem.tbl: Invalid JSON object: unexpected end of JSON input"},
+	}
+
+	for _, test := range tests {
+		reader, err := NewRecordReader(strings.NewReader(test.data), object, test.format)
+		require.Nil(t, err)
+
+		for {
+			_, err = reader.Read()
+			if err != nil {
+				break
+			}
+		}
+
+		var recordErr *RecordError
+		require.True(t, errors.As(err, &recordErr), test.expected)
+		assert.EqualError(t, err, test.expected)
+	}
+}
+
+// ranges of compressed objects are decompressed by the mapper and start at a complete
+// line, so the first line is not skipped and the offsets are in the decompressed range
+func Test_RecordReader_Compressed(t *testing.T) {
+	object := lambdas.InputObject{
+		Bucket:      "logs",
+		Key:         "lineitem.tbl.bz2",
+		InitialByte: 100,
+		Compression: &objectstore.CompressedRange{Codec: compression.Bzip2, Size: 500},
+	}
+	format := RecordFormat{Format: FormatTbl, Columns: []string{"id", "name"}}
+
+	records := readRecords(t, "1|a|\n2|b|\n", object, format)
+	assert.Equal(t, [][]string{{"1", "a"}, {"2", "b"}}, records)
+
+	reader, err := NewRecordReader(strings.NewReader("1|a|\n2|\n"), object, format)
+	require.Nil(t, err)
+
+	_, err = reader.Read()
+	require.Nil(t, err)
+	_, err = reader.Read()
+	assert.EqualError(t, err, "Error in record at byte 5 of the decompressed range of s3://logs/lineitem.tbl.bz2: Expected 2 fields but got 1")
+}
+
+func Test_RecordFormat_Validate(t *testing.T) {
+	assert.Nil(t, RecordFormat{Format: FormatTbl}.Validate())
+	assert.EqualError(t, RecordFormat{Format: "xml"}.Validate(), `Invalid input format "xml", it must be csv, tsv, pipe, tbl, jsonl or parquet`)
+	assert.EqualError(t, RecordFormat{Format: FormatCSV, Separator: "::"}.Validate(), `Invalid separator "::", it must be a single character`)
+	assert.EqualError(t, RecordFormat{Format: FormatCSV, Separator: "'", Quote: "'"}.Validate(), "The separator and the quote must be different")
+	assert.EqualError(t, RecordFormat{Format: FormatJSONLines}.Validate(), "The columns to read are needed for JSON Lines input")
+}
+
+// this function runs a record mapper in a mapper that streams its input
+func Test_NewRecordMapper(t *testing.T) {
+	ctx := context.Background()
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err := store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("input")})
+	require.Nil(t, err)
+	_, err = store.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("input"),
+		Key:    aws.String("tpch/lineitem.tbl"),
+		Body:   strings.NewReader("1|R|17.5|\n2|A|2|\n3|R|x|\n"),
+	})
+	require.Nil(t, err)
+
+	format := RecordFormat{Format: FormatTbl, Columns: []string{"id", "flag", "quantity"}}
+	recordMapper := func(record Record, output aggregators.MapAggregator) error {
+		flag, err := record.String(record.Index("flag"))
+		if err != nil {
+			return err
+		}
+		quantity, err := record.Float(record.Index("quantity"))
+		if err != nil {
+			return err
+		}
+
+		return output.AddSum(flag, quantity)
+	}
+	assert.True(t, IsRecordMapper(recordMapper))
+
+	mapper := &lambdas.Mapper{MapID: uuid.New(), ObjectStoreAPI: store}
+
+	// the first two records
+	output, err := mapper.RunMap(ctx, objectstore.ObjectRange{Bucket: "input", Key: "tpch/lineitem.tbl", InitialByte: 0, FinalByte: 16}, NewRecordMapper(format, recordMapper))
+	require.Nil(t, err)
+	assert.Equal(t, float64(17.5), output["R"].ToNum())
+	assert.Equal(t, float64(2), output["A"].ToNum())
+
+	// the last record can't be processed
+	_, err = mapper.RunMap(ctx, objectstore.ObjectRange{Bucket: "input", Key: "tpch/lineitem.tbl", InitialByte: 16, FinalByte: 23}, NewRecordMapper(format, recordMapper))
+	var mapperErr *lambdas.MapperError
+	require.True(t, errors.As(err, &mapperErr))
+	assert.Equal(t, mapper.MapID.String(), mapperErr.MappingID)
+	assert.EqualError(t, mapperErr.Err, `Error in record at byte 17 of s3://input/tpch/lineitem.tbl: Invalid float "x" in field 2`)
+}
+
+// this function runs a record mapper on the row groups of a Parquet object, where each
+// range has a row group, and checks that errors are reported with the row of the object
+func Test_NewRecordMapper_Parquet(t *testing.T) {
+	ctx := context.Background()
+
+	var file bytes.Buffer
+	err := parquettest.Write(
+		&file,
+		[]parquettest.Column{
+			{Name: "id", Type: parquettest.Int64},
+			{Name: "flag", Type: parquettest.String, Dictionary: true},
+			{Name: "quantity", Type: parquettest.Double, Optional: true},
+		},
+		[][]interface{}{
+			{int64(1), "R", 17.5},
+			{int64(2), "A", 2.0},
+			{int64(3), "R", 1.0},
+			{int64(4), "N", nil},
+		},
+		parquettest.Options{RowGroupSize: 2, Codec: parquet.Snappy},
+	)
+	require.Nil(t, err)
+
+	store := objectstore.NewMemoryObjectStore()
+	_, err = store.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("warehouse")})
+	require.Nil(t, err)
+	_, err = store.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("warehouse"),
+		Key:    aws.String("lineitem.parquet"),
+		Body:   bytes.NewReader(file.Bytes()),
+	})
+	require.Nil(t, err)
+
+	// read the footer to get the range of each row group
+	data := file.Bytes()
+	footerLength, err := parquet.FooterLength(data[len(data)-parquet.TailSize:])
+	require.Nil(t, err)
+	footerOffset := int64(len(data)) - parquet.TailSize - footerLength
+	metadata, err := parquet.ReadMetadata(data[footerOffset : len(data)-parquet.TailSize])
+	require.Nil(t, err)
+	require.Len(t, metadata.RowGroups, 2)
+
+	rowGroupRange := func(i int) objectstore.ObjectRange {
+		start, end := metadata.RowGroups[i].ByteRange()
+		return objectstore.ObjectRange{
+			Bucket:      "warehouse",
+			Key:         "lineitem.parquet",
+			InitialByte: start,
+			FinalByte:   end - 1,
+			RowGroups: &objectstore.RowGroupRange{
+				First:        i,
+				Count:        1,
+				FooterOffset: footerOffset,
+				FooterLength: footerLength,
+			},
+		}
+	}
+
+	// only the flag and the quantity are read
+	format := RecordFormat{Format: FormatParquet, Columns: []string{"flag", "quantity"}}
+	recordMapper := func(record Record, output aggregators.MapAggregator) error {
+		flag, err := record.String(record.Index("flag"))
+		if err != nil {
+			return err
+		}
+		quantity, err := record.Float(record.Index("quantity"))
+		if err != nil {
+			return err
+		}
+
+		return output.AddSum(flag, quantity)
+	}
+
+	mapper := &lambdas.Mapper{MapID: uuid.New(), ObjectStoreAPI: store}
+
+	output, err := mapper.RunMap(ctx, rowGroupRange(0), NewRecordMapper(format, recordMapper))
+	require.Nil(t, err)
+	assert.Equal(t, float64(17.5), output["R"].ToNum())
+	assert.Equal(t, float64(2), output["A"].ToNum())
+
+	// the quantity of the last row is null
+	_, err = mapper.RunMap(ctx, rowGroupRange(1), NewRecordMapper(format, recordMapper))
+	var mapperErr *lambdas.MapperError
+	require.True(t, errors.As(err, &mapperErr))
+	assert.EqualError(t, mapperErr.Err, `Error in row 4 of s3://warehouse/lineitem.parquet: Invalid float "" in field 1`)
+
+	// ranges that are not split by row groups can't be read
+	_, err = NewRecordReader(strings.NewReader(""), lambdas.InputObject{Bucket: "warehouse", Key: "lineitem.parquet"}, format)
+	assert.EqualError(t, err, "The range of s3://warehouse/lineitem.parquet doesn't have row groups, the input must be split as Parquet")
+}
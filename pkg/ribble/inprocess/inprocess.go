@@ -0,0 +1,72 @@
+// Package inprocess runs ribble jobs in the current process using in-memory
+// versions of the cloud services. Job binaries import it for its side effect
+// to be able to run with the in-process flag:
+//
+//	import _ "github.com/josenarvaezp/displ/pkg/ribble/inprocess"
+package inprocess
+
+import (
+	"context"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/josenarvaezp/displ/internal/config"
+	"github.com/josenarvaezp/displ/internal/driver"
+	"github.com/josenarvaezp/displ/pkg/ribble"
+)
+
+func init() {
+	ribble.RegisterInProcessRunner(Run)
+}
+
+// Run runs the job in the current process and writes the output to stdout. If
+// the data directory is set the objects are read from and written to it
+func Run(job *ribble.InProcessJob) error {
+	// use a new id if the job doesn't have one
+	id := uuid.New()
+	if job.JobID != "" {
+		var err error
+		id, err = uuid.Parse(job.JobID)
+		if err != nil {
+			return err
+		}
+	}
+
+	conf := &config.Config{
+		InputBuckets: job.Config.InputBuckets,
+		OutputBucket: job.Config.OutputBucket,
+		OutputPrefix: job.Config.OutputPrefix,
+		Region:       job.Config.Region,
+		LogLevel:     job.Config.LogLevel,
+		LogicalSplit: job.Config.LogicalSplit,
+		Records: config.Records{
+			Format: string(job.Config.Records.Format),
+		},
+		DetectContentEncoding: job.Config.DetectContentEncoding,
+	}
+
+	// objects are kept in memory unless a data directory is given
+	jobDriver := driver.NewLocalDriver(id, conf)
+	if job.DataDir != "" {
+		var err error
+		jobDriver, err = driver.NewLocalFileDriver(id, conf, job.DataDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return jobDriver.RunInProcess(
+		context.Background(),
+		&driver.LocalJob{
+			Mapper:              job.Mapper,
+			Filter:              job.Filter,
+			Sort:                job.Sort,
+			RandomizedPartition: job.Config.RandomizedPartition,
+			InputPath:           job.Input,
+			MapperFailurePolicy: job.Config.MapperFailurePolicy,
+			NumReducers:         job.NumReducers,
+			PollInterval:        job.PollInterval,
+		},
+		os.Stdout,
+	)
+}
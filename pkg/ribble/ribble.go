@@ -1,19 +1,25 @@
 package ribble
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"sort"
+	"time"
 
 	"github.com/josenarvaezp/displ/internal/generators"
 	"github.com/josenarvaezp/displ/pkg/aggregators"
+	"github.com/josenarvaezp/displ/pkg/lambdas"
+	"github.com/josenarvaezp/displ/pkg/records"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
 	InputBuckets        []string `yaml:"input"`
+	OutputBucket        string   `yaml:"output,omitempty"`
+	OutputPrefix        string   `yaml:"outputPrefix,omitempty"`
 	Region              string   `yaml:"region"`
 	Local               bool     `yaml:"local"`
 	LogLevel            int      `yaml:"logLevel"`
@@ -21,10 +27,57 @@ type Config struct {
 	Username            string   `yaml:"username"`
 	LogicalSplit        bool     `yaml:"logicalSplit"`
 	RandomizedPartition bool     `yaml:"randomizedPartition"`
+	// Records is the format of the input of record mappers
+	Records records.RecordFormat `yaml:"records,omitempty"`
+	// MapperFailurePolicy decides if a split where the mapper returns an error
+	// fails the job, the default, or is skipped and reported in the job status
+	MapperFailurePolicy lambdas.MapperFailurePolicy `yaml:"mapperFailurePolicy,omitempty"`
+	// DetectContentEncoding reads the content encoding of the input objects
+	// without a known compression extension to find if they are compressed
+	DetectContentEncoding bool `yaml:"detectContentEncoding,omitempty"`
 }
 
+// InProcessJob is a job run with the in-process flag
+type InProcessJob struct {
+	// Mapper is a file or stream mapper, record mappers
+	// are wrapped with the record format of the job
+	Mapper interface{}
+	Filter func(aggregators.MapAggregator) aggregators.MapAggregator
+	Sort   func(aggregators.MapAggregator) sort.Interface
+	Config Config
+	// JobID is empty if the job doesn't have an id yet
+	JobID string
+	// Input is a local file or directory loaded into the first input bucket
+	Input string
+	// DataDir is a local directory used as object store
+	DataDir string
+	// NumReducers is the number of reducers, if 0 it is half the number of mappers
+	NumReducers int
+	// PollInterval is the time between checks of the coordinator, if 0 it is the default
+	PollInterval time.Duration
+}
+
+// InProcessRunner runs a job in the current process
+type InProcessRunner func(job *InProcessJob) error
+
+// inProcessRunner is set by the inprocess package, so that only the job
+// binaries that import it depend on the driver and the in-memory services
+var inProcessRunner InProcessRunner
+
+// RegisterInProcessRunner sets the runner of the jobs run with the in-process flag
+func RegisterInProcessRunner(runner InProcessRunner) {
+	inProcessRunner = runner
+}
+
+// Job generates the code of the job or runs it in-process. The mapper can take the
+// name of a file with its input, func(string) aggregators.MapAggregator, stream
+// its input, func(io.Reader, lambdas.InputObject) aggregators.MapAggregator, or
+// process one record at a time, func(records.Record, aggregators.MapAggregator) error,
+// where the records are read with the format in config.Records. File and stream
+// mappers can also return an error, func(string) (aggregators.MapAggregator, error),
+// which is handled with config.MapperFailurePolicy
 func Job(
-	mapper func(string) aggregators.MapAggregator,
+	mapper interface{},
 	filter func(aggregators.MapAggregator) aggregators.MapAggregator,
 	sort func(aggregators.MapAggregator) sort.Interface,
 	config Config,
@@ -32,17 +85,39 @@ func Job(
 	// get job id and workspace from flags
 	var workSpace string
 	var jobID string
+	var inProcess bool
+	var input string
+	var dataDir string
+	var numReducers int
+	var pollInterval time.Duration
 
 	flag.StringVar(&workSpace, "workspace", "", "The workspace for the job")
 	flag.StringVar(&jobID, "job-id", "", "The ID for the job")
+	flag.BoolVar(&inProcess, "in-process", false, "Run the job in this process without AWS")
+	flag.StringVar(&input, "input", "", "Local file or directory used as input when running in-process")
+	flag.StringVar(&dataDir, "data-dir", "", "Local directory used as object store when running in-process")
+	flag.IntVar(&numReducers, "reducers", 0, "Number of reducers used when running in-process")
+	flag.DurationVar(&pollInterval, "poll-interval", 0, "Time between checks of the coordinator when running in-process")
 	flag.Parse()
 
 	// validate mapper function
-	err := generators.ValidateMapper(mapper)
+	var err error
+	if records.IsRecordMapper(mapper) {
+		err = config.Records.Validate()
+	} else if config.Records.Format == records.FormatParquet {
+		err = errors.New("Parquet input needs a record mapper, func(records.Record, aggregators.MapAggregator) error")
+	} else {
+		err = generators.ValidateMapper(mapper)
+	}
 	if err != nil {
 		return err
 	}
 
+	// validate what happens when the mapper fails
+	if err := config.MapperFailurePolicy.Validate(); err != nil {
+		return err
+	}
+
 	// validate filter function
 	if filter != nil {
 		if err := generators.ValidateFilter(filter); err != nil {
@@ -57,8 +132,36 @@ func Job(
 		}
 	}
 
+	if inProcess {
+		if inProcessRunner == nil {
+			return errors.New("Running in-process needs the job to import github.com/josenarvaezp/displ/pkg/ribble/inprocess")
+		}
+
+		// record mappers read the records with the format of the job
+		if records.IsRecordMapper(mapper) {
+			mapper = records.NewRecordMapper(config.Records, mapper.(func(records.Record, aggregators.MapAggregator) error))
+		}
+
+		return inProcessRunner(&InProcessJob{
+			Mapper:       mapper,
+			Filter:       filter,
+			Sort:         sort,
+			Config:       config,
+			JobID:        jobID,
+			Input:        input,
+			DataDir:      dataDir,
+			NumReducers:  numReducers,
+			PollInterval: pollInterval,
+		})
+	}
+
 	// get function name and package info
 	mapperData := generators.GetFunctionData(mapper, jobID, config.Local)
+	if records.IsRecordMapper(mapper) {
+		// the generated mapper reads the records with the format of the job
+		mapperData.RecordFormat = fmt.Sprintf("%#v", config.Records)
+	}
+	mapperData.FailurePolicy = string(config.MapperFailurePolicy)
 
 	// generate mapper file for lambda function
 	err = generators.ExecuteMapperGenerator(jobID, config.RandomizedPartition, mapperData)
@@ -76,7 +179,7 @@ func Job(
 	}
 
 	// get function name and package info
-	reducerData := generators.GetReducerData(filter, sort, config.RandomizedPartition, jobID, config.Local)
+	reducerData := generators.GetReducerData(filter, sort, config.RandomizedPartition, jobID, config.Local, mapperData.PackagePath)
 
 	// generate mapper file for lambda function
 	err = generators.ExecuteReducerGenerator(jobID, config.RandomizedPartition, reducerData)